@@ -0,0 +1,76 @@
+// Package main provides a command-line tool to generate Prometheus alerting rules
+// for common PostgreSQL failure modes.
+//
+// The rules cover connection saturation, free disk space, transaction ID age,
+// long-running transactions, replication lag, the rollback ratio and sustained
+// CPU. Prometheus loads the generated file next to recording_rules.yml, so the
+// alerts reach any Alertmanager it is configured with.
+//
+// Usage:
+//
+//	go run main.go [-output path/to/output.yml] [-connections-percent 90] ...
+//
+// Flags:
+//
+//	-output: Specifies the output file path for the alerting rules YAML
+//	        (default: "../prometheus/alerting_rules.yml")
+//
+// Every threshold has a flag; run with -help to list them and their defaults.
+//
+// Exit codes:
+//
+//	0: Success
+//	1: Error (directory creation or file writing failed)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"local/bff/pkg/alerting"
+	"os"
+	"path/filepath"
+)
+
+func writeRulesToFile(path string, yamlConfig string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(yamlConfig), 0644); err != nil {
+		return fmt.Errorf("error writing file: %v", err)
+	}
+
+	fmt.Printf("Alerting rules written to %s\n", path)
+	return nil
+}
+
+func main() {
+	t := alerting.DefaultThresholds()
+
+	outputPath := flag.String("output", "../prometheus/alerting_rules.yml", "Output path for the alerting rules YAML")
+	flag.Float64Var(&t.ConnectionsPercent, "connections-percent", t.ConnectionsPercent, "Alert when backends exceed this percent of max_connections")
+	flag.DurationVar(&t.ConnectionsDuration, "connections-for", t.ConnectionsDuration, "How long connections must stay above the threshold")
+	flag.Float64Var(&t.DiskFreePercent, "disk-free-percent", t.DiskFreePercent, "Alert when free disk space falls below this percent")
+	flag.DurationVar(&t.DiskFreeDuration, "disk-free-for", t.DiskFreeDuration, "How long free disk space must stay below the threshold")
+	flag.Float64Var(&t.XIDAge, "xid-age", t.XIDAge, "Alert when the transaction ID age of a database exceeds this")
+	flag.DurationVar(&t.LongTransaction, "long-transaction", t.LongTransaction, "Alert when a transaction has been open for longer than this")
+	flag.DurationVar(&t.ReplicationLag, "replication-lag", t.ReplicationLag, "Alert when a standby replays more than this far behind")
+	flag.Float64Var(&t.ReplicationLagBytes, "replication-lag-bytes", t.ReplicationLagBytes, "Alert when a standby has more than this many WAL bytes left to replay")
+	flag.DurationVar(&t.ReplicationLagDuration, "replication-lag-for", t.ReplicationLagDuration, "How long replication lag must stay above the threshold")
+	flag.Float64Var(&t.RollbackPercent, "rollback-percent", t.RollbackPercent, "Alert when more than this percent of transactions roll back")
+	flag.Float64Var(&t.RollbackMinTPS, "rollback-min-tps", t.RollbackMinTPS, "Ignore the rollback ratio below this many transactions per second")
+	flag.Float64Var(&t.CPUPercent, "cpu-percent", t.CPUPercent, "Alert when average user and system CPU exceeds this percent")
+	flag.DurationVar(&t.SustainedCPUDuration, "cpu-for", t.SustainedCPUDuration, "How long CPU must stay above the threshold")
+	flag.Parse()
+
+	rules := alerting.PostgresRules(t)
+	yamlConfig := alerting.GeneratePrometheusAlertingRules("autodba_postgres_alerts", rules)
+
+	if err := writeRulesToFile(*outputPath, yamlConfig); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Generated %d alerting rules\n", len(rules))
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"local/bff/pkg/alerting"
+	"local/bff/pkg/anomaly"
+	"local/bff/pkg/events"
+	"local/bff/pkg/instances"
 	"local/bff/pkg/metrics"
 	"local/bff/pkg/prometheus"
 	"local/bff/pkg/query_storage"
 	"local/bff/pkg/server"
+	"local/bff/pkg/state_storage"
 	"os"
 	"path/filepath"
 
+	client_prometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
 )
 
@@ -73,6 +80,13 @@ func run(webappPath string) error {
 	}
 	config.Port = port
 
+	// Serving the metrics of the bff is optional
+	metricsPort := os.Getenv("AUTODBA_BFF_METRICS_PORT")
+	if metricsPort == "" {
+		metricsPort, _ = rawConfig["metrics_port"].(string)
+	}
+	config.MetricsPort = metricsPort
+
 	dataPath := os.Getenv("AUTODBA_DATA_PATH")
 	if dataPath == "" {
 		dataPath, ok = rawConfig["data_path"].(string)
@@ -82,6 +96,16 @@ func run(webappPath string) error {
 	}
 	config.DataPath = dataPath
 
+	// The data path is shared with collector-api and may be read-only, so the
+	// state of the bff can be kept elsewhere
+	statePath := os.Getenv("AUTODBA_STATE_PATH")
+	if statePath == "" {
+		statePath, ok = rawConfig["state_path"].(string)
+		if !ok || statePath == "" {
+			statePath = dataPath
+		}
+	}
+
 	prometheusURL := os.Getenv("PROMETHEUS_URL")
 	if prometheusURL == "" {
 		prometheusURL, ok = rawConfig["prometheus_server"].(string)
@@ -142,7 +166,20 @@ func run(webappPath string) error {
 
 	fmt.Printf("Config:\n%+v\n", config)
 
+	var cacheConfig metrics.CacheConfig
+	if err := viper.UnmarshalKey("query_cache", &cacheConfig); err != nil {
+		return fmt.Errorf("error unmarshaling query cache config: %s", err)
+	}
+
 	metrics_repo := prometheus.New(config.PrometheusServer)
+	if cacheConfig.Enabled {
+		cache, err := metrics.NewCachingRepository(metrics_repo, cacheConfig)
+		if err != nil {
+			return fmt.Errorf("invalid query cache config: %s", err)
+		}
+		client_prometheus.MustRegister(cache)
+		metrics_repo = cache
+	}
 	metrics_service := metrics.CreateService(metrics_repo)
 
 	dbPath := filepath.Join(dataPath, "crystaldb-collector.db")
@@ -151,7 +188,49 @@ func run(webappPath string) error {
 		return fmt.Errorf("Failed to create query storage: %s", err)
 	}
 
-	server := server.CreateServer(config.RoutesConfig, metrics_service, queryRepo, config)
+	var alertingConfig alerting.Config
+	if err := viper.UnmarshalKey("alerting", &alertingConfig); err != nil {
+		return fmt.Errorf("error unmarshaling alerting config: %s", err)
+	}
+
+	var anomalyConfig anomaly.Config
+	if err := viper.UnmarshalKey("anomaly_detection", &anomalyConfig); err != nil {
+		return fmt.Errorf("error unmarshaling anomaly detection config: %s", err)
+	}
+
+	if err := os.MkdirAll(statePath, 0o755); err != nil {
+		return fmt.Errorf("Failed to create state path: %s", err)
+	}
+	stateDB, err := state_storage.Open(filepath.Join(statePath, "crystaldb-bff.db"))
+	if err != nil {
+		return fmt.Errorf("Failed to open state storage: %s", err)
+	}
+	defer stateDB.Close()
+	eventStore := events.NewStore(stateDB)
+
+	var alerts *alerting.Engine
+	if alertingConfig.Enabled {
+		notifier, err := alerting.NewWebhookNotifier(alertingConfig.Webhooks)
+		if err != nil {
+			return fmt.Errorf("invalid alerting config: %s", err)
+		}
+		alerts, err = alerting.NewEngine(alertingConfig, metrics_service, alerting.NewStore(stateDB), notifier)
+		if err != nil {
+			return fmt.Errorf("invalid alerting config: %s", err)
+		}
+		go alerts.Run(context.Background())
+	}
+
+	var anomalies *anomaly.Detector
+	if anomalyConfig.Enabled {
+		anomalies, err = anomaly.NewDetector(anomalyConfig, metrics_service, eventStore)
+		if err != nil {
+			return fmt.Errorf("invalid anomaly detection config: %s", err)
+		}
+		go anomalies.Run(context.Background())
+	}
+
+	server := server.CreateServer(config.RoutesConfig, metrics_service, queryRepo, config, alerts, anomalies, eventStore, instances.NewStore(stateDB))
 
 	if err = server.Run(); err != nil {
 		return err
@@ -0,0 +1,319 @@
+// Package advisor turns the collected state of a system into tuning advice
+// that is meant to be reviewed before it is applied.
+package advisor
+
+import (
+	"fmt"
+	"local/bff/pkg/query_storage"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Index finding kinds, in the order they are reported. An index is reported
+// once, under the first kind it matches.
+const (
+	IndexInvalid   = "invalid"
+	IndexDuplicate = "duplicate"
+	IndexPrefix    = "prefix"
+	IndexUnused    = "unused"
+)
+
+var indexKindOrder = map[string]int{IndexInvalid: 0, IndexDuplicate: 1, IndexPrefix: 2, IndexUnused: 3}
+
+// IndexFinding is an index that can likely be dropped. CoveredBy names the
+// index that makes a duplicate or prefix index redundant.
+type IndexFinding struct {
+	Kind          string `json:"kind"`
+	Database      string `json:"datname"`
+	Schema        string `json:"schema"`
+	Relation      string `json:"relation"`
+	Index         string `json:"index"`
+	Definition    string `json:"index_def"`
+	SizeBytes     int64  `json:"size_bytes"`
+	Reason        string `json:"reason"`
+	CoveredBy     string `json:"covered_by,omitempty"`
+	DropStatement string `json:"drop_statement"`
+}
+
+// IndexAdvice lists the findings of a system with the space dropping all of
+// them would free, and a script of their drop statements grouped by database
+type IndexAdvice struct {
+	Findings              []IndexFinding `json:"findings"`
+	EstimatedSavingsBytes int64          `json:"estimated_savings_bytes"`
+	DropScript            string         `json:"drop_script"`
+}
+
+// indexDef is the parsed part of an index definition that decides whether two
+// indexes answer the same lookups
+type indexDef struct {
+	method    string
+	keys      []string
+	include   []string
+	predicate string
+}
+
+// AdviseIndexes finds the invalid indexes, the indexes that duplicate or are
+// a prefix of another index on the same table, and the indexes without scans
+// for the last unusedWindow. Indexes enforcing a constraint or uniqueness are
+// never reported as unused or as a prefix.
+func AdviseIndexes(indexes []query_storage.SystemIndex, now time.Time, unusedWindow time.Duration) IndexAdvice {
+	findings := make(map[int]IndexFinding)
+	defs := make([]*indexDef, len(indexes))
+	for i, idx := range indexes {
+		defs[i] = parseIndexDef(idx.Definition)
+	}
+
+	for i, idx := range indexes {
+		if !idx.IsValid {
+			findings[i] = newIndexFinding(IndexInvalid, idx, "the index is invalid, usually left by a failed CREATE INDEX CONCURRENTLY, and is maintained on writes but never used", "")
+		}
+	}
+
+	// Exact duplicates first, so that of two copies of a prefix index one is
+	// reported as the duplicate of the other. The indexes that cover a
+	// redundant one are kept, even when unused, since dropping both would
+	// leave its lookups without an index.
+	covering := make(map[int]bool)
+	redundant := func(kind string, covers func(i, j int) bool, reason string) {
+		for i, idx := range indexes {
+			if _, ok := findings[i]; ok || defs[i] == nil {
+				continue
+			}
+			for j, other := range indexes {
+				if i == j || !other.IsValid || defs[j] == nil || !sameTable(idx, other) {
+					continue
+				}
+				if _, ok := findings[j]; ok || !covers(i, j) {
+					continue
+				}
+				findings[i] = newIndexFinding(kind, idx, fmt.Sprintf(reason, other.Name), other.Name)
+				covering[j] = true
+				break
+			}
+		}
+	}
+	redundant(IndexDuplicate, func(i, j int) bool {
+		return defs[i].equivalent(defs[j]) && dropsBefore(indexes[i], indexes[j])
+	}, "the index has the same definition as %s")
+	redundant(IndexPrefix, func(i, j int) bool {
+		return droppable(indexes[i]) && defs[i].prefixOf(defs[j])
+	}, "the index columns are a leading prefix of %s, which can answer the same lookups")
+
+	unusedSince := now.Add(-unusedWindow).Unix()
+	for i, idx := range indexes {
+		if _, ok := findings[i]; ok || covering[i] || !droppable(idx) {
+			continue
+		}
+		if idx.FirstSeen <= unusedSince && idx.ScansChangedAt <= unusedSince {
+			findings[i] = newIndexFinding(IndexUnused, idx, fmt.Sprintf("the index has not been scanned in the last %s", formatWindow(unusedWindow)), "")
+		}
+	}
+
+	advice := IndexAdvice{Findings: make([]IndexFinding, 0, len(findings))}
+	for _, finding := range findings {
+		advice.Findings = append(advice.Findings, finding)
+		advice.EstimatedSavingsBytes += finding.SizeBytes
+	}
+	sort.Slice(advice.Findings, func(i, j int) bool {
+		a, b := advice.Findings[i], advice.Findings[j]
+		if a.Database != b.Database {
+			return a.Database < b.Database
+		}
+		if a.Kind != b.Kind {
+			return indexKindOrder[a.Kind] < indexKindOrder[b.Kind]
+		}
+		if a.SizeBytes != b.SizeBytes {
+			return a.SizeBytes > b.SizeBytes
+		}
+		return a.Schema+"."+a.Index < b.Schema+"."+b.Index
+	})
+	advice.DropScript = dropScript(advice.Findings)
+	return advice
+}
+
+func newIndexFinding(kind string, idx query_storage.SystemIndex, reason, coveredBy string) IndexFinding {
+	return IndexFinding{
+		Kind:          kind,
+		Database:      idx.Database,
+		Schema:        idx.Schema,
+		Relation:      idx.Relation,
+		Index:         idx.Name,
+		Definition:    idx.Definition,
+		SizeBytes:     idx.SizeBytes,
+		Reason:        reason,
+		CoveredBy:     coveredBy,
+		DropStatement: fmt.Sprintf("DROP INDEX CONCURRENTLY IF EXISTS %s.%s;", quoteIdent(idx.Schema), quoteIdent(idx.Name)),
+	}
+}
+
+// droppable reports whether idx can be dropped without changing what the
+// table enforces
+func droppable(idx query_storage.SystemIndex) bool {
+	return !idx.IsPrimary && !idx.IsUnique && idx.ConstraintDef == ""
+}
+
+// dropsBefore reports whether idx is the one to drop of two equivalent
+// indexes. Indexes backing a constraint are kept first, then unique ones,
+// then the one with more scans.
+func dropsBefore(idx, other query_storage.SystemIndex) bool {
+	if idx.ConstraintDef != "" || idx.IsPrimary || (idx.IsUnique && !other.IsUnique) {
+		return false
+	}
+	if other.ConstraintDef != "" || other.IsPrimary {
+		return true
+	}
+	if idx.IsUnique != other.IsUnique {
+		return other.IsUnique
+	}
+	if idx.ScanCount != other.ScanCount {
+		return idx.ScanCount < other.ScanCount
+	}
+	return idx.Name > other.Name
+}
+
+func sameTable(a, b query_storage.SystemIndex) bool {
+	return a.Database == b.Database && a.Schema == b.Schema && a.Relation == b.Relation
+}
+
+func (d *indexDef) equivalent(other *indexDef) bool {
+	return d.method == other.method && d.predicate == other.predicate &&
+		equalStrings(d.keys, other.keys) && equalStrings(d.include, other.include)
+}
+
+// prefixOf reports whether d is a btree index whose key columns lead other's,
+// so that other answers every lookup d does
+func (d *indexDef) prefixOf(other *indexDef) bool {
+	if d.method != "btree" || other.method != "btree" || d.predicate != other.predicate || len(d.include) > 0 {
+		return false
+	}
+	return len(d.keys) < len(other.keys) && equalStrings(d.keys, other.keys[:len(d.keys)])
+}
+
+// parseIndexDef parses the output of pg_get_indexdef, e.g.
+// "CREATE INDEX a_b_idx ON public.a USING btree (b, lower(c)) INCLUDE (d) WHERE (e > 0)".
+// It returns nil for definitions it does not understand.
+func parseIndexDef(def string) *indexDef {
+	using := strings.Index(def, " USING ")
+	if using < 0 {
+		return nil
+	}
+	rest := def[using+len(" USING "):]
+	open := strings.Index(rest, " (")
+	if open < 0 {
+		return nil
+	}
+
+	d := &indexDef{method: rest[:open]}
+	keys, rest, ok := splitParenthesized(rest[open+1:])
+	if !ok {
+		return nil
+	}
+	d.keys = keys
+
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "INCLUDE (") {
+		d.include, rest, ok = splitParenthesized(rest[len("INCLUDE "):])
+		if !ok {
+			return nil
+		}
+	}
+	if where := strings.Index(rest, "WHERE "); where >= 0 {
+		d.predicate = strings.TrimSpace(rest[where+len("WHERE "):])
+	}
+	return d
+}
+
+// splitParenthesized splits the parenthesized list s starts with on its top
+// level commas, returning the items and what follows the closing parenthesis
+func splitParenthesized(s string) ([]string, string, bool) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", false
+	}
+
+	var items []string
+	depth, start := 0, 1
+	inQuote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inQuote != 0:
+			if c == inQuote {
+				inQuote = 0
+			}
+		case c == '"' || c == '\'':
+			inQuote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(s[start:i]))
+				return items, s[i+1:], true
+			}
+		case c == ',' && depth == 1:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return nil, "", false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteIdent quotes a PostgreSQL identifier
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// formatWindow formats whole days as days, e.g. "30 days", and anything else as a
+// Go duration
+func formatWindow(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// dropScript joins the drop statements of findings, grouped by the database
+// they have to run in
+func dropScript(findings []IndexFinding) string {
+	if len(findings) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("-- Review before running. DROP INDEX CONCURRENTLY cannot run inside a transaction block.\n")
+	database := ""
+	for i, finding := range findings {
+		if i == 0 || finding.Database != database {
+			database = finding.Database
+			fmt.Fprintf(&b, "\n-- Database: %s\n", database)
+		}
+		fmt.Fprintf(&b, "-- %s, %s: %s\n%s\n", finding.Kind, formatBytes(finding.SizeBytes), finding.Reason, finding.DropStatement)
+	}
+	return b.String()
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
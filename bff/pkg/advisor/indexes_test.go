@@ -0,0 +1,106 @@
+package advisor
+
+import (
+	"local/bff/pkg/query_storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIndexDef(t *testing.T) {
+	testCases := []struct {
+		def      string
+		expected *indexDef
+	}{
+		{
+			def:      "CREATE UNIQUE INDEX orders_pkey ON public.orders USING btree (id)",
+			expected: &indexDef{method: "btree", keys: []string{"id"}},
+		},
+		{
+			def: `CREATE INDEX "Orders idx" ON public.orders USING btree (customer_id, lower((email)::text), "Created, At" DESC) INCLUDE (total) WHERE (deleted_at IS NULL)`,
+			expected: &indexDef{
+				method:    "btree",
+				keys:      []string{"customer_id", "lower((email)::text)", `"Created, At" DESC`},
+				include:   []string{"total"},
+				predicate: "(deleted_at IS NULL)",
+			},
+		},
+		{
+			def:      "CREATE INDEX docs_body_idx ON public.docs USING gin (to_tsvector('english'::regconfig, body)) WITH (fastupdate=off)",
+			expected: &indexDef{method: "gin", keys: []string{"to_tsvector('english'::regconfig, body)"}},
+		},
+		{def: "CREATE INDEX broken ON public.docs USING btree (a", expected: nil},
+		{def: "not an index", expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.def, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseIndexDef(tc.def))
+		})
+	}
+}
+
+func TestAdviseIndexes(t *testing.T) {
+	now := time.Unix(100*24*3600, 0)
+	longAgo := now.Add(-60 * 24 * time.Hour).Unix()
+	recently := now.Add(-time.Hour).Unix()
+
+	index := func(name, def string, size int64, scansChangedAt int64) query_storage.SystemIndex {
+		return query_storage.SystemIndex{
+			Database: "app", Schema: "public", Relation: "orders", Name: name, Definition: def, Type: "btree",
+			IsValid: true, SizeBytes: size, ScansChangedAt: scansChangedAt, FirstSeen: longAgo,
+		}
+	}
+
+	pkey := index("orders_pkey", "CREATE UNIQUE INDEX orders_pkey ON public.orders USING btree (id)", 1000, longAgo)
+	pkey.IsPrimary, pkey.IsUnique, pkey.ConstraintDef = true, true, "PRIMARY KEY (id)"
+	uniqueID := index("orders_id_key", "CREATE UNIQUE INDEX orders_id_key ON public.orders USING btree (id)", 900, recently)
+	uniqueID.IsUnique = true
+	customer := index("orders_customer_idx", "CREATE INDEX orders_customer_idx ON public.orders USING btree (customer_id)", 500, recently)
+	customerCreated := index("orders_customer_created_idx", "CREATE INDEX orders_customer_created_idx ON public.orders USING btree (customer_id, created_at)", 800, recently)
+	customerCopy := index("orders_customer_idx1", "CREATE INDEX orders_customer_idx1 ON public.orders USING btree (customer_id)", 500, longAgo)
+	status := index("orders_status_idx", "CREATE INDEX orders_status_idx ON public.orders USING btree (status)", 300, longAgo)
+	newStatus := index("orders_status_new_idx", "CREATE INDEX orders_status_new_idx ON public.orders USING hash (status)", 200, now.Unix())
+	newStatus.FirstSeen = recently
+	invalid := index("orders_total_idx", "CREATE INDEX CONCURRENTLY orders_total_idx ON public.orders USING btree (total)", 100, longAgo)
+	invalid.IsValid = false
+	otherDB := index("orders_status_idx", "CREATE INDEX orders_status_idx ON public.orders USING btree (status)", 300, recently)
+	otherDB.Database = "archive"
+
+	advice := AdviseIndexes([]query_storage.SystemIndex{
+		pkey, uniqueID, customer, customerCreated, customerCopy, status, newStatus, invalid, otherDB,
+	}, now, 30*24*time.Hour)
+
+	type finding struct{ kind, index, coveredBy string }
+	var got []finding
+	for _, f := range advice.Findings {
+		got = append(got, finding{f.Kind, f.Index, f.CoveredBy})
+	}
+	assert.Equal(t, []finding{
+		{IndexInvalid, "orders_total_idx", ""},
+		{IndexDuplicate, "orders_id_key", "orders_pkey"},
+		{IndexDuplicate, "orders_customer_idx1", "orders_customer_idx"},
+		{IndexPrefix, "orders_customer_idx", "orders_customer_created_idx"},
+		{IndexUnused, "orders_status_idx", ""},
+	}, got)
+	assert.Equal(t, int64(100+900+500+500+300), advice.EstimatedSavingsBytes)
+
+	require.NotEmpty(t, advice.Findings)
+	assert.Equal(t, `DROP INDEX CONCURRENTLY IF EXISTS "public"."orders_total_idx";`, advice.Findings[0].DropStatement)
+	assert.Equal(t, "the index has not been scanned in the last 30 days", advice.Findings[4].Reason)
+	assert.Contains(t, advice.DropScript, "-- Database: app\n-- invalid, 100 B: ")
+	assert.Contains(t, advice.DropScript, `DROP INDEX CONCURRENTLY IF EXISTS "public"."orders_status_idx";`)
+
+	t.Run("Idle covering index", func(t *testing.T) {
+		idleCustomerCreated := customerCreated
+		idleCustomerCreated.ScansChangedAt = longAgo
+		advice := AdviseIndexes([]query_storage.SystemIndex{customer, idleCustomerCreated}, now, 30*24*time.Hour)
+
+		require.Len(t, advice.Findings, 1, "the index covering the prefix index is not reported as unused")
+		assert.Equal(t, IndexPrefix, advice.Findings[0].Kind)
+		assert.Equal(t, "orders_customer_created_idx", advice.Findings[0].CoveredBy)
+		assert.NotContains(t, advice.DropScript, `DROP INDEX CONCURRENTLY IF EXISTS "public"."orders_customer_created_idx";`)
+	})
+}
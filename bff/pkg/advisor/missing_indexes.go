@@ -0,0 +1,148 @@
+package advisor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// TableScans is how a table was read over a time window, from the
+// cc_relation_* metrics. Counts are increases over the window; size and live
+// tuples are the latest values.
+type TableScans struct {
+	Database   string
+	Schema     string
+	Relation   string
+	SizeBytes  float64
+	LiveTuples float64
+	SeqScans   float64
+	SeqTupRead float64
+	IdxScans   float64
+}
+
+// QueryLoad is the load of a query over the same window, either from query
+// statistics (calls and total time) or from activity snapshots (the active
+// sessions sampled running it)
+type QueryLoad struct {
+	Fingerprint      string  `json:"query_fp,omitempty"`
+	Query            string  `json:"query"`
+	Calls            float64 `json:"calls,omitempty"`
+	TotalTimeSeconds float64 `json:"total_time_seconds,omitempty"`
+	ActiveSamples    float64 `json:"active_samples,omitempty"`
+}
+
+// MissingIndexThresholds decide which tables are read sequentially often and
+// widely enough to be worth an index
+type MissingIndexThresholds struct {
+	// MinSizeBytes skips small tables, which are cheaper to scan than to
+	// index
+	MinSizeBytes float64
+	// MinSeqScans is the minimum number of sequential scans in the window
+	MinSeqScans float64
+	// MinRowsPerSeqScan skips tables whose scans stop early, e.g. with LIMIT
+	MinRowsPerSeqScan float64
+	// MinSeqScanRatio is the minimum share of sequential scans among all
+	// scans of the table
+	MinSeqScanRatio float64
+}
+
+func DefaultMissingIndexThresholds() MissingIndexThresholds {
+	return MissingIndexThresholds{
+		MinSizeBytes:      8 << 20,
+		MinSeqScans:       10,
+		MinRowsPerSeqScan: 1000,
+		MinSeqScanRatio:   0.1,
+	}
+}
+
+// maxHintQueries is how many queries are listed for each table
+const maxHintQueries = 5
+
+// MissingIndexHint is a table whose sequential scans suggest a missing index.
+// EstimatedReadBytes estimates the heap read by those scans over the window
+// as the tuples they read times the average tuple size; it ranks the hints
+// and ignores caching. Queries are the heaviest queries mentioning the table.
+type MissingIndexHint struct {
+	Database           string      `json:"datname"`
+	Schema             string      `json:"schema"`
+	Relation           string      `json:"relation"`
+	SizeBytes          float64     `json:"size_bytes"`
+	LiveTuples         float64     `json:"live_tuples"`
+	SeqScans           float64     `json:"seq_scans"`
+	SeqTupRead         float64     `json:"seq_tup_read"`
+	IdxScans           float64     `json:"idx_scans"`
+	RowsPerSeqScan     float64     `json:"rows_per_seq_scan"`
+	SeqScanRatio       float64     `json:"seq_scan_ratio"`
+	EstimatedReadBytes float64     `json:"estimated_read_bytes"`
+	Reason             string      `json:"reason"`
+	Queries            []QueryLoad `json:"queries"`
+}
+
+// AdviseMissingIndexes returns the tables read by large and frequent
+// sequential scans, by estimated I/O impact, each with the queries that
+// mention it. Matching queries by table name is a heuristic: a query can
+// mention a table of the same name in another schema or database.
+func AdviseMissingIndexes(tables []TableScans, queries []QueryLoad, t MissingIndexThresholds) []MissingIndexHint {
+	hints := []MissingIndexHint{}
+	for _, table := range tables {
+		if table.SizeBytes < t.MinSizeBytes || table.SeqScans < t.MinSeqScans || table.SeqScans <= 0 {
+			continue
+		}
+		rowsPerScan := table.SeqTupRead / table.SeqScans
+		ratio := table.SeqScans / (table.SeqScans + table.IdxScans)
+		if rowsPerScan < t.MinRowsPerSeqScan || ratio < t.MinSeqScanRatio {
+			continue
+		}
+
+		tupleBytes := table.SizeBytes
+		if table.LiveTuples >= 1 {
+			tupleBytes = table.SizeBytes / table.LiveTuples
+		}
+
+		hints = append(hints, MissingIndexHint{
+			Database:           table.Database,
+			Schema:             table.Schema,
+			Relation:           table.Relation,
+			SizeBytes:          table.SizeBytes,
+			LiveTuples:         table.LiveTuples,
+			SeqScans:           table.SeqScans,
+			SeqTupRead:         table.SeqTupRead,
+			IdxScans:           table.IdxScans,
+			RowsPerSeqScan:     rowsPerScan,
+			SeqScanRatio:       ratio,
+			EstimatedReadBytes: table.SeqTupRead * tupleBytes,
+			Reason: fmt.Sprintf("%.0f sequential scans read %.0f rows each on average, %.0f%% of the scans of the table",
+				table.SeqScans, rowsPerScan, ratio*100),
+			Queries: queriesMentioning(table.Relation, queries),
+		})
+	}
+
+	sort.SliceStable(hints, func(i, j int) bool {
+		return hints[i].EstimatedReadBytes > hints[j].EstimatedReadBytes
+	})
+	return hints
+}
+
+// queriesMentioning returns the heaviest queries whose text names relation,
+// queries from statistics by total time first, then those from activity by
+// active samples
+func queriesMentioning(relation string, queries []QueryLoad) []QueryLoad {
+	pattern := regexp.MustCompile(`(?i)(^|[^\w$])"?` + regexp.QuoteMeta(relation) + `"?([^\w$]|$)`)
+
+	matched := []QueryLoad{}
+	for _, q := range queries {
+		if pattern.MatchString(q.Query) {
+			matched = append(matched, q)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].TotalTimeSeconds != matched[j].TotalTimeSeconds {
+			return matched[i].TotalTimeSeconds > matched[j].TotalTimeSeconds
+		}
+		return matched[i].ActiveSamples > matched[j].ActiveSamples
+	})
+	if len(matched) > maxHintQueries {
+		matched = matched[:maxHintQueries]
+	}
+	return matched
+}
@@ -0,0 +1,51 @@
+package advisor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdviseMissingIndexes(t *testing.T) {
+	const mib = 1 << 20
+	tables := []TableScans{
+		// 100 MiB over 100k rows: 1 KiB per row
+		{Database: "app", Schema: "public", Relation: "orders", SizeBytes: 100 * mib, LiveTuples: 100000, SeqScans: 50, SeqTupRead: 5000000, IdxScans: 50},
+		{Database: "app", Schema: "public", Relation: "events", SizeBytes: 1000 * mib, LiveTuples: 10000000, SeqScans: 20, SeqTupRead: 200000000, IdxScans: 100},
+		{Database: "app", Schema: "public", Relation: "countries", SizeBytes: 1 * mib, LiveTuples: 200, SeqScans: 100000, SeqTupRead: 20000000},
+		{Database: "app", Schema: "public", Relation: "jobs", SizeBytes: 50 * mib, LiveTuples: 100000, SeqScans: 1000, SeqTupRead: 1000},
+		{Database: "app", Schema: "public", Relation: "users", SizeBytes: 50 * mib, LiveTuples: 100000, SeqScans: 100, SeqTupRead: 10000000, IdxScans: 100000},
+		{Database: "app", Schema: "public", Relation: "audit", SizeBytes: 50 * mib, LiveTuples: 100000, SeqScans: 5, SeqTupRead: 500000},
+	}
+	queries := []QueryLoad{
+		{Query: "SELECT * FROM orders WHERE customer_id = $1", Calls: 50, TotalTimeSeconds: 30},
+		{Query: `SELECT count(*) FROM "orders" o JOIN events e ON e.order_id = o.id`, Calls: 2, TotalTimeSeconds: 60},
+		{Query: "SELECT * FROM orders_archive WHERE id = $1", Calls: 500, TotalTimeSeconds: 100},
+		{Fingerprint: "fp1", Query: "DELETE FROM orders WHERE created_at < $1", ActiveSamples: 12},
+	}
+
+	hints := AdviseMissingIndexes(tables, queries, DefaultMissingIndexThresholds())
+	require.Len(t, hints, 2, "small, early stopping, mostly indexed and rarely scanned tables are skipped")
+
+	events, orders := hints[0], hints[1]
+	assert.Equal(t, "events", events.Relation)
+	assert.Equal(t, 200000000*104.8576, events.EstimatedReadBytes)
+
+	assert.Equal(t, "orders", orders.Relation)
+	assert.Equal(t, 100000.0, orders.RowsPerSeqScan)
+	assert.Equal(t, 0.5, orders.SeqScanRatio)
+	assert.Equal(t, 5000000*1048.576, orders.EstimatedReadBytes)
+	assert.Equal(t, "50 sequential scans read 100000 rows each on average, 50% of the scans of the table", orders.Reason)
+
+	var matched []string
+	for _, q := range orders.Queries {
+		matched = append(matched, q.Query)
+	}
+	assert.Equal(t, []string{
+		`SELECT count(*) FROM "orders" o JOIN events e ON e.order_id = o.id`,
+		"SELECT * FROM orders WHERE customer_id = $1",
+		"DELETE FROM orders WHERE created_at < $1",
+	}, matched)
+	assert.Len(t, events.Queries, 1)
+}
@@ -0,0 +1,309 @@
+package advisor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Setting finding severities, from least to most urgent
+const (
+	SettingInfo     = "info"
+	SettingWarning  = "warning"
+	SettingCritical = "critical"
+)
+
+var settingSeverityRank = map[string]int{SettingCritical: 0, SettingWarning: 1, SettingInfo: 2}
+
+// Setting is the current value of a server setting as reported in
+// pg_settings: numeric, in the setting's unit (e.g. 8kB pages for
+// shared_buffers), with booleans as 1 and 0
+type Setting struct {
+	Value float64
+	Unit  string
+}
+
+// Host is what the settings are compared against, from the same snapshot.
+// Zero values are unknown, and the rules that need them are skipped.
+type Host struct {
+	MemoryBytes  float64  `json:"memory_bytes"`
+	LogicalCores float64  `json:"logical_cores"`
+	DiskTypes    []string `json:"disk_types"`
+}
+
+// SettingFinding is a setting whose value looks wrong for the host, with the
+// value to consider instead. Values are formatted as they would be written in
+// postgresql.conf.
+type SettingFinding struct {
+	Setting        string `json:"setting"`
+	Severity       string `json:"severity"`
+	CurrentValue   string `json:"current_value"`
+	SuggestedValue string `json:"suggested_value"`
+	Rationale      string `json:"rationale"`
+}
+
+// SettingsAdvice lists the findings of a system with the host facts they were
+// derived from
+type SettingsAdvice struct {
+	Host     Host             `json:"host"`
+	Findings []SettingFinding `json:"findings"`
+}
+
+const (
+	kiB = 1 << 10
+	miB = 1 << 20
+	giB = 1 << 30
+)
+
+// AdviseSettings checks the memory, autovacuum, planner cost and parallelism
+// settings against the host. Findings are ordered by severity, then setting.
+func AdviseSettings(settings map[string]Setting, host Host) SettingsAdvice {
+	r := settingRules{settings: settings, host: host, findings: []SettingFinding{}}
+	r.memory()
+	r.autovacuum()
+	r.disk()
+	r.parallelism()
+
+	sort.SliceStable(r.findings, func(i, j int) bool {
+		a, b := r.findings[i], r.findings[j]
+		if a.Severity != b.Severity {
+			return settingSeverityRank[a.Severity] < settingSeverityRank[b.Severity]
+		}
+		return a.Setting < b.Setting
+	})
+	return SettingsAdvice{Host: host, Findings: r.findings}
+}
+
+type settingRules struct {
+	settings map[string]Setting
+	host     Host
+	findings []SettingFinding
+}
+
+func (r *settingRules) add(setting, severity, current, suggested, rationale string) {
+	r.findings = append(r.findings, SettingFinding{
+		Setting:        setting,
+		Severity:       severity,
+		CurrentValue:   current,
+		SuggestedValue: suggested,
+		Rationale:      rationale,
+	})
+}
+
+func (r *settingRules) value(name string) (float64, bool) {
+	s, ok := r.settings[name]
+	return s.Value, ok
+}
+
+// bytes returns a memory setting in bytes
+func (r *settingRules) bytes(name string) (float64, bool) {
+	s, ok := r.settings[name]
+	if !ok {
+		return 0, false
+	}
+	unit, ok := unitBytes(s.Unit)
+	if !ok || s.Value < 0 {
+		return 0, false
+	}
+	return s.Value * unit, true
+}
+
+// aurora reports whether the settings are those of Aurora PostgreSQL, which
+// sizes shared_buffers and storage differently from PostgreSQL
+func (r *settingRules) aurora() bool {
+	for name := range r.settings {
+		if strings.HasPrefix(name, "apg_") || strings.HasPrefix(name, "aurora_") {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *settingRules) memory() {
+	memory := r.host.MemoryBytes
+	if memory <= 0 {
+		return
+	}
+	share := func(b float64) string { return fmt.Sprintf("%.0f%%", b/memory*100) }
+
+	sharedBuffers, hasSharedBuffers := r.bytes("shared_buffers")
+	if hasSharedBuffers {
+		suggested := formatSettingBytes(roundDownMB(memory * 0.25))
+		switch ratio := sharedBuffers / memory; {
+		case ratio < 0.15 && memory >= giB:
+			r.add("shared_buffers", SettingWarning, formatSettingBytes(sharedBuffers), suggested,
+				fmt.Sprintf("shared_buffers is %s of the host's %s of memory. About 25%% keeps the working set in PostgreSQL's cache while leaving the rest to the OS page cache.",
+					share(sharedBuffers), formatSettingBytes(memory)))
+		case ratio > 0.4 && !r.aurora():
+			r.add("shared_buffers", SettingWarning, formatSettingBytes(sharedBuffers), suggested,
+				fmt.Sprintf("shared_buffers is %s of the host's %s of memory, which leaves little for connections, maintenance and the OS page cache, and caches most pages twice.",
+					share(sharedBuffers), formatSettingBytes(memory)))
+		}
+	}
+
+	if cache, ok := r.bytes("effective_cache_size"); ok {
+		suggested := formatSettingBytes(roundDownMB(memory * 0.75))
+		switch ratio := cache / memory; {
+		case ratio < 0.5:
+			r.add("effective_cache_size", SettingInfo, formatSettingBytes(cache), suggested,
+				fmt.Sprintf("effective_cache_size is %s of the host's memory. The planner underestimates how much data is cached and may avoid index scans; 50-75%% of memory is typical.",
+					share(cache)))
+		case ratio > 1:
+			r.add("effective_cache_size", SettingWarning, formatSettingBytes(cache), suggested,
+				fmt.Sprintf("effective_cache_size is larger than the host's %s of memory. The planner overestimates how much data is cached and may favor index scans that read from disk.",
+					formatSettingBytes(memory)))
+		}
+	}
+
+	workMem, hasWorkMem := r.bytes("work_mem")
+	connections, hasConnections := r.value("max_connections")
+	if hasWorkMem && hasConnections && connections > 0 {
+		available := memory - sharedBuffers
+		if total := workMem * connections; total > available {
+			severity := SettingWarning
+			if total > 2*memory {
+				severity = SettingCritical
+			}
+			// Leave room for a second sort or hash per query
+			suggested := math.Max(roundDownMB(available/connections/2), miB)
+			r.add("work_mem", severity, formatSettingBytes(workMem), formatSettingBytes(suggested),
+				fmt.Sprintf("work_mem × max_connections (%s × %.0f = %s) exceeds the %s of memory left after shared_buffers. Each sort or hash in a query can use work_mem, so busy periods can run the host out of memory. Lower work_mem, or raise it per role or session for the queries that need it.",
+					formatSettingBytes(workMem), connections, formatSettingBytes(total), formatSettingBytes(available)))
+		}
+	}
+}
+
+func (r *settingRules) autovacuum() {
+	if enabled, ok := r.value("autovacuum"); ok && enabled == 0 {
+		r.add("autovacuum", SettingCritical, "off", "on",
+			"autovacuum is off. Dead tuples accumulate, statistics go stale, and tables are only frozen by emergency anti-wraparound vacuums.")
+	}
+
+	if factor, ok := r.value("autovacuum_vacuum_scale_factor"); ok && factor > 0.2 {
+		r.add("autovacuum_vacuum_scale_factor", SettingWarning, formatNumber(factor), "0.1",
+			fmt.Sprintf("Tables are vacuumed once %.0f%% of their rows are dead, which lets large tables bloat between vacuums.", factor*100))
+	}
+
+	if factor, ok := r.value("autovacuum_analyze_scale_factor"); ok && factor > 0.1 {
+		r.add("autovacuum_analyze_scale_factor", SettingInfo, formatNumber(factor), "0.05",
+			fmt.Sprintf("Tables are analyzed once %.0f%% of their rows change, so plans for large tables are made with stale statistics.", factor*100))
+	}
+
+	if naptime, ok := r.value("autovacuum_naptime"); ok && naptime > 60 {
+		r.add("autovacuum_naptime", SettingInfo, formatNumber(naptime)+"s", "60s",
+			fmt.Sprintf("The autovacuum launcher checks each database every %.0fs, which delays vacuums on busy tables.", naptime))
+	}
+
+	if workers, ok := r.value("autovacuum_max_workers"); ok && r.host.LogicalCores > 0 && workers > r.host.LogicalCores {
+		r.add("autovacuum_max_workers", SettingInfo, formatNumber(workers), formatNumber(r.host.LogicalCores),
+			fmt.Sprintf("%.0f autovacuum workers can run at once on %.0f cores and compete with queries for CPU.", workers, r.host.LogicalCores))
+	}
+}
+
+// Disk types reported by the collector, from the kernel's rotational flag or
+// the cloud provider's volume type
+var (
+	rotationalDiskTypes = map[string]bool{"hdd": true, "standard": true, "magnetic": true, "st1": true, "sc1": true}
+	solidStateDiskTypes = map[string]bool{"ssd": true, "nvme": true, "gp2": true, "gp3": true, "io1": true, "io2": true}
+)
+
+func (r *settingRules) disk() {
+	var rotational, solidState int
+	for _, diskType := range r.host.DiskTypes {
+		switch t := strings.ToLower(diskType); {
+		case rotationalDiskTypes[t]:
+			rotational++
+		case solidStateDiskTypes[t]:
+			solidState++
+		}
+	}
+
+	randomPageCost, hasRandomPageCost := r.value("random_page_cost")
+	switch {
+	case solidState > 0 && rotational == 0:
+		if hasRandomPageCost && randomPageCost > 2 {
+			r.add("random_page_cost", SettingWarning, formatNumber(randomPageCost), "1.1",
+				"The data is on solid-state storage, where random reads cost about as much as sequential ones. A higher cost models spinning disks and makes the planner prefer sequential scans over index scans.")
+		}
+		if concurrency, ok := r.value("effective_io_concurrency"); ok && concurrency < 100 {
+			r.add("effective_io_concurrency", SettingInfo, formatNumber(concurrency), "200",
+				"Solid-state storage serves many concurrent reads. A higher value lets bitmap heap scans prefetch more pages.")
+		}
+	case rotational > 0 && solidState == 0:
+		if hasRandomPageCost && randomPageCost < 2 {
+			r.add("random_page_cost", SettingWarning, formatNumber(randomPageCost), "4",
+				"The data is on rotational storage, where random reads are several times costlier than sequential ones. A low cost makes the planner favor index scans that seek across the disk.")
+		}
+	}
+}
+
+func (r *settingRules) parallelism() {
+	cores := r.host.LogicalCores
+
+	parallelWorkers, hasParallelWorkers := r.value("max_parallel_workers")
+	if hasParallelWorkers && cores > 0 && parallelWorkers > cores {
+		r.add("max_parallel_workers", SettingInfo, formatNumber(parallelWorkers), formatNumber(cores),
+			fmt.Sprintf("Up to %.0f parallel workers can run on %.0f cores, so parallel queries slow down everything else instead of finishing sooner.", parallelWorkers, cores))
+	}
+
+	if perGather, ok := r.value("max_parallel_workers_per_gather"); ok && cores > 0 && perGather >= cores {
+		r.add("max_parallel_workers_per_gather", SettingWarning, formatNumber(perGather), formatNumber(math.Max(math.Floor(cores/2), 1)),
+			fmt.Sprintf("A single query can use %.0f workers on %.0f cores and occupy the whole host.", perGather, cores))
+	}
+
+	if workerProcesses, ok := r.value("max_worker_processes"); ok && hasParallelWorkers && workerProcesses < parallelWorkers {
+		r.add("max_worker_processes", SettingInfo, formatNumber(workerProcesses), formatNumber(parallelWorkers),
+			"Parallel workers are taken from max_worker_processes, so fewer than max_parallel_workers can ever start.")
+	}
+}
+
+// unitBytes returns the size in bytes of a memory unit from pg_settings, such
+// as "8kB" or "MB"
+func unitBytes(unit string) (float64, bool) {
+	multiples := []struct {
+		suffix string
+		bytes  float64
+	}{{"TB", 1 << 40}, {"GB", giB}, {"MB", miB}, {"kB", kiB}, {"B", 1}}
+
+	for _, m := range multiples {
+		if !strings.HasSuffix(unit, m.suffix) {
+			continue
+		}
+		count := 1.0
+		if prefix := strings.TrimSuffix(unit, m.suffix); prefix != "" {
+			n, err := strconv.ParseFloat(prefix, 64)
+			if err != nil || n <= 0 {
+				return 0, false
+			}
+			count = n
+		}
+		return count * m.bytes, true
+	}
+	return 0, false
+}
+
+func roundDownMB(b float64) float64 {
+	return math.Floor(b/miB) * miB
+}
+
+// formatSettingBytes formats a size with the largest unit that represents it
+// exactly, as postgresql.conf would
+func formatSettingBytes(b float64) string {
+	n := int64(b)
+	switch {
+	case n != 0 && n%giB == 0:
+		return fmt.Sprintf("%dGB", n/giB)
+	case n != 0 && n%miB == 0:
+		return fmt.Sprintf("%dMB", n/miB)
+	case n%kiB == 0:
+		return fmt.Sprintf("%dkB", n/kiB)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,165 @@
+package advisor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdviseSettings(t *testing.T) {
+	host := Host{MemoryBytes: 16 * giB, LogicalCores: 4, DiskTypes: []string{"gp3"}}
+	settings := map[string]Setting{
+		// 128MB in 8kB pages
+		"shared_buffers":                  {Value: 16384, Unit: "8kB"},
+		"effective_cache_size":            {Value: 524288, Unit: "8kB"},
+		"work_mem":                        {Value: 262144, Unit: "kB"},
+		"max_connections":                 {Value: 100},
+		"autovacuum":                      {Value: 0},
+		"autovacuum_vacuum_scale_factor":  {Value: 0.2},
+		"autovacuum_analyze_scale_factor": {Value: 0.2},
+		"random_page_cost":                {Value: 4},
+		"effective_io_concurrency":        {Value: 1},
+		"max_parallel_workers":            {Value: 8},
+		"max_parallel_workers_per_gather": {Value: 2},
+		"max_worker_processes":            {Value: 8},
+	}
+
+	advice := AdviseSettings(settings, host)
+	assert.Equal(t, host, advice.Host)
+
+	bySetting := make(map[string]SettingFinding)
+	var order []string
+	for _, f := range advice.Findings {
+		bySetting[f.Setting] = f
+		order = append(order, f.Setting)
+	}
+	assert.Equal(t, []string{
+		"autovacuum",
+		"random_page_cost",
+		"shared_buffers",
+		"work_mem",
+		"autovacuum_analyze_scale_factor",
+		"effective_cache_size",
+		"effective_io_concurrency",
+		"max_parallel_workers",
+	}, order, "findings are ordered by severity, then setting")
+
+	sharedBuffers := bySetting["shared_buffers"]
+	assert.Equal(t, "128MB", sharedBuffers.CurrentValue)
+	assert.Equal(t, "4GB", sharedBuffers.SuggestedValue)
+	assert.Contains(t, sharedBuffers.Rationale, "1% of the host's 16GB of memory")
+
+	assert.Equal(t, SettingInfo, bySetting["effective_cache_size"].Severity)
+	assert.Equal(t, "4GB", bySetting["effective_cache_size"].CurrentValue)
+	assert.Equal(t, "12GB", bySetting["effective_cache_size"].SuggestedValue)
+
+	// 256MB × 100 = 25GB, over the 16256MB left after shared_buffers
+	workMem := bySetting["work_mem"]
+	assert.Equal(t, SettingWarning, workMem.Severity)
+	assert.Equal(t, "256MB", workMem.CurrentValue)
+	assert.Equal(t, "81MB", workMem.SuggestedValue)
+	assert.Contains(t, workMem.Rationale, "256MB × 100 = 25GB")
+
+	assert.Equal(t, SettingFinding{
+		Setting:        "autovacuum",
+		Severity:       SettingCritical,
+		CurrentValue:   "off",
+		SuggestedValue: "on",
+		Rationale:      "autovacuum is off. Dead tuples accumulate, statistics go stale, and tables are only frozen by emergency anti-wraparound vacuums.",
+	}, bySetting["autovacuum"])
+
+	assert.Equal(t, "1.1", bySetting["random_page_cost"].SuggestedValue)
+	assert.Equal(t, "0.05", bySetting["autovacuum_analyze_scale_factor"].SuggestedValue)
+	assert.Equal(t, "4", bySetting["max_parallel_workers"].SuggestedValue)
+}
+
+func TestAdviseSettingsRules(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings map[string]Setting
+		host     Host
+		expected map[string]string
+	}{
+		{
+			name:     "No host facts",
+			settings: map[string]Setting{"shared_buffers": {Value: 16, Unit: "8kB"}, "random_page_cost": {Value: 4}, "max_parallel_workers": {Value: 64}},
+			expected: map[string]string{},
+		},
+		{
+			name:     "Large shared_buffers",
+			settings: map[string]Setting{"shared_buffers": {Value: 6, Unit: "GB"}},
+			host:     Host{MemoryBytes: 8 * giB},
+			expected: map[string]string{"shared_buffers": "2GB"},
+		},
+		{
+			name:     "Large shared_buffers on Aurora",
+			settings: map[string]Setting{"shared_buffers": {Value: 6, Unit: "GB"}, "apg_plan_mgmt.capture_plan_baselines": {}},
+			host:     Host{MemoryBytes: 8 * giB},
+			expected: map[string]string{},
+		},
+		{
+			name:     "Small host",
+			settings: map[string]Setting{"shared_buffers": {Value: 16384, Unit: "8kB"}},
+			host:     Host{MemoryBytes: 512 * miB},
+			expected: map[string]string{},
+		},
+		{
+			name:     "Cache larger than memory",
+			settings: map[string]Setting{"effective_cache_size": {Value: 32, Unit: "GB"}},
+			host:     Host{MemoryBytes: 16 * giB},
+			expected: map[string]string{"effective_cache_size": "12GB"},
+		},
+		{
+			name:     "Rotational disk",
+			settings: map[string]Setting{"random_page_cost": {Value: 1.1}, "effective_io_concurrency": {Value: 1}},
+			host:     Host{DiskTypes: []string{"hdd"}},
+			expected: map[string]string{"random_page_cost": "4"},
+		},
+		{
+			name:     "Mixed disks",
+			settings: map[string]Setting{"random_page_cost": {Value: 4}},
+			host:     Host{DiskTypes: []string{"hdd", "ssd"}},
+			expected: map[string]string{},
+		},
+		{
+			name: "Autovacuum",
+			settings: map[string]Setting{
+				"autovacuum":                     {Value: 1},
+				"autovacuum_vacuum_scale_factor": {Value: 0.4},
+				"autovacuum_naptime":             {Value: 300, Unit: "s"},
+				"autovacuum_max_workers":         {Value: 6},
+			},
+			host:     Host{LogicalCores: 2},
+			expected: map[string]string{"autovacuum_vacuum_scale_factor": "0.1", "autovacuum_naptime": "60s", "autovacuum_max_workers": "2"},
+		},
+		{
+			name:     "Parallelism",
+			settings: map[string]Setting{"max_parallel_workers": {Value: 8}, "max_parallel_workers_per_gather": {Value: 4}, "max_worker_processes": {Value: 4}},
+			host:     Host{LogicalCores: 4},
+			expected: map[string]string{"max_parallel_workers": "4", "max_parallel_workers_per_gather": "2", "max_worker_processes": "8"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suggested := make(map[string]string)
+			for _, f := range AdviseSettings(tc.settings, tc.host).Findings {
+				suggested[f.Setting] = f.SuggestedValue
+			}
+			assert.Equal(t, tc.expected, suggested)
+		})
+	}
+}
+
+func TestUnitBytes(t *testing.T) {
+	for unit, expected := range map[string]float64{"B": 1, "kB": kiB, "8kB": 8 * kiB, "16MB": 16 * miB, "GB": giB} {
+		b, ok := unitBytes(unit)
+		require.True(t, ok, unit)
+		assert.Equal(t, expected, b, unit)
+	}
+	for _, unit := range []string{"", "ms", "s", "xkB"} {
+		_, ok := unitBytes(unit)
+		assert.False(t, ok, unit)
+	}
+}
@@ -0,0 +1,184 @@
+package alerting
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Alert states. A pending alert has not yet been active for the For duration
+// of its rule; a resolved alert is kept for history until it is purged.
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert is one series of a rule, identified by the fingerprint of its labels.
+// Times are unix seconds; zero means not yet.
+type Alert struct {
+	Fingerprint     string            `json:"fingerprint"`
+	Rule            string            `json:"rule"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+	State           string            `json:"state"`
+	Value           float64           `json:"value"`
+	ActiveAt        int64             `json:"active_at"`
+	FiredAt         int64             `json:"fired_at,omitempty"`
+	ResolvedAt      int64             `json:"resolved_at,omitempty"`
+	LastEvaluatedAt int64             `json:"last_evaluated_at"`
+	NotifiedState   string            `json:"notified_state,omitempty"`
+	LastNotifiedAt  int64             `json:"last_notified_at,omitempty"`
+	Silenced        bool              `json:"silenced"`
+}
+
+// fingerprint identifies a set of labels independently of their order
+func fingerprint(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(labels[name]))
+		h.Write([]byte{0xff})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// alertLabels are the labels of the alert for a series of rule: the series
+// labels, overridden by the rule labels, its name and severity
+func alertLabels(rule Rule, series map[string]string) map[string]string {
+	labels := make(map[string]string, len(series)+len(rule.Labels)+2)
+	for name, value := range series {
+		if name != "__name__" {
+			labels[name] = value
+		}
+	}
+	for name, value := range rule.Labels {
+		labels[name] = value
+	}
+	labels["alertname"] = rule.Name
+	labels["severity"] = rule.Severity
+	return labels
+}
+
+// expandAnnotations fills in the Prometheus style {{ $labels.<name> }} and
+// {{ $value }} references of annotations. An annotation that is not a valid
+// template is kept as written.
+func expandAnnotations(annotations map[string]string, labels map[string]string, value float64) map[string]string {
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{labels, value}
+
+	expanded := make(map[string]string, len(annotations))
+	for name, text := range annotations {
+		expanded[name] = text
+
+		tmpl, err := template.New(name).Option("missingkey=zero").
+			Parse("{{$labels := .Labels}}{{$value := .Value}}" + text)
+		if err != nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err == nil {
+			expanded[name] = buf.String()
+		}
+	}
+	return expanded
+}
+
+// Matcher selects alerts by the value of one label
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"is_regex"`
+}
+
+func (m Matcher) validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("matcher name is required")
+	}
+	if m.IsRegex {
+		if _, err := regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+			return fmt.Errorf("matcher %s: invalid regex: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether labels satisfy m. Regexes are anchored, as in PromQL.
+func (m Matcher) matches(labels map[string]string) bool {
+	value := labels[m.Name]
+	if !m.IsRegex {
+		return value == m.Value
+	}
+	re, err := regexp.Compile("^(?:" + m.Value + ")$")
+	return err == nil && re.MatchString(value)
+}
+
+// Silence mutes the notifications of the alerts matching all of its matchers
+// between StartsAt and EndsAt, in unix seconds
+type Silence struct {
+	ID        int64     `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  int64     `json:"starts_at"`
+	EndsAt    int64     `json:"ends_at"`
+	CreatedBy string    `json:"created_by"`
+	Comment   string    `json:"comment"`
+	CreatedAt int64     `json:"created_at"`
+}
+
+// Validate checks a silence before it is stored
+func (s Silence) Validate() error {
+	if len(s.Matchers) == 0 {
+		return fmt.Errorf("a silence needs at least one matcher")
+	}
+	for _, m := range s.Matchers {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+	if s.EndsAt <= s.StartsAt {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	if strings.TrimSpace(s.CreatedBy) == "" {
+		return fmt.Errorf("created_by is required")
+	}
+	return nil
+}
+
+// Active reports whether s is in effect at now
+func (s Silence) Active(now int64) bool {
+	return s.StartsAt <= now && now < s.EndsAt
+}
+
+// Silences reports whether s mutes an alert with the given labels
+func (s Silence) Silences(labels map[string]string) bool {
+	for _, m := range s.Matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// silenced reports whether any of silences mutes labels at now
+func silenced(silences []Silence, labels map[string]string, now int64) bool {
+	for _, s := range silences {
+		if s.Active(now) && s.Silences(labels) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+// Package alerting evaluates PromQL alert rules against Prometheus, tracks
+// the state of each alert in the bff state database and notifies webhooks
+// when alerts fire and resolve.
+package alerting
+
+import (
+	"fmt"
+	"local/bff/pkg/configutil"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Config is the "alerting" section of the bff config file
+type Config struct {
+	Enabled bool `mapstructure:"enabled"`
+	// EvaluationInterval is how often every rule is evaluated
+	EvaluationInterval string `mapstructure:"evaluation_interval"`
+	// RepeatInterval is how often a still firing alert is notified again
+	RepeatInterval string `mapstructure:"repeat_interval"`
+	// ResolvedRetention is how long resolved alerts are kept
+	ResolvedRetention string    `mapstructure:"resolved_retention"`
+	Webhooks          []Webhook `mapstructure:"webhooks"`
+	Rules             []Rule    `mapstructure:"rules"`
+}
+
+const (
+	defaultEvaluationInterval = time.Minute
+	defaultRepeatInterval     = 4 * time.Hour
+	defaultResolvedRetention  = 7 * 24 * time.Hour
+	defaultWebhookTimeout     = 10 * time.Second
+)
+
+// Rule severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Rule fires an alert for every series returned by Expr that keeps being
+// returned for at least For
+type Rule struct {
+	Name        string            `mapstructure:"name" json:"name"`
+	Expr        string            `mapstructure:"expr" json:"expr"`
+	For         string            `mapstructure:"for" json:"for"`
+	Severity    string            `mapstructure:"severity" json:"severity"`
+	Labels      map[string]string `mapstructure:"labels" json:"labels"`
+	Annotations map[string]string `mapstructure:"annotations" json:"annotations"`
+}
+
+// Webhook receives every notification as an HTTP POST of a JSON Payload
+type Webhook struct {
+	Name    string            `mapstructure:"name"`
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+	Timeout string            `mapstructure:"timeout"`
+}
+
+var ruleNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Validate checks the configuration without contacting Prometheus or the
+// webhooks
+func (c Config) Validate() error {
+	for _, field := range []struct{ name, value string }{
+		{"evaluation_interval", c.EvaluationInterval},
+		{"repeat_interval", c.RepeatInterval},
+		{"resolved_retention", c.ResolvedRetention},
+	} {
+		if _, err := configutil.ParseDuration(field.name, field.value, 0, true); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range c.Rules {
+		if !ruleNameRegex.MatchString(rule.Name) {
+			return fmt.Errorf("rules[%d]: name %q must match %s", i, rule.Name, ruleNameRegex)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rules[%d]: duplicate rule name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Expr == "" {
+			return fmt.Errorf("rule %s: expr is required", rule.Name)
+		}
+		if _, err := configutil.ParseDuration("rule "+rule.Name+": for", rule.For, 0, true); err != nil {
+			return err
+		}
+		switch rule.Severity {
+		case SeverityInfo, SeverityWarning, SeverityCritical:
+		default:
+			return fmt.Errorf("rule %s: severity must be one of %s, %s or %s", rule.Name, SeverityInfo, SeverityWarning, SeverityCritical)
+		}
+	}
+
+	for i, webhook := range c.Webhooks {
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhooks[%d]: url %q must be an http or https URL", i, webhook.URL)
+		}
+		if _, err := configutil.ParseDuration(fmt.Sprintf("webhooks[%d]: timeout", i), webhook.Timeout, 0, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
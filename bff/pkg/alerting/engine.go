@@ -0,0 +1,264 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"local/bff/pkg/configutil"
+	"local/bff/pkg/metrics"
+	"log"
+	"strconv"
+	"time"
+)
+
+type rule struct {
+	Rule
+	forDuration time.Duration
+}
+
+// Engine evaluates the alert rules on an interval, keeps their alerts in the
+// store and notifies the alerts that fired or resolved. Notifications are
+// deduplicated by alert: an alert is notified when it fires, again every
+// repeat interval while it keeps firing, and once when it resolves. Silenced
+// alerts are not notified; a failed notification is retried at the next
+// evaluation.
+type Engine struct {
+	rules             []rule
+	metrics           metrics.Service
+	store             *Store
+	notifier          Notifier
+	interval          time.Duration
+	repeatInterval    time.Duration
+	resolvedRetention time.Duration
+}
+
+func NewEngine(cfg Config, metrics_service metrics.Service, store *Store, notifier Notifier) (*Engine, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	e := &Engine{metrics: metrics_service, store: store, notifier: notifier}
+	e.interval, _ = configutil.ParseDuration("evaluation_interval", cfg.EvaluationInterval, defaultEvaluationInterval, true)
+	e.repeatInterval, _ = configutil.ParseDuration("repeat_interval", cfg.RepeatInterval, defaultRepeatInterval, true)
+	e.resolvedRetention, _ = configutil.ParseDuration("resolved_retention", cfg.ResolvedRetention, defaultResolvedRetention, true)
+	if e.interval == 0 {
+		e.interval = defaultEvaluationInterval
+	}
+
+	for _, r := range cfg.Rules {
+		forDuration, _ := configutil.ParseDuration("for", r.For, 0, true)
+		e.rules = append(e.rules, rule{Rule: r, forDuration: forDuration})
+	}
+	return e, nil
+}
+
+// Rules returns the configured rules
+func (e *Engine) Rules() []Rule {
+	rules := make([]Rule, len(e.rules))
+	for i, r := range e.rules {
+		rules[i] = r.Rule
+	}
+	return rules
+}
+
+func (e *Engine) Store() *Store {
+	return e.store
+}
+
+// Run evaluates the rules every evaluation interval until ctx is done
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Evaluate(ctx, time.Now()); err != nil {
+			log.Printf("Error evaluating alert rules: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate runs every rule at now, updates the alert states and sends the
+// notifications due. A rule whose query fails keeps its alerts as they are.
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) error {
+	stored, err := e.store.ListAlerts("")
+	if err != nil {
+		return err
+	}
+	alerts := make(map[string]Alert, len(stored))
+	for _, a := range stored {
+		alerts[a.Fingerprint] = a
+	}
+
+	evaluated := make(map[string]bool)
+	failedRules := make(map[string]bool)
+	ts := now.Unix()
+
+	for _, r := range e.rules {
+		samples, err := e.metrics.ExecuteRaw(r.Expr, map[string]string{
+			"start": strconv.FormatInt(now.UnixMilli(), 10),
+			"end":   strconv.FormatInt(now.UnixMilli(), 10),
+			"dim":   "alert",
+		})
+		if err != nil {
+			log.Printf("Error evaluating alert rule %s: %v", r.Name, err)
+			failedRules[r.Name] = true
+			continue
+		}
+
+		for _, sample := range samples {
+			series, value, ok := parseSample(sample)
+			if !ok {
+				continue
+			}
+
+			labels := alertLabels(r.Rule, series)
+			fp := fingerprint(labels)
+			if evaluated[fp] {
+				continue
+			}
+			evaluated[fp] = true
+
+			a, ok := alerts[fp]
+			if !ok || a.State == StateResolved {
+				// Keep what was notified, so a resolved notification still
+				// owed is not lost to the alert firing again
+				a = Alert{
+					Fingerprint:    fp,
+					Rule:           r.Name,
+					State:          StatePending,
+					ActiveAt:       ts,
+					NotifiedState:  a.NotifiedState,
+					LastNotifiedAt: a.LastNotifiedAt,
+				}
+			}
+			a.Labels = labels
+			a.Annotations = expandAnnotations(r.Annotations, labels, value)
+			a.Value = value
+			a.LastEvaluatedAt = ts
+			if a.State == StatePending && now.Sub(time.Unix(a.ActiveAt, 0)) >= r.forDuration {
+				a.State = StateFiring
+				a.FiredAt = ts
+			}
+			alerts[fp] = a
+		}
+	}
+
+	for fp, a := range alerts {
+		if evaluated[fp] || failedRules[a.Rule] || a.State == StateResolved {
+			continue
+		}
+		if a.State == StatePending && a.NotifiedState != StateFiring {
+			if err := e.store.DeleteAlert(fp); err != nil {
+				return err
+			}
+			delete(alerts, fp)
+			continue
+		}
+		a.State = StateResolved
+		a.ResolvedAt = ts
+		alerts[fp] = a
+	}
+
+	for _, a := range alerts {
+		if err := e.store.SaveAlert(a); err != nil {
+			return err
+		}
+	}
+
+	if err := e.notify(ctx, alerts, ts); err != nil {
+		log.Printf("Error sending alert notifications: %v", err)
+	}
+
+	return e.store.PurgeResolved(now.Add(-e.resolvedRetention).Unix())
+}
+
+// notify sends the notifications due for alerts and records them as sent
+func (e *Engine) notify(ctx context.Context, alerts map[string]Alert, now int64) error {
+	silences, err := e.store.ListSilences(now, false)
+	if err != nil {
+		return err
+	}
+
+	var due []Alert
+	for _, a := range alerts {
+		if !e.notificationDue(a, now) || silenced(silences, a.Labels, now) {
+			continue
+		}
+		due = append(due, a)
+	}
+	if len(due) == 0 || e.notifier == nil {
+		return nil
+	}
+
+	if err := e.notifier.Notify(ctx, due); err != nil {
+		return fmt.Errorf("notify %d alerts: %w", len(due), err)
+	}
+
+	for _, a := range due {
+		a.NotifiedState = a.State
+		a.LastNotifiedAt = now
+		if err := e.store.SaveAlert(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) notificationDue(a Alert, now int64) bool {
+	switch a.State {
+	case StateFiring:
+		return a.NotifiedState != StateFiring || now-a.LastNotifiedAt >= int64(e.repeatInterval.Seconds())
+	case StateResolved:
+		return a.NotifiedState == StateFiring
+	}
+	return false
+}
+
+// ListAlerts returns the stored alerts in state, or all of them, marking the
+// ones muted by an active silence
+func (e *Engine) ListAlerts(state string, now time.Time) ([]Alert, error) {
+	alerts, err := e.store.ListAlerts(state)
+	if err != nil {
+		return nil, err
+	}
+	silences, err := e.store.ListSilences(now.Unix(), false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range alerts {
+		alerts[i].Silenced = silenced(silences, alerts[i].Labels, now.Unix())
+	}
+	return alerts, nil
+}
+
+// parseSample returns the labels and value of an instant query sample
+func parseSample(sample map[string]interface{}) (map[string]string, float64, bool) {
+	values, ok := sample["values"].([]map[string]interface{})
+	if !ok || len(values) == 0 {
+		return nil, 0, false
+	}
+	value, ok := values[0]["value"].(float64)
+	if !ok {
+		return nil, 0, false
+	}
+
+	labels := make(map[string]string)
+	switch metric := sample["metric"].(type) {
+	case map[string]interface{}:
+		for name, v := range metric {
+			if s, ok := v.(string); ok {
+				labels[name] = s
+			}
+		}
+	case map[string]string:
+		for name, v := range metric {
+			labels[name] = v
+		}
+	}
+	return labels, value, true
+}
@@ -0,0 +1,277 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"local/bff/pkg/metrics/metricstest"
+	"local/bff/pkg/state_storage/statetest"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func sample(labels map[string]interface{}, value float64) map[string]interface{} {
+	return map[string]interface{}{
+		"metric": labels,
+		"values": []map[string]interface{}{{"timestamp": int64(0), "value": value}},
+	}
+}
+
+// recordingNotifier keeps every batch of notified alerts
+type recordingNotifier struct {
+	batches [][]Alert
+	err     error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alerts []Alert) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.batches = append(n.batches, alerts)
+	return nil
+}
+
+func (n *recordingNotifier) states() []string {
+	var states []string
+	for _, batch := range n.batches {
+		for _, a := range batch {
+			states = append(states, a.State)
+		}
+	}
+	return states
+}
+
+const connectionsExpr = `cc_backend_count > 90`
+
+// answer makes the mock return samples, or err, for the rule expression
+func answer(m *metricstest.MockService, samples []map[string]interface{}, err error) *mock.Call {
+	return m.On("ExecuteRaw", connectionsExpr, mock.Anything).Return(samples, err)
+}
+
+func newTestEngine(t *testing.T, m *metricstest.MockService, n Notifier) *Engine {
+	engine, err := NewEngine(Config{
+		RepeatInterval: "1h",
+		Rules: []Rule{{
+			Name:        "ConnectionSaturation",
+			Expr:        connectionsExpr,
+			For:         "5m",
+			Severity:    SeverityWarning,
+			Labels:      map[string]string{"team": "dba"},
+			Annotations: map[string]string{"summary": "{{ $labels.sys_id }} has {{ $value }} connections"},
+		}},
+	}, m, NewStore(statetest.Open(t)), n)
+	require.NoError(t, err)
+	return engine
+}
+
+func TestEngineAlertLifecycle(t *testing.T) {
+	m := new(metricstest.MockService)
+	call := answer(m, []map[string]interface{}{sample(map[string]interface{}{"__name__": "cc_backend_count", "sys_id": "db1"}, 95)}, nil)
+	n := &recordingNotifier{}
+	engine := newTestEngine(t, m, n)
+	ctx := context.Background()
+	start := time.Unix(1_700_000_000, 0)
+
+	// Active, but not for long enough yet
+	require.NoError(t, engine.Evaluate(ctx, start))
+	alerts, err := engine.ListAlerts("", start)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	a := alerts[0]
+	assert.Equal(t, StatePending, a.State)
+	assert.Equal(t, map[string]string{"alertname": "ConnectionSaturation", "severity": "warning", "team": "dba", "sys_id": "db1"}, a.Labels)
+	assert.Equal(t, "db1 has 95 connections", a.Annotations["summary"])
+	assert.Empty(t, n.batches)
+
+	// Fires once active for the for duration, and is notified once
+	require.NoError(t, engine.Evaluate(ctx, start.Add(5*time.Minute)))
+	require.NoError(t, engine.Evaluate(ctx, start.Add(6*time.Minute)))
+	alerts, err = engine.ListAlerts(StateFiring, start)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, start.Add(5*time.Minute).Unix(), alerts[0].FiredAt)
+	assert.Equal(t, []string{StateFiring}, n.states())
+
+	// Notified again after the repeat interval
+	require.NoError(t, engine.Evaluate(ctx, start.Add(65*time.Minute)))
+	assert.Equal(t, []string{StateFiring, StateFiring}, n.states())
+
+	// Resolves when the series is gone
+	call.Unset()
+	answer(m, nil, nil)
+	require.NoError(t, engine.Evaluate(ctx, start.Add(70*time.Minute)))
+	require.NoError(t, engine.Evaluate(ctx, start.Add(71*time.Minute)))
+	alerts, err = engine.ListAlerts("", start)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StateResolved, alerts[0].State)
+	assert.Equal(t, start.Add(70*time.Minute).Unix(), alerts[0].ResolvedAt)
+	assert.Equal(t, []string{StateFiring, StateFiring, StateResolved}, n.states())
+
+	// Purged after the retention
+	require.NoError(t, engine.Evaluate(ctx, start.Add(70*time.Minute+defaultResolvedRetention+time.Second)))
+	alerts, err = engine.ListAlerts("", start)
+	require.NoError(t, err)
+	assert.Empty(t, alerts)
+}
+
+func TestEnginePendingAlertIsDropped(t *testing.T) {
+	m := new(metricstest.MockService)
+	call := answer(m, []map[string]interface{}{sample(map[string]interface{}{"sys_id": "db1"}, 95)}, nil)
+	n := &recordingNotifier{}
+	engine := newTestEngine(t, m, n)
+	start := time.Unix(1_700_000_000, 0)
+
+	require.NoError(t, engine.Evaluate(context.Background(), start))
+	call.Unset()
+	answer(m, nil, nil)
+	require.NoError(t, engine.Evaluate(context.Background(), start.Add(time.Minute)))
+
+	alerts, err := engine.ListAlerts("", start)
+	require.NoError(t, err)
+	assert.Empty(t, alerts)
+	assert.Empty(t, n.batches)
+}
+
+func TestEngineQueryErrorKeepsAlerts(t *testing.T) {
+	m := new(metricstest.MockService)
+	call := answer(m, []map[string]interface{}{sample(map[string]interface{}{"sys_id": "db1"}, 95)}, nil)
+	engine := newTestEngine(t, m, &recordingNotifier{})
+	start := time.Unix(1_700_000_000, 0)
+
+	require.NoError(t, engine.Evaluate(context.Background(), start))
+	require.NoError(t, engine.Evaluate(context.Background(), start.Add(5*time.Minute)))
+
+	call.Unset()
+	answer(m, nil, errors.New("prometheus unavailable"))
+	require.NoError(t, engine.Evaluate(context.Background(), start.Add(6*time.Minute)))
+
+	alerts, err := engine.ListAlerts(StateFiring, start)
+	require.NoError(t, err)
+	assert.Len(t, alerts, 1)
+}
+
+func TestEngineSilencesAndRetries(t *testing.T) {
+	m := new(metricstest.MockService)
+	answer(m, []map[string]interface{}{
+		sample(map[string]interface{}{"sys_id": "db1"}, 95),
+		sample(map[string]interface{}{"sys_id": "db2"}, 99),
+	}, nil)
+	n := &recordingNotifier{}
+	engine := newTestEngine(t, m, n)
+	ctx := context.Background()
+	start := time.Unix(1_700_000_000, 0)
+
+	_, err := engine.Store().CreateSilence(Silence{
+		Matchers:  []Matcher{{Name: "sys_id", Value: "db1"}},
+		StartsAt:  start.Unix(),
+		EndsAt:    start.Add(time.Hour).Unix(),
+		CreatedBy: "ops",
+	})
+	require.NoError(t, err)
+
+	// A failed delivery is retried at the next evaluation
+	n.err = errors.New("receiver down")
+	require.NoError(t, engine.Evaluate(ctx, start))
+	require.NoError(t, engine.Evaluate(ctx, start.Add(5*time.Minute)))
+	assert.Empty(t, n.batches)
+
+	n.err = nil
+	require.NoError(t, engine.Evaluate(ctx, start.Add(6*time.Minute)))
+	require.Len(t, n.batches, 1)
+	require.Len(t, n.batches[0], 1)
+	assert.Equal(t, "db2", n.batches[0][0].Labels["sys_id"])
+
+	alerts, err := engine.ListAlerts(StateFiring, start.Add(6*time.Minute))
+	require.NoError(t, err)
+	silencedBySys := map[string]bool{}
+	for _, a := range alerts {
+		silencedBySys[a.Labels["sys_id"]] = a.Silenced
+	}
+	assert.Equal(t, map[string]bool{"db1": true, "db2": false}, silencedBySys)
+
+	// Notified once the silence ends
+	require.NoError(t, engine.Evaluate(ctx, start.Add(61*time.Minute)))
+	require.Len(t, n.batches, 2)
+	require.Len(t, n.batches[1], 1)
+	assert.Equal(t, "db1", n.batches[1][0].Labels["sys_id"])
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var received []Payload
+	var authHeader string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		mu.Lock()
+		received = append(received, payload)
+		authHeader = r.Header.Get("Authorization")
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	notifier, err := NewWebhookNotifier([]Webhook{
+		{Name: "ops", URL: receiver.URL, Headers: map[string]string{"Authorization": "Bearer token"}},
+		{URL: failing.URL},
+	})
+	require.NoError(t, err)
+
+	err = notifier.Notify(context.Background(), []Alert{
+		{Fingerprint: "a", State: StateFiring, Labels: map[string]string{"alertname": "A"}, FiredAt: 100},
+		{Fingerprint: "b", State: StateResolved, Labels: map[string]string{"alertname": "B"}, FiredAt: 100, ResolvedAt: 200},
+	})
+	assert.ErrorContains(t, err, "webhook webhook-1: unexpected status 502")
+
+	require.Len(t, received, 1)
+	payload := received[0]
+	assert.Equal(t, "Bearer token", authHeader)
+	assert.Equal(t, "ops", payload.Receiver)
+	assert.Equal(t, StateFiring, payload.Status)
+	require.Len(t, payload.Alerts, 2)
+	assert.Equal(t, time.Unix(100, 0).UTC(), payload.Alerts[0].StartsAt)
+	assert.True(t, payload.Alerts[0].EndsAt.IsZero())
+	assert.Equal(t, time.Unix(200, 0).UTC(), payload.Alerts[1].EndsAt)
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Rule{Name: "HighCPU", Expr: "cc_system_cpu_user_percent > 90", Severity: SeverityWarning}
+
+	testCases := []struct {
+		name   string
+		config Config
+		errMsg string
+	}{
+		{name: "Valid", config: Config{Rules: []Rule{valid}, Webhooks: []Webhook{{URL: "https://example.com/hook"}}}},
+		{name: "Bad interval", config: Config{EvaluationInterval: "soon"}, errMsg: "evaluation_interval"},
+		{name: "Bad name", config: Config{Rules: []Rule{{Name: "high cpu", Expr: "1", Severity: SeverityInfo}}}, errMsg: "name"},
+		{name: "Duplicate name", config: Config{Rules: []Rule{valid, valid}}, errMsg: "duplicate"},
+		{name: "Missing expr", config: Config{Rules: []Rule{{Name: "A", Severity: SeverityInfo}}}, errMsg: "expr"},
+		{name: "Bad for", config: Config{Rules: []Rule{{Name: "A", Expr: "1", For: "-1m", Severity: SeverityInfo}}}, errMsg: "for"},
+		{name: "Bad severity", config: Config{Rules: []Rule{{Name: "A", Expr: "1", Severity: "page"}}}, errMsg: "severity"},
+		{name: "Bad webhook", config: Config{Webhooks: []Webhook{{URL: "ftp://example.com"}}}, errMsg: "url"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.errMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"local/bff/pkg/configutil"
+	"net/http"
+	"time"
+)
+
+// Notifier delivers notifications of alerts that fired or resolved
+type Notifier interface {
+	Notify(ctx context.Context, alerts []Alert) error
+}
+
+// Payload is the JSON body posted to webhooks. It follows the Alertmanager
+// webhook format, so receivers written for Alertmanager can be reused.
+type Payload struct {
+	Version  string         `json:"version"`
+	Receiver string         `json:"receiver"`
+	Status   string         `json:"status"`
+	Alerts   []PayloadAlert `json:"alerts"`
+}
+
+type PayloadAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint"`
+	Value       float64           `json:"value"`
+}
+
+const payloadVersion = "4"
+
+func newPayload(receiver string, alerts []Alert) Payload {
+	payload := Payload{Version: payloadVersion, Receiver: receiver, Status: StateResolved}
+	for _, a := range alerts {
+		if a.State == StateFiring {
+			payload.Status = StateFiring
+		}
+
+		pa := PayloadAlert{
+			Status:      a.State,
+			Labels:      a.Labels,
+			Annotations: a.Annotations,
+			StartsAt:    time.Unix(a.FiredAt, 0).UTC(),
+			Fingerprint: a.Fingerprint,
+			Value:       a.Value,
+		}
+		if a.State == StateResolved {
+			pa.EndsAt = time.Unix(a.ResolvedAt, 0).UTC()
+		}
+		payload.Alerts = append(payload.Alerts, pa)
+	}
+	return payload
+}
+
+type webhookTarget struct {
+	Webhook
+	timeout time.Duration
+}
+
+// WebhookNotifier posts every notification to each configured webhook
+type WebhookNotifier struct {
+	webhooks []webhookTarget
+	client   *http.Client
+}
+
+func NewWebhookNotifier(webhooks []Webhook) (*WebhookNotifier, error) {
+	n := &WebhookNotifier{client: &http.Client{}}
+	for i, webhook := range webhooks {
+		timeout, err := configutil.ParseDuration(fmt.Sprintf("webhooks[%d]: timeout", i), webhook.Timeout, defaultWebhookTimeout, true)
+		if err != nil {
+			return nil, err
+		}
+		if webhook.Name == "" {
+			webhook.Name = fmt.Sprintf("webhook-%d", i)
+		}
+		n.webhooks = append(n.webhooks, webhookTarget{Webhook: webhook, timeout: timeout})
+	}
+	return n, nil
+}
+
+// Notify posts alerts to every webhook and returns the failures joined. A
+// webhook that answers with a non-2xx status has failed.
+func (n *WebhookNotifier) Notify(ctx context.Context, alerts []Alert) error {
+	var errs []error
+	for _, webhook := range n.webhooks {
+		if err := n.post(ctx, webhook, newPayload(webhook.Name, alerts)); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", webhook.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, webhook webhookTarget, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhook.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
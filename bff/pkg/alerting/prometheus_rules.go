@@ -0,0 +1,215 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thresholds are the limits of the generated PostgreSQL alert rules
+type Thresholds struct {
+	ConnectionsPercent     float64       // Backends as a percent of max_connections
+	ConnectionsDuration    time.Duration // How long connections must stay above ConnectionsPercent
+	DiskFreePercent        float64       // Free disk space as a percent of the total
+	DiskFreeDuration       time.Duration // How long free space must stay below DiskFreePercent
+	XIDAge                 float64       // Age of the oldest unfrozen transaction ID of a database
+	LongTransaction        time.Duration // Age of the oldest open transaction
+	ReplicationLag         time.Duration // Replay delay of a standby
+	ReplicationLagBytes    float64       // WAL bytes a standby has not yet replayed, as seen by its primary
+	ReplicationLagDuration time.Duration // How long either replication lag must stay above its threshold
+	RollbackPercent        float64       // Rollbacks as a percent of all transactions
+	RollbackMinTPS         float64       // Transaction rate below which the rollback ratio is ignored
+	CPUPercent             float64       // Average user and system CPU over all cores
+	SustainedCPUDuration   time.Duration // How long CPU must stay above CPUPercent
+}
+
+// DefaultThresholds are conservative limits that should only fire on real
+// trouble for most instances
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		ConnectionsPercent:     90,
+		ConnectionsDuration:    5 * time.Minute,
+		DiskFreePercent:        10,
+		DiskFreeDuration:       10 * time.Minute,
+		XIDAge:                 1_000_000_000,
+		LongTransaction:        time.Hour,
+		ReplicationLag:         5 * time.Minute,
+		ReplicationLagBytes:    1 << 30,
+		ReplicationLagDuration: 5 * time.Minute,
+		RollbackPercent:        10,
+		RollbackMinTPS:         1,
+		CPUPercent:             90,
+		SustainedCPUDuration:   15 * time.Minute,
+	}
+}
+
+// systemLabels identify an instance; the rules aggregate by them so alerts
+// are per instance rather than per series
+const systemLabels = "sys_id, sys_scope, sys_type"
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatDuration formats d in the Prometheus duration syntax
+func formatDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// PostgresRules returns alert rules for common PostgreSQL failure modes,
+// using the metrics collector-api writes
+func PostgresRules(t Thresholds) []Rule {
+	// Transaction counters fall back to the per-snapshot series where
+	// collector-api does not write <name>_total
+	rollbackRate := fmt.Sprintf(`sum by (%s, datname) (rate(cc_db_xact_rollback_total[15m]) or rate(cc_db_xact_rollback[15m]))`, systemLabels)
+	transactionRate := fmt.Sprintf(`(sum by (%[1]s, datname) (rate(cc_db_xact_commit_total[15m]) or rate(cc_db_xact_commit[15m])) + %[2]s)`, systemLabels, rollbackRate)
+
+	return []Rule{
+		{
+			Name: "PostgresConnectionSaturation",
+			Expr: fmt.Sprintf(`sum by (%[1]s) (cc_backend_count) / on (%[1]s) max by (%[1]s) (cc_pgsetting_current_value{name="max_connections"}) * 100 > %[2]s`,
+				systemLabels, formatNumber(t.ConnectionsPercent)),
+			For:      formatDuration(t.ConnectionsDuration),
+			Severity: SeverityWarning,
+			Annotations: map[string]string{
+				"summary":     "{{ $labels.sys_id }} is using {{ printf \"%.0f\" $value }}% of max_connections",
+				"description": "New connections will be refused once max_connections is reached. Look for connection leaks or add a connection pooler.",
+			},
+		},
+		{
+			Name: "PostgresDiskSpaceLow",
+			Expr: fmt.Sprintf(`sum by (%[1]s, mountpoint) (cc_system_diskpartition_free_bytes) / sum by (%[1]s, mountpoint) (cc_system_diskpartition_total_bytes) * 100 < %[2]s`,
+				systemLabels, formatNumber(t.DiskFreePercent)),
+			For:      formatDuration(t.DiskFreeDuration),
+			Severity: SeverityCritical,
+			Annotations: map[string]string{
+				"summary":     "{{ $labels.sys_id }} has {{ printf \"%.1f\" $value }}% disk space free on {{ $labels.mountpoint }}",
+				"description": "PostgreSQL shuts down when it cannot write WAL. Free up space or grow the volume.",
+			},
+		},
+		{
+			Name:     "PostgresTransactionIDAgeHigh",
+			Expr:     fmt.Sprintf(`max by (%s, datname) (cc_db_frozen_xid_age) > %s`, systemLabels, formatNumber(t.XIDAge)),
+			For:      "15m",
+			Severity: SeverityCritical,
+			Annotations: map[string]string{
+				"summary":     "Database {{ $labels.datname }} on {{ $labels.sys_id }} has a transaction ID age of {{ printf \"%.0f\" $value }}",
+				"description": "PostgreSQL stops accepting writes before the age reaches 2^31. Check that anti-wraparound autovacuum is making progress.",
+			},
+		},
+		{
+			Name: "PostgresLongRunningTransaction",
+			Expr: fmt.Sprintf(`max by (%s, datname) (cc_backend_max_xact_age_seconds) > %s`,
+				systemLabels, formatNumber(t.LongTransaction.Seconds())),
+			For:      "5m",
+			Severity: SeverityWarning,
+			Annotations: map[string]string{
+				"summary":     "A transaction in {{ $labels.datname }} on {{ $labels.sys_id }} has been open for {{ printf \"%.0f\" $value }}s",
+				"description": "Long-running transactions hold back vacuum and can cause bloat and lock waits.",
+			},
+		},
+		{
+			Name: "PostgresReplicationLag",
+			Expr: fmt.Sprintf(`max by (%s) (cc_replication_replay_lag_seconds) > %s`,
+				systemLabels, formatNumber(t.ReplicationLag.Seconds())),
+			For:      formatDuration(t.ReplicationLagDuration),
+			Severity: SeverityWarning,
+			Annotations: map[string]string{
+				"summary":     "Standby {{ $labels.sys_id }} is replaying {{ printf \"%.0f\" $value }}s behind its primary",
+				"description": "Reads from the standby are stale and a failover would lose or wait for the unreplayed changes.",
+			},
+		},
+		{
+			Name: "PostgresStandbyByteLag",
+			Expr: fmt.Sprintf(`max by (%s, application_name, client_addr) (cc_replication_standby_byte_lag) > %s`,
+				systemLabels, formatNumber(t.ReplicationLagBytes)),
+			For:      formatDuration(t.ReplicationLagDuration),
+			Severity: SeverityWarning,
+			Annotations: map[string]string{
+				"summary":     "Standby {{ $labels.application_name }} of {{ $labels.sys_id }} has {{ printf \"%.0f\" $value }} bytes of WAL left to replay",
+				"description": "The primary keeps WAL until standbys have received it, so a lagging standby also grows disk usage.",
+			},
+		},
+		{
+			Name: "PostgresHighRollbackRatio",
+			Expr: fmt.Sprintf(`%[1]s / %[2]s * 100 > %[3]s and %[2]s > %[4]s`,
+				rollbackRate, transactionRate, formatNumber(t.RollbackPercent), formatNumber(t.RollbackMinTPS)),
+			For:      "15m",
+			Severity: SeverityWarning,
+			Annotations: map[string]string{
+				"summary":     "{{ printf \"%.1f\" $value }}% of transactions in {{ $labels.datname }} on {{ $labels.sys_id }} roll back",
+				"description": "A high rollback ratio usually means application errors, such as constraint violations or serialization failures.",
+			},
+		},
+		{
+			Name: "PostgresHighCPU",
+			Expr: fmt.Sprintf(`avg by (%s) (cc_system_cpu_user_percent + cc_system_cpu_system_percent) > %s`,
+				systemLabels, formatNumber(t.CPUPercent)),
+			For:      formatDuration(t.SustainedCPUDuration),
+			Severity: SeverityWarning,
+			Annotations: map[string]string{
+				"summary":     "{{ $labels.sys_id }} has used {{ printf \"%.0f\" $value }}% CPU for more than " + formatDuration(t.SustainedCPUDuration),
+				"description": "Sustained CPU saturation slows every query. Check the top queries by time.",
+			},
+		},
+	}
+}
+
+// GeneratePrometheusAlertingRules renders rules as a Prometheus rule file
+// with a single group. Strings are written as JSON, which is valid YAML.
+func GeneratePrometheusAlertingRules(groupName string, rules []Rule) string {
+	quote := func(s string) string {
+		var sb strings.Builder
+		enc := json.NewEncoder(&sb)
+		enc.SetEscapeHTML(false)
+		enc.Encode(s)
+		return strings.TrimSuffix(sb.String(), "\n")
+	}
+	writeMap := func(sb *strings.Builder, name string, m map[string]string) {
+		if len(m) == 0 {
+			return
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		sb.WriteString(fmt.Sprintf("        %s:\n", name))
+		for _, k := range keys {
+			sb.WriteString(fmt.Sprintf("          %s: %s\n", k, quote(m[k])))
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("groups:\n")
+	sb.WriteString(fmt.Sprintf("  - name: %s\n", groupName))
+	sb.WriteString("    rules:\n")
+
+	for _, rule := range rules {
+		labels := map[string]string{"severity": rule.Severity}
+		for k, v := range rule.Labels {
+			labels[k] = v
+		}
+
+		sb.WriteString(fmt.Sprintf("      - alert: %s\n", rule.Name))
+		sb.WriteString(fmt.Sprintf("        expr: %s\n", quote(rule.Expr)))
+		if rule.For != "" {
+			sb.WriteString(fmt.Sprintf("        for: %s\n", rule.For))
+		}
+		writeMap(&sb, "labels", labels)
+		writeMap(&sb, "annotations", rule.Annotations)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,69 @@
+package alerting
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresRules(t *testing.T) {
+	thresholds := DefaultThresholds()
+	thresholds.ConnectionsPercent = 75
+	thresholds.LongTransaction = 30 * time.Minute
+	thresholds.SustainedCPUDuration = 90 * time.Second
+
+	rules := PostgresRules(thresholds)
+
+	// The generated rules are valid for the built-in engine as well
+	require.NoError(t, Config{Rules: rules}.Validate())
+
+	byName := make(map[string]Rule)
+	for _, rule := range rules {
+		byName[rule.Name] = rule
+	}
+	assert.Len(t, byName, 8)
+
+	assert.True(t, strings.HasSuffix(byName["PostgresConnectionSaturation"].Expr, "* 100 > 75"))
+	assert.Contains(t, byName["PostgresConnectionSaturation"].Expr, `cc_pgsetting_current_value{name="max_connections"}`)
+	assert.True(t, strings.HasSuffix(byName["PostgresLongRunningTransaction"].Expr, "(cc_backend_max_xact_age_seconds) > 1800"))
+	assert.True(t, strings.HasSuffix(byName["PostgresTransactionIDAgeHigh"].Expr, "> 1000000000"))
+	assert.True(t, strings.HasSuffix(byName["PostgresStandbyByteLag"].Expr, "> 1073741824"))
+	assert.Contains(t, byName["PostgresHighRollbackRatio"].Expr, "rate(cc_db_xact_rollback_total[15m]) or rate(cc_db_xact_rollback[15m])")
+	assert.Equal(t, "90s", byName["PostgresHighCPU"].For)
+	assert.Equal(t, "10m", byName["PostgresDiskSpaceLow"].For)
+}
+
+func TestGeneratePrometheusAlertingRules(t *testing.T) {
+	yaml := GeneratePrometheusAlertingRules("test_alerts", []Rule{
+		{
+			Name:        "HighCPU",
+			Expr:        `avg(cc_system_cpu_user_percent{sys_id="a"}) > 90`,
+			For:         "15m",
+			Severity:    SeverityWarning,
+			Labels:      map[string]string{"team": "dba"},
+			Annotations: map[string]string{"summary": `{{ $labels.sys_id }} at {{ printf "%.0f" $value }}%`},
+		},
+		{Name: "Down", Expr: "up == 0", Severity: SeverityCritical},
+	})
+
+	expected := `groups:
+  - name: test_alerts
+    rules:
+      - alert: HighCPU
+        expr: "avg(cc_system_cpu_user_percent{sys_id=\"a\"}) > 90"
+        for: 15m
+        labels:
+          severity: "warning"
+          team: "dba"
+        annotations:
+          summary: "{{ $labels.sys_id }} at {{ printf \"%.0f\" $value }}%"
+      - alert: Down
+        expr: "up == 0"
+        labels:
+          severity: "critical"
+`
+	assert.Equal(t, expected, yaml)
+}
@@ -0,0 +1,181 @@
+package alerting
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrSilenceNotFound is returned when expiring a silence that does not exist
+var ErrSilenceNotFound = errors.New("silence not found")
+
+// Store persists alert states and silences in the bff state database
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const selectAlerts = `
+	SELECT fingerprint, rule, labels, annotations, state, value, active_at,
+		fired_at, resolved_at, last_evaluated_at, notified_state, last_notified_at
+	FROM alerts`
+
+// ListAlerts returns the stored alerts, newest first, optionally only those
+// in the given state
+func (s *Store) ListAlerts(state string) ([]Alert, error) {
+	query := selectAlerts + ` ORDER BY active_at DESC, fingerprint`
+	args := []interface{}{}
+	if state != "" {
+		query = selectAlerts + ` WHERE state = ? ORDER BY active_at DESC, fingerprint`
+		args = append(args, state)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var a Alert
+		var labels, annotations string
+		var firedAt, resolvedAt, lastNotifiedAt sql.NullInt64
+		var notifiedState sql.NullString
+		err := rows.Scan(&a.Fingerprint, &a.Rule, &labels, &annotations, &a.State, &a.Value, &a.ActiveAt,
+			&firedAt, &resolvedAt, &a.LastEvaluatedAt, &notifiedState, &lastNotifiedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(labels), &a.Labels); err != nil {
+			return nil, fmt.Errorf("decode labels of alert %s: %w", a.Fingerprint, err)
+		}
+		if err := json.Unmarshal([]byte(annotations), &a.Annotations); err != nil {
+			return nil, fmt.Errorf("decode annotations of alert %s: %w", a.Fingerprint, err)
+		}
+		a.FiredAt, a.ResolvedAt, a.LastNotifiedAt = firedAt.Int64, resolvedAt.Int64, lastNotifiedAt.Int64
+		a.NotifiedState = notifiedState.String
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// SaveAlert inserts or replaces the alert with the fingerprint of a
+func (s *Store) SaveAlert(a Alert) error {
+	labels, err := json.Marshal(a.Labels)
+	if err != nil {
+		return err
+	}
+	annotations, err := json.Marshal(a.Annotations)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT OR REPLACE INTO alerts (fingerprint, rule, labels, annotations, state, value, active_at,
+			fired_at, resolved_at, last_evaluated_at, notified_state, last_notified_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.Fingerprint, a.Rule, string(labels), string(annotations), a.State, a.Value, a.ActiveAt,
+		nullInt64(a.FiredAt), nullInt64(a.ResolvedAt), a.LastEvaluatedAt, nullString(a.NotifiedState), nullInt64(a.LastNotifiedAt))
+	if err != nil {
+		return fmt.Errorf("save alert %s: %w", a.Fingerprint, err)
+	}
+	return nil
+}
+
+func (s *Store) DeleteAlert(fingerprint string) error {
+	if _, err := s.db.Exec(`DELETE FROM alerts WHERE fingerprint = ?`, fingerprint); err != nil {
+		return fmt.Errorf("delete alert %s: %w", fingerprint, err)
+	}
+	return nil
+}
+
+// PurgeResolved deletes the alerts resolved before the given time, unless a
+// resolved notification is still owed for them
+func (s *Store) PurgeResolved(before int64) error {
+	_, err := s.db.Exec(`
+		DELETE FROM alerts
+		WHERE state = ? AND resolved_at < ? AND (notified_state IS NULL OR notified_state != ?)`,
+		StateResolved, before, StateFiring)
+	if err != nil {
+		return fmt.Errorf("purge resolved alerts: %w", err)
+	}
+	return nil
+}
+
+// CreateSilence stores s and returns it with its ID
+func (s *Store) CreateSilence(silence Silence) (Silence, error) {
+	matchers, err := json.Marshal(silence.Matchers)
+	if err != nil {
+		return Silence{}, err
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO silences (matchers, starts_at, ends_at, created_by, comment, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		string(matchers), silence.StartsAt, silence.EndsAt, silence.CreatedBy, silence.Comment, silence.CreatedAt)
+	if err != nil {
+		return Silence{}, fmt.Errorf("create silence: %w", err)
+	}
+
+	silence.ID, err = result.LastInsertId()
+	return silence, err
+}
+
+// ListSilences returns the silences that have not ended at now, or all of
+// them when includeExpired is set, newest first
+func (s *Store) ListSilences(now int64, includeExpired bool) ([]Silence, error) {
+	query := `SELECT id, matchers, starts_at, ends_at, created_by, comment, created_at FROM silences`
+	args := []interface{}{}
+	if !includeExpired {
+		query += ` WHERE ends_at > ?`
+		args = append(args, now)
+	}
+	query += ` ORDER BY id DESC`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list silences: %w", err)
+	}
+	defer rows.Close()
+
+	silences := []Silence{}
+	for rows.Next() {
+		var silence Silence
+		var matchers string
+		err := rows.Scan(&silence.ID, &matchers, &silence.StartsAt, &silence.EndsAt, &silence.CreatedBy, &silence.Comment, &silence.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(matchers), &silence.Matchers); err != nil {
+			return nil, fmt.Errorf("decode matchers of silence %d: %w", silence.ID, err)
+		}
+		silences = append(silences, silence)
+	}
+	return silences, rows.Err()
+}
+
+// ExpireSilence ends the silence with the given ID at now, if it has not ended
+// already
+func (s *Store) ExpireSilence(id int64, now int64) error {
+	result, err := s.db.Exec(`UPDATE silences SET ends_at = MIN(ends_at, MAX(starts_at, ?)) WHERE id = ?`, now, id)
+	if err != nil {
+		return fmt.Errorf("expire silence %d: %w", id, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrSilenceNotFound
+	}
+	return nil
+}
+
+func nullInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
+
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
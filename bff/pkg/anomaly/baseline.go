@@ -0,0 +1,122 @@
+package anomaly
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	week        = 7 * 24 * time.Hour
+	hoursOfWeek = 7 * 24
+)
+
+// HourOfWeek returns the hour of the week of t in UTC, from 0 at midnight
+// between Sunday and Monday to 167
+func HourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return (int(t.Weekday())+6)%7*24 + t.Hour()
+}
+
+// Stats summarizes the load of a wait event class over one hour-of-week
+type Stats struct {
+	Mean    float64 `json:"mean"`
+	StdDev  float64 `json:"stddev"`
+	Samples int     `json:"samples"`
+}
+
+// Baseline is the normal load of an instance per wait event class and
+// hour-of-week. Samples counts the observations of each hour-of-week; a class
+// absent from Classes had no active sessions in any of them.
+type Baseline struct {
+	LearnedAt int64                         `json:"learned_at"`
+	Samples   [hoursOfWeek]int              `json:"samples"`
+	Classes   map[string][hoursOfWeek]Stats `json:"classes"`
+}
+
+// Stats returns the statistics of class at hour
+func (b *Baseline) Stats(class string, hour int) Stats {
+	if stats, ok := b.Classes[class]; ok {
+		return stats[hour]
+	}
+	return Stats{Samples: b.Samples[hour]}
+}
+
+// WaitEventClasses returns the classes of b, sorted
+func (b *Baseline) WaitEventClasses() []string {
+	classes := make([]string, 0, len(b.Classes))
+	for class := range b.Classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// observations holds the load per wait event class at every timestamp, in
+// unix milliseconds, at which the instance reported any activity. A class
+// missing at such a timestamp had no active sessions.
+type observations struct {
+	timestamps map[int64]bool
+	classes    map[string]map[int64]float64
+}
+
+func newObservations() *observations {
+	return &observations{timestamps: make(map[int64]bool), classes: make(map[string]map[int64]float64)}
+}
+
+func (o *observations) add(class string, ts int64, value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	o.timestamps[ts] = true
+	if o.classes[class] == nil {
+		o.classes[class] = make(map[int64]float64)
+	}
+	o.classes[class][ts] += value
+}
+
+// mean returns the average load of class over the observed timestamps
+func (o *observations) mean(class string) float64 {
+	if len(o.timestamps) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, value := range o.classes[class] {
+		sum += value
+	}
+	return sum / float64(len(o.timestamps))
+}
+
+// learnBaseline buckets the observations by hour-of-week and computes the
+// mean and standard deviation of every class in each bucket
+func learnBaseline(o *observations, learnedAt time.Time) *Baseline {
+	b := &Baseline{LearnedAt: learnedAt.UnixMilli(), Classes: make(map[string][hoursOfWeek]Stats)}
+	for ts := range o.timestamps {
+		b.Samples[HourOfWeek(time.UnixMilli(ts))]++
+	}
+
+	for class, values := range o.classes {
+		var sums, squares [hoursOfWeek]float64
+		var counts [hoursOfWeek]int
+		for ts := range o.timestamps {
+			hour := HourOfWeek(time.UnixMilli(ts))
+			value := values[ts]
+			sums[hour] += value
+			squares[hour] += value * value
+			counts[hour]++
+		}
+
+		var stats [hoursOfWeek]Stats
+		for hour := range stats {
+			if counts[hour] == 0 {
+				continue
+			}
+			n := float64(counts[hour])
+			mean := sums[hour] / n
+			variance := squares[hour]/n - mean*mean
+			stats[hour] = Stats{Mean: mean, StdDev: math.Sqrt(math.Max(variance, 0)), Samples: counts[hour]}
+		}
+		b.Classes[class] = stats
+	}
+	return b
+}
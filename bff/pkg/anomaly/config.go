@@ -0,0 +1,110 @@
+// Package anomaly learns the normal load of every instance, as the average
+// active sessions of cc_pg_stat_activity per wait event class and
+// hour-of-week, and records the deviations from it as events attributed to
+// the queries, wait events and applications behind them.
+package anomaly
+
+import (
+	"fmt"
+	"local/bff/pkg/configutil"
+	"time"
+)
+
+// Config is the "anomaly_detection" section of the bff config file
+type Config struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval is how often every instance is checked
+	Interval string `mapstructure:"interval"`
+	// Window is the recent load compared against the baseline
+	Window string `mapstructure:"window"`
+	// BaselineWeeks is how many weeks of history the baseline learns from
+	BaselineWeeks int `mapstructure:"baseline_weeks"`
+	// BaselineStep is the resolution of the baseline history
+	BaselineStep string `mapstructure:"baseline_step"`
+	// BaselineRefresh is how often the baseline of an instance is relearned
+	BaselineRefresh string `mapstructure:"baseline_refresh"`
+	// ZScore is how many standard deviations from the mean are anomalous
+	ZScore float64 `mapstructure:"z_score"`
+	// MinDelta is the minimum deviation, in average active sessions, that is
+	// anomalous, so that the noise of mostly idle instances is not flagged
+	MinDelta float64 `mapstructure:"min_delta"`
+	// MinSamples is how many baseline samples an hour-of-week needs before it
+	// is used
+	MinSamples int `mapstructure:"min_samples"`
+}
+
+const (
+	defaultInterval        = 5 * time.Minute
+	defaultWindow          = 15 * time.Minute
+	defaultBaselineWeeks   = 4
+	defaultBaselineStep    = 5 * time.Minute
+	defaultBaselineRefresh = 6 * time.Hour
+	defaultZScore          = 3
+	defaultMinDelta        = 0.5
+	defaultMinSamples      = 24
+
+	// maxBaselinePoints keeps the baseline history query below the
+	// Prometheus limit of 11000 points per series
+	maxBaselinePoints = 11000
+)
+
+// settings is a validated Config with its defaults applied
+type settings struct {
+	interval        time.Duration
+	window          time.Duration
+	baselineWeeks   int
+	baselineStep    time.Duration
+	baselineRefresh time.Duration
+	zScore          float64
+	minDelta        float64
+	minSamples      int
+}
+
+// Validate checks the configuration without contacting Prometheus
+func (c Config) Validate() error {
+	_, err := c.settings()
+	return err
+}
+
+func (c Config) settings() (settings, error) {
+	s := settings{
+		baselineWeeks: c.BaselineWeeks,
+		zScore:        c.ZScore,
+		minDelta:      c.MinDelta,
+		minSamples:    c.MinSamples,
+	}
+
+	if err := configutil.ParseDurations(
+		configutil.DurationField{Name: "interval", Value: c.Interval, Default: defaultInterval, Target: &s.interval},
+		configutil.DurationField{Name: "window", Value: c.Window, Default: defaultWindow, Target: &s.window},
+		configutil.DurationField{Name: "baseline_step", Value: c.BaselineStep, Default: defaultBaselineStep, Target: &s.baselineStep},
+		configutil.DurationField{Name: "baseline_refresh", Value: c.BaselineRefresh, Default: defaultBaselineRefresh, Target: &s.baselineRefresh},
+	); err != nil {
+		return s, err
+	}
+
+	if s.baselineWeeks == 0 {
+		s.baselineWeeks = defaultBaselineWeeks
+	}
+	if s.zScore == 0 {
+		s.zScore = defaultZScore
+	}
+	if s.minDelta == 0 {
+		s.minDelta = defaultMinDelta
+	}
+	if s.minSamples == 0 {
+		s.minSamples = defaultMinSamples
+	}
+
+	if s.baselineWeeks < 0 || s.zScore < 0 || s.minDelta < 0 || s.minSamples < 0 {
+		return s, fmt.Errorf("baseline_weeks, z_score, min_delta and min_samples must not be negative")
+	}
+	if s.window >= time.Hour {
+		return s, fmt.Errorf("window must be shorter than an hour, got %s", s.window)
+	}
+	if points := time.Duration(s.baselineWeeks) * week / s.baselineStep; points > maxBaselinePoints {
+		return s, fmt.Errorf("baseline of %d weeks at a %s step has %d points, more than the %d Prometheus allows; raise baseline_step",
+			s.baselineWeeks, s.baselineStep, points, maxBaselinePoints)
+	}
+	return s, nil
+}
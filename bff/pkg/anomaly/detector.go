@@ -0,0 +1,407 @@
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/events"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event source and kind of the anomalies recorded by the detector
+const (
+	EventSource = "anomaly"
+	EventKind   = "aas_anomaly"
+)
+
+const (
+	DirectionAbove = "above"
+	DirectionBelow = "below"
+)
+
+// AttributionDimensions are the cc_pg_stat_activity labels an anomaly is
+// attributed to
+var AttributionDimensions = []string{"query_fp", "wait_event_name", "application_name"}
+
+const (
+	// currentStep is the resolution of the recent load and of the attribution
+	currentStep = time.Minute
+	// maxContributors is how many values of each dimension are kept in an
+	// attribution
+	maxContributors = 5
+)
+
+// Anomaly is a deviation of the load of a wait event class from its baseline
+type Anomaly struct {
+	WaitEventClass string  `json:"wait_event_class"`
+	HourOfWeek     int     `json:"hour_of_week"`
+	Direction      string  `json:"direction"`
+	Current        float64 `json:"current"`
+	Expected       float64 `json:"expected"`
+	StdDev         float64 `json:"stddev"`
+	ZScore         float64 `json:"z_score"`
+	// Attribution lists, per dimension, the values whose load changed the
+	// most in the direction of the anomaly
+	Attribution map[string][]Contribution `json:"attribution"`
+}
+
+// Contribution is the change in load of one value of a dimension. Share is
+// its part of the whole deviation.
+type Contribution struct {
+	Value    string  `json:"value"`
+	Current  float64 `json:"current"`
+	Expected float64 `json:"expected"`
+	Delta    float64 `json:"delta"`
+	Share    float64 `json:"share"`
+}
+
+// Detector checks the recent load of every instance against its baseline on
+// an interval and records the anomalies as events. Baselines are kept in
+// memory and relearned every baseline refresh.
+type Detector struct {
+	settings settings
+	metrics  metrics.Service
+	events   *events.Store
+
+	mu        sync.Mutex
+	baselines map[query_storage.SystemRef]*Baseline
+}
+
+func NewDetector(cfg Config, metrics_service metrics.Service, store *events.Store) (*Detector, error) {
+	s, err := cfg.settings()
+	if err != nil {
+		return nil, err
+	}
+	return &Detector{
+		settings:  s,
+		metrics:   metrics_service,
+		events:    store,
+		baselines: make(map[query_storage.SystemRef]*Baseline),
+	}, nil
+}
+
+func (d *Detector) Events() *events.Store {
+	return d.events
+}
+
+// Run checks every instance each interval until ctx is done
+func (d *Detector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.settings.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.DetectAll(time.Now()); err != nil {
+			log.Printf("Error detecting anomalies: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DetectAll checks every instance that reported activity recently. An
+// instance that fails is logged and skipped.
+func (d *Detector) DetectAll(now time.Time) error {
+	samples, err := d.metrics.ExecuteRaw(`group by (sys_id, sys_scope, sys_type) (cc_pg_stat_activity)`, map[string]string{
+		"start": strconv.FormatInt(now.UnixMilli(), 10),
+		"end":   strconv.FormatInt(now.UnixMilli(), 10),
+		"dim":   "system",
+	})
+	if err != nil {
+		return fmt.Errorf("list instances: %w", err)
+	}
+
+	for _, sample := range samples {
+		labels, _ := parseSeries(sample)
+		system := query_storage.SystemRef{
+			SystemID:    labels["sys_id"],
+			SystemScope: labels["sys_scope"],
+			SystemType:  labels["sys_type"],
+		}
+		if _, err := d.Detect(system, now); err != nil {
+			log.Printf("Error detecting anomalies of %s/%s/%s: %v", system.SystemType, system.SystemID, system.SystemScope, err)
+		}
+	}
+	return nil
+}
+
+// Detect compares the load of system over the window ending at now against
+// its baseline, records the anomalies found and returns them. An anomaly
+// still ongoing from a previous check extends its event.
+func (d *Detector) Detect(system query_storage.SystemRef, now time.Time) ([]Anomaly, error) {
+	baseline, err := d.Baseline(system, now)
+	if err != nil {
+		return nil, err
+	}
+
+	start := now.Add(-d.settings.window)
+	current, err := d.classObservations(system, start, now, currentStep)
+	if err != nil {
+		return nil, fmt.Errorf("query recent load: %w", err)
+	}
+	if len(current.timestamps) == 0 {
+		return nil, nil
+	}
+
+	hour := HourOfWeek(now)
+	classes := baseline.WaitEventClasses()
+	for class := range current.classes {
+		if _, ok := baseline.Classes[class]; !ok {
+			classes = append(classes, class)
+		}
+	}
+
+	var anomalies []Anomaly
+	for _, class := range classes {
+		a, ok := d.compare(class, hour, current.mean(class), baseline.Stats(class, hour))
+		if !ok {
+			continue
+		}
+		a.Attribution, err = d.attribute(system, a, start, now)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s anomaly: %w", class, err)
+		}
+		if err := d.record(system, a, start, now); err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, a)
+	}
+	return anomalies, nil
+}
+
+// compare returns the anomaly of class when current deviates from stats by at
+// least the z-score and the minimum delta. The standard deviation is floored
+// so that a flat baseline still needs a deviation of the minimum delta.
+func (d *Detector) compare(class string, hour int, current float64, stats Stats) (Anomaly, bool) {
+	if stats.Samples < d.settings.minSamples {
+		return Anomaly{}, false
+	}
+
+	delta := current - stats.Mean
+	sigma := math.Max(stats.StdDev, d.settings.minDelta/d.settings.zScore)
+	z := delta / sigma
+	if math.Abs(delta) < d.settings.minDelta || math.Abs(z) < d.settings.zScore {
+		return Anomaly{}, false
+	}
+
+	direction := DirectionAbove
+	if delta < 0 {
+		direction = DirectionBelow
+	}
+	return Anomaly{
+		WaitEventClass: class,
+		HourOfWeek:     hour,
+		Direction:      direction,
+		Current:        current,
+		Expected:       stats.Mean,
+		StdDev:         stats.StdDev,
+		ZScore:         z,
+	}, true
+}
+
+// Baseline returns the baseline of system, learning it when it is missing or
+// older than the baseline refresh. The history ends before the window checked
+// at now so that an ongoing anomaly does not shift its own baseline.
+func (d *Detector) Baseline(system query_storage.SystemRef, now time.Time) (*Baseline, error) {
+	d.mu.Lock()
+	baseline, ok := d.baselines[system]
+	d.mu.Unlock()
+	if ok && now.Sub(time.UnixMilli(baseline.LearnedAt)) < d.settings.baselineRefresh {
+		return baseline, nil
+	}
+
+	end := now.Add(-d.settings.window)
+	start := end.Add(-time.Duration(d.settings.baselineWeeks) * week)
+	history, err := d.classObservations(system, start, end, d.settings.baselineStep)
+	if err != nil {
+		return nil, fmt.Errorf("query baseline history: %w", err)
+	}
+
+	baseline = learnBaseline(history, now)
+	d.mu.Lock()
+	d.baselines[system] = baseline
+	d.mu.Unlock()
+	return baseline, nil
+}
+
+// attribute compares the load of every value of each attribution dimension
+// over [start, end] with the same window of the previous baseline weeks
+func (d *Detector) attribute(system query_storage.SystemRef, a Anomaly, start, end time.Time) (map[string][]Contribution, error) {
+	selector := activitySelector(system, "wait_event_name=~`"+regexp.QuoteMeta(a.WaitEventClass)+"(:.*)?`")
+	steps := float64(end.Sub(start)/currentStep + 1)
+	total := a.Current - a.Expected
+
+	attribution := make(map[string][]Contribution)
+	for _, dim := range AttributionDimensions {
+		query := fmt.Sprintf("sum by (%s) (%s)", dim, selector)
+
+		current, err := d.sumByLabel(query, dim, start, end)
+		if err != nil {
+			return nil, err
+		}
+		expected := make(map[string]float64)
+		for w := 1; w <= d.settings.baselineWeeks; w++ {
+			offset := time.Duration(w) * week
+			sums, err := d.sumByLabel(query, dim, start.Add(-offset), end.Add(-offset))
+			if err != nil {
+				return nil, err
+			}
+			for value, sum := range sums {
+				expected[value] += sum / float64(d.settings.baselineWeeks)
+			}
+		}
+
+		var contributions []Contribution
+		for _, value := range unionKeys(current, expected) {
+			c := Contribution{Value: value, Current: current[value] / steps, Expected: expected[value] / steps}
+			c.Delta = c.Current - c.Expected
+			if c.Delta == 0 || (c.Delta > 0) != (total > 0) {
+				continue
+			}
+			c.Share = c.Delta / total
+			contributions = append(contributions, c)
+		}
+		sort.SliceStable(contributions, func(i, j int) bool {
+			return math.Abs(contributions[i].Delta) > math.Abs(contributions[j].Delta)
+		})
+		if len(contributions) > maxContributors {
+			contributions = contributions[:maxContributors]
+		}
+		attribution[dim] = contributions
+	}
+	return attribution, nil
+}
+
+// record stores a as an event, extending the event of the same anomaly when
+// it was still ongoing at the previous check
+func (d *Detector) record(system query_storage.SystemRef, a Anomaly, start, end time.Time) error {
+	details, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("%s load of %.2f active sessions is %s the usual %.2f", a.WaitEventClass, a.Current, a.Direction, a.Expected)
+	dedupKey := EventKind + ":" + a.WaitEventClass + ":" + a.Direction
+
+	ongoing, err := d.events.Ongoing(system, dedupKey, end.Add(-2*d.settings.interval).UnixMilli())
+	if err != nil {
+		return err
+	}
+	if ongoing != nil {
+		return d.events.Extend(ongoing.ID, end.UnixMilli(), title, details)
+	}
+
+	_, err = d.events.Add(events.Event{
+		System:    system,
+		Source:    EventSource,
+		Kind:      EventKind,
+		Title:     title,
+		StartsAt:  start.UnixMilli(),
+		EndsAt:    end.UnixMilli(),
+		Tags:      []string{"wait_event_class:" + a.WaitEventClass},
+		Details:   details,
+		DedupKey:  dedupKey,
+		CreatedAt: end.UnixMilli(),
+	})
+	return err
+}
+
+// classObservations queries the load of system per wait event class over
+// [start, end]
+func (d *Detector) classObservations(system query_storage.SystemRef, start, end time.Time, step time.Duration) (*observations, error) {
+	query := fmt.Sprintf(`sum by (wait_event_class) (label_replace(%s, "wait_event_class", "$1", "wait_event_name", "([^:]*).*"))`,
+		activitySelector(system, ""))
+
+	samples, err := d.metrics.ExecuteRaw(query, rangeOptions(start, end, step))
+	if err != nil {
+		return nil, err
+	}
+
+	o := newObservations()
+	for _, sample := range samples {
+		labels, values := parseSeries(sample)
+		for _, v := range values {
+			ts, ok := v["timestamp"].(int64)
+			value, ok2 := v["value"].(float64)
+			if ok && ok2 {
+				o.add(labels["wait_event_class"], ts, value)
+			}
+		}
+	}
+	return o, nil
+}
+
+// sumByLabel queries the load over [start, end] and sums it by the value of
+// label
+func (d *Detector) sumByLabel(query, label string, start, end time.Time) (map[string]float64, error) {
+	samples, err := d.metrics.ExecuteRaw(query, rangeOptions(start, end, currentStep))
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]float64)
+	for _, sample := range samples {
+		labels, values := parseSeries(sample)
+		for _, v := range values {
+			if value, ok := v["value"].(float64); ok && !math.IsNaN(value) {
+				sums[labels[label]] += value
+			}
+		}
+	}
+	return sums, nil
+}
+
+func activitySelector(system query_storage.SystemRef, extra string) string {
+	selector := fmt.Sprintf(`sys_id="%s",sys_scope="%s",sys_type="%s"`, system.SystemID, system.SystemScope, system.SystemType)
+	if extra != "" {
+		selector += "," + extra
+	}
+	return "cc_pg_stat_activity{" + selector + "}"
+}
+
+func rangeOptions(start, end time.Time, step time.Duration) map[string]string {
+	return map[string]string{
+		"start": strconv.FormatInt(start.UnixMilli(), 10),
+		"end":   strconv.FormatInt(end.UnixMilli(), 10),
+		"step":  step.String(),
+		"dim":   "time",
+	}
+}
+
+// parseSeries returns the labels and values of a sample from ExecuteRaw
+func parseSeries(sample map[string]interface{}) (map[string]string, []map[string]interface{}) {
+	labels := make(map[string]string)
+	if metric, ok := sample["metric"].(map[string]interface{}); ok {
+		for name, v := range metric {
+			if s, ok := v.(string); ok {
+				labels[name] = s
+			}
+		}
+	}
+	values, _ := sample["values"].([]map[string]interface{})
+	return labels, values
+}
+
+func unionKeys(a, b map[string]float64) []string {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
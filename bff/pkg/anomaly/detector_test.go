@@ -0,0 +1,233 @@
+package anomaly
+
+import (
+	"local/bff/pkg/events"
+	"local/bff/pkg/metrics/metricstest"
+	"local/bff/pkg/query_storage"
+	"local/bff/pkg/state_storage/statetest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var sumByRegex = regexp.MustCompile(`^sum by \((\w+)\)`)
+
+// newTestMetrics answers range queries by evaluating load at every step. load
+// returns the value of each series at t, keyed by the value of the label the
+// query sums by.
+func newTestMetrics(load func(query string, t time.Time) map[string]float64) *metricstest.MockService {
+	m := new(metricstest.MockService)
+	m.On("ExecuteRaw", mock.Anything, mock.Anything).Return(func(query string, options map[string]string) ([]map[string]interface{}, error) {
+		return evaluate(load, query, options), nil
+	})
+	return m
+}
+
+func evaluate(load func(string, time.Time) map[string]float64, query string, options map[string]string) []map[string]interface{} {
+	if options["dim"] != "time" {
+		return []map[string]interface{}{{
+			"metric": map[string]interface{}{"sys_id": "db1", "sys_scope": "us-west-2", "sys_type": "amazon_rds"},
+			"values": []map[string]interface{}{{"timestamp": int64(0), "value": 1.0}},
+		}}
+	}
+
+	start, _ := strconv.ParseInt(options["start"], 10, 64)
+	end, _ := strconv.ParseInt(options["end"], 10, 64)
+	step, _ := time.ParseDuration(options["step"])
+	label := sumByRegex.FindStringSubmatch(query)[1]
+
+	series := make(map[string][]map[string]interface{})
+	var order []string
+	for ts := start; ts <= end; ts += step.Milliseconds() {
+		for value, v := range load(query, time.UnixMilli(ts)) {
+			if _, ok := series[value]; !ok {
+				order = append(order, value)
+			}
+			series[value] = append(series[value], map[string]interface{}{"timestamp": ts, "value": v})
+		}
+	}
+
+	var samples []map[string]interface{}
+	for _, value := range order {
+		samples = append(samples, map[string]interface{}{
+			"metric": map[string]interface{}{label: value},
+			"values": series[value],
+		})
+	}
+	return samples
+}
+
+var (
+	testSystem = query_storage.SystemRef{SystemType: "amazon_rds", SystemID: "db1", SystemScope: "us-west-2"}
+	// testNow is a Monday at 10:00 UTC, hour-of-week 10
+	testNow = time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+)
+
+// spikingLoad has a steady baseline of about 1 CPU and 0.5 IO active
+// sessions, and, after spikeAt, 4 CPU active sessions, mostly from query 111
+func spikingLoad(spikeAt time.Time) func(string, time.Time) map[string]float64 {
+	return func(query string, t time.Time) map[string]float64 {
+		spike := !t.Before(spikeAt)
+		cpu := 1.0
+		if t.Unix()/300%2 == 0 {
+			cpu = 1.2
+		}
+		if spike {
+			cpu = 4
+		}
+
+		switch {
+		case strings.HasPrefix(query, "sum by (wait_event_class)"):
+			return map[string]float64{"CPU": cpu, "IO": 0.5}
+		case strings.HasPrefix(query, "sum by (query_fp)"):
+			if spike {
+				return map[string]float64{"111": 3.5, "222": 0.5}
+			}
+			return map[string]float64{"111": cpu - 1, "222": 1}
+		case strings.HasPrefix(query, "sum by (wait_event_name)"):
+			return map[string]float64{"CPU": cpu}
+		default:
+			return map[string]float64{"web": cpu}
+		}
+	}
+}
+
+func newTestDetector(t *testing.T, m *metricstest.MockService) *Detector {
+	detector, err := NewDetector(Config{}, m, events.NewStore(statetest.Open(t)))
+	require.NoError(t, err)
+	return detector
+}
+
+func TestHourOfWeek(t *testing.T) {
+	assert.Equal(t, 0, HourOfWeek(time.Date(2026, 10, 19, 0, 30, 0, 0, time.UTC)))
+	assert.Equal(t, 10, HourOfWeek(testNow))
+	assert.Equal(t, 167, HourOfWeek(time.Date(2026, 10, 25, 23, 59, 0, 0, time.UTC)))
+	assert.Equal(t, 0, HourOfWeek(time.Date(2026, 10, 18, 20, 0, 0, 0, time.FixedZone("EDT", -4*3600))))
+}
+
+func TestLearnBaseline(t *testing.T) {
+	o := newObservations()
+	for i, cpu := range []float64{1, 3, 2, 2} {
+		ts := testNow.Add(time.Duration(i) * 5 * time.Minute).UnixMilli()
+		o.add("CPU", ts, cpu)
+		if i == 0 {
+			o.add("Lock", ts, 4)
+		}
+	}
+
+	baseline := learnBaseline(o, testNow)
+	assert.Equal(t, []string{"CPU", "Lock"}, baseline.WaitEventClasses())
+	assert.Equal(t, Stats{Mean: 2, StdDev: 0.7071067811865476, Samples: 4}, baseline.Stats("CPU", 10))
+	// Lock had no active sessions at three of the four observations
+	assert.Equal(t, 1.0, baseline.Stats("Lock", 10).Mean)
+	assert.Equal(t, Stats{Samples: 4}, baseline.Stats("IO", 10))
+	assert.Equal(t, Stats{}, baseline.Stats("CPU", 11))
+}
+
+func TestDetectRecordsAttributedAnomaly(t *testing.T) {
+	spikeAt := testNow.Add(-defaultWindow)
+	m := newTestMetrics(spikingLoad(spikeAt))
+	detector := newTestDetector(t, m)
+
+	anomalies, err := detector.Detect(testSystem, testNow)
+	require.NoError(t, err)
+	require.Len(t, anomalies, 1)
+
+	a := anomalies[0]
+	assert.Equal(t, "CPU", a.WaitEventClass)
+	assert.Equal(t, DirectionAbove, a.Direction)
+	assert.Equal(t, 10, a.HourOfWeek)
+	assert.Equal(t, 4.0, a.Current)
+	assert.InDelta(t, 1.1, a.Expected, 0.01)
+	assert.Greater(t, a.ZScore, 3.0)
+
+	require.Len(t, a.Attribution["query_fp"], 1, "query 222 lost load and does not explain the increase")
+	top := a.Attribution["query_fp"][0]
+	assert.Equal(t, "111", top.Value)
+	assert.Equal(t, 3.5, top.Current)
+	assert.InDelta(t, 3.4, top.Delta, 0.05)
+	assert.Equal(t, "CPU", a.Attribution["wait_event_name"][0].Value)
+	assert.Equal(t, "web", a.Attribution["application_name"][0].Value)
+
+	recorded, err := detector.Events().List(testSystem, 0, testNow.UnixMilli(), EventSource)
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, EventKind, recorded[0].Kind)
+	assert.Equal(t, spikeAt.UnixMilli(), recorded[0].StartsAt)
+	assert.Equal(t, "CPU load of 4.00 active sessions is above the usual 1.10", recorded[0].Title)
+
+	// The baseline is reused and the ongoing anomaly extends its event
+	queries := len(m.Calls)
+	later := testNow.Add(defaultInterval)
+	_, err = detector.Detect(testSystem, later)
+	require.NoError(t, err)
+
+	recorded, err = detector.Events().List(testSystem, 0, later.UnixMilli(), EventSource)
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, later.UnixMilli(), recorded[0].EndsAt)
+	assert.Equal(t, queries+1+len(AttributionDimensions)*(1+defaultBaselineWeeks), len(m.Calls))
+}
+
+func TestDetectIgnoresNormalLoadAndShortHistory(t *testing.T) {
+	t.Run("Normal load", func(t *testing.T) {
+		detector := newTestDetector(t, newTestMetrics(spikingLoad(testNow.Add(time.Hour))))
+		anomalies, err := detector.Detect(testSystem, testNow)
+		require.NoError(t, err)
+		assert.Empty(t, anomalies)
+	})
+
+	t.Run("Short history", func(t *testing.T) {
+		load := spikingLoad(testNow.Add(-defaultWindow))
+		detector := newTestDetector(t, newTestMetrics(func(query string, ts time.Time) map[string]float64 {
+			if ts.Before(testNow.Add(-24 * time.Hour)) {
+				return nil
+			}
+			return load(query, ts)
+		}))
+		anomalies, err := detector.Detect(testSystem, testNow)
+		require.NoError(t, err)
+		assert.Empty(t, anomalies)
+	})
+}
+
+func TestDetectAll(t *testing.T) {
+	detector := newTestDetector(t, newTestMetrics(spikingLoad(testNow.Add(-defaultWindow))))
+	require.NoError(t, detector.DetectAll(testNow))
+
+	recorded, err := detector.Events().List(testSystem, 0, testNow.UnixMilli(), EventSource)
+	require.NoError(t, err)
+	assert.Len(t, recorded, 1)
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{name: "Defaults", config: Config{}},
+		{name: "Invalid interval", config: Config{Interval: "often"}, wantErr: "interval"},
+		{name: "Window of an hour", config: Config{Window: "1h"}, wantErr: "window"},
+		{name: "Negative z-score", config: Config{ZScore: -1}, wantErr: "must not be negative"},
+		{name: "Too many baseline points", config: Config{BaselineWeeks: 8, BaselineStep: "1m"}, wantErr: "raise baseline_step"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// Package configutil holds helpers shared by the config sections of the bff
+package configutil
+
+import (
+	"fmt"
+	"time"
+)
+
+// ParseDuration parses the duration setting field, which falls back to def
+// when value is empty. Negative durations are rejected, and so is zero unless
+// allowZero is set.
+func ParseDuration(field, value string, def time.Duration, allowZero bool) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 || (d == 0 && !allowZero) {
+		return 0, fmt.Errorf("%s: invalid duration %q", field, value)
+	}
+	return d, nil
+}
+
+// DurationField is a positive duration setting parsed into Target
+type DurationField struct {
+	Name    string
+	Value   string
+	Default time.Duration
+	Target  *time.Duration
+}
+
+// ParseDurations parses every field with ParseDuration, stopping at the first
+// invalid one
+func ParseDurations(fields ...DurationField) error {
+	for _, field := range fields {
+		d, err := ParseDuration(field.Name, field.Value, field.Default, false)
+		if err != nil {
+			return err
+		}
+		*field.Target = d
+	}
+	return nil
+}
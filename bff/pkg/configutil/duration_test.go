@@ -0,0 +1,41 @@
+package configutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDuration(t *testing.T) {
+	d, err := ParseDuration("interval", "", time.Minute, false)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, d)
+
+	d, err = ParseDuration("interval", "90s", time.Minute, false)
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	d, err = ParseDuration("for", "0s", time.Minute, true)
+	require.NoError(t, err)
+	assert.Zero(t, d)
+
+	for _, value := range []string{"0s", "-1m", "soon"} {
+		_, err = ParseDuration("interval", value, time.Minute, false)
+		assert.EqualError(t, err, `interval: invalid duration "`+value+`"`)
+	}
+}
+
+func TestParseDurations(t *testing.T) {
+	var interval, window time.Duration
+	require.NoError(t, ParseDurations(
+		DurationField{Name: "interval", Value: "30s", Default: time.Minute, Target: &interval},
+		DurationField{Name: "window", Default: time.Hour, Target: &window},
+	))
+	assert.Equal(t, 30*time.Second, interval)
+	assert.Equal(t, time.Hour, window)
+
+	err := ParseDurations(DurationField{Name: "window", Value: "0", Target: &window})
+	assert.EqualError(t, err, `window: invalid duration "0"`)
+}
@@ -0,0 +1,129 @@
+// Package events stores the events of a system, such as detected anomalies,
+// that are shown alongside its metrics.
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/query_storage"
+)
+
+// Event is something that happened on a system over a time range, in unix
+// milliseconds. Details hold source specific data.
+type Event struct {
+	ID        int64                   `json:"id"`
+	System    query_storage.SystemRef `json:"-"`
+	Source    string                  `json:"source"`
+	Kind      string                  `json:"kind"`
+	Title     string                  `json:"title"`
+	StartsAt  int64                   `json:"starts_at"`
+	EndsAt    int64                   `json:"ends_at"`
+	Tags      []string                `json:"tags"`
+	Link      string                  `json:"link"`
+	Details   json.RawMessage         `json:"details,omitempty"`
+	DedupKey  string                  `json:"-"`
+	CreatedAt int64                   `json:"created_at"`
+}
+
+// Store persists events in the bff state database
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Add stores e and returns it with its ID
+func (s *Store) Add(e Event) (Event, error) {
+	if e.Tags == nil {
+		e.Tags = []string{}
+	}
+	tags, err := json.Marshal(e.Tags)
+	if err != nil {
+		return Event{}, err
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO events (sys_id, sys_scope, sys_type, source, kind, title, starts_at, ends_at, tags, link, details, dedup_key, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.System.SystemID, e.System.SystemScope, e.System.SystemType, e.Source, e.Kind, e.Title,
+		e.StartsAt, e.EndsAt, string(tags), e.Link, nullDetails(e.Details), e.DedupKey, e.CreatedAt)
+	if err != nil {
+		return Event{}, fmt.Errorf("add event: %w", err)
+	}
+
+	e.ID, err = result.LastInsertId()
+	return e, err
+}
+
+// Extend moves the end of the event with the given ID to endsAt and replaces
+// its title and details
+func (s *Store) Extend(id int64, endsAt int64, title string, details json.RawMessage) error {
+	_, err := s.db.Exec(`UPDATE events SET ends_at = MAX(ends_at, ?), title = ?, details = ? WHERE id = ?`,
+		endsAt, title, nullDetails(details), id)
+	if err != nil {
+		return fmt.Errorf("extend event %d: %w", id, err)
+	}
+	return nil
+}
+
+// Ongoing returns the latest event of system with dedupKey that ended at or
+// after since, or nil if there is none
+func (s *Store) Ongoing(system query_storage.SystemRef, dedupKey string, since int64) (*Event, error) {
+	events, err := s.list(`
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND dedup_key = ? AND ends_at >= ?
+		ORDER BY ends_at DESC LIMIT 1`,
+		system.SystemID, system.SystemScope, system.SystemType, dedupKey, since)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	return &events[0], nil
+}
+
+// List returns the events of system overlapping [start, end], oldest first,
+// optionally only those from one source
+func (s *Store) List(system query_storage.SystemRef, start, end int64, source string) ([]Event, error) {
+	where := `WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND starts_at <= ? AND ends_at >= ?`
+	args := []interface{}{system.SystemID, system.SystemScope, system.SystemType, end, start}
+	if source != "" {
+		where += ` AND source = ?`
+		args = append(args, source)
+	}
+	return s.list(where+` ORDER BY starts_at, id`, args...)
+}
+
+func (s *Store) list(where string, args ...interface{}) ([]Event, error) {
+	rows, err := s.db.Query(`
+		SELECT id, sys_id, sys_scope, sys_type, source, kind, title, starts_at, ends_at, tags, link, details, dedup_key, created_at
+		FROM events `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var tags string
+		var details sql.NullString
+		err := rows.Scan(&e.ID, &e.System.SystemID, &e.System.SystemScope, &e.System.SystemType, &e.Source, &e.Kind, &e.Title,
+			&e.StartsAt, &e.EndsAt, &tags, &e.Link, &details, &e.DedupKey, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tags), &e.Tags); err != nil {
+			return nil, fmt.Errorf("decode tags of event %d: %w", e.ID, err)
+		}
+		if details.Valid {
+			e.Details = json.RawMessage(details.String)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func nullDetails(details json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(details), Valid: len(details) > 0}
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"encoding/json"
+	"local/bff/pkg/query_storage"
+	"local/bff/pkg/state_storage/statetest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T) *Store {
+	db := statetest.Open(t)
+	return NewStore(db)
+}
+
+func TestStore(t *testing.T) {
+	store := newTestStore(t)
+	system := query_storage.SystemRef{SystemType: "amazon_rds", SystemID: "db1", SystemScope: "us-west-2"}
+	other := query_storage.SystemRef{SystemType: "amazon_rds", SystemID: "db2", SystemScope: "us-west-2"}
+
+	first, err := store.Add(Event{System: system, Source: "anomaly", Kind: "aas_anomaly", Title: "CPU high",
+		StartsAt: 1000, EndsAt: 2000, Details: json.RawMessage(`{"current":4}`), DedupKey: "cpu", CreatedAt: 2000})
+	require.NoError(t, err)
+	assert.NotZero(t, first.ID)
+
+	_, err = store.Add(Event{System: system, Source: "user", Kind: "deploy", Title: "Release 1.2",
+		StartsAt: 5000, EndsAt: 5000, Tags: []string{"deploy"}, Link: "https://example.com/1.2", CreatedAt: 5000})
+	require.NoError(t, err)
+	_, err = store.Add(Event{System: other, Source: "anomaly", Kind: "aas_anomaly", Title: "IO high",
+		StartsAt: 1000, EndsAt: 2000, DedupKey: "cpu", CreatedAt: 2000})
+	require.NoError(t, err)
+
+	listed, err := store.List(system, 0, 10000, "")
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, "CPU high", listed[0].Title)
+	assert.Equal(t, []string{}, listed[0].Tags)
+	assert.JSONEq(t, `{"current":4}`, string(listed[0].Details))
+	assert.Equal(t, []string{"deploy"}, listed[1].Tags)
+	assert.Nil(t, listed[1].Details)
+
+	listed, err = store.List(system, 2500, 10000, "anomaly")
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+
+	ongoing, err := store.Ongoing(system, "cpu", 1500)
+	require.NoError(t, err)
+	require.NotNil(t, ongoing)
+	assert.Equal(t, first.ID, ongoing.ID)
+
+	require.NoError(t, store.Extend(first.ID, 3000, "CPU still high", json.RawMessage(`{"current":5}`)))
+	listed, err = store.List(system, 2500, 10000, "anomaly")
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, int64(3000), listed[0].EndsAt)
+	assert.Equal(t, "CPU still high", listed[0].Title)
+
+	ongoing, err = store.Ongoing(system, "cpu", 3500)
+	require.NoError(t, err)
+	assert.Nil(t, ongoing)
+}
@@ -0,0 +1,94 @@
+// Package instances stores what users tell about their systems, such as a
+// display name, environment, team and tags, next to what the collectors
+// report about them.
+package instances
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/query_storage"
+)
+
+// Metadata is what users set on a system. UpdatedAt is in unix milliseconds.
+type Metadata struct {
+	System      query_storage.SystemRef `json:"-"`
+	DisplayName string                  `json:"display_name"`
+	Environment string                  `json:"environment"`
+	Team        string                  `json:"team"`
+	Tags        []string                `json:"tags"`
+	UpdatedAt   int64                   `json:"updated_at"`
+}
+
+// HasTag reports whether tag is one of the tags of m
+func (m Metadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Store persists metadata in the bff state database
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Set replaces the metadata of m.System
+func (s *Store) Set(m Metadata) (Metadata, error) {
+	if m.Tags == nil {
+		m.Tags = []string{}
+	}
+	tags, err := json.Marshal(m.Tags)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO instance_metadata (sys_id, sys_scope, sys_type, display_name, environment, team, tags, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (sys_id, sys_scope, sys_type) DO UPDATE SET
+			display_name = excluded.display_name,
+			environment = excluded.environment,
+			team = excluded.team,
+			tags = excluded.tags,
+			updated_at = excluded.updated_at`,
+		m.System.SystemID, m.System.SystemScope, m.System.SystemType, m.DisplayName, m.Environment, m.Team,
+		string(tags), m.UpdatedAt)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("set instance metadata: %w", err)
+	}
+	return m, nil
+}
+
+// List returns the metadata of every system that has any, by system
+func (s *Store) List() (map[query_storage.SystemRef]Metadata, error) {
+	rows, err := s.db.Query(`
+		SELECT sys_id, sys_scope, sys_type, display_name, environment, team, tags, updated_at
+		FROM instance_metadata`)
+	if err != nil {
+		return nil, fmt.Errorf("list instance metadata: %w", err)
+	}
+	defer rows.Close()
+
+	metadata := make(map[query_storage.SystemRef]Metadata)
+	for rows.Next() {
+		var m Metadata
+		var tags string
+		err := rows.Scan(&m.System.SystemID, &m.System.SystemScope, &m.System.SystemType, &m.DisplayName,
+			&m.Environment, &m.Team, &tags, &m.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tags), &m.Tags); err != nil {
+			return nil, fmt.Errorf("decode tags of %s/%s/%s: %w", m.System.SystemType, m.System.SystemID, m.System.SystemScope, err)
+		}
+		metadata[m.System] = m
+	}
+	return metadata, rows.Err()
+}
@@ -0,0 +1,37 @@
+package instances
+
+import (
+	"local/bff/pkg/query_storage"
+	"local/bff/pkg/state_storage/statetest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	db := statetest.Open(t)
+	store := NewStore(db)
+
+	system := query_storage.SystemRef{SystemType: "amazon_rds", SystemID: "db1", SystemScope: "us-west-2"}
+	other := query_storage.SystemRef{SystemType: "self_hosted", SystemID: "db2"}
+
+	_, err := store.Set(Metadata{System: system, DisplayName: "Orders", Environment: "staging", Tags: []string{"billing"}, UpdatedAt: 1000})
+	require.NoError(t, err)
+	saved, err := store.Set(Metadata{System: other, UpdatedAt: 1000})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, saved.Tags)
+
+	updated := Metadata{System: system, DisplayName: "Orders", Environment: "production", Team: "payments", Tags: []string{"billing", "tier:1"}, UpdatedAt: 2000}
+	_, err = store.Set(updated)
+	require.NoError(t, err)
+
+	metadata, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, map[query_storage.SystemRef]Metadata{
+		system: updated,
+		other:  {System: other, Tags: []string{}, UpdatedAt: 1000},
+	}, metadata)
+	assert.True(t, metadata[system].HasTag("tier:1"))
+	assert.False(t, metadata[system].HasTag("tier"))
+}
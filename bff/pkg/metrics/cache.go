@@ -0,0 +1,473 @@
+package metrics
+
+import (
+	"container/list"
+	"fmt"
+	"local/bff/pkg/configutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheConfig is the "query_cache" section of the bff config file
+type CacheConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// RecentTTL is how long the result of a window ending within
+	// RecentWindow of now is kept
+	RecentTTL string `mapstructure:"recent_ttl"`
+	// HistoricalTTL is how long the result of an older window is kept
+	HistoricalTTL string `mapstructure:"historical_ttl"`
+	// RecentWindow is how far back data can still change as late snapshots
+	// arrive
+	RecentWindow string `mapstructure:"recent_window"`
+	// InstantStep is the resolution instant queries are aligned to
+	InstantStep string `mapstructure:"instant_step"`
+	// MaxEntries is how many results are kept
+	MaxEntries int `mapstructure:"max_entries"`
+	// MaxSamples is how many samples are kept over all results; a result
+	// with more samples is not cached
+	MaxSamples int `mapstructure:"max_samples"`
+}
+
+const (
+	defaultRecentTTL     = 15 * time.Second
+	defaultHistoricalTTL = 10 * time.Minute
+	defaultRecentWindow  = FullSnapshotWindow
+	defaultInstantStep   = 15 * time.Second
+	defaultMaxEntries    = 2000
+	defaultMaxSamples    = 2_000_000
+
+	// defaultRangeStep is the step the Prometheus repository uses when a
+	// range query has none
+	defaultRangeStep = 30 * time.Second
+)
+
+// cacheSettings is a validated CacheConfig with its defaults applied
+type cacheSettings struct {
+	recentTTL     time.Duration
+	historicalTTL time.Duration
+	recentWindow  time.Duration
+	instantStep   time.Duration
+	maxEntries    int
+	maxSamples    int
+}
+
+// Validate checks the configuration
+func (c CacheConfig) Validate() error {
+	_, err := c.settings()
+	return err
+}
+
+func (c CacheConfig) settings() (cacheSettings, error) {
+	s := cacheSettings{
+		maxEntries: c.MaxEntries,
+		maxSamples: c.MaxSamples,
+	}
+
+	if err := configutil.ParseDurations(
+		configutil.DurationField{Name: "recent_ttl", Value: c.RecentTTL, Default: defaultRecentTTL, Target: &s.recentTTL},
+		configutil.DurationField{Name: "historical_ttl", Value: c.HistoricalTTL, Default: defaultHistoricalTTL, Target: &s.historicalTTL},
+		configutil.DurationField{Name: "recent_window", Value: c.RecentWindow, Default: defaultRecentWindow, Target: &s.recentWindow},
+		configutil.DurationField{Name: "instant_step", Value: c.InstantStep, Default: defaultInstantStep, Target: &s.instantStep},
+	); err != nil {
+		return s, err
+	}
+
+	if s.maxEntries == 0 {
+		s.maxEntries = defaultMaxEntries
+	}
+	if s.maxSamples == 0 {
+		s.maxSamples = defaultMaxSamples
+	}
+	if s.maxEntries < 0 || s.maxSamples < 0 {
+		return s, fmt.Errorf("max_entries and max_samples must not be negative")
+	}
+	if s.instantStep < time.Millisecond {
+		return s, fmt.Errorf("instant_step must be at least 1ms, got %s", s.instantStep)
+	}
+	return s, nil
+}
+
+// CacheStats counts how the cache answered queries since it was created
+type CacheStats struct {
+	Hits      uint64 // Answered from the cache
+	Misses    uint64 // Run against Prometheus
+	Shared    uint64 // Answered by an identical query already running
+	Bypassed  uint64 // Not cacheable, such as queries without a start
+	Evictions uint64 // Dropped to stay within the size limits
+	Entries   int
+	Samples   int
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	samples int
+	expires time.Time
+}
+
+// CachingRepository is a Repository that keeps the results of another one.
+// Queries are keyed on their text, with whitespace normalized, and their
+// options, with start and end aligned down to the step so that dashboards
+// refreshed within a step share results. Identical queries running at the
+// same time are run once. Results are kept for a short time when their
+// window is recent, since data can still arrive, and longer otherwise.
+//
+// Every caller gets its own copy of a result, so handlers are free to modify
+// what they get.
+type CachingRepository struct {
+	repo     Repository
+	settings cacheSettings
+	now      func() time.Time
+	group    singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Most recently used first
+	stats   CacheStats
+}
+
+func NewCachingRepository(repo Repository, config CacheConfig) (*CachingRepository, error) {
+	settings, err := config.settings()
+	if err != nil {
+		return nil, err
+	}
+	return &CachingRepository{
+		repo:     repo,
+		settings: settings,
+		now:      time.Now,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}, nil
+}
+
+func (c *CachingRepository) Execute(query string, options map[string]string) (*map[int64]map[string]float64, error) {
+	value, err := c.get("execute", query, options, true, func(options map[string]string) (interface{}, int, error) {
+		result, err := c.repo.Execute(query, options)
+		if err != nil {
+			return nil, 0, err
+		}
+		samples := 0
+		for _, record := range *result {
+			samples += len(record)
+		}
+		return result, samples, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*map[int64]map[string]float64), nil
+}
+
+func (c *CachingRepository) ExecuteRaw(query string, options map[string]string) ([]map[string]interface{}, error) {
+	isRange := options["dim"] == "" || options["dim"] == "time"
+	value, err := c.get("raw", query, options, isRange, func(options map[string]string) (interface{}, int, error) {
+		result, err := c.repo.ExecuteRaw(query, options)
+		if err != nil {
+			return nil, 0, err
+		}
+		samples := 0
+		for _, series := range result {
+			values, _ := series["values"].([]map[string]interface{})
+			samples += 1 + len(values)
+		}
+		return result, samples, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]map[string]interface{}), nil
+}
+
+// Stats returns the counters and size of the cache
+func (c *CachingRepository) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// get answers a query from the cache, or runs it with execute, which returns
+// the result and its number of samples
+func (c *CachingRepository) get(kind, query string, options map[string]string, isRange bool,
+	execute func(options map[string]string) (interface{}, int, error)) (interface{}, error) {
+	now := c.now()
+	key, aligned, end, ok := c.key(kind, query, options, isRange, now)
+	if !ok {
+		c.count(&c.stats.Bypassed)
+		value, _, err := execute(options)
+		return value, err
+	}
+
+	if value, ok := c.lookup(key, now); ok {
+		c.count(&c.stats.Hits)
+		return copyResult(value), nil
+	}
+
+	ran := false
+	value, err, _ := c.group.Do(key, func() (interface{}, error) {
+		ran = true
+		// An identical query may have finished since the lookup
+		if value, ok := c.lookup(key, now); ok {
+			c.count(&c.stats.Hits)
+			return value, nil
+		}
+
+		c.count(&c.stats.Misses)
+		value, samples, err := execute(aligned)
+		if err != nil {
+			return nil, err
+		}
+		ttl := c.settings.historicalTTL
+		if now.Sub(end) < c.settings.recentWindow {
+			ttl = c.settings.recentTTL
+		}
+		c.store(key, value, samples, now.Add(ttl))
+		return value, nil
+	})
+	if !ran {
+		c.count(&c.stats.Shared)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return copyResult(value), nil
+}
+
+// key returns the cache key of a query, its options with start and end
+// aligned down to the step and the aligned end. Queries without a start are
+// not cacheable: the repository runs them at the current time.
+func (c *CachingRepository) key(kind, query string, options map[string]string, isRange bool, now time.Time) (string, map[string]string, time.Time, bool) {
+	if options["start"] == "" {
+		return "", nil, time.Time{}, false
+	}
+	start, err := strconv.ParseInt(options["start"], 10, 64)
+	if err != nil {
+		return "", nil, time.Time{}, false
+	}
+	end := now.UnixMilli()
+	if options["end"] != "" {
+		if end, err = strconv.ParseInt(options["end"], 10, 64); err != nil {
+			return "", nil, time.Time{}, false
+		}
+	}
+
+	step := c.settings.instantStep
+	if isRange {
+		step = defaultRangeStep
+		if options["step"] != "" {
+			if step, err = time.ParseDuration(options["step"]); err != nil {
+				return "", nil, time.Time{}, false
+			}
+		}
+	}
+	stepMs := step.Milliseconds()
+	if stepMs <= 0 {
+		return "", nil, time.Time{}, false
+	}
+	start -= start % stepMs
+	end -= end % stepMs
+	end = max(end, start)
+
+	aligned := make(map[string]string, len(options)+1)
+	for k, v := range options {
+		aligned[k] = v
+	}
+	aligned["start"] = strconv.FormatInt(start, 10)
+	aligned["end"] = strconv.FormatInt(end, 10)
+
+	names := make([]string, 0, len(aligned))
+	for name := range aligned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	key.WriteString(kind)
+	key.WriteByte(0)
+	key.WriteString(normalizeQuery(query))
+	for _, name := range names {
+		key.WriteByte(0)
+		key.WriteString(name)
+		key.WriteByte('=')
+		key.WriteString(aligned[name])
+	}
+	return key.String(), aligned, time.UnixMilli(end), true
+}
+
+func (c *CachingRepository) lookup(key string, now time.Time) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *CachingRepository) store(key string, value interface{}, samples int, expires time.Time) {
+	if samples > c.settings.maxSamples {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, samples: samples, expires: expires})
+	c.stats.Samples += samples
+
+	for c.lru.Len() > c.settings.maxEntries || c.stats.Samples > c.settings.maxSamples {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops an entry; c.mu must be held
+func (c *CachingRepository) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.stats.Samples -= entry.samples
+}
+
+func (c *CachingRepository) count(counter *uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*counter++
+}
+
+var (
+	cacheRequestsDesc = prometheus.NewDesc("bff_query_cache_requests_total",
+		"Queries to Prometheus by how the cache answered them: hit, miss, shared with an identical running query, or bypass.",
+		[]string{"result"}, nil)
+	cacheEvictionsDesc = prometheus.NewDesc("bff_query_cache_evictions_total",
+		"Results dropped to keep the cache within its size limits.", nil, nil)
+	cacheEntriesDesc = prometheus.NewDesc("bff_query_cache_entries",
+		"Results in the cache.", nil, nil)
+	cacheSamplesDesc = prometheus.NewDesc("bff_query_cache_samples",
+		"Samples in the cached results.", nil, nil)
+)
+
+// Describe implements prometheus.Collector
+func (c *CachingRepository) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheRequestsDesc
+	ch <- cacheEvictionsDesc
+	ch <- cacheEntriesDesc
+	ch <- cacheSamplesDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *CachingRepository) Collect(ch chan<- prometheus.Metric) {
+	stats := c.Stats()
+	for result, value := range map[string]uint64{"hit": stats.Hits, "miss": stats.Misses, "shared": stats.Shared, "bypass": stats.Bypassed} {
+		ch <- prometheus.MustNewConstMetric(cacheRequestsDesc, prometheus.CounterValue, float64(value), result)
+	}
+	ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(stats.Entries))
+	ch <- prometheus.MustNewConstMetric(cacheSamplesDesc, prometheus.GaugeValue, float64(stats.Samples))
+}
+
+// normalizeQuery collapses runs of whitespace outside of string literals,
+// so that queries differing only in formatting share a cache entry
+func normalizeQuery(query string) string {
+	var b strings.Builder
+	var quote rune
+	escaped, space := false, false
+	for _, r := range strings.TrimSpace(query) {
+		switch {
+		case quote != 0:
+			b.WriteRune(r)
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\' && quote != '`':
+				escaped = true
+			case r == quote:
+				quote = 0
+			}
+			continue
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		if r == '"' || r == '\'' || r == '`' {
+			quote = r
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// copyResult deep copies a result, so callers cannot modify what is cached
+func copyResult(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		out := make([]map[string]interface{}, len(v))
+		for i, m := range v {
+			out[i] = copyResult(m).(map[string]interface{})
+		}
+		return out
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			out[k] = copyResult(x)
+		}
+		return out
+	case map[string]string:
+		if v == nil {
+			return v
+		}
+		out := make(map[string]string, len(v))
+		for k, x := range v {
+			out[k] = x
+		}
+		return out
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		out := make([]interface{}, len(v))
+		for i, x := range v {
+			out[i] = copyResult(x)
+		}
+		return out
+	case *map[int64]map[string]float64:
+		out := make(map[int64]map[string]float64, len(*v))
+		for t, record := range *v {
+			copied := make(map[string]float64, len(record))
+			for k, x := range record {
+				copied[k] = x
+			}
+			out[t] = copied
+		}
+		return &out
+	default:
+		return value
+	}
+}
@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func rawSeries(label string, values ...float64) map[string]interface{} {
+	points := make([]map[string]interface{}, 0, len(values))
+	for i, value := range values {
+		points = append(points, map[string]interface{}{"timestamp": int64(i), "value": value})
+	}
+	return map[string]interface{}{"metric": map[string]interface{}{"datname": label}, "values": points}
+}
+
+func newTestCache(t *testing.T, repo Repository, config CacheConfig, now *time.Time) *CachingRepository {
+	cache, err := NewCachingRepository(repo, config)
+	require.NoError(t, err)
+	cache.now = func() time.Time { return *now }
+	return cache
+}
+
+func TestCachingRepositoryAlignsAndReusesResults(t *testing.T) {
+	now := time.UnixMilli(10_000_000)
+	repo := new(MockRepository)
+	repo.On("ExecuteRaw", "sum by (datname) (cc_backend_count)", map[string]string{
+		"start": "9000000", "end": "9960000", "step": "1m", "dim": "time",
+	}).Return([]map[string]interface{}{rawSeries("app", 1, 2)}, nil).Once()
+	cache := newTestCache(t, repo, CacheConfig{}, &now)
+
+	result, err := cache.ExecuteRaw("sum by (datname) (cc_backend_count)", map[string]string{"start": "9000000", "end": "10000000", "step": "1m", "dim": "time"})
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{rawSeries("app", 1, 2)}, result)
+
+	// The caller owns its result
+	result[0]["metric"].(map[string]interface{})["datname"] = "changed"
+
+	// Same step, different formatting
+	result, err = cache.ExecuteRaw("sum by (datname)\n  (cc_backend_count)", map[string]string{"start": "9010000", "end": "9990000", "step": "1m", "dim": "time"})
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{rawSeries("app", 1, 2)}, result)
+
+	repo.AssertExpectations(t)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Entries: 1, Samples: 3}, cache.Stats())
+}
+
+func TestCachingRepositoryTTL(t *testing.T) {
+	now := time.UnixMilli(100_000_000)
+	repo := new(MockRepository)
+	repo.On("ExecuteRaw", mock.Anything, mock.Anything).Return([]map[string]interface{}{}, nil)
+	cache := newTestCache(t, repo, CacheConfig{RecentTTL: "15s", HistoricalTTL: "10m", RecentWindow: "15m"}, &now)
+
+	recent := map[string]string{"start": "99000000", "end": "100000000", "dim": "time"}
+	historical := map[string]string{"start": "10000000", "end": "20000000", "dim": "time"}
+	run := func() {
+		_, err := cache.ExecuteRaw("up", recent)
+		require.NoError(t, err)
+		_, err = cache.ExecuteRaw("up", historical)
+		require.NoError(t, err)
+	}
+
+	run()
+	now = now.Add(10 * time.Second)
+	run()
+	assert.Equal(t, uint64(2), cache.Stats().Misses)
+
+	now = now.Add(time.Minute)
+	run()
+	assert.Equal(t, uint64(3), cache.Stats().Misses, "only the recent window expired")
+
+	now = now.Add(10 * time.Minute)
+	run()
+	assert.Equal(t, uint64(5), cache.Stats().Misses)
+}
+
+// blockingRepository holds every query until release is closed
+type blockingRepository struct {
+	MockRepository
+	started chan struct{}
+	release chan struct{}
+	mu      sync.Mutex
+	calls   int
+}
+
+func (b *blockingRepository) ExecuteRaw(query string, options map[string]string) ([]map[string]interface{}, error) {
+	b.mu.Lock()
+	b.calls++
+	b.mu.Unlock()
+	close(b.started)
+	<-b.release
+	return []map[string]interface{}{rawSeries("app", 1)}, nil
+}
+
+func TestCachingRepositoryDeduplicatesConcurrentQueries(t *testing.T) {
+	now := time.UnixMilli(10_000_000)
+	repo := &blockingRepository{started: make(chan struct{}), release: make(chan struct{})}
+	cache := newTestCache(t, repo, CacheConfig{}, &now)
+	options := map[string]string{"start": "9000000", "end": "10000000", "dim": "time"}
+
+	var wg sync.WaitGroup
+	results := make([][]map[string]interface{}, 5)
+	run := func(i int) {
+		defer wg.Done()
+		result, err := cache.ExecuteRaw("up", options)
+		assert.NoError(t, err)
+		results[i] = result
+	}
+
+	wg.Add(1)
+	go run(0)
+	<-repo.started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go run(i)
+	}
+	// Give the other queries time to join the running one
+	time.Sleep(50 * time.Millisecond)
+	close(repo.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, repo.calls)
+	for _, result := range results {
+		assert.Equal(t, []map[string]interface{}{rawSeries("app", 1)}, result)
+	}
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(len(results)-1), stats.Hits+stats.Shared)
+}
+
+func TestCachingRepositorySizeLimits(t *testing.T) {
+	now := time.UnixMilli(10_000_000)
+	repo := new(MockRepository)
+	repo.On("ExecuteRaw", "small", mock.Anything).Return([]map[string]interface{}{rawSeries("app", 1)}, nil)
+	repo.On("ExecuteRaw", "large", mock.Anything).Return([]map[string]interface{}{rawSeries("app", 1, 2, 3, 4, 5)}, nil)
+	cache := newTestCache(t, repo, CacheConfig{MaxEntries: 2, MaxSamples: 5}, &now)
+
+	query := func(query, start string) {
+		_, err := cache.ExecuteRaw(query, map[string]string{"start": start, "end": "10000000", "dim": "time"})
+		require.NoError(t, err)
+	}
+
+	query("large", "9000000")
+	assert.Equal(t, 0, cache.Stats().Entries, "results above max_samples are not cached")
+
+	query("small", "9000000")
+	query("small", "8000000")
+	query("small", "9000000")
+	query("small", "7000000")
+	stats := cache.Stats()
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 4, Evictions: 1, Entries: 2, Samples: 4}, stats)
+
+	query("small", "9000000")
+	assert.Equal(t, uint64(2), cache.Stats().Hits, "the most recently used entry is kept")
+	query("small", "8000000")
+	assert.Equal(t, uint64(5), cache.Stats().Misses, "the least recently used entry was evicted")
+}
+
+func TestCachingRepositoryBypassesAndErrors(t *testing.T) {
+	now := time.UnixMilli(10_000_000)
+	repo := new(MockRepository)
+	repo.On("ExecuteRaw", "now", map[string]string{"dim": "datname"}).Return([]map[string]interface{}{}, nil).Twice()
+	repo.On("ExecuteRaw", "failing", mock.Anything).Return([]map[string]interface{}(nil), errors.New("timeout")).Twice()
+	total := map[int64]map[string]float64{9990000: {"value": 1}}
+	repo.On("Execute", "total", map[string]string{"start": "9000000", "end": "9990000", "step": "30s"}).Return(&total, nil).Once()
+	cache := newTestCache(t, repo, CacheConfig{}, &now)
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.ExecuteRaw("now", map[string]string{"dim": "datname"})
+		require.NoError(t, err)
+		_, err = cache.ExecuteRaw("failing", map[string]string{"start": "9000000", "dim": "datname"})
+		assert.Error(t, err)
+		result, err := cache.Execute("total", map[string]string{"start": "9000000", "end": "10000000", "step": "30s"})
+		require.NoError(t, err)
+		assert.Equal(t, &total, result)
+		(*result)[9990000]["value"] = 2
+	}
+
+	repo.AssertExpectations(t)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 3, Bypassed: 2, Entries: 1, Samples: 1}, cache.Stats())
+}
+
+func TestCachingRepositoryCollector(t *testing.T) {
+	now := time.UnixMilli(10_000_000)
+	cache := newTestCache(t, new(MockRepository), CacheConfig{}, &now)
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(cache))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	series := make(map[string]int)
+	for _, family := range families {
+		series[family.GetName()] = len(family.GetMetric())
+	}
+	assert.Equal(t, map[string]int{
+		"bff_query_cache_requests_total":  4,
+		"bff_query_cache_evictions_total": 1,
+		"bff_query_cache_entries":         1,
+		"bff_query_cache_samples":         1,
+	}, series)
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{query: "  sum by (datname)\n\t(cc_backend_count)  ", expected: "sum by (datname) (cc_backend_count)"},
+		{query: `cc_pg_stat_activity{query="SELECT  1"}`, expected: `cc_pg_stat_activity{query="SELECT  1"}`},
+		{query: `up{a="x \"  y"}  or  up{b='  '}`, expected: `up{a="x \"  y"} or up{b='  '}`},
+		{query: "up{a=~`  \\`}   or up", expected: "up{a=~`  \\`} or up"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			assert.Equal(t, tc.expected, normalizeQuery(tc.query))
+		})
+	}
+}
+
+func TestCacheConfigValidation(t *testing.T) {
+	assert.NoError(t, CacheConfig{}.Validate())
+	assert.NoError(t, CacheConfig{RecentTTL: "30s", HistoricalTTL: "1h", MaxEntries: 10}.Validate())
+	assert.Error(t, CacheConfig{RecentTTL: "soon"}.Validate())
+	assert.Error(t, CacheConfig{InstantStep: "1us"}.Validate())
+	assert.Error(t, CacheConfig{MaxSamples: -1}.Validate())
+}
@@ -2,8 +2,14 @@ package metrics
 
 import (
 	"fmt"
+	"time"
 )
 
+// FullSnapshotWindow is how far back the latest full snapshot of a system is
+// looked for. Collectors send one about every 10 minutes, so this leaves room
+// for one that arrives late.
+const FullSnapshotWindow = 15 * time.Minute
+
 // type TimeSeries map[int64]float64
 // type MetricsRecord map[string]float64
 // type AggregatedMetrics map[int64]MetricsRecord
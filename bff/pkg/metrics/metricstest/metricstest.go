@@ -0,0 +1,32 @@
+// Package metricstest provides a mock metrics.Service for tests of the
+// packages that query metrics.
+package metricstest
+
+import (
+	"github.com/stretchr/testify/mock"
+)
+
+// MockService is a testify mock of metrics.Service. Besides values, Return
+// accepts a function with the signature of the mocked method, which is called
+// to compute the result.
+type MockService struct {
+	mock.Mock
+}
+
+func (m *MockService) Execute(metrics map[string]string, options map[string]string) (map[int64]map[string]float64, error) {
+	args := m.Called(metrics, options)
+	if f, ok := args.Get(0).(func(map[string]string, map[string]string) (map[int64]map[string]float64, error)); ok {
+		return f(metrics, options)
+	}
+	result, _ := args.Get(0).(map[int64]map[string]float64)
+	return result, args.Error(1)
+}
+
+func (m *MockService) ExecuteRaw(query string, options map[string]string) ([]map[string]interface{}, error) {
+	args := m.Called(query, options)
+	if f, ok := args.Get(0).(func(string, map[string]string) ([]map[string]interface{}, error)); ok {
+		return f(query, options)
+	}
+	result, _ := args.Get(0).([]map[string]interface{})
+	return result, args.Error(1)
+}
@@ -1,6 +1,136 @@
 package query_storage
 
+// SystemRef identifies a monitored system, as encoded in a dbidentifier
+type SystemRef struct {
+	SystemType  string
+	SystemID    string
+	SystemScope string
+}
+
+// SystemQuery is the stored text and metadata of a query on a single system.
+// FirstSeen and LastSeen are unix seconds of the snapshots it was seen in.
+// InOrderSightings counts the snapshots that saw it later than any before
+// them, leaving out late ones.
+type SystemQuery struct {
+	Fingerprint      string
+	Query            string
+	QueryFull        string
+	Database         string
+	Role             string
+	FirstSeen        int64
+	LastSeen         int64
+	InOrderSightings int64
+}
+
+// SystemIndex is the latest definition and usage of an index on a single
+// system, from the full snapshots stored by collector-api. ScanCount is the
+// number of scans since the full snapshot before the latest one, and
+// ScansChangedAt the last snapshot, in unix seconds, that counted any scans
+// of it, or the first one it was seen in.
+type SystemIndex struct {
+	Database       string
+	Schema         string
+	Name           string
+	Relation       string
+	Definition     string
+	ConstraintDef  string
+	Type           string
+	IsPrimary      bool
+	IsUnique       bool
+	IsValid        bool
+	SizeBytes      int64
+	ScanCount      int64
+	ScansChangedAt int64
+	FirstSeen      int64
+	CollectedAt    int64
+}
+
+// SystemSetting is the latest raw value of a setting on a single system, as
+// reported by pg_settings in the full snapshots stored by collector-api. Empty
+// strings stand for NULL.
+type SystemSetting struct {
+	Name        string
+	Value       string
+	Unit        string
+	Source      string
+	SourceFile  string
+	BootValue   string
+	ResetValue  string
+	FirstSeen   int64
+	CollectedAt int64
+}
+
+// SettingChange is a setting whose value or source differs between two
+// consecutive full snapshots of a system. ChangeType is added, changed or
+// removed; the old fields are empty for added settings and the new ones for
+// removed settings.
+type SettingChange struct {
+	Name                string
+	ChangeType          string
+	Unit                string
+	OldValue            string
+	NewValue            string
+	OldSource           string
+	NewSource           string
+	PreviousCollectedAt int64
+	ChangedAt           int64
+}
+
+// SchemaEvent is a schema change detected between two consecutive full
+// snapshots of a system, such as a table created or a column altered. Type is
+// one of collector-api's event types, e.g. table_created or column_altered.
+// For table events Relation and Name are both the table name.
+type SchemaEvent struct {
+	Database            string
+	Type                string
+	Schema              string
+	Relation            string
+	Name                string
+	OldDefinition       string
+	NewDefinition       string
+	PreviousCollectedAt int64
+	DetectedAt          int64
+}
+
+// SystemInstance is the registry entry of a system, fed by collector-api from
+// every snapshot it receives. FirstSeen and LastSeen are the oldest and newest
+// snapshots, in unix seconds; the other facts come from the latest full
+// snapshot, collected at FactsCollectedAt, and are empty before the first one.
+type SystemInstance struct {
+	System           SystemRef
+	FirstSeen        int64
+	LastSeen         int64
+	PostgresVersion  string
+	Platform         string
+	InstanceClass    string
+	MemoryBytes      int64
+	LogicalCores     int64
+	CollectorVersion string
+	FactsCollectedAt int64
+}
+
 type QueryStorage interface {
 	GetQuery(fingerprint string) (string, error)
 	GetFullQuery(fingerprint string) (string, error)
+	// GetQueries looks up the normalized text of many fingerprints of a
+	// system at once. Fingerprints without stored text are absent from the
+	// result.
+	GetQueries(system SystemRef, fingerprints []string) (map[string]string, error)
+	ListQueries(system SystemRef, start, end int64) ([]SystemQuery, error)
+	ListNewQueries(system SystemRef, since int64) ([]SystemQuery, error)
+	// SearchQueries full-text searches the normalized and full query text of a
+	// system for queries seen between start and end (unix seconds). It returns
+	// the limit most recently seen matches, newest first, so callers ranking
+	// matches another way must ask for every candidate they rank.
+	SearchQueries(system SystemRef, text string, start, end int64, limit int) ([]SystemQuery, error)
+	ListIndexes(system SystemRef) ([]SystemIndex, error)
+	ListSettings(system SystemRef) ([]SystemSetting, error)
+	// ListSettingChanges returns the setting changes of a system detected
+	// between start and end (unix seconds, inclusive), oldest first
+	ListSettingChanges(system SystemRef, start, end int64) ([]SettingChange, error)
+	// ListSchemaEvents returns the schema events of a system detected between
+	// start and end (unix seconds, inclusive), oldest first
+	ListSchemaEvents(system SystemRef, start, end int64) ([]SchemaEvent, error)
+	// ListInstances returns the registry entries of every system
+	ListInstances() ([]SystemInstance, error)
 }
@@ -2,25 +2,100 @@ package query_storage
 
 import (
 	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// CollectorSchemaVersion is the newest collector-api schema version this build
+// knows how to read. Bump it together with new collector-api migrations;
+// TestCollectorSchemaVersionMatchesMigrations fails until it is.
+const CollectorSchemaVersion = 10
+
+// QueryCacheSize is the number of system fingerprints whose text lookups are
+// cached
+const QueryCacheSize = 10000
+
+// QueryCacheTTL bounds how long a found text is served from the cache, since
+// collector-api can rewrite stored texts, e.g. when redacting them
+const QueryCacheTTL = time.Minute
+
+// maxLookupBatch keeps IN lists well below SQLite's bound parameter limit
+const maxLookupBatch = 500
+
 type SQLiteQueryStorage struct {
-	db *sql.DB
+	db    *sql.DB
+	cache *lruCache[queryKey, cachedQuery]
+	now   func() time.Time
+}
+
+// queryKey identifies a fingerprint on a system, since the same fingerprint
+// can have different text on different systems
+type queryKey struct {
+	system      SystemRef
+	fingerprint string
+}
+
+// cachedQuery is a cached text lookup. A hit is trusted for QueryCacheTTL
+// after loadedAt; a miss is only trusted while no new queries have been
+// stored since, as tracked by generation.
+type cachedQuery struct {
+	text       string
+	found      bool
+	loadedAt   time.Time
+	generation int64
 }
 
 func NewSQLiteQueryStorage(dbPath string) (*SQLiteQueryStorage, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// collector-api writes the database while the bff reads it, so wait for
+	// its locks instead of failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000")
 	if err != nil {
 		return nil, err
 	}
 
-	storage := &SQLiteQueryStorage{db: db}
+	if err := checkSchemaVersion(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	storage := &SQLiteQueryStorage{
+		db:    db,
+		cache: newLRUCache[queryKey, cachedQuery](QueryCacheSize),
+		now:   time.Now,
+	}
 
 	return storage, nil
 }
 
+// checkSchemaVersion refuses to use a database migrated by a newer
+// collector-api. An older or not yet migrated database is only logged, since
+// collector-api may still be starting up and will migrate it.
+func checkSchemaVersion(db *sql.DB) error {
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			log.Printf("Warning: collector database has no schema_version table yet; expecting version %d", CollectorSchemaVersion)
+			return nil
+		}
+		return fmt.Errorf("read collector schema version: %w", err)
+	}
+
+	if int(version.Int64) > CollectorSchemaVersion {
+		return fmt.Errorf("collector database schema version %d is newer than the supported version %d", version.Int64, CollectorSchemaVersion)
+	}
+
+	if int(version.Int64) < CollectorSchemaVersion {
+		log.Printf("Warning: collector database schema version %d is older than expected version %d", version.Int64, CollectorSchemaVersion)
+	}
+
+	return nil
+}
+
 func (s *SQLiteQueryStorage) GetQuery(fingerprint string) (string, error) {
 	var query string
 	err := s.db.QueryRow("SELECT query FROM queries WHERE fingerprint = ?", fingerprint).Scan(&query)
@@ -32,3 +107,313 @@ func (s *SQLiteQueryStorage) GetFullQuery(fingerprint string) (string, error) {
 	err := s.db.QueryRow("SELECT full_query FROM full_queries WHERE fingerprint = ?", fingerprint).Scan(&fullQuery)
 	return fullQuery, err
 }
+
+func (s *SQLiteQueryStorage) GetQueries(system SystemRef, fingerprints []string) (map[string]string, error) {
+	texts := make(map[string]string, len(fingerprints))
+	seen := make(map[string]bool, len(fingerprints))
+	var pending, cachedMisses []string
+	now := s.now()
+
+	for _, fp := range fingerprints {
+		if seen[fp] {
+			continue
+		}
+		seen[fp] = true
+
+		entry, ok := s.cache.Get(queryKey{system, fp})
+		switch {
+		case !ok:
+			pending = append(pending, fp)
+		case entry.found && now.Sub(entry.loadedAt) < QueryCacheTTL:
+			texts[fp] = entry.text
+		case entry.found:
+			pending = append(pending, fp)
+		default:
+			cachedMisses = append(cachedMisses, fp)
+		}
+	}
+
+	if len(pending) == 0 && len(cachedMisses) == 0 {
+		return texts, nil
+	}
+
+	// Read the generation before loading, so a query stored concurrently is
+	// never cached as a miss under the newer generation
+	generation, err := s.queriesGeneration()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fp := range cachedMisses {
+		if entry, ok := s.cache.Get(queryKey{system, fp}); ok && !entry.found && entry.generation == generation {
+			continue
+		}
+		pending = append(pending, fp)
+	}
+
+	for start := 0; start < len(pending); start += maxLookupBatch {
+		end := start + maxLookupBatch
+		if end > len(pending) {
+			end = len(pending)
+		}
+		if err := s.loadQueries(system, pending[start:end], texts); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fp := range pending {
+		if text, ok := texts[fp]; ok {
+			s.cache.Add(queryKey{system, fp}, cachedQuery{text: text, found: true, loadedAt: now})
+		} else {
+			s.cache.Add(queryKey{system, fp}, cachedQuery{generation: generation})
+		}
+	}
+
+	return texts, nil
+}
+
+func (s *SQLiteQueryStorage) loadQueries(system SystemRef, fingerprints []string, texts map[string]string) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(fingerprints)), ",")
+	args := []interface{}{system.SystemID, system.SystemScope, system.SystemType}
+	for _, fp := range fingerprints {
+		args = append(args, fp)
+	}
+
+	rows, err := s.db.Query(`SELECT fingerprint, query FROM system_queries
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND fingerprint IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("look up queries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fp string
+		var text sql.NullString
+		if err := rows.Scan(&fp, &text); err != nil {
+			return err
+		}
+		texts[fp] = text.String
+	}
+	return rows.Err()
+}
+
+// queriesGeneration changes whenever a previously unseen query is stored.
+// system_queries rows are only ever inserted for new fingerprints (sightings
+// update in place), so the highest rowid is enough.
+func (s *SQLiteQueryStorage) queriesGeneration() (int64, error) {
+	var generation int64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(rowid), 0) FROM system_queries").Scan(&generation)
+	if err != nil {
+		return 0, fmt.Errorf("read queries generation: %w", err)
+	}
+	return generation, nil
+}
+
+const selectSystemQueries = `
+	SELECT fingerprint, query, full_query, datname, usename, first_seen, last_seen, in_order_sightings
+	FROM system_queries`
+
+func (s *SQLiteQueryStorage) ListQueries(system SystemRef, start, end int64) ([]SystemQuery, error) {
+	return s.listSystemQueries(selectSystemQueries+`
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?
+			AND last_seen >= ? AND first_seen <= ?
+		ORDER BY last_seen DESC`,
+		system.SystemID, system.SystemScope, system.SystemType, start, end)
+}
+
+func (s *SQLiteQueryStorage) ListNewQueries(system SystemRef, since int64) ([]SystemQuery, error) {
+	return s.listSystemQueries(selectSystemQueries+`
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND first_seen >= ?
+		ORDER BY first_seen DESC`,
+		system.SystemID, system.SystemScope, system.SystemType, since)
+}
+
+func (s *SQLiteQueryStorage) SearchQueries(system SystemRef, text string, start, end int64, limit int) ([]SystemQuery, error) {
+	match := MatchExpression(text)
+	if match == "" {
+		return []SystemQuery{}, nil
+	}
+
+	return s.listSystemQueries(`
+		SELECT sq.fingerprint, sq.query, sq.full_query, sq.datname, sq.usename, sq.first_seen, sq.last_seen, sq.in_order_sightings
+		FROM query_search
+		JOIN system_queries sq ON sq.rowid = query_search.rowid
+		WHERE query_search MATCH ?
+			AND sq.sys_id = ? AND sq.sys_scope = ? AND sq.sys_type = ?
+			AND sq.last_seen >= ? AND sq.first_seen <= ?
+		ORDER BY sq.last_seen DESC
+		LIMIT ?`,
+		match, system.SystemID, system.SystemScope, system.SystemType, start, end, limit)
+}
+
+// MatchExpression turns free-form search text into a full-text MATCH
+// expression. Every whitespace-separated term is quoted as a phrase so that
+// operators and punctuation in the text are matched literally, and all terms
+// must match.
+func MatchExpression(text string) string {
+	var phrases []string
+	for _, term := range strings.Fields(text) {
+		phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(phrases, " ")
+}
+
+func (s *SQLiteQueryStorage) listSystemQueries(query string, args ...interface{}) ([]SystemQuery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queries := []SystemQuery{}
+	for rows.Next() {
+		var q SystemQuery
+		var text, fullText, datname, usename sql.NullString
+		if err := rows.Scan(&q.Fingerprint, &text, &fullText, &datname, &usename, &q.FirstSeen, &q.LastSeen, &q.InOrderSightings); err != nil {
+			return nil, err
+		}
+		q.Query = text.String
+		q.QueryFull = fullText.String
+		q.Database = datname.String
+		q.Role = usename.String
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+func (s *SQLiteQueryStorage) ListIndexes(system SystemRef) ([]SystemIndex, error) {
+	rows, err := s.db.Query(`
+		SELECT datname, schema_name, index_name, relation, index_def, constraint_def, index_type,
+			is_primary, is_unique, is_valid, size_bytes, scan_count, scans_changed_at, first_seen, collected_at
+		FROM system_indexes
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?
+		ORDER BY datname, schema_name, relation, index_name`,
+		system.SystemID, system.SystemScope, system.SystemType)
+	if err != nil {
+		return nil, fmt.Errorf("list indexes: %w", err)
+	}
+	defer rows.Close()
+
+	var indexes []SystemIndex
+	for rows.Next() {
+		var idx SystemIndex
+		var constraintDef sql.NullString
+		err := rows.Scan(&idx.Database, &idx.Schema, &idx.Name, &idx.Relation, &idx.Definition, &constraintDef, &idx.Type,
+			&idx.IsPrimary, &idx.IsUnique, &idx.IsValid, &idx.SizeBytes, &idx.ScanCount, &idx.ScansChangedAt, &idx.FirstSeen, &idx.CollectedAt)
+		if err != nil {
+			return nil, err
+		}
+		idx.ConstraintDef = constraintDef.String
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
+
+func (s *SQLiteQueryStorage) ListSettings(system SystemRef) ([]SystemSetting, error) {
+	rows, err := s.db.Query(`
+		SELECT name, setting_value, unit, source, source_file, boot_value, reset_value, first_seen, collected_at
+		FROM system_settings
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?
+		ORDER BY name`,
+		system.SystemID, system.SystemScope, system.SystemType)
+	if err != nil {
+		return nil, fmt.Errorf("list settings: %w", err)
+	}
+	defer rows.Close()
+
+	var settings []SystemSetting
+	for rows.Next() {
+		var setting SystemSetting
+		var unit, source, sourceFile, bootValue, resetValue sql.NullString
+		err := rows.Scan(&setting.Name, &setting.Value, &unit, &source, &sourceFile, &bootValue, &resetValue,
+			&setting.FirstSeen, &setting.CollectedAt)
+		if err != nil {
+			return nil, err
+		}
+		setting.Unit, setting.Source, setting.SourceFile = unit.String, source.String, sourceFile.String
+		setting.BootValue, setting.ResetValue = bootValue.String, resetValue.String
+		settings = append(settings, setting)
+	}
+	return settings, rows.Err()
+}
+
+func (s *SQLiteQueryStorage) ListSettingChanges(system SystemRef, start, end int64) ([]SettingChange, error) {
+	rows, err := s.db.Query(`
+		SELECT name, change_type, unit, old_value, new_value, old_source, new_source, previous_collected_at, changed_at
+		FROM system_setting_changes
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND changed_at BETWEEN ? AND ?
+		ORDER BY changed_at, name`,
+		system.SystemID, system.SystemScope, system.SystemType, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("list setting changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []SettingChange
+	for rows.Next() {
+		var c SettingChange
+		var unit, oldValue, newValue, oldSource, newSource sql.NullString
+		err := rows.Scan(&c.Name, &c.ChangeType, &unit, &oldValue, &newValue, &oldSource, &newSource,
+			&c.PreviousCollectedAt, &c.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		c.Unit, c.OldValue, c.NewValue = unit.String, oldValue.String, newValue.String
+		c.OldSource, c.NewSource = oldSource.String, newSource.String
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func (s *SQLiteQueryStorage) ListSchemaEvents(system SystemRef, start, end int64) ([]SchemaEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT datname, event_type, schema_name, relation, object_name, old_definition, new_definition,
+			previous_collected_at, detected_at
+		FROM system_schema_events
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND detected_at BETWEEN ? AND ?
+		ORDER BY detected_at, id`,
+		system.SystemID, system.SystemScope, system.SystemType, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("list schema events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []SchemaEvent
+	for rows.Next() {
+		var e SchemaEvent
+		var oldDefinition, newDefinition sql.NullString
+		err := rows.Scan(&e.Database, &e.Type, &e.Schema, &e.Relation, &e.Name, &oldDefinition, &newDefinition,
+			&e.PreviousCollectedAt, &e.DetectedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.OldDefinition, e.NewDefinition = oldDefinition.String, newDefinition.String
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLiteQueryStorage) ListInstances() ([]SystemInstance, error) {
+	rows, err := s.db.Query(`
+		SELECT sys_id, sys_scope, sys_type, first_seen, last_seen, postgres_version, platform, instance_class,
+			memory_bytes, logical_cores, collector_version, facts_collected_at
+		FROM system_instances
+		ORDER BY sys_type, sys_id, sys_scope`)
+	if err != nil {
+		return nil, fmt.Errorf("list instances: %w", err)
+	}
+	defer rows.Close()
+
+	var instances []SystemInstance
+	for rows.Next() {
+		var i SystemInstance
+		err := rows.Scan(&i.System.SystemID, &i.System.SystemScope, &i.System.SystemType, &i.FirstSeen, &i.LastSeen,
+			&i.PostgresVersion, &i.Platform, &i.InstanceClass, &i.MemoryBytes, &i.LogicalCores, &i.CollectorVersion,
+			&i.FactsCollectedAt)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, i)
+	}
+	return instances, rows.Err()
+}
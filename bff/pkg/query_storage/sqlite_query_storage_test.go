@@ -0,0 +1,322 @@
+package query_storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStorage creates the subset of the collector schema the bff reads
+func newTestStorage(t *testing.T) (*SQLiteQueryStorage, *sql.DB) {
+	dbPath := filepath.Join(t.TempDir(), "collector.db")
+
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+	CREATE TABLE queries (fingerprint TEXT PRIMARY KEY, query TEXT, last_update INTEGER);
+	CREATE TABLE system_queries (
+		sys_id TEXT, sys_scope TEXT, sys_type TEXT, fingerprint TEXT, query TEXT, full_query TEXT,
+		datname TEXT, usename TEXT, first_seen INTEGER, last_seen INTEGER, in_order_sightings INTEGER,
+		PRIMARY KEY (sys_id, sys_scope, sys_type, fingerprint)
+	);
+	CREATE VIRTUAL TABLE query_search USING fts4(query, full_query);
+	CREATE TRIGGER system_queries_search_insert AFTER INSERT ON system_queries
+	BEGIN
+		INSERT INTO query_search (rowid, query, full_query) VALUES (new.rowid, new.query, new.full_query);
+	END;
+	CREATE TABLE system_indexes (
+		sys_id TEXT, sys_scope TEXT, sys_type TEXT, datname TEXT, schema_name TEXT, index_name TEXT, relation TEXT,
+		index_def TEXT, constraint_def TEXT, index_type TEXT, is_primary INTEGER, is_unique INTEGER, is_valid INTEGER,
+		size_bytes INTEGER, scan_count INTEGER, scans_changed_at INTEGER, first_seen INTEGER, collected_at INTEGER,
+		PRIMARY KEY (sys_id, sys_scope, sys_type, datname, schema_name, index_name)
+	);
+	CREATE TABLE system_settings (
+		sys_id TEXT, sys_scope TEXT, sys_type TEXT, name TEXT, setting_value TEXT, unit TEXT, source TEXT, source_file TEXT,
+		boot_value TEXT, reset_value TEXT, first_seen INTEGER, collected_at INTEGER,
+		PRIMARY KEY (sys_id, sys_scope, sys_type, name)
+	);
+	CREATE TABLE system_setting_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, sys_id TEXT, sys_scope TEXT, sys_type TEXT, name TEXT, change_type TEXT,
+		unit TEXT, old_value TEXT, new_value TEXT, old_source TEXT, new_source TEXT, previous_collected_at INTEGER, changed_at INTEGER
+	);
+	CREATE TABLE system_schema_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, sys_id TEXT, sys_scope TEXT, sys_type TEXT, datname TEXT, event_type TEXT,
+		schema_name TEXT, relation TEXT, object_name TEXT, old_definition TEXT, new_definition TEXT,
+		previous_collected_at INTEGER, detected_at INTEGER
+	);
+	CREATE TABLE system_instances (
+		sys_id TEXT, sys_scope TEXT, sys_type TEXT, first_seen INTEGER, last_seen INTEGER, postgres_version TEXT,
+		platform TEXT, instance_class TEXT, memory_bytes INTEGER, logical_cores INTEGER, collector_version TEXT,
+		facts_collected_at INTEGER, PRIMARY KEY (sys_id, sys_scope, sys_type)
+	);`)
+	require.NoError(t, err)
+
+	store, err := NewSQLiteQueryStorage(dbPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.db.Close() })
+
+	return store, db
+}
+
+var testSystem = SystemRef{SystemType: "amazon_rds", SystemID: "a", SystemScope: "us-east-1"}
+
+func storeTestQuery(t *testing.T, db *sql.DB, system SystemRef, fingerprint, query string) {
+	_, err := db.Exec(`INSERT OR IGNORE INTO system_queries (sys_id, sys_scope, sys_type, fingerprint, query, first_seen, last_seen, in_order_sightings)
+		VALUES (?, ?, ?, ?, ?, 1, 1, 1)`, system.SystemID, system.SystemScope, system.SystemType, fingerprint, query)
+	require.NoError(t, err)
+}
+
+func TestGetQueries(t *testing.T) {
+	store, db := newTestStorage(t)
+	storeTestQuery(t, db, testSystem, "fp1", "SELECT $1")
+
+	texts, err := store.GetQueries(testSystem, []string{"fp1", "fp2", "fp1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"fp1": "SELECT $1"}, texts)
+
+	// Hits are served from the cache until they expire, so rewritten texts
+	// such as redacted ones show up
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	_, err = db.Exec("UPDATE system_queries SET query = 'SELECT ?' WHERE fingerprint = 'fp1'")
+	require.NoError(t, err)
+	texts, err = store.GetQueries(testSystem, []string{"fp1"})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT $1", texts["fp1"])
+
+	now = now.Add(QueryCacheTTL)
+	texts, err = store.GetQueries(testSystem, []string{"fp1"})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT ?", texts["fp1"])
+
+	// A cached miss is looked up again once new queries arrive
+	storeTestQuery(t, db, testSystem, "fp2", "SELECT now()")
+	texts, err = store.GetQueries(testSystem, []string{"fp1", "fp2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"fp1": "SELECT ?", "fp2": "SELECT now()"}, texts)
+}
+
+func TestGetQueriesPerSystem(t *testing.T) {
+	store, db := newTestStorage(t)
+	other := SystemRef{SystemType: "amazon_rds", SystemID: "b", SystemScope: "us-east-1"}
+	storeTestQuery(t, db, testSystem, "fp1", "SELECT * FROM orders")
+	storeTestQuery(t, db, other, "fp1", "SELECT * FROM invoices")
+
+	texts, err := store.GetQueries(testSystem, []string{"fp1", "fp2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"fp1": "SELECT * FROM orders"}, texts)
+
+	texts, err = store.GetQueries(other, []string{"fp1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"fp1": "SELECT * FROM invoices"}, texts, "the cached text of the first system is not reused")
+
+	texts, err = store.GetQueries(SystemRef{SystemType: "self_hosted", SystemID: "c"}, []string{"fp1"})
+	require.NoError(t, err)
+	assert.Empty(t, texts)
+}
+
+func TestGetQueriesLargeBatch(t *testing.T) {
+	store, db := newTestStorage(t)
+
+	var fingerprints []string
+	for i := 0; i < maxLookupBatch*2+1; i++ {
+		fingerprints = append(fingerprints, fmt.Sprintf("fp%d", i))
+	}
+	storeTestQuery(t, db, testSystem, fingerprints[len(fingerprints)-1], "SELECT 1")
+
+	texts, err := store.GetQueries(testSystem, fingerprints)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{fingerprints[len(fingerprints)-1]: "SELECT 1"}, texts)
+}
+
+func TestSearchQueries(t *testing.T) {
+	store, db := newTestStorage(t)
+
+	_, err := db.Exec(`INSERT INTO system_queries (sys_id, sys_scope, sys_type, fingerprint, query, first_seen, last_seen, in_order_sightings) VALUES
+		('a', 'us-east-1', 'amazon_rds', 'old', 'SELECT * FROM orders WHERE id = $1', 100, 200, 1),
+		('a', 'us-east-1', 'amazon_rds', 'window', 'SELECT * FROM orders WHERE status = $1', 150, 400, 1),
+		('a', 'us-east-1', 'amazon_rds', 'recent', 'SELECT count(*) FROM orders', 900, 1000, 1),
+		('a', 'us-east-1', 'amazon_rds', 'other', 'SELECT * FROM users', 300, 400, 1),
+		('b', 'us-east-1', 'amazon_rds', 'window', 'SELECT * FROM orders WHERE status = $1', 300, 400, 1)`)
+	require.NoError(t, err)
+	system := SystemRef{SystemType: "amazon_rds", SystemID: "a", SystemScope: "us-east-1"}
+
+	// Queries seen after the window do not take the place of those in it
+	queries, err := store.SearchQueries(system, "orders", 300, 500, 1)
+	require.NoError(t, err)
+	require.Len(t, queries, 1)
+	assert.Equal(t, "window", queries[0].Fingerprint)
+
+	queries, err = store.SearchQueries(system, "orders", 0, 1000, 10)
+	require.NoError(t, err)
+	fingerprints := []string{}
+	for _, q := range queries {
+		fingerprints = append(fingerprints, q.Fingerprint)
+	}
+	assert.Equal(t, []string{"recent", "window", "old"}, fingerprints)
+}
+
+func TestListIndexes(t *testing.T) {
+	store, db := newTestStorage(t)
+
+	_, err := db.Exec(`INSERT INTO system_indexes VALUES
+		('a', 'us-east-1', 'amazon_rds', 'app', 'public', 'orders_pkey', 'orders',
+			'CREATE UNIQUE INDEX orders_pkey ON public.orders USING btree (id)', 'PRIMARY KEY (id)', 'btree', 1, 1, 1, 8192, 10, 200, 100, 300),
+		('a', 'us-east-1', 'amazon_rds', 'app', 'public', 'orders_customer_idx', 'orders',
+			'CREATE INDEX orders_customer_idx ON public.orders USING btree (customer_id)', NULL, 'btree', 0, 0, 1, 4096, 0, 100, 100, 300),
+		('b', 'us-east-1', 'amazon_rds', 'app', 'public', 'orders_pkey', 'orders',
+			'CREATE UNIQUE INDEX orders_pkey ON public.orders USING btree (id)', 'PRIMARY KEY (id)', 'btree', 1, 1, 1, 8192, 10, 200, 100, 300)`)
+	require.NoError(t, err)
+
+	indexes, err := store.ListIndexes(SystemRef{SystemType: "amazon_rds", SystemID: "a", SystemScope: "us-east-1"})
+	require.NoError(t, err)
+	require.Len(t, indexes, 2)
+	assert.Equal(t, SystemIndex{
+		Database: "app", Schema: "public", Name: "orders_customer_idx", Relation: "orders",
+		Definition: "CREATE INDEX orders_customer_idx ON public.orders USING btree (customer_id)", Type: "btree",
+		IsValid: true, SizeBytes: 4096, ScansChangedAt: 100, FirstSeen: 100, CollectedAt: 300,
+	}, indexes[0])
+	assert.Equal(t, "PRIMARY KEY (id)", indexes[1].ConstraintDef)
+	assert.True(t, indexes[1].IsPrimary)
+}
+
+func TestListSettings(t *testing.T) {
+	store, db := newTestStorage(t)
+
+	_, err := db.Exec(`INSERT INTO system_settings VALUES
+		('a', 'us-east-1', 'amazon_rds', 'work_mem', '65536', 'kB', 'configuration file', '/etc/postgresql.conf', '4096', '65536', 100, 300),
+		('a', 'us-east-1', 'amazon_rds', 'jit', 'off', NULL, 'default', NULL, 'off', 'off', 100, 300),
+		('b', 'us-east-1', 'amazon_rds', 'jit', 'on', NULL, 'default', NULL, 'on', 'on', 100, 300)`)
+	require.NoError(t, err)
+
+	settings, err := store.ListSettings(SystemRef{SystemType: "amazon_rds", SystemID: "a", SystemScope: "us-east-1"})
+	require.NoError(t, err)
+	require.Len(t, settings, 2)
+	assert.Equal(t, SystemSetting{Name: "jit", Value: "off", Source: "default", BootValue: "off", ResetValue: "off", FirstSeen: 100, CollectedAt: 300}, settings[0])
+	assert.Equal(t, SystemSetting{
+		Name: "work_mem", Value: "65536", Unit: "kB", Source: "configuration file", SourceFile: "/etc/postgresql.conf",
+		BootValue: "4096", ResetValue: "65536", FirstSeen: 100, CollectedAt: 300,
+	}, settings[1])
+}
+
+func TestListSettingChanges(t *testing.T) {
+	store, db := newTestStorage(t)
+
+	_, err := db.Exec(`INSERT INTO system_setting_changes
+		(sys_id, sys_scope, sys_type, name, change_type, unit, old_value, new_value, old_source, new_source, previous_collected_at, changed_at)
+		VALUES
+		('a', 'us-east-1', 'amazon_rds', 'work_mem', 'changed', 'kB', '4096', '65536', 'default', 'configuration file', 200, 300),
+		('a', 'us-east-1', 'amazon_rds', 'jit', 'removed', NULL, 'on', NULL, 'default', NULL, 100, 200),
+		('a', 'us-east-1', 'amazon_rds', 'work_mem', 'changed', 'kB', '65536', '8192', 'configuration file', 'configuration file', 300, 400),
+		('b', 'us-east-1', 'amazon_rds', 'jit', 'added', NULL, NULL, 'on', NULL, 'default', 100, 200)`)
+	require.NoError(t, err)
+
+	changes, err := store.ListSettingChanges(SystemRef{SystemType: "amazon_rds", SystemID: "a", SystemScope: "us-east-1"}, 200, 300)
+	require.NoError(t, err)
+	assert.Equal(t, []SettingChange{
+		{Name: "jit", ChangeType: "removed", OldValue: "on", OldSource: "default", PreviousCollectedAt: 100, ChangedAt: 200},
+		{Name: "work_mem", ChangeType: "changed", Unit: "kB", OldValue: "4096", NewValue: "65536", OldSource: "default", NewSource: "configuration file", PreviousCollectedAt: 200, ChangedAt: 300},
+	}, changes)
+}
+
+func TestListSchemaEvents(t *testing.T) {
+	store, db := newTestStorage(t)
+
+	_, err := db.Exec(`INSERT INTO system_schema_events
+		(sys_id, sys_scope, sys_type, datname, event_type, schema_name, relation, object_name, old_definition, new_definition, previous_collected_at, detected_at)
+		VALUES
+		('a', 'us-east-1', 'amazon_rds', 'app', 'column_altered', 'public', 'orders', 'status', 'text', 'text NOT NULL', 200, 300),
+		('a', 'us-east-1', 'amazon_rds', 'app', 'table_created', 'public', 'customers', 'customers', NULL, NULL, 100, 200),
+		('a', 'us-east-1', 'amazon_rds', 'app', 'table_dropped', 'public', 'carts', 'carts', NULL, NULL, 300, 400),
+		('b', 'us-east-1', 'amazon_rds', 'app', 'table_created', 'public', 'orders', 'orders', NULL, NULL, 100, 200)`)
+	require.NoError(t, err)
+
+	events, err := store.ListSchemaEvents(SystemRef{SystemType: "amazon_rds", SystemID: "a", SystemScope: "us-east-1"}, 200, 300)
+	require.NoError(t, err)
+	assert.Equal(t, []SchemaEvent{
+		{Database: "app", Type: "table_created", Schema: "public", Relation: "customers", Name: "customers", PreviousCollectedAt: 100, DetectedAt: 200},
+		{Database: "app", Type: "column_altered", Schema: "public", Relation: "orders", Name: "status", OldDefinition: "text", NewDefinition: "text NOT NULL", PreviousCollectedAt: 200, DetectedAt: 300},
+	}, events)
+}
+
+func TestLRUCacheEvictsOldest(t *testing.T) {
+	cache := newLRUCache[string, int](2)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	cache.Get("a")
+	cache.Add("c", 3)
+
+	_, ok := cache.Get("b")
+	assert.False(t, ok)
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestMatchExpression(t *testing.T) {
+	assert.Equal(t, `"users" "id=$1"`, MatchExpression(" users  id=$1 "))
+	assert.Equal(t, `"say" """hi"""`, MatchExpression(`say "hi"`))
+	assert.Equal(t, "", MatchExpression("   "))
+}
+
+func TestListInstances(t *testing.T) {
+	store, db := newTestStorage(t)
+
+	_, err := db.Exec(`INSERT INTO system_instances VALUES
+		('b', 'us-east-1', 'amazon_rds', 100, 300, '16.3', 'amazon_rds', 'db.t4g.medium', 4121206784, 2, 'pganalyze-collector 0.58.0', 250),
+		('a', '', 'self_hosted', 100, 100, '', '', '', 0, 0, '', 0)`)
+	require.NoError(t, err)
+
+	instances, err := store.ListInstances()
+	require.NoError(t, err)
+	assert.Equal(t, []SystemInstance{
+		{
+			System:    SystemRef{SystemType: "amazon_rds", SystemID: "b", SystemScope: "us-east-1"},
+			FirstSeen: 100, LastSeen: 300, PostgresVersion: "16.3", Platform: "amazon_rds", InstanceClass: "db.t4g.medium",
+			MemoryBytes: 4121206784, LogicalCores: 2, CollectorVersion: "pganalyze-collector 0.58.0", FactsCollectedAt: 250,
+		},
+		{System: SystemRef{SystemType: "self_hosted", SystemID: "a"}, FirstSeen: 100, LastSeen: 100},
+	}, instances)
+}
+
+// TestCollectorSchemaVersionMatchesMigrations fails when a collector-api
+// migration is added without bumping CollectorSchemaVersion. Migrations are
+// SQL files named <version>_<name>.sql or Go migrations in migrate.go.
+func TestCollectorSchemaVersionMatchesMigrations(t *testing.T) {
+	dbDir := filepath.Join("..", "..", "..", "collector-api", "internal", "db")
+
+	latest := 0
+	entries, err := os.ReadDir(filepath.Join(dbDir, "migrations"))
+	require.NoError(t, err)
+	for _, entry := range entries {
+		versionStr, _, found := strings.Cut(entry.Name(), "_")
+		if !found || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		version, err := strconv.Atoi(versionStr)
+		require.NoError(t, err, entry.Name())
+		latest = max(latest, version)
+	}
+
+	source, err := os.ReadFile(filepath.Join(dbDir, "migrate.go"))
+	require.NoError(t, err)
+	for _, match := range regexp.MustCompile(`Version:\s*(\d+),`).FindAllStringSubmatch(string(source), -1) {
+		version, err := strconv.Atoi(match[1])
+		require.NoError(t, err)
+		latest = max(latest, version)
+	}
+
+	assert.Equal(t, latest, CollectorSchemaVersion)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"local/bff/pkg/alerting"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// writeJSON writes v wrapped as the data of a response
+func writeJSON(w http.ResponseWriter, status int, v interface{}, now time.Time) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	wrappedJSON, err := WrapJSON(js, map[string]interface{}{"server_now": now.UnixMilli()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(wrappedJSON)
+}
+
+// alerts_handler lists the tracked alerts, optionally only those in one state
+func alerts_handler(engine *alerting.Engine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := r.URL.Query().Get("state")
+		switch state {
+		case "", alerting.StatePending, alerting.StateFiring, alerting.StateResolved:
+		default:
+			http.Error(w, fmt.Sprintf("The 'state' parameter must be one of: %s, %s, %s.", alerting.StatePending, alerting.StateFiring, alerting.StateResolved), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		alerts, err := engine.ListAlerts(state, now)
+		if err != nil {
+			http.Error(w, "Error listing alerts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, alerts, now)
+	})
+}
+
+// alert_rules_handler lists the configured alert rules
+func alert_rules_handler(engine *alerting.Engine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, engine.Rules(), time.Now())
+	})
+}
+
+// silences_handler lists the silences that have not ended, or all of them
+// with expired=true
+func silences_handler(engine *alerting.Engine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		includeExpired := r.URL.Query().Get("expired") == "true"
+
+		now := time.Now()
+		silences, err := engine.Store().ListSilences(now.Unix(), includeExpired)
+		if err != nil {
+			http.Error(w, "Error listing silences: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, silences, now)
+	})
+}
+
+// SilenceRequest creates a silence. Times are unix seconds; the silence
+// starts now unless StartsAt is set, and ends at EndsAt or after Duration.
+type SilenceRequest struct {
+	Matchers  []alerting.Matcher `json:"matchers"`
+	StartsAt  int64              `json:"starts_at"`
+	EndsAt    int64              `json:"ends_at"`
+	Duration  string             `json:"duration"`
+	CreatedBy string             `json:"created_by"`
+	Comment   string             `json:"comment"`
+}
+
+// create_silence_handler stores the silence in the request body
+func create_silence_handler(engine *alerting.Engine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SilenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid silence: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		silence := alerting.Silence{
+			Matchers:  req.Matchers,
+			StartsAt:  req.StartsAt,
+			EndsAt:    req.EndsAt,
+			CreatedBy: req.CreatedBy,
+			Comment:   req.Comment,
+			CreatedAt: now.Unix(),
+		}
+		if silence.StartsAt == 0 {
+			silence.StartsAt = now.Unix()
+		}
+		if req.Duration != "" {
+			if req.EndsAt != 0 {
+				http.Error(w, "Invalid silence: set either ends_at or duration", http.StatusBadRequest)
+				return
+			}
+			duration, err := time.ParseDuration(req.Duration)
+			if err != nil {
+				http.Error(w, "Invalid silence: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			silence.EndsAt = silence.StartsAt + int64(duration.Seconds())
+		}
+
+		if err := silence.Validate(); err != nil {
+			http.Error(w, "Invalid silence: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		silence, err := engine.Store().CreateSilence(silence)
+		if err != nil {
+			http.Error(w, "Error creating silence: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, silence, now)
+	})
+}
+
+// expire_silence_handler ends a silence now
+func expire_silence_handler(engine *alerting.Engine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid silence id", http.StatusBadRequest)
+			return
+		}
+
+		err = engine.Store().ExpireSilence(id, time.Now().Unix())
+		if errors.Is(err, alerting.ErrSilenceNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error expiring silence: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/alerting"
+	"local/bff/pkg/state_storage/statetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAlertRouter(t *testing.T) (chi.Router, *alerting.Engine) {
+	db := statetest.Open(t)
+
+	engine, err := alerting.NewEngine(alerting.Config{
+		Rules: []alerting.Rule{{Name: "HighCPU", Expr: "cc_system_cpu_user_percent > 90", Severity: alerting.SeverityWarning}},
+	}, new(MockMetricsService), alerting.NewStore(db), nil)
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/alerts", alerts_handler(engine))
+	r.Get("/api/v1/alerts/rules", alert_rules_handler(engine))
+	r.Get("/api/v1/alerts/silences", silences_handler(engine))
+	r.Post("/api/v1/alerts/silences", create_silence_handler(engine))
+	r.Delete("/api/v1/alerts/silences/{id}", expire_silence_handler(engine))
+	return r, engine
+}
+
+func TestAlertHandlers(t *testing.T) {
+	r, _ := newTestAlertRouter(t)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		record := httptest.NewRecorder()
+		r.ServeHTTP(record, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return record
+	}
+
+	record := serve(http.MethodGet, "/api/v1/alerts/rules", "")
+	require.Equal(t, http.StatusOK, record.Code)
+	var rules struct {
+		Data []alerting.Rule `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &rules))
+	require.Len(t, rules.Data, 1)
+	assert.Equal(t, "HighCPU", rules.Data[0].Name)
+
+	record = serve(http.MethodGet, "/api/v1/alerts?state=firing", "")
+	assert.Equal(t, http.StatusOK, record.Code)
+	assert.JSONEq(t, `[]`, string(mustData(t, record)))
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/v1/alerts?state=active", "").Code)
+
+	record = serve(http.MethodPost, "/api/v1/alerts/silences",
+		`{"matchers":[{"name":"alertname","value":"HighCPU"}],"duration":"2h","created_by":"ops","comment":"maintenance"}`)
+	require.Equal(t, http.StatusCreated, record.Code)
+	var created struct {
+		Data alerting.Silence `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &created))
+	assert.NotZero(t, created.Data.ID)
+	assert.Equal(t, int64(7200), created.Data.EndsAt-created.Data.StartsAt)
+
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{name: "Not JSON", body: `matchers`},
+		{name: "No matchers", body: `{"duration":"1h","created_by":"ops"}`},
+		{name: "Bad regex", body: `{"matchers":[{"name":"sys_id","value":"(","is_regex":true}],"duration":"1h","created_by":"ops"}`},
+		{name: "No end", body: `{"matchers":[{"name":"sys_id","value":"a"}],"created_by":"ops"}`},
+		{name: "Both end and duration", body: `{"matchers":[{"name":"sys_id","value":"a"}],"ends_at":1,"duration":"1h","created_by":"ops"}`},
+		{name: "No author", body: `{"matchers":[{"name":"sys_id","value":"a"}],"duration":"1h"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/api/v1/alerts/silences", tc.body).Code)
+		})
+	}
+
+	var silences struct {
+		Data []alerting.Silence `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(serve(http.MethodGet, "/api/v1/alerts/silences", "").Body.Bytes(), &silences))
+	assert.Len(t, silences.Data, 1)
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/api/v1/alerts/silences/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/api/v1/alerts/silences/42", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodDelete, "/api/v1/alerts/silences/x", "").Code)
+
+	require.NoError(t, json.Unmarshal(serve(http.MethodGet, "/api/v1/alerts/silences", "").Body.Bytes(), &silences))
+	assert.Empty(t, silences.Data)
+	require.NoError(t, json.Unmarshal(serve(http.MethodGet, "/api/v1/alerts/silences?expired=true", "").Body.Bytes(), &silences))
+	assert.Len(t, silences.Data, 1)
+}
+
+func mustData(t *testing.T, record *httptest.ResponseRecorder) json.RawMessage {
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	return response.Data
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"local/bff/pkg/anomaly"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// anomalies_handler lists the load anomalies detected on an instance over a
+// time window, each with its attribution in the event details
+func anomalies_handler(detector *anomaly.Detector, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		start, end, err := parseTimeWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		anomalies, err := detector.Events().List(system, start.UnixMilli(), end.UnixMilli(), anomaly.EventSource)
+		if err != nil {
+			http.Error(w, "Error listing anomalies: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, anomalies, now)
+	})
+}
+
+// anomaly_baseline_handler returns the learned load of an instance per wait
+// event class and hour-of-week, learning it first if needed
+func anomaly_baseline_handler(detector *anomaly.Detector, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		baseline, err := detector.Baseline(system, now)
+		if err != nil {
+			http.Error(w, "Error learning baseline: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, baseline, now)
+	})
+}
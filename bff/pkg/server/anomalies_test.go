@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/anomaly"
+	"local/bff/pkg/events"
+	"local/bff/pkg/query_storage"
+	"local/bff/pkg/state_storage/statetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnomalyHandlers(t *testing.T) {
+	db := statetest.Open(t)
+
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, `cc_pg_stat_activity{sys_id="default_db",sys_scope="us-west-2",sys_type="amazon_rds"}`)
+	}), mock.Anything).Return([]map[string]interface{}{{
+		"metric": map[string]interface{}{"wait_event_class": "CPU"},
+		"values": []map[string]interface{}{{"timestamp": int64(1800000), "value": 2.0}},
+	}}, nil)
+
+	detector, err := anomaly.NewDetector(anomaly.Config{}, mockService, events.NewStore(db))
+	require.NoError(t, err)
+
+	system := query_storage.SystemRef{SystemType: "amazon_rds", SystemID: "default_db", SystemScope: "us-west-2"}
+	_, err = detector.Events().Add(events.Event{System: system, Source: anomaly.EventSource, Kind: anomaly.EventKind,
+		Title: "CPU load of 4.00 active sessions is above the usual 1.10", StartsAt: 1000000, EndsAt: 1300000,
+		Details: json.RawMessage(`{"wait_event_class":"CPU"}`), CreatedAt: 1300000})
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/anomalies", anomalies_handler(detector, CreateValidator()))
+	r.Get("/api/v1/anomalies/baseline", anomaly_baseline_handler(detector, CreateValidator()))
+
+	dbIdentifier := "amazon_rds/default_db/us-west-2"
+	testCases := []struct {
+		name         string
+		target       string
+		expectedCode int
+		expectedLen  int
+	}{
+		{name: "Overlapping window", target: "/api/v1/anomalies?dbidentifier=" + dbIdentifier + "&start=1200000&end=2000000", expectedCode: http.StatusOK, expectedLen: 1},
+		{name: "Later window", target: "/api/v1/anomalies?dbidentifier=" + dbIdentifier + "&start=1400000&end=2000000", expectedCode: http.StatusOK, expectedLen: 0},
+		{name: "Missing start", target: "/api/v1/anomalies?dbidentifier=" + dbIdentifier, expectedCode: http.StatusBadRequest},
+		{name: "Missing dbidentifier", target: "/api/v1/anomalies?start=now-1h", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			require.Equal(t, tc.expectedCode, record.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data []events.Event `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+			assert.Len(t, response.Data, tc.expectedLen)
+		})
+	}
+
+	record := httptest.NewRecorder()
+	r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/anomalies/baseline?dbidentifier="+dbIdentifier, nil))
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data anomaly.Baseline `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	assert.Equal(t, []string{"CPU"}, response.Data.WaitEventClasses())
+	assert.Equal(t, 1, response.Data.Samples[anomaly.HourOfWeek(time.UnixMilli(1800000))])
+}
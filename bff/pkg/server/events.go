@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/events"
+	"local/bff/pkg/query_storage"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// UserEventSource is the source of events pushed through the API, such as
+	// deploys, failovers and maintenance windows
+	UserEventSource = "user"
+	userEventKind   = "annotation"
+	maxEventTags    = 20
+)
+
+// EventRequest is the body of POST /api/v1/events. Times are unix
+// milliseconds; an event without ends_at is a point in time.
+type EventRequest struct {
+	DbIdentifier string   `json:"dbidentifier"`
+	Kind         string   `json:"kind"`
+	Title        string   `json:"title"`
+	StartsAt     int64    `json:"starts_at"`
+	EndsAt       int64    `json:"ends_at"`
+	Tags         []string `json:"tags"`
+	Link         string   `json:"link"`
+}
+
+// Validate checks the fields that are not checked by the store
+func (req EventRequest) Validate() error {
+	if req.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	if req.StartsAt <= 0 {
+		return fmt.Errorf("starts_at is required")
+	}
+	if req.EndsAt != 0 && req.EndsAt < req.StartsAt {
+		return fmt.Errorf("ends_at must not be before starts_at")
+	}
+	if len(req.Tags) > maxEventTags {
+		return fmt.Errorf("at most %d tags are allowed", maxEventTags)
+	}
+	for _, tag := range req.Tags {
+		if tag == "" {
+			return fmt.Errorf("tags must not be empty")
+		}
+	}
+	if req.Link != "" {
+		link, err := url.Parse(req.Link)
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+			return fmt.Errorf("link must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// create_event_handler stores an event pushed by a user or a deploy pipeline
+// for an instance
+func create_event_handler(store *events.Store, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EventRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		system, err := parseSystemRef(req.DbIdentifier, validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			http.Error(w, "Invalid event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		event := events.Event{
+			System:    system,
+			Source:    UserEventSource,
+			Kind:      req.Kind,
+			Title:     req.Title,
+			StartsAt:  req.StartsAt,
+			EndsAt:    req.EndsAt,
+			Tags:      req.Tags,
+			Link:      req.Link,
+			CreatedAt: now.UnixMilli(),
+		}
+		if event.Kind == "" {
+			event.Kind = userEventKind
+		}
+		if event.EndsAt == 0 {
+			event.EndsAt = event.StartsAt
+		}
+
+		event, err = store.Add(event)
+		if err != nil {
+			http.Error(w, "Error creating event: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, event, now)
+	})
+}
+
+// events_handler lists the events of an instance overlapping a time window,
+// oldest first, optionally only those from one source
+func events_handler(store *events.Store, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		start, end, err := parseTimeWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		list, err := store.List(system, start.UnixMilli(), end.UnixMilli(), r.URL.Query().Get("source"))
+		if err != nil {
+			http.Error(w, "Error listing events: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, list, now)
+	})
+}
+
+// windowEvents returns the events of an instance overlapping [start, end] to
+// be returned next to its metrics. Events are an addition to the response, so
+// a failure to list them is logged rather than failing the request.
+func windowEvents(store *events.Store, dbIdentifier string, start, end time.Time) []events.Event {
+	if store == nil {
+		return []events.Event{}
+	}
+
+	systemType, systemID, systemScope, err := splitDbIdentifier(dbIdentifier)
+	if err != nil {
+		return []events.Event{}
+	}
+
+	system := query_storage.SystemRef{SystemType: systemType, SystemID: systemID, SystemScope: systemScope}
+	list, err := store.List(system, start.UnixMilli(), end.UnixMilli(), "")
+	if err != nil {
+		log.Printf("Error listing events of %s: %v", dbIdentifier, err)
+		return []events.Event{}
+	}
+	return list
+}
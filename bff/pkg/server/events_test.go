@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/events"
+	"local/bff/pkg/state_storage/statetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEventStore(t *testing.T) *events.Store {
+	db := statetest.Open(t)
+	return events.NewStore(db)
+}
+
+func TestEventHandlers(t *testing.T) {
+	store := newTestEventStore(t)
+	r := chi.NewRouter()
+	r.Get("/api/v1/events", events_handler(store, CreateValidator()))
+	r.Post("/api/v1/events", create_event_handler(store, CreateValidator()))
+
+	dbIdentifier := "amazon_rds/default_db/us-west-2"
+	createCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "Deploy", body: `{"dbidentifier":"` + dbIdentifier + `","kind":"deploy","title":"Deploy v1.2","starts_at":1000000,"ends_at":1300000,"tags":["app:api"],"link":"https://ci.example.com/builds/42"}`, expectedCode: http.StatusCreated},
+		{name: "Point in time", body: `{"dbidentifier":"` + dbIdentifier + `","title":"Failover","starts_at":1500000}`, expectedCode: http.StatusCreated},
+		{name: "Other instance", body: `{"dbidentifier":"amazon_rds/other_db/us-west-2","title":"Maintenance","starts_at":1000000,"ends_at":2000000}`, expectedCode: http.StatusCreated},
+		{name: "Missing dbidentifier", body: `{"title":"Deploy","starts_at":1000000}`, expectedCode: http.StatusBadRequest},
+		{name: "Missing title", body: `{"dbidentifier":"` + dbIdentifier + `","starts_at":1000000}`, expectedCode: http.StatusBadRequest},
+		{name: "Missing starts_at", body: `{"dbidentifier":"` + dbIdentifier + `","title":"Deploy"}`, expectedCode: http.StatusBadRequest},
+		{name: "Ends before start", body: `{"dbidentifier":"` + dbIdentifier + `","title":"Deploy","starts_at":2000,"ends_at":1000}`, expectedCode: http.StatusBadRequest},
+		{name: "Invalid link", body: `{"dbidentifier":"` + dbIdentifier + `","title":"Deploy","starts_at":1000,"link":"javascript:alert(1)"}`, expectedCode: http.StatusBadRequest},
+		{name: "Empty tag", body: `{"dbidentifier":"` + dbIdentifier + `","title":"Deploy","starts_at":1000,"tags":[""]}`, expectedCode: http.StatusBadRequest},
+		{name: "Malformed body", body: `{"title":`, expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range createCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			r.ServeHTTP(record, httptest.NewRequest(http.MethodPost, "/api/v1/events", strings.NewReader(tc.body)))
+			assert.Equal(t, tc.expectedCode, record.Code, record.Body.String())
+		})
+	}
+
+	record := httptest.NewRecorder()
+	r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/events?dbidentifier="+dbIdentifier+"&start=1200000&end=2000000", nil))
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data []events.Event `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	require.Len(t, response.Data, 2)
+	assert.Equal(t, UserEventSource, response.Data[0].Source)
+	assert.Equal(t, "deploy", response.Data[0].Kind)
+	assert.Equal(t, []string{"app:api"}, response.Data[0].Tags)
+	assert.Equal(t, "https://ci.example.com/builds/42", response.Data[0].Link)
+	assert.Equal(t, userEventKind, response.Data[1].Kind)
+	assert.Equal(t, int64(1500000), response.Data[1].EndsAt, "an event without an end is a point in time")
+
+	listCases := []struct {
+		name         string
+		target       string
+		expectedCode int
+		expectedLen  int
+	}{
+		{name: "Before the events", target: "/api/v1/events?dbidentifier=" + dbIdentifier + "&start=0&end=900000", expectedCode: http.StatusOK, expectedLen: 0},
+		{name: "Other source", target: "/api/v1/events?dbidentifier=" + dbIdentifier + "&start=0&end=2000000&source=anomaly", expectedCode: http.StatusOK, expectedLen: 0},
+		{name: "Missing start", target: "/api/v1/events?dbidentifier=" + dbIdentifier, expectedCode: http.StatusBadRequest},
+		{name: "Missing dbidentifier", target: "/api/v1/events?start=now-1h", expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range listCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			require.Equal(t, tc.expectedCode, record.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+			var response struct {
+				Data []events.Event `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+			assert.Len(t, response.Data, tc.expectedLen)
+		})
+	}
+}
+
+func TestMetricResponsesIncludeEvents(t *testing.T) {
+	store := newTestEventStore(t)
+	system, err := parseSystemRef("amazon_rds/default_db/us-west-2", CreateValidator())
+	require.NoError(t, err)
+	for _, e := range []events.Event{
+		{System: system, Source: UserEventSource, Kind: "deploy", Title: "Deploy v1.2", StartsAt: 10, EndsAt: 15},
+		{System: system, Source: UserEventSource, Kind: "maintenance", Title: "Maintenance", StartsAt: 100, EndsAt: 200},
+	} {
+		_, err := store.Add(e)
+		require.NoError(t, err)
+	}
+
+	var response struct {
+		Events []events.Event `json:"events"`
+	}
+
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.Anything, mock.Anything).Return([]map[string]interface{}{}, nil)
+	activity := activity_handler(mockService, &MockQueryStorage{}, store, CreateValidator(), 24, 24)
+	params := map[string]string{
+		"dbidentifier":  "amazon_rds/default_db/us-west-2",
+		"database_list": "postgres",
+		"start":         "10",
+		"end":           "20",
+		"step":          "5ms",
+		"legend":        "wait_event_name",
+		"dim":           "time",
+	}
+	record := httptest.NewRecorder()
+	activity.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/activity?"+formatQueryParams(params), nil))
+	require.Equal(t, http.StatusOK, record.Code, record.Body.String())
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	require.Len(t, response.Events, 1)
+	assert.Equal(t, "Deploy v1.2", response.Events[0].Title)
+
+	mockService.On("Execute", mock.Anything, mock.Anything).Return(map[int64]map[string]float64{}, nil)
+	routesConfig := map[string]RouteConfig{
+		"/v1/health": {
+			Params:  []string{"dbidentifier", "start", "end"},
+			Options: map[string]string{"start": "$start", "end": "$end"},
+			Metrics: map[string]string{"connections": `sum(pg_stat_database_numbackends{sys_id="$sys_id"})`},
+		},
+		"/v1/fleet": {
+			Params:  []string{"start", "end"},
+			Options: map[string]string{"start": "$start", "end": "$end"},
+			Metrics: map[string]string{"connections": `sum(pg_stat_database_numbackends)`},
+		},
+	}
+	metrics := metrics_handler(routesConfig, mockService, store)
+
+	record = httptest.NewRecorder()
+	metrics.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/health?dbidentifier=amazon_rds/default_db/us-west-2&start=50&end=150", nil))
+	require.Equal(t, http.StatusOK, record.Code, record.Body.String())
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	require.Len(t, response.Events, 1)
+	assert.Equal(t, "Maintenance", response.Events[0].Title)
+
+	// Routes that are not scoped to an instance have no events
+	record = httptest.NewRecorder()
+	metrics.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/fleet?start=50&end=150", nil))
+	require.Equal(t, http.StatusOK, record.Code, record.Body.String())
+	assert.NotContains(t, record.Body.String(), `"events"`)
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	"local/bff/pkg/alerting"
+	"local/bff/pkg/instances"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FleetStatusCritical = "critical"
+	FleetStatusWarning  = "warning"
+	FleetStatusOffline  = "offline"
+	FleetStatusOK       = "ok"
+
+	// Host and database metrics come with full snapshots
+	fleetMetricWindow = metrics.FullSnapshotWindow
+	fleetAASWindow    = 5 * time.Minute
+
+	fleetLabels = "sys_id, sys_scope, sys_type"
+)
+
+// fleetStatusRank orders statuses by how much attention they need
+var fleetStatusRank = map[string]int{
+	FleetStatusCritical: 0,
+	FleetStatusWarning:  1,
+	FleetStatusOffline:  2,
+	FleetStatusOK:       3,
+}
+
+// fleetWarningThresholds flag instances getting close to the limits of the
+// default alert rules, which make them critical
+var fleetWarningThresholds = alerting.Thresholds{
+	ConnectionsPercent: 75,
+	DiskFreePercent:    20,
+	XIDAge:             500_000_000,
+	CPUPercent:         75,
+}
+
+// FleetInstance is the current state of one instance. Metrics are nil when
+// the instance did not report them recently.
+type FleetInstance struct {
+	Instance           InstanceInfo `json:"instance"`
+	Status             string       `json:"status"`
+	Reasons            []string     `json:"reasons"`
+	AAS                *float64     `json:"aas"`
+	CPUPercent         *float64     `json:"cpu_percent"`
+	ConnectionsPercent *float64     `json:"connections_percent"`
+	DiskFreeBytes      *float64     `json:"disk_free_bytes"`
+	DiskFreePercent    *float64     `json:"disk_free_percent"`
+	MaxXIDAge          *float64     `json:"max_xid_age"`
+	DataAgeSeconds     *float64     `json:"data_age_seconds"`
+}
+
+func (f *FleetInstance) field(stat string) **float64 {
+	switch stat {
+	case "aas":
+		return &f.AAS
+	case "cpu_percent":
+		return &f.CPUPercent
+	case "connections_percent":
+		return &f.ConnectionsPercent
+	case "disk_free_bytes":
+		return &f.DiskFreeBytes
+	case "disk_free_percent":
+		return &f.DiskFreePercent
+	case "max_xid_age":
+		return &f.MaxXIDAge
+	}
+	return nil
+}
+
+// fleet_handler returns the current state and health of every instance, the
+// ones needing attention first. It takes the filters of /api/v1/instance,
+// plus status.
+func fleet_handler(metrics_service metrics.Service, queryStore query_storage.QueryStorage, metadataStore *instances.Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		status := r.URL.Query().Get("status")
+		if _, ok := fleetStatusRank[status]; status != "" && !ok {
+			http.Error(w, "Invalid status: "+status, http.StatusBadRequest)
+			return
+		}
+
+		instanceInfos, err := listInstances(metrics_service, queryStore, metadataStore, r.URL.Query(), now)
+		if err != nil {
+			http.Error(w, "Error querying list of instances.", http.StatusInternalServerError)
+			return
+		}
+
+		samples, err := metrics_service.ExecuteRaw(fleetQuery(), instantOptions(now, "sys_id"))
+		if err != nil {
+			http.Error(w, "Error querying fleet metrics: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fleet := make(map[query_storage.SystemRef]*FleetInstance, len(instanceInfos))
+		for _, info := range instanceInfos {
+			instance := &FleetInstance{Instance: info, Reasons: []string{}}
+			if info.LastSeenMs > 0 {
+				age := math.Max(float64(now.UnixMilli()-info.LastSeenMs)/1000, 0)
+				instance.DataAgeSeconds = &age
+			}
+			fleet[query_storage.SystemRef{SystemID: info.SystemID, SystemScope: info.SystemScope, SystemType: info.SystemType}] = instance
+		}
+
+		for _, sample := range samples {
+			system := query_storage.SystemRef{
+				SystemID:    getValue(sample, "sys_id"),
+				SystemScope: getValue(sample, "sys_scope"),
+				SystemType:  getValue(sample, "sys_type"),
+			}
+			instance, ok := fleet[system]
+			if !ok {
+				continue
+			}
+			value, ok := sampleValue(sample)
+			if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			if field := instance.field(getValue(sample, "stat")); field != nil {
+				*field = &value
+			}
+		}
+
+		list := []FleetInstance{}
+		for _, instance := range fleet {
+			instance.Status, instance.Reasons = fleetHealth(*instance, alerting.DefaultThresholds(), fleetWarningThresholds)
+			if status == "" || instance.Status == status {
+				list = append(list, *instance)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if fleetStatusRank[list[i].Status] != fleetStatusRank[list[j].Status] {
+				return fleetStatusRank[list[i].Status] < fleetStatusRank[list[j].Status]
+			}
+			return list[i].Instance.DBIdentifier < list[j].Instance.DBIdentifier
+		})
+
+		writeJSON(w, http.StatusOK, list, now)
+	})
+}
+
+// fleetQuery returns the current metrics of every instance in one instant
+// query, each labelled with its stat
+func fleetQuery() string {
+	window := fmt.Sprintf("%ds", int(fleetMetricWindow.Seconds()))
+	last := func(selector string) string {
+		return fmt.Sprintf("last_over_time(%s[%s])", selector, window)
+	}
+
+	stats := []struct{ name, expr string }{
+		{"aas", fmt.Sprintf("avg_over_time(sum by (%s) (cc_pg_stat_activity)[%ds:])", fleetLabels, int(fleetAASWindow.Seconds()))},
+		{"cpu_percent", fmt.Sprintf("avg by (%s) (%s + %s)", fleetLabels, last("cc_system_cpu_user_percent"), last("cc_system_cpu_system_percent"))},
+		{"connections_percent", fmt.Sprintf(`sum by (%[1]s) (%[2]s) / on (%[1]s) max by (%[1]s) (%[3]s) * 100`,
+			fleetLabels, last("cc_backend_count"), last(`cc_pgsetting_current_value{name="max_connections"}`))},
+		{"disk_free_bytes", fmt.Sprintf("min by (%[1]s) (sum by (%[1]s, mountpoint) (%[2]s))", fleetLabels, last("cc_system_diskpartition_free_bytes"))},
+		{"disk_free_percent", fmt.Sprintf("min by (%[1]s) (sum by (%[1]s, mountpoint) (%[2]s) / sum by (%[1]s, mountpoint) (%[3]s) * 100)",
+			fleetLabels, last("cc_system_diskpartition_free_bytes"), last("cc_system_diskpartition_total_bytes"))},
+		{"max_xid_age", fmt.Sprintf("max by (%s) (%s)", fleetLabels, last("cc_db_frozen_xid_age"))},
+	}
+
+	parts := make([]string, 0, len(stats))
+	for _, stat := range stats {
+		parts = append(parts, fmt.Sprintf(`label_replace(%s, "stat", "%s", "", "")`, stat.expr, stat.name))
+	}
+	return strings.Join(parts, " or ")
+}
+
+// fleetHealth rates an instance critical when a metric is past the limit of
+// the matching alert rule, warning when it gets close to it or data stops
+// arriving, and offline once it stopped reporting. The reasons explain
+// everything that is not ok.
+func fleetHealth(f FleetInstance, critical, warning alerting.Thresholds) (string, []string) {
+	if f.Instance.State == InstanceStateOffline {
+		return FleetStatusOffline, []string{fmt.Sprintf("no snapshot since %s", time.UnixMilli(f.Instance.LastSeenMs).UTC().Format(time.RFC3339))}
+	}
+
+	status := FleetStatusOK
+	reasons := []string{}
+	flag := func(level, reason string) {
+		if fleetStatusRank[level] < fleetStatusRank[status] {
+			status = level
+		}
+		reasons = append(reasons, reason)
+	}
+	above := func(value *float64, criticalLimit, warningLimit float64, reason string) {
+		switch {
+		case value == nil:
+		case *value >= criticalLimit:
+			flag(FleetStatusCritical, fmt.Sprintf(reason, *value))
+		case *value >= warningLimit:
+			flag(FleetStatusWarning, fmt.Sprintf(reason, *value))
+		}
+	}
+
+	above(f.ConnectionsPercent, critical.ConnectionsPercent, warning.ConnectionsPercent, "connections at %.0f%% of max_connections")
+	above(f.CPUPercent, critical.CPUPercent, warning.CPUPercent, "CPU at %.0f%%")
+	above(f.MaxXIDAge, critical.XIDAge, warning.XIDAge, "oldest unfrozen transaction ID is %.0f transactions old")
+	if f.DiskFreePercent != nil {
+		free := *f.DiskFreePercent
+		switch {
+		case free <= critical.DiskFreePercent:
+			flag(FleetStatusCritical, fmt.Sprintf("%.0f%% disk free", free))
+		case free <= warning.DiskFreePercent:
+			flag(FleetStatusWarning, fmt.Sprintf("%.0f%% disk free", free))
+		}
+	}
+	if f.AAS != nil && f.Instance.LogicalCores > 0 && *f.AAS > float64(f.Instance.LogicalCores) {
+		flag(FleetStatusWarning, fmt.Sprintf("%.1f average active sessions on %d cores", *f.AAS, f.Instance.LogicalCores))
+	}
+	if f.Instance.State == InstanceStateStale {
+		flag(FleetStatusWarning, fmt.Sprintf("no snapshot for %.0f minutes", *f.DataAgeSeconds/60))
+	}
+
+	return status, reasons
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/alerting"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func fleetSample(stat, sysID string, value float64) map[string]interface{} {
+	return statSample(stat, map[string]interface{}{"sys_type": "amazon_rds", "sys_id": sysID, "sys_scope": "us-west-2"}, value)
+}
+
+func TestFleetHandler(t *testing.T) {
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.HasPrefix(query, "count(cc_pg_stat_activity)")
+	}), mock.Anything).Return([]map[string]interface{}{
+		statSample("", map[string]interface{}{"sys_type": "amazon_rds", "sys_id": "orders", "sys_scope": "us-west-2"}, 3),
+		statSample("", map[string]interface{}{"sys_type": "amazon_rds", "sys_id": "new", "sys_scope": "us-west-2"}, 1),
+	}, nil)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, `"stat", "aas"`) && strings.Contains(query, `"stat", "max_xid_age"`)
+	}), mock.MatchedBy(func(options map[string]string) bool {
+		return options["dim"] != "time" && options["start"] == options["end"]
+	})).Return([]map[string]interface{}{
+		fleetSample("aas", "orders", 3),
+		fleetSample("cpu_percent", "orders", 50),
+		fleetSample("connections_percent", "orders", 95),
+		fleetSample("disk_free_percent", "orders", 15),
+		fleetSample("disk_free_bytes", "orders", 1<<30),
+		fleetSample("max_xid_age", "orders", 100_000_000),
+		fleetSample("aas", "new", 0.5),
+		fleetSample("connections_percent", "new", 10),
+		fleetSample("connections_percent", "unknown", 99),
+	}, nil)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/fleet", fleet_handler(mockService, &MockQueryStorage{}, nil))
+
+	record := httptest.NewRecorder()
+	r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/fleet", nil))
+	require.Equal(t, http.StatusOK, record.Code, record.Body.String())
+
+	var response struct {
+		Data []FleetInstance `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	require.Len(t, response.Data, 3, "only known instances are listed")
+
+	orders, legacy, discovered := response.Data[0], response.Data[1], response.Data[2]
+	assert.Equal(t, "amazon_rds/orders/us-west-2", orders.Instance.DBIdentifier)
+	assert.Equal(t, FleetStatusCritical, orders.Status)
+	assert.Equal(t, []string{
+		"connections at 95% of max_connections",
+		"15% disk free",
+		"3.0 average active sessions on 2 cores",
+	}, orders.Reasons)
+	assert.Equal(t, 50.0, *orders.CPUPercent)
+	assert.Equal(t, float64(1<<30), *orders.DiskFreeBytes)
+	assert.InDelta(t, 300, *orders.DataAgeSeconds, 5)
+
+	assert.Equal(t, "amazon_rds/legacy/us-west-2", legacy.Instance.DBIdentifier)
+	assert.Equal(t, FleetStatusOffline, legacy.Status)
+	assert.Nil(t, legacy.AAS)
+
+	assert.Equal(t, "amazon_rds/new/us-west-2", discovered.Instance.DBIdentifier)
+	assert.Equal(t, FleetStatusOK, discovered.Status)
+	assert.Empty(t, discovered.Reasons)
+	assert.Nil(t, discovered.DataAgeSeconds, "instances missing from the registry have no snapshot time")
+
+	testCases := []struct {
+		name         string
+		query        string
+		expectedCode int
+		expected     []string
+	}{
+		{name: "Status", query: "status=ok", expectedCode: http.StatusOK, expected: []string{"amazon_rds/new/us-west-2"}},
+		{name: "State", query: "state=offline", expectedCode: http.StatusOK, expected: []string{"amazon_rds/legacy/us-west-2"}},
+		{name: "Invalid status", query: "status=bad", expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/fleet?"+tc.query, nil))
+			require.Equal(t, tc.expectedCode, record.Code, record.Body.String())
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data []FleetInstance `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+			identifiers := []string{}
+			for _, instance := range response.Data {
+				identifiers = append(identifiers, instance.Instance.DBIdentifier)
+			}
+			assert.Equal(t, tc.expected, identifiers)
+		})
+	}
+}
+
+func TestFleetHealth(t *testing.T) {
+	testCases := []struct {
+		name            string
+		instance        FleetInstance
+		expectedStatus  string
+		expectedReasons []string
+	}{
+		{
+			name:            "No metrics",
+			instance:        FleetInstance{Instance: InstanceInfo{State: InstanceStateActive}},
+			expectedStatus:  FleetStatusOK,
+			expectedReasons: []string{},
+		},
+		{
+			name:            "Close to the alert limits",
+			instance:        FleetInstance{Instance: InstanceInfo{State: InstanceStateActive}, CPUPercent: floatPtr(80), MaxXIDAge: floatPtr(600_000_000)},
+			expectedStatus:  FleetStatusWarning,
+			expectedReasons: []string{"CPU at 80%", "oldest unfrozen transaction ID is 600000000 transactions old"},
+		},
+		{
+			name:            "Past an alert limit",
+			instance:        FleetInstance{Instance: InstanceInfo{State: InstanceStateActive}, DiskFreePercent: floatPtr(5)},
+			expectedStatus:  FleetStatusCritical,
+			expectedReasons: []string{"5% disk free"},
+		},
+		{
+			name:            "Stale data",
+			instance:        FleetInstance{Instance: InstanceInfo{State: InstanceStateStale}, DataAgeSeconds: floatPtr(1800)},
+			expectedStatus:  FleetStatusWarning,
+			expectedReasons: []string{"no snapshot for 30 minutes"},
+		},
+		{
+			name:            "Offline",
+			instance:        FleetInstance{Instance: InstanceInfo{State: InstanceStateOffline, LastSeenMs: 1718000000000}, CPUPercent: floatPtr(99)},
+			expectedStatus:  FleetStatusOffline,
+			expectedReasons: []string{"no snapshot since 2024-06-10T06:13:20Z"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, reasons := fleetHealth(tc.instance, alerting.DefaultThresholds(), fleetWarningThresholds)
+			assert.Equal(t, tc.expectedStatus, status)
+			assert.Equal(t, tc.expectedReasons, reasons)
+		})
+	}
+}
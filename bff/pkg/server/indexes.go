@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"local/bff/pkg/advisor"
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// defaultUnusedIndexWindow is how long an index must go without scans to be
+// reported as unused, unless the request sets unused_window
+const defaultUnusedIndexWindow = 30 * 24 * time.Hour
+
+// index_advice_handler reports the invalid, duplicate, prefix and unused
+// indexes of an instance, with the space dropping them would free and the
+// statements to do so
+func index_advice_handler(query_storage query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		window := defaultUnusedIndexWindow
+		if value := r.URL.Query().Get("unused_window"); value != "" {
+			window, err = time.ParseDuration(value)
+			if err != nil || window <= 0 {
+				http.Error(w, fmt.Sprintf("The 'unused_window' parameter must be a positive duration such as %s.", defaultUnusedIndexWindow), http.StatusBadRequest)
+				return
+			}
+		}
+
+		indexes, err := query_storage.ListIndexes(system)
+		if err != nil {
+			http.Error(w, "Error listing indexes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now()
+		writeJSON(w, http.StatusOK, advisor.AdviseIndexes(indexes, now, window), now)
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/advisor"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexAdviceHandler(t *testing.T) {
+	handler := index_advice_handler(&MockQueryStorage{}, CreateValidator())
+	dbIdentifier := "amazon_rds/default_db/us-west-2"
+
+	testCases := []struct {
+		name          string
+		query         string
+		expectedCode  int
+		expectedKinds []string
+	}{
+		{name: "Default window", query: "dbidentifier=" + dbIdentifier, expectedCode: http.StatusOK, expectedKinds: []string{advisor.IndexUnused}},
+		{name: "Custom window", query: "dbidentifier=" + dbIdentifier + "&unused_window=2h", expectedCode: http.StatusOK, expectedKinds: []string{advisor.IndexUnused}},
+		{name: "Invalid window", query: "dbidentifier=" + dbIdentifier + "&unused_window=30d", expectedCode: http.StatusBadRequest},
+		{name: "Missing dbidentifier", query: "", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/indexes/advice?"+tc.query, nil))
+			require.Equal(t, tc.expectedCode, record.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data advisor.IndexAdvice `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+
+			var kinds []string
+			for _, finding := range response.Data.Findings {
+				kinds = append(kinds, finding.Kind)
+			}
+			assert.Equal(t, tc.expectedKinds, kinds)
+			assert.Equal(t, int64(8192), response.Data.EstimatedSavingsBytes)
+			assert.Contains(t, response.Data.DropScript, `DROP INDEX CONCURRENTLY IF EXISTS "public"."orders_status_idx";`)
+		})
+	}
+}
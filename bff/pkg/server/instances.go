@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/instances"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	InstanceStateActive  = "active"
+	InstanceStateStale   = "stale"
+	InstanceStateOffline = "offline"
+
+	// A system is active if a full snapshot arrived within the discovery
+	// window and offline after missing several in a row
+	instanceActiveWindow = metrics.FullSnapshotWindow
+	instanceStaleWindow  = time.Hour
+
+	maxInstanceTags = 20
+)
+
+// InstanceMetadataRequest is the body of PUT /api/v1/instance/metadata. It
+// replaces everything set on the instance before.
+type InstanceMetadataRequest struct {
+	DbIdentifier string   `json:"dbidentifier"`
+	DisplayName  string   `json:"display_name"`
+	Environment  string   `json:"environment"`
+	Team         string   `json:"team"`
+	Tags         []string `json:"tags"`
+}
+
+// Validate checks the fields that are not checked by the store
+func (req InstanceMetadataRequest) Validate() error {
+	if len(req.Tags) > maxInstanceTags {
+		return fmt.Errorf("at most %d tags are allowed", maxInstanceTags)
+	}
+	seen := make(map[string]bool, len(req.Tags))
+	for _, tag := range req.Tags {
+		if tag == "" {
+			return fmt.Errorf("tags must not be empty")
+		}
+		if seen[tag] {
+			return fmt.Errorf("duplicate tag %q", tag)
+		}
+		seen[tag] = true
+	}
+	return nil
+}
+
+// instanceState tells whether a system last seen at lastSeen is still
+// reporting at now
+func instanceState(lastSeen, now time.Time) string {
+	switch age := now.Sub(lastSeen); {
+	case age <= instanceActiveWindow:
+		return InstanceStateActive
+	case age <= instanceStaleWindow:
+		return InstanceStateStale
+	default:
+		return InstanceStateOffline
+	}
+}
+
+// info_handler lists the known instances with what users set on them. The
+// list can be narrowed with the tag (repeatable, all must match),
+// environment, team and state parameters.
+func info_handler(metrics_service metrics.Service, queryStore query_storage.QueryStorage, metadataStore *instances.Store, _ *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instanceInfos, err := listInstances(metrics_service, queryStore, metadataStore, r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, "Error querying list of instances.", http.StatusInternalServerError)
+			return
+		}
+
+		info_handler_internal(instanceInfos).ServeHTTP(w, r)
+	})
+}
+
+// listInstances returns the instances in the registry kept by collector-api,
+// plus those only seen in Prometheus during the last 15 minutes, with what
+// users set on them, that match the filters in params. It only fails when
+// neither the registry nor Prometheus can be read.
+func listInstances(metrics_service metrics.Service, queryStore query_storage.QueryStorage, metadataStore *instances.Store, params url.Values, now time.Time) ([]InstanceInfo, error) {
+	instanceInfoMap := make(map[query_storage.SystemRef]InstanceInfo)
+
+	registry, registryErr := queryStore.ListInstances()
+	if registryErr != nil {
+		log.Printf("Error listing registered instances: %v", registryErr)
+	}
+	for _, instance := range registry {
+		lastSeen := time.Unix(instance.LastSeen, 0)
+		instanceInfoMap[instance.System] = InstanceInfo{
+			DBIdentifier:     instance.System.SystemType + "/" + instance.System.SystemID + "/" + instance.System.SystemScope,
+			SystemID:         instance.System.SystemID,
+			SystemScope:      instance.System.SystemScope,
+			SystemType:       instance.System.SystemType,
+			State:            instanceState(lastSeen, now),
+			FirstSeenMs:      instance.FirstSeen * 1000,
+			LastSeenMs:       lastSeen.UnixMilli(),
+			PostgresVersion:  instance.PostgresVersion,
+			Platform:         instance.Platform,
+			InstanceClass:    instance.InstanceClass,
+			MemoryBytes:      instance.MemoryBytes,
+			LogicalCores:     instance.LogicalCores,
+			CollectorVersion: instance.CollectorVersion,
+		}
+	}
+
+	query := `count(cc_pg_stat_activity) by (sys_id, sys_scope, sys_scope_fallback,sys_type)`
+	options := map[string]string{
+		"start": strconv.FormatInt(now.Add(-instanceActiveWindow).UnixMilli(), 10),
+		"end":   strconv.FormatInt(now.UnixMilli(), 10),
+	}
+	result, err := metrics_service.ExecuteRaw(query, options)
+	if err != nil {
+		if registryErr != nil {
+			return nil, fmt.Errorf("list instances: %w", err)
+		}
+		log.Printf("Error querying active instances: %v", err)
+	}
+
+	for _, sample := range result {
+		system := query_storage.SystemRef{
+			SystemID:    getValue(sample, "sys_id"),
+			SystemScope: getValue(sample, "sys_scope"),
+			SystemType:  getValue(sample, "sys_type"),
+		}
+
+		// Prometheus has the most recent word on whether a system reports
+		info, exists := instanceInfoMap[system]
+		if !exists {
+			info = InstanceInfo{
+				DBIdentifier: system.SystemType + "/" + system.SystemID + "/" + system.SystemScope,
+				SystemID:     system.SystemID,
+				SystemScope:  system.SystemScope,
+				SystemType:   system.SystemType,
+			}
+		}
+		info.State = InstanceStateActive
+		instanceInfoMap[system] = info
+	}
+
+	if metadataStore != nil {
+		metadata, err := metadataStore.List()
+		if err != nil {
+			log.Printf("Error listing instance metadata: %v", err)
+		}
+		for system, m := range metadata {
+			info, exists := instanceInfoMap[system]
+			if !exists {
+				continue
+			}
+			info.DisplayName = m.DisplayName
+			info.Environment = m.Environment
+			info.Team = m.Team
+			info.Tags = m.Tags
+			instanceInfoMap[system] = info
+		}
+	}
+
+	instanceInfos := []InstanceInfo{}
+	for _, info := range instanceInfoMap {
+		if matchesInstanceFilters(info, params["tag"], params.Get("environment"), params.Get("team"), params.Get("state")) {
+			instanceInfos = append(instanceInfos, info)
+		}
+	}
+	sort.Slice(instanceInfos, func(i, j int) bool {
+		return instanceInfos[i].DBIdentifier < instanceInfos[j].DBIdentifier
+	})
+
+	return instanceInfos, nil
+}
+
+// matchesInstanceFilters tells whether info has every tag in tags and the
+// given environment, team and state; empty filters match everything
+func matchesInstanceFilters(info InstanceInfo, tags []string, environment, team, state string) bool {
+	if environment != "" && info.Environment != environment {
+		return false
+	}
+	if team != "" && info.Team != team {
+		return false
+	}
+	if state != "" && info.State != state {
+		return false
+	}
+	m := instances.Metadata{Tags: info.Tags}
+	for _, tag := range tags {
+		if !m.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// instance_metadata_handler sets the display name, environment, team and tags
+// of an instance
+func instance_metadata_handler(store *instances.Store, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req InstanceMetadataRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid instance metadata: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		system, err := parseSystemRef(req.DbIdentifier, validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			http.Error(w, "Invalid instance metadata: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		metadata, err := store.Set(instances.Metadata{
+			System:      system,
+			DisplayName: req.DisplayName,
+			Environment: req.Environment,
+			Team:        req.Team,
+			Tags:        req.Tags,
+			UpdatedAt:   now.UnixMilli(),
+		})
+		if err != nil {
+			http.Error(w, "Error saving instance metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, metadata, now)
+	})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/instances"
+	"local/bff/pkg/state_storage/statetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestInstanceStore(t *testing.T) *instances.Store {
+	db := statetest.Open(t)
+	return instances.NewStore(db)
+}
+
+func TestInstanceState(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, InstanceStateActive, instanceState(now.Add(-10*time.Minute), now))
+	assert.Equal(t, InstanceStateStale, instanceState(now.Add(-30*time.Minute), now))
+	assert.Equal(t, InstanceStateOffline, instanceState(now.Add(-2*time.Hour), now))
+}
+
+func TestInstanceHandlers(t *testing.T) {
+	store := newTestInstanceStore(t)
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.Anything, mock.Anything).Return([]map[string]interface{}{
+		statSample("", map[string]interface{}{"sys_type": "amazon_rds", "sys_id": "orders", "sys_scope": "us-west-2"}, 3),
+		statSample("", map[string]interface{}{"sys_type": "self_hosted", "sys_id": "new", "sys_scope": "dc1"}, 1),
+	}, nil)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/instance", info_handler(mockService, &MockQueryStorage{}, store, CreateValidator()))
+	r.Put("/api/v1/instance/metadata", instance_metadata_handler(store, CreateValidator()))
+
+	putCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "Orders", body: `{"dbidentifier":"amazon_rds/orders/us-west-2","display_name":"Orders","environment":"production","team":"payments","tags":["billing","tier:1"]}`, expectedCode: http.StatusOK},
+		{name: "Legacy", body: `{"dbidentifier":"amazon_rds/legacy/us-west-2","environment":"production","tags":["billing"]}`, expectedCode: http.StatusOK},
+		{name: "Missing dbidentifier", body: `{"display_name":"Orders"}`, expectedCode: http.StatusBadRequest},
+		{name: "Empty tag", body: `{"dbidentifier":"amazon_rds/orders/us-west-2","tags":[""]}`, expectedCode: http.StatusBadRequest},
+		{name: "Duplicate tag", body: `{"dbidentifier":"amazon_rds/orders/us-west-2","tags":["billing","billing"]}`, expectedCode: http.StatusBadRequest},
+		{name: "Malformed body", body: `{"tags":`, expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range putCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			r.ServeHTTP(record, httptest.NewRequest(http.MethodPut, "/api/v1/instance/metadata", strings.NewReader(tc.body)))
+			assert.Equal(t, tc.expectedCode, record.Code, record.Body.String())
+		})
+	}
+
+	record := httptest.NewRecorder()
+	r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/instance", nil))
+	require.Equal(t, http.StatusOK, record.Code, record.Body.String())
+
+	var response struct {
+		List []InstanceInfo `json:"list"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	require.Len(t, response.List, 3)
+
+	legacy, orders, discovered := response.List[0], response.List[1], response.List[2]
+	assert.Equal(t, "amazon_rds/legacy/us-west-2", legacy.DBIdentifier)
+	assert.Equal(t, InstanceStateOffline, legacy.State)
+
+	assert.Equal(t, "Orders", orders.DisplayName)
+	assert.Equal(t, "payments", orders.Team)
+	assert.Equal(t, []string{"billing", "tier:1"}, orders.Tags)
+	assert.Equal(t, InstanceStateActive, orders.State)
+	assert.Equal(t, "16.3", orders.PostgresVersion)
+	assert.Equal(t, "db.t4g.medium", orders.InstanceClass)
+	assert.Equal(t, int64(2), orders.LogicalCores)
+	assert.NotZero(t, orders.LastSeenMs)
+
+	assert.Equal(t, InstanceInfo{
+		DBIdentifier: "self_hosted/new/dc1",
+		SystemID:     "new",
+		SystemScope:  "dc1",
+		SystemType:   "self_hosted",
+		State:        InstanceStateActive,
+	}, discovered, "systems only seen in Prometheus are listed without registry facts")
+
+	filterCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{name: "One tag", query: "tag=billing", expected: []string{"amazon_rds/legacy/us-west-2", "amazon_rds/orders/us-west-2"}},
+		{name: "All tags must match", query: "tag=billing&tag=tier:1", expected: []string{"amazon_rds/orders/us-west-2"}},
+		{name: "Unknown tag", query: "tag=reporting", expected: []string{}},
+		{name: "Environment and state", query: "environment=production&state=offline", expected: []string{"amazon_rds/legacy/us-west-2"}},
+		{name: "Team", query: "team=payments", expected: []string{"amazon_rds/orders/us-west-2"}},
+	}
+	for _, tc := range filterCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/instance?"+tc.query, nil))
+			require.Equal(t, http.StatusOK, record.Code)
+
+			var response struct {
+				List []InstanceInfo `json:"list"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+			identifiers := []string{}
+			for _, info := range response.List {
+				identifiers = append(identifiers, info.DBIdentifier)
+			}
+			assert.Equal(t, tc.expected, identifiers)
+		})
+	}
+}
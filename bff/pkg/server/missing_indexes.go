@@ -0,0 +1,212 @@
+package server
+
+import (
+	"fmt"
+	"local/bff/pkg/advisor"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	defaultMissingIndexWindow = 24 * time.Hour
+	// missingIndexQueryLimit bounds the queries considered for matching, by
+	// total time and by activity
+	missingIndexQueryLimit = 500
+)
+
+// missing_index_hints_handler lists the tables whose sequential scans over the
+// window suggest a missing index, by estimated I/O impact, with the queries
+// that mention them
+func missing_index_hints_handler(metrics_service metrics.Service, query_storage query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		end := now
+		if value := r.URL.Query().Get("end"); value != "" {
+			end, err = parseTimeParameter(value, now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		window := defaultMissingIndexWindow
+		if value := r.URL.Query().Get("window"); value != "" {
+			window, err = time.ParseDuration(value)
+			if err != nil || window < time.Minute {
+				http.Error(w, "The 'window' parameter must be a duration of at least 1m.", http.StatusBadRequest)
+				return
+			}
+		}
+
+		tables, err := queryTableScans(metrics_service, system, end, window)
+		if err != nil {
+			http.Error(w, "Error querying table scans: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		queries, err := queryLoads(metrics_service, query_storage, system, end, window)
+		if err != nil {
+			http.Error(w, "Error querying query load: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, advisor.AdviseMissingIndexes(tables, queries, advisor.DefaultMissingIndexThresholds()), now)
+	})
+}
+
+func instantOptions(at time.Time, dim string) map[string]string {
+	return map[string]string{
+		"start": strconv.FormatInt(at.UnixMilli(), 10),
+		"end":   strconv.FormatInt(at.UnixMilli(), 10),
+		"dim":   dim,
+	}
+}
+
+// queryTableScans fetches the scans of every table over the window ending at
+// end, and its latest size, in a single query
+func queryTableScans(metrics_service metrics.Service, system query_storage.SystemRef, end time.Time, window time.Duration) ([]advisor.TableScans, error) {
+	selector := fmt.Sprintf(`sys_id="%s",sys_scope="%s",sys_type="%s"`, system.SystemID, system.SystemScope, system.SystemType)
+	seconds := int64(window.Seconds())
+	samples, err := metrics_service.ExecuteRaw(fmt.Sprintf(
+		`label_replace(%[2]s, "stat", "seq_scans", "", "")`+
+			` or label_replace(%[3]s, "stat", "seq_tup_read", "", "")`+
+			` or label_replace(%[4]s, "stat", "idx_scans", "", "")`+
+			` or label_replace(cc_relation_size_bytes{%[1]s}, "stat", "size_bytes", "", "")`+
+			` or label_replace(cc_relation_n_live_tup{%[1]s}, "stat", "live_tuples", "", "")`,
+		selector,
+		counterExpr("increase", "cc_relation_seq_scan", selector, seconds),
+		counterExpr("increase", "cc_relation_seq_tup_read", selector, seconds),
+		counterExpr("increase", "cc_relation_idx_scan", selector, seconds)), instantOptions(end, "relation"))
+	if err != nil {
+		return nil, err
+	}
+
+	byTable := make(map[string]*advisor.TableScans)
+	var keys []string
+	for _, sample := range samples {
+		value, ok := sampleValue(sample)
+		if !ok {
+			continue
+		}
+
+		datname, schema, relation := getValue(sample, "datname"), getValue(sample, "schema"), getValue(sample, "relation")
+		key := datname + "\x00" + schema + "\x00" + relation
+		table, ok := byTable[key]
+		if !ok {
+			table = &advisor.TableScans{Database: datname, Schema: schema, Relation: relation}
+			byTable[key] = table
+			keys = append(keys, key)
+		}
+
+		switch getValue(sample, "stat") {
+		case "seq_scans":
+			table.SeqScans = value
+		case "seq_tup_read":
+			table.SeqTupRead = value
+		case "idx_scans":
+			table.IdxScans = value
+		case "size_bytes":
+			table.SizeBytes = value
+		case "live_tuples":
+			table.LiveTuples = value
+		}
+	}
+
+	sort.Strings(keys)
+	tables := make([]advisor.TableScans, 0, len(keys))
+	for _, key := range keys {
+		tables = append(tables, *byTable[key])
+	}
+	return tables, nil
+}
+
+// queryLoads fetches the heaviest queries over the window ending at end, from
+// query statistics and from activity. A query seen in both is merged.
+func queryLoads(metrics_service metrics.Service, queryStore query_storage.QueryStorage, system query_storage.SystemRef, end time.Time, window time.Duration) ([]advisor.QueryLoad, error) {
+	selector := fmt.Sprintf(`sys_id="%s",sys_scope="%s",sys_type="%s"`, system.SystemID, system.SystemScope, system.SystemType)
+	seconds := int64(window.Seconds())
+
+	stats, err := metrics_service.ExecuteRaw(fmt.Sprintf(
+		`label_replace(topk(%[1]d, sum by (query) %[2]s), "stat", "total_time", "", "")`+
+			` or label_replace(topk(%[1]d, sum by (query) %[3]s), "stat", "calls", "", "")`,
+		missingIndexQueryLimit,
+		counterExpr("increase", "cc_query_total_time_seconds", selector, seconds),
+		counterExpr("increase", "cc_query_calls", selector, seconds)), instantOptions(end, "query"))
+	if err != nil {
+		return nil, err
+	}
+
+	activity, err := metrics_service.ExecuteRaw(fmt.Sprintf(
+		`topk(%[3]d, sum by (query_fp) (sum_over_time(cc_pg_stat_activity{%[1]s,query_fp!=""}[%[2]ds])))`,
+		selector, seconds, missingIndexQueryLimit), instantOptions(end, "query_fp"))
+	if err != nil {
+		return nil, err
+	}
+
+	byText := make(map[string]*advisor.QueryLoad)
+	var texts []string
+	load := func(text string) *advisor.QueryLoad {
+		q, ok := byText[text]
+		if !ok {
+			q = &advisor.QueryLoad{Query: text}
+			byText[text] = q
+			texts = append(texts, text)
+		}
+		return q
+	}
+
+	for _, sample := range stats {
+		value, ok := sampleValue(sample)
+		text := getValue(sample, "query")
+		if !ok || text == "" {
+			continue
+		}
+		switch getValue(sample, "stat") {
+		case "total_time":
+			load(text).TotalTimeSeconds = value
+		case "calls":
+			load(text).Calls = value
+		}
+	}
+
+	samplesByFingerprint := make(map[string]float64)
+	fingerprints := make([]string, 0, len(activity))
+	for _, sample := range activity {
+		if value, ok := sampleValue(sample); ok {
+			fp := getValue(sample, "query_fp")
+			samplesByFingerprint[fp] = value
+			fingerprints = append(fingerprints, fp)
+		}
+	}
+	if len(fingerprints) > 0 {
+		textsByFingerprint, err := queryStore.GetQueries(system, fingerprints)
+		if err != nil {
+			return nil, err
+		}
+		for _, fp := range fingerprints {
+			if text, ok := textsByFingerprint[fp]; ok && text != "" {
+				q := load(text)
+				q.Fingerprint = fp
+				q.ActiveSamples = samplesByFingerprint[fp]
+			}
+		}
+	}
+
+	loads := make([]advisor.QueryLoad, 0, len(texts))
+	for _, text := range texts {
+		loads = append(loads, *byText[text])
+	}
+	return loads, nil
+}
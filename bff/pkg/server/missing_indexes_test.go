@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/advisor"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func statSample(stat string, labels map[string]interface{}, value float64) map[string]interface{} {
+	metric := map[string]interface{}{"stat": stat}
+	for name, v := range labels {
+		metric[name] = v
+	}
+	return map[string]interface{}{
+		"metric": metric,
+		"values": []map[string]interface{}{{"timestamp": int64(2000000), "value": value}},
+	}
+}
+
+func TestMissingIndexHintsHandler(t *testing.T) {
+	dbIdentifier := "amazon_rds/default_db/us-west-2"
+	orders := map[string]interface{}{"datname": "app", "schema": "public", "relation": "orders"}
+
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, `(increase(cc_relation_seq_scan_total{sys_id="default_db",sys_scope="us-west-2",sys_type="amazon_rds"}[3600s])`+
+			` or increase(cc_relation_seq_scan{sys_id="default_db",sys_scope="us-west-2",sys_type="amazon_rds"}[3600s]))`)
+	}), map[string]string{"start": "2000000", "end": "2000000", "dim": "relation"}).Return([]map[string]interface{}{
+		statSample("seq_scans", orders, 50),
+		statSample("seq_tup_read", orders, 5000000),
+		statSample("idx_scans", orders, 50),
+		statSample("size_bytes", orders, 100<<20),
+		statSample("live_tuples", orders, 100000),
+	}, nil)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "cc_query_total_time_seconds_total") && strings.Contains(query, " or increase(cc_query_calls{")
+	}), map[string]string{"start": "2000000", "end": "2000000", "dim": "query"}).Return([]map[string]interface{}{
+		statSample("total_time", map[string]interface{}{"query": "SELECT * FROM orders WHERE customer_id = $1"}, 30),
+		statSample("calls", map[string]interface{}{"query": "SELECT * FROM orders WHERE customer_id = $1"}, 50),
+		statSample("total_time", map[string]interface{}{"query": "SELECT * FROM table WHERE id = $1"}, 10),
+	}, nil)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "cc_pg_stat_activity")
+	}), map[string]string{"start": "2000000", "end": "2000000", "dim": "query_fp"}).Return([]map[string]interface{}{
+		statSample("", map[string]interface{}{"query_fp": "fp1"}, 12),
+	}, nil)
+
+	handler := missing_index_hints_handler(mockService, &MockQueryStorage{}, CreateValidator())
+
+	record := httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/indexes/missing?dbidentifier="+dbIdentifier+"&end=2000000&window=1h", nil))
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data []advisor.MissingIndexHint `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	require.Len(t, response.Data, 1)
+	hint := response.Data[0]
+	assert.Equal(t, "orders", hint.Relation)
+	assert.Equal(t, 50.0, hint.SeqScans)
+	assert.Equal(t, []advisor.QueryLoad{
+		{Query: "SELECT * FROM orders WHERE customer_id = $1", Calls: 50, TotalTimeSeconds: 30},
+	}, hint.Queries)
+
+	for _, query := range []string{"window=30s", "window=1d", "end=yesterday"} {
+		record := httptest.NewRecorder()
+		handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/indexes/missing?dbidentifier="+dbIdentifier+"&"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, record.Code, query)
+	}
+}
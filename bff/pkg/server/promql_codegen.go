@@ -272,6 +272,15 @@ func escapePromQLLabelValue(value string) string {
 	return replacer.Replace(value)
 }
 
+// counterExpr applies fn (rate or increase) to the <name>_total counter of a
+// cumulative statistic over window seconds, falling back per series to the
+// per-snapshot <name> series where there is no counter: windows from before
+// the counters existed, or collector-api running with counter_metrics.totals
+// disabled
+func counterExpr(fn, name, selector string, window int64) string {
+	return fmt.Sprintf(`(%[1]s(%[2]s_total{%[3]s}[%[4]ds]) or %[1]s(%[2]s{%[3]s}[%[4]ds]))`, fn, name, selector, window)
+}
+
 // Utility function to parse start and finish times
 func parseTimeParameter(param string, now time.Time) (time.Time, error) {
 	switch {
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// QueryInfo is a captured query of an instance along with when it was seen
+type QueryInfo struct {
+	QueryFP          string `json:"query_fp"`
+	QueryText        string `json:"query_text"`
+	QueryFull        string `json:"query_full"`
+	Datname          string `json:"datname"`
+	Usename          string `json:"usename"`
+	FirstSeenMs      int64  `json:"first_seen_ms"`
+	LastSeenMs       int64  `json:"last_seen_ms"`
+	InOrderSightings int64  `json:"in_order_sightings"`
+}
+
+// parseTimeWindow reads the start and end query parameters, defaulting end to now
+func parseTimeWindow(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	start := r.URL.Query().Get("start")
+	if start == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("start is required.")
+	}
+
+	startTime, err := parseTimeParameter(start, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	endTime := now
+	if end := r.URL.Query().Get("end"); end != "" {
+		endTime, err = parseTimeParameter(end, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	if startTime.After(endTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("End time must be after Start time.")
+	}
+
+	return startTime, endTime, nil
+}
+
+// parseSystemRef validates a single dbidentifier and splits it into its parts
+func parseSystemRef(dbIdentifier string, validate *validator.Validate) (query_storage.SystemRef, error) {
+	if err := validate.Var(dbIdentifier, "required,dbIdentifier"); err != nil {
+		return query_storage.SystemRef{}, fmt.Errorf("The 'dbidentifier' parameter is required and must be valid.")
+	}
+
+	systemType, systemID, systemScope, err := splitDbIdentifier(dbIdentifier)
+	if err != nil {
+		return query_storage.SystemRef{}, fmt.Errorf("The 'dbidentifier' is malformatted.")
+	}
+
+	return query_storage.SystemRef{
+		SystemType:  systemType,
+		SystemID:    systemID,
+		SystemScope: systemScope,
+	}, nil
+}
+
+// queries_handler lists the queries captured for an instance within a time
+// window. With new=true, only queries first seen within the window are listed,
+// which answers "what started running after the deploy?".
+func queries_handler(queryStore query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		startTime, endTime, err := parseTimeWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		onlyNew := r.URL.Query().Get("new") == "true"
+
+		var stored []query_storage.SystemQuery
+		if onlyNew {
+			stored, err = queryStore.ListNewQueries(system, startTime.Unix())
+		} else {
+			stored, err = queryStore.ListQueries(system, startTime.Unix(), endTime.Unix())
+		}
+		if err != nil {
+			http.Error(w, "Error listing queries: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		queries := make([]QueryInfo, 0, len(stored))
+		for _, q := range stored {
+			if onlyNew && q.FirstSeen > endTime.Unix() {
+				continue
+			}
+			queries = append(queries, toQueryInfo(q))
+		}
+
+		js, err := json.Marshal(queries)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		wrappedJSON, err := WrapJSON(js, map[string]interface{}{"server_now": now.UnixMilli()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(wrappedJSON)
+	})
+}
+
+// QuerySearchResult is a query matching a search along with its activity over
+// the searched window. ActivityTotal sums the sampled active sessions of the
+// query, so it grows with both concurrency and runtime.
+type QuerySearchResult struct {
+	QueryInfo
+	ActivityTotal float64 `json:"activity_total"`
+}
+
+const (
+	defaultQuerySearchLimit = 50
+	maxQuerySearchLimit     = 500
+	// maxQuerySearchCandidates bounds the matches ranked by activity, since
+	// all of them go into a single PromQL matcher
+	maxQuerySearchCandidates = 2000
+)
+
+// queries_search_handler full-text searches the captured query text of an
+// instance, ranks the matching fingerprints by their activity in
+// cc_pg_stat_activity over the chosen window and returns the top ones. When
+// more than maxQuerySearchCandidates queries match, only the most recently
+// seen of them are ranked and the response metadata says so.
+func queries_search_handler(metrics_service metrics.Service, queryStore query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		text := strings.TrimSpace(r.URL.Query().Get("q"))
+		if text == "" {
+			http.Error(w, "The 'q' parameter is required.", http.StatusBadRequest)
+			return
+		}
+
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		startTime, endTime, err := parseTimeWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := defaultQuerySearchLimit
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 || limit > maxQuerySearchLimit {
+				http.Error(w, fmt.Sprintf("The 'limit' parameter must be between 1 and %d.", maxQuerySearchLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		stored, err := queryStore.SearchQueries(system, text, startTime.Unix(), endTime.Unix(), maxQuerySearchCandidates+1)
+		if err != nil {
+			http.Error(w, "Error searching queries: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		truncated := len(stored) > maxQuerySearchCandidates
+		if truncated {
+			stored = stored[:maxQuerySearchCandidates]
+		}
+
+		results := make([]QuerySearchResult, 0, len(stored))
+		if len(stored) > 0 {
+			totals, err := queryActivityTotals(metrics_service, system, stored, startTime, endTime)
+			if err != nil {
+				http.Error(w, "Error querying query activity: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			for _, q := range stored {
+				results = append(results, QuerySearchResult{
+					QueryInfo:     toQueryInfo(q),
+					ActivityTotal: totals[q.Fingerprint],
+				})
+			}
+		}
+
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].ActivityTotal > results[j].ActivityTotal
+		})
+		if len(results) > limit {
+			results = results[:limit]
+		}
+
+		js, err := json.Marshal(results)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		wrappedJSON, err := WrapJSON(js, map[string]interface{}{
+			"server_now":           now.UnixMilli(),
+			"candidates_truncated": truncated,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(wrappedJSON)
+	})
+}
+
+// queryActivityTotals sums cc_pg_stat_activity per fingerprint over the window
+// in a single instant query evaluated at its end
+func queryActivityTotals(metrics_service metrics.Service, system query_storage.SystemRef, queries []query_storage.SystemQuery, startTime, endTime time.Time) (map[string]float64, error) {
+	fingerprints := make([]string, 0, len(queries))
+	for _, q := range queries {
+		fingerprints = append(fingerprints, regexp.QuoteMeta(q.Fingerprint))
+	}
+
+	window := int64(endTime.Sub(startTime).Seconds())
+	if window < 1 {
+		window = 1
+	}
+
+	// Fingerprints may contain regex metacharacters, so the matcher uses a raw
+	// string to keep the escaping intact
+	query := fmt.Sprintf("sum by (query_fp) (sum_over_time(cc_pg_stat_activity{sys_id=\"%s\",sys_scope=\"%s\",sys_type=\"%s\",query_fp=~`%s`}[%ds]))",
+		system.SystemID, system.SystemScope, system.SystemType, strings.Join(fingerprints, "|"), window)
+
+	options := map[string]string{
+		"start": strconv.FormatInt(startTime.UnixMilli(), 10),
+		"end":   strconv.FormatInt(endTime.UnixMilli(), 10),
+		"dim":   "query_fp",
+	}
+
+	samples, err := metrics_service.ExecuteRaw(query, options)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]float64, len(samples))
+	for _, sample := range samples {
+		values, ok := sample["values"].([]map[string]interface{})
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if value, ok := values[0]["value"].(float64); ok {
+			totals[getValue(sample, "query_fp")] += value
+		}
+	}
+	return totals, nil
+}
+
+func toQueryInfo(q query_storage.SystemQuery) QueryInfo {
+	return QueryInfo{
+		QueryFP:          q.Fingerprint,
+		QueryText:        q.Query,
+		QueryFull:        q.QueryFull,
+		Datname:          q.Database,
+		Usename:          q.Role,
+		FirstSeenMs:      q.FirstSeen * 1000,
+		LastSeenMs:       q.LastSeen * 1000,
+		InOrderSightings: q.InOrderSightings,
+	}
+}
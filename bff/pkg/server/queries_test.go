@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueriesHandler(t *testing.T) {
+	handler := queries_handler(&MockQueryStorage{}, CreateValidator())
+	dbIdentifier := "amazon_rds/default_db/us-west-2/abcdefghijkl"
+
+	testCases := []struct {
+		name         string
+		query        string
+		expectedCode int
+		expectedFPs  []string
+	}{
+		{
+			name:         "All queries in window",
+			query:        "dbidentifier=" + dbIdentifier + "&start=1000000&end=2000000",
+			expectedCode: http.StatusOK,
+			expectedFPs:  []string{"fp1"},
+		},
+		{
+			name:         "Only new queries, ignoring those first seen after the window",
+			query:        "dbidentifier=" + dbIdentifier + "&start=1000000&end=2000000&new=true",
+			expectedCode: http.StatusOK,
+			expectedFPs:  []string{"fp2"},
+		},
+		{
+			name:         "Missing dbidentifier",
+			query:        "start=now-1h",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Missing start",
+			query:        "dbidentifier=" + dbIdentifier,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "End before start",
+			query:        "dbidentifier=" + dbIdentifier + "&start=2000000&end=1000000",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/queries?"+tc.query, nil)
+			handler.ServeHTTP(record, req)
+			assert.Equal(t, tc.expectedCode, record.Code)
+
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data []QueryInfo `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+
+			var fps []string
+			for _, q := range response.Data {
+				fps = append(fps, q.QueryFP)
+				assert.Equal(t, q.FirstSeenMs%1000, int64(0), "timestamps are returned in milliseconds")
+			}
+			assert.Equal(t, tc.expectedFPs, fps)
+		})
+	}
+}
+
+func TestQueriesSearchHandler(t *testing.T) {
+	dbIdentifier := "amazon_rds/default_db/us-west-2/abcdefghijkl"
+
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "query_fp=~`fp1|fp\\+2/`") && strings.Contains(query, "[1000s]")
+	}), map[string]string{"start": "1000000", "end": "2000000", "dim": "query_fp"}).Return([]map[string]interface{}{
+		{
+			"metric": map[string]interface{}{"query_fp": "fp+2/"},
+			"values": []map[string]interface{}{{"timestamp": int64(2000000), "value": 12.0}},
+		},
+		{
+			"metric": map[string]interface{}{"query_fp": "fp1"},
+			"values": []map[string]interface{}{{"timestamp": int64(2000000), "value": 3.0}},
+		},
+	}, nil)
+
+	handler := queries_search_handler(mockService, &MockQueryStorage{}, CreateValidator())
+
+	testCases := []struct {
+		name         string
+		query        string
+		expectedCode int
+		expectedFPs  []string
+	}{
+		{
+			name:         "Matches ranked by activity",
+			query:        "q=users&dbidentifier=" + dbIdentifier + "&start=1000000&end=2000000",
+			expectedCode: http.StatusOK,
+			expectedFPs:  []string{"fp+2/", "fp1"},
+		},
+		{
+			name:         "Limit applies after ranking",
+			query:        "q=users&dbidentifier=" + dbIdentifier + "&start=1000000&end=2000000&limit=1",
+			expectedCode: http.StatusOK,
+			expectedFPs:  []string{"fp+2/"},
+		},
+		{
+			name:         "Missing search text",
+			query:        "dbidentifier=" + dbIdentifier + "&start=1000000",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid limit",
+			query:        "q=users&dbidentifier=" + dbIdentifier + "&start=1000000&limit=0",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/queries/search?"+tc.query, nil)
+			handler.ServeHTTP(record, req)
+			assert.Equal(t, tc.expectedCode, record.Code)
+
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data []QuerySearchResult `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+			var fps []string
+			for _, q := range response.Data {
+				fps = append(fps, q.QueryFP)
+			}
+			assert.Equal(t, tc.expectedFPs, fps)
+			assert.Equal(t, 12.0, response.Data[0].ActivityTotal)
+			if len(response.Data) > 1 {
+				assert.Equal(t, int64(5), response.Data[1].InOrderSightings)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/events"
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	SchemaEventSource = "schema"
+	SchemaEventKind   = "schema_change"
+	// maxAnnotationTitleChanges is how many changes are named in the title of
+	// a schema change annotation
+	maxAnnotationTitleChanges = 3
+)
+
+// SchemaChangeInfo is a schema change detected between two consecutive full
+// snapshots of an instance, so it happened between previous_collected_at_ms
+// and detected_at_ms
+type SchemaChangeInfo struct {
+	Datname               string `json:"datname"`
+	Type                  string `json:"type"`
+	Schema                string `json:"schema"`
+	Relation              string `json:"relation"`
+	Name                  string `json:"name"`
+	OldDefinition         string `json:"old_definition,omitempty"`
+	NewDefinition         string `json:"new_definition,omitempty"`
+	PreviousCollectedAtMs int64  `json:"previous_collected_at_ms"`
+	DetectedAtMs          int64  `json:"detected_at_ms"`
+}
+
+func toSchemaChangeInfo(e query_storage.SchemaEvent) SchemaChangeInfo {
+	return SchemaChangeInfo{
+		Datname:               e.Database,
+		Type:                  e.Type,
+		Schema:                e.Schema,
+		Relation:              e.Relation,
+		Name:                  e.Name,
+		OldDefinition:         e.OldDefinition,
+		NewDefinition:         e.NewDefinition,
+		PreviousCollectedAtMs: e.PreviousCollectedAt * 1000,
+		DetectedAtMs:          e.DetectedAt * 1000,
+	}
+}
+
+// schema_changes_handler lists the schema changes of an instance within a
+// time window, oldest first. The datname and relation parameters narrow it to
+// a database or a table.
+func schema_changes_handler(queryStore query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		startTime, endTime, err := parseTimeWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stored, err := queryStore.ListSchemaEvents(system, startTime.Unix(), endTime.Unix())
+		if err != nil {
+			http.Error(w, "Error listing schema changes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		datname, relation := r.URL.Query().Get("datname"), r.URL.Query().Get("relation")
+		changes := make([]SchemaChangeInfo, 0, len(stored))
+		for _, e := range stored {
+			if (datname != "" && e.Database != datname) || (relation != "" && e.Relation != relation) {
+				continue
+			}
+			changes = append(changes, toSchemaChangeInfo(e))
+		}
+
+		writeJSON(w, http.StatusOK, changes, now)
+	})
+}
+
+// schema_annotations_handler returns the schema changes of an instance within
+// a time window as chart annotations: one event per snapshot with changes,
+// spanning the interval they happened in
+func schema_annotations_handler(queryStore query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		startTime, endTime, err := parseTimeWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stored, err := queryStore.ListSchemaEvents(system, startTime.Unix(), endTime.Unix())
+		if err != nil {
+			http.Error(w, "Error listing schema changes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		annotations, err := schemaAnnotations(system, stored)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, annotations, now)
+	})
+}
+
+// schemaAnnotations groups schema changes by the snapshot they were detected
+// in. Each annotation is tagged with its databases and change types, and
+// holds its changes in the details.
+func schemaAnnotations(system query_storage.SystemRef, stored []query_storage.SchemaEvent) ([]events.Event, error) {
+	annotations := []events.Event{}
+	for start := 0; start < len(stored); {
+		end := start
+		for end < len(stored) && stored[end].DetectedAt == stored[start].DetectedAt {
+			end++
+		}
+		group := stored[start:end]
+		start = end
+
+		changes := make([]SchemaChangeInfo, 0, len(group))
+		var descriptions []string
+		tags := make(map[string]bool)
+		for _, e := range group {
+			changes = append(changes, toSchemaChangeInfo(e))
+			descriptions = append(descriptions, describeSchemaChange(e))
+			tags["datname:"+e.Database] = true
+			tags[e.Type] = true
+		}
+
+		details, err := json.Marshal(changes)
+		if err != nil {
+			return nil, err
+		}
+
+		title := strings.Join(descriptions, ", ")
+		if len(descriptions) > maxAnnotationTitleChanges {
+			title = fmt.Sprintf("%s and %d more", strings.Join(descriptions[:maxAnnotationTitleChanges], ", "), len(descriptions)-maxAnnotationTitleChanges)
+		}
+
+		annotation := events.Event{
+			System:   system,
+			Source:   SchemaEventSource,
+			Kind:     SchemaEventKind,
+			Title:    title,
+			StartsAt: group[0].PreviousCollectedAt * 1000,
+			EndsAt:   group[0].DetectedAt * 1000,
+			Details:  details,
+		}
+		for tag := range tags {
+			annotation.Tags = append(annotation.Tags, tag)
+		}
+		sort.Strings(annotation.Tags)
+		annotations = append(annotations, annotation)
+	}
+	return annotations, nil
+}
+
+var schemaChangeVerbs = map[string]string{
+	"table_created":      "created table",
+	"table_dropped":      "dropped table",
+	"column_added":       "added column",
+	"column_altered":     "altered column",
+	"column_dropped":     "dropped column",
+	"index_created":      "created index",
+	"index_altered":      "altered index",
+	"index_dropped":      "dropped index",
+	"partition_attached": "attached partition",
+	"partition_detached": "detached partition",
+}
+
+// describeSchemaChange names a change, e.g. "added column public.orders.status"
+func describeSchemaChange(e query_storage.SchemaEvent) string {
+	verb, ok := schemaChangeVerbs[e.Type]
+	if !ok {
+		verb = strings.ReplaceAll(e.Type, "_", " ")
+	}
+	switch {
+	case strings.HasPrefix(e.Type, "column_"):
+		return fmt.Sprintf("%s %s.%s.%s", verb, e.Schema, e.Relation, e.Name)
+	default:
+		return fmt.Sprintf("%s %s.%s", verb, e.Schema, e.Name)
+	}
+}
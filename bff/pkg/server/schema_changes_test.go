@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/events"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaChangesHandler(t *testing.T) {
+	handler := schema_changes_handler(&MockQueryStorage{}, CreateValidator())
+
+	record := httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/schema/changes?dbidentifier=amazon_rds/default_db/us-west-2&start=now-1h&datname=app&relation=orders", nil))
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data []SchemaChangeInfo `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	require.Len(t, response.Data, 3)
+	assert.Equal(t, SchemaChangeInfo{
+		Datname: "app", Type: "column_added", Schema: "public", Relation: "orders", Name: "status",
+		NewDefinition: "text", PreviousCollectedAtMs: 900000, DetectedAtMs: 1000000,
+	}, response.Data[0])
+
+	record = httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/schema/changes?dbidentifier=amazon_rds/default_db/us-west-2", nil))
+	assert.Equal(t, http.StatusBadRequest, record.Code, "start is required")
+}
+
+func TestSchemaAnnotationsHandler(t *testing.T) {
+	handler := schema_annotations_handler(&MockQueryStorage{}, CreateValidator())
+
+	record := httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/schema/annotations?dbidentifier=amazon_rds/default_db/us-west-2&start=now-1h", nil))
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data []events.Event `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	require.Len(t, response.Data, 2)
+
+	first, second := response.Data[0], response.Data[1]
+	assert.Equal(t, SchemaEventSource, first.Source)
+	assert.Equal(t, SchemaEventKind, first.Kind)
+	assert.Equal(t, int64(900000), first.StartsAt)
+	assert.Equal(t, int64(1000000), first.EndsAt)
+	assert.Equal(t, "created table public.customers, added column public.orders.status, created index public.reports_day_idx", first.Title)
+	assert.Equal(t, []string{"column_added", "datname:app", "datname:reporting", "index_created", "table_created"}, first.Tags)
+
+	var details []SchemaChangeInfo
+	require.NoError(t, json.Unmarshal(first.Details, &details))
+	assert.Len(t, details, 3)
+
+	assert.Equal(t, "dropped table public.carts, dropped column public.orders.legacy, altered column public.orders.status and 1 more", second.Title)
+	assert.Equal(t, int64(1100000), second.EndsAt)
+}
@@ -5,11 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"local/bff/pkg/alerting"
+	"local/bff/pkg/anomaly"
+	"local/bff/pkg/events"
+	"local/bff/pkg/instances"
 	"local/bff/pkg/metrics"
 	"local/bff/pkg/middleware"
 	"local/bff/pkg/query_storage"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -25,6 +30,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	_ "github.com/mattn/go-sqlite3"
 	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -42,6 +48,7 @@ type Config struct {
 	AccessKey            string                 `json:"access_key"`
 	ForceBypassAccessKey bool                   `json:"force_bypass_access_key"`
 	DataPath             string                 `json:"data_path"`
+	MetricsPort          string                 `json:"metrics_port"`
 }
 
 type RouteConfig struct {
@@ -55,13 +62,32 @@ type server_imp struct {
 	query_storage   query_storage.QueryStorage
 	config          Config
 	inputValidator  *validator.Validate
+	alerts          *alerting.Engine  // nil when alerting is disabled
+	anomalies       *anomaly.Detector // nil when anomaly detection is disabled
+	events          *events.Store
+	instances       *instances.Store
 }
 
+// InstanceInfo describes a system: what collectors reported about it, as kept
+// in the registry, and what users set on it. Times are unix milliseconds.
 type InstanceInfo struct {
-	DBIdentifier string `json:"dbIdentifier"`
-	SystemID     string `json:"systemId"`
-	SystemScope  string `json:"systemScope"`
-	SystemType   string `json:"systemType"`
+	DBIdentifier     string   `json:"dbIdentifier"`
+	SystemID         string   `json:"systemId"`
+	SystemScope      string   `json:"systemScope"`
+	SystemType       string   `json:"systemType"`
+	DisplayName      string   `json:"displayName,omitempty"`
+	Environment      string   `json:"environment,omitempty"`
+	Team             string   `json:"team,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	State            string   `json:"state,omitempty"`
+	FirstSeenMs      int64    `json:"firstSeenMs,omitempty"`
+	LastSeenMs       int64    `json:"lastSeenMs,omitempty"`
+	PostgresVersion  string   `json:"postgresVersion,omitempty"`
+	Platform         string   `json:"platform,omitempty"`
+	InstanceClass    string   `json:"instanceClass,omitempty"`
+	MemoryBytes      int64    `json:"memoryBytes,omitempty"`
+	LogicalCores     int64    `json:"logicalCores,omitempty"`
+	CollectorVersion string   `json:"collectorVersion,omitempty"`
 }
 
 type ValidationErrorResponse struct {
@@ -74,6 +100,11 @@ const replace_prefix = "$"
 const query_fp_label = "query_fp"
 const query_text_label = "query_text"
 
+// other_query_fp is the value collector-api folds the least active query
+// fingerprints into once a system goes over its cardinality limit. It stands
+// for many queries, so it has no query text.
+const other_query_fp = "__other__"
+
 const (
 	SYSTEM_ID_MAX_LENGTH      = 63
 	AWS_REGION_MAX_LENGTH     = 50
@@ -97,9 +128,9 @@ func CORS(next http.Handler) http.Handler {
 	})
 }
 
-func CreateServer(r map[string]RouteConfig, m metrics.Service, q query_storage.QueryStorage, config Config) Server {
+func CreateServer(r map[string]RouteConfig, m metrics.Service, q query_storage.QueryStorage, config Config, alerts *alerting.Engine, anomalies *anomaly.Detector, events *events.Store, instances *instances.Store) Server {
 
-	return server_imp{m, q, config, CreateValidator()}
+	return server_imp{m, q, config, CreateValidator(), alerts, anomalies, events, instances}
 }
 
 func CreateValidator() *validator.Validate {
@@ -137,13 +168,47 @@ func (s server_imp) Run() error {
 	r.Use(authMiddleware.Authenticate)
 	r.Use(CORS)
 
-	r.Get("/api/v1/activity", activity_handler(s.metrics_service, s.query_storage, s.inputValidator, s.config.TimeDimGuard, s.config.NonTimeDimGuard))
-	r.Get("/api/v1/instance", info_handler(s.metrics_service, s.inputValidator))
+	r.Get("/api/v1/activity", activity_handler(s.metrics_service, s.query_storage, s.events, s.inputValidator, s.config.TimeDimGuard, s.config.NonTimeDimGuard))
+	r.Get("/api/v1/instance", info_handler(s.metrics_service, s.query_storage, s.instances, s.inputValidator))
+	r.Get("/api/v1/fleet", fleet_handler(s.metrics_service, s.query_storage, s.instances))
+	if s.instances != nil {
+		r.Put("/api/v1/instance/metadata", instance_metadata_handler(s.instances, s.inputValidator))
+	}
 	r.Get("/api/v1/instance/database", databases_handler(s.metrics_service, s.inputValidator))
 	r.Get("/api/v1/snapshots", snapshots_handler(s.config.DataPath))
+	r.Get("/api/v1/queries", queries_handler(s.query_storage, s.inputValidator))
+	r.Get("/api/v1/queries/search", queries_search_handler(s.metrics_service, s.query_storage, s.inputValidator))
+	r.Get("/api/v1/tables/health", tables_health_handler(s.metrics_service, s.inputValidator))
+	r.Get("/api/v1/tables/{table}", table_detail_handler(s.metrics_service, s.query_storage, s.inputValidator))
+	r.Get("/api/v1/indexes/advice", index_advice_handler(s.query_storage, s.inputValidator))
+	r.Get("/api/v1/indexes/missing", missing_index_hints_handler(s.metrics_service, s.query_storage, s.inputValidator))
+	r.Get("/api/v1/wraparound", wraparound_handler(s.metrics_service, s.inputValidator))
+	r.Get("/api/v1/settings/advice", settings_advice_handler(s.metrics_service, s.inputValidator))
+	r.Get("/api/v1/settings/changes", setting_changes_handler(s.query_storage, s.inputValidator))
+	r.Get("/api/v1/settings/diff", settings_diff_handler(s.query_storage, s.inputValidator))
+	r.Get("/api/v1/schema/changes", schema_changes_handler(s.query_storage, s.inputValidator))
+	r.Get("/api/v1/schema/annotations", schema_annotations_handler(s.query_storage, s.inputValidator))
+
+	if s.alerts != nil {
+		r.Get("/api/v1/alerts", alerts_handler(s.alerts))
+		r.Get("/api/v1/alerts/rules", alert_rules_handler(s.alerts))
+		r.Get("/api/v1/alerts/silences", silences_handler(s.alerts))
+		r.Post("/api/v1/alerts/silences", create_silence_handler(s.alerts))
+		r.Delete("/api/v1/alerts/silences/{id}", expire_silence_handler(s.alerts))
+	}
+
+	if s.anomalies != nil {
+		r.Get("/api/v1/anomalies", anomalies_handler(s.anomalies, s.inputValidator))
+		r.Get("/api/v1/anomalies/baseline", anomaly_baseline_handler(s.anomalies, s.inputValidator))
+	}
+
+	if s.events != nil {
+		r.Get("/api/v1/events", events_handler(s.events, s.inputValidator))
+		r.Post("/api/v1/events", create_event_handler(s.events, s.inputValidator))
+	}
 
 	r.Route(api_prefix, func(r chi.Router) {
-		r.Mount("/", metrics_handler(s.config.RoutesConfig, s.metrics_service))
+		r.Mount("/", metrics_handler(s.config.RoutesConfig, s.metrics_service, s.events))
 	})
 
 	fs := http.FileServer(http.Dir(s.config.WebappPath))
@@ -157,6 +222,18 @@ func (s server_imp) Run() error {
 		}
 	}))
 
+	// The metrics of the bff itself are served on their own port, which is
+	// not published, so that Prometheus scrapes them without the access key
+	if s.config.MetricsPort != "" {
+		listener, err := net.Listen("tcp", ":"+s.config.MetricsPort)
+		if err != nil {
+			return fmt.Errorf("listen on metrics port: %w", err)
+		}
+		go func() {
+			log.Printf("Error serving metrics: %v", http.Serve(listener, promhttp.Handler()))
+		}()
+	}
+
 	return http.ListenAndServe(":"+s.config.Port, r)
 }
 
@@ -199,7 +276,7 @@ func splitDbIdentifier(dbIdentifier string) (string, string, string, error) {
 	return systemType, systemID, systemScope, nil
 }
 
-func metrics_handler(route_configs map[string]RouteConfig, metrics_service metrics.Service) http.Handler {
+func metrics_handler(route_configs map[string]RouteConfig, metrics_service metrics.Service, eventStore *events.Store) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		route := strings.TrimPrefix(r.URL.Path, api_prefix)
@@ -236,6 +313,7 @@ func metrics_handler(route_configs map[string]RouteConfig, metrics_service metri
 			"end":   strconv.FormatInt(endTime.UnixMilli(), 10),
 		}
 		metrics := make(map[string]string)
+		var dbIdentifier string
 
 		if route_config, ok := route_configs[route]; ok {
 			for _, param := range route_config.Params {
@@ -258,6 +336,7 @@ func metrics_handler(route_configs map[string]RouteConfig, metrics_service metri
 						}
 						all_params = []string{param, "sys_type", "sys_id", "sys_scope"}
 						all_values = []string{value, systemType, systemID, systemScope}
+						dbIdentifier = value
 					}
 					for metric, query := range route_config.Metrics {
 						var current_query string
@@ -337,7 +416,11 @@ func metrics_handler(route_configs map[string]RouteConfig, metrics_service metri
 			}
 
 			currentTime := time.Now().UnixNano() / int64(time.Millisecond)
-			wrappedJSON, err := WrapJSON(js, map[string]interface{}{"server_now": currentTime})
+			metadata := map[string]interface{}{"server_now": currentTime}
+			if dbIdentifier != "" {
+				metadata["events"] = windowEvents(eventStore, dbIdentifier, startTime, endTime)
+			}
+			wrappedJSON, err := WrapJSON(js, metadata)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -445,7 +528,7 @@ func extractPromQLInput(params ActivityParams, now time.Time) (PromQLInput, erro
 	}, nil
 }
 
-func activity_handler(metrics_service metrics.Service, query_storage query_storage.QueryStorage, validate *validator.Validate, timeDimGuard int, nonTimeDimGuard int) http.HandlerFunc {
+func activity_handler(metrics_service metrics.Service, query_storage query_storage.QueryStorage, eventStore *events.Store, validate *validator.Validate, timeDimGuard int, nonTimeDimGuard int) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		params := ActivityParams{
@@ -532,15 +615,17 @@ func activity_handler(metrics_service metrics.Service, query_storage query_stora
 		}
 
 		// populate query_fp with query text
-		for _, result := range results {
-			switch metric := result["metric"].(type) {
-			case map[string]interface{}:
-				result["metric"] = handleQueryFP(metric, query_storage)
-			case map[string]string:
-				result["metric"] = handleQueryFP(metric, query_storage)
-			default:
-				log.Printf("Metric not found or unsupported type: %T", metric)
-			}
+		system, err := parseSystemRef(params.DbIdentifier, validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Without text the activity is still worth showing, so a failed
+		// lookup is only reported alongside it
+		missingQueryFPs, queryTextErr := populateQueryText(results, system, query_storage)
+		if queryTextErr != nil {
+			log.Printf("Error fetching query text: %v", queryTextErr)
+			missingQueryFPs = []string{}
 		}
 
 		js, err := json.Marshal(results)
@@ -550,7 +635,15 @@ func activity_handler(metrics_service metrics.Service, query_storage query_stora
 		}
 
 		currentTime := now.UnixMilli()
-		wrappedJSON, err := WrapJSON(js, map[string]interface{}{"server_now": currentTime})
+		metadata := map[string]interface{}{
+			"server_now":        currentTime,
+			"missing_query_fps": missingQueryFPs,
+			"events":            windowEvents(eventStore, params.DbIdentifier, promQLInput.Start, promQLInput.End),
+		}
+		if queryTextErr != nil {
+			metadata["query_text_error"] = queryTextErr.Error()
+		}
+		wrappedJSON, err := WrapJSON(js, metadata)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -562,37 +655,65 @@ func activity_handler(metrics_service metrics.Service, query_storage query_stora
 	})
 }
 
-func handleQueryFP(metric interface{}, query_storage query_storage.QueryStorage) interface{} {
-	var queryFP string
-	var ok bool
-
-	switch m := metric.(type) {
-	case map[string]interface{}:
-		queryFP, ok = m[query_fp_label].(string)
-	case map[string]string:
-		queryFP, ok = m[query_fp_label]
-	default:
-		return metric
+// populateQueryText sets the query_text label of every result carrying a
+// query_fp, looking all fingerprints up in one batch. Fingerprints whose text
+// is unknown are left without query_text and returned, sorted.
+func populateQueryText(results []map[string]interface{}, system query_storage.SystemRef, query_storage query_storage.QueryStorage) ([]string, error) {
+	var fingerprints []string
+	for _, result := range results {
+		if queryFP, ok := metricQueryFP(result["metric"]); ok && queryFP != other_query_fp {
+			fingerprints = append(fingerprints, queryFP)
+		}
 	}
 
-	if !ok {
-		return metric
+	missing := []string{}
+	if len(fingerprints) == 0 {
+		return missing, nil
 	}
 
-	queryText, err := query_storage.GetQuery(queryFP)
+	texts, err := query_storage.GetQueries(system, fingerprints)
 	if err != nil {
-		queryText = "<not found>"
-		log.Printf("Error fetching query text: %s", err)
+		return nil, err
+	}
+
+	missingSet := make(map[string]bool)
+	for _, result := range results {
+		queryFP, ok := metricQueryFP(result["metric"])
+		if !ok || queryFP == other_query_fp {
+			continue
+		}
+
+		queryText, found := texts[queryFP]
+		if !found {
+			missingSet[queryFP] = true
+			continue
+		}
+
+		switch m := result["metric"].(type) {
+		case map[string]interface{}:
+			m[query_text_label] = queryText
+		case map[string]string:
+			m[query_text_label] = queryText
+		}
 	}
 
+	for queryFP := range missingSet {
+		missing = append(missing, queryFP)
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+func metricQueryFP(metric interface{}) (string, bool) {
 	switch m := metric.(type) {
 	case map[string]interface{}:
-		m[query_text_label] = queryText
+		queryFP, ok := m[query_fp_label].(string)
+		return queryFP, ok
 	case map[string]string:
-		m[query_text_label] = queryText
+		queryFP, ok := m[query_fp_label]
+		return queryFP, ok
 	}
-
-	return metric
+	return "", false
 }
 
 func databases_handler(metrics_service metrics.Service, validate *validator.Validate) http.HandlerFunc {
@@ -656,49 +777,6 @@ func extractDatabaseNames(results []map[string]interface{}) []string {
 	return dbNames
 }
 
-func info_handler(metrics_service metrics.Service, _ *validator.Validate) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		query := `count(cc_pg_stat_activity) by (sys_id, sys_scope, sys_scope_fallback,sys_type)`
-		now := time.Now().UnixMilli()
-		options := map[string]string{
-			"start": strconv.FormatInt(now-15*60*1000, 10), // 15 minutes ago
-			"end":   strconv.FormatInt(now, 10),            // now
-		}
-
-		result, err := metrics_service.ExecuteRaw(query, options)
-		if err != nil {
-			http.Error(w, "Error querying list of instances.", http.StatusInternalServerError)
-			return
-		}
-
-		instanceInfoMap := make(map[string]InstanceInfo)
-
-		for _, sample := range result {
-			sysID := getValue(sample, "sys_id")
-			sysScope := getValue(sample, "sys_scope")
-			sysType := getValue(sample, "sys_type")
-
-			dbIdentifier := sysType + "/" + sysID + "/" + sysScope
-
-			if _, exists := instanceInfoMap[dbIdentifier]; !exists {
-				instanceInfoMap[dbIdentifier] = InstanceInfo{
-					DBIdentifier: dbIdentifier,
-					SystemID:     sysID,
-					SystemScope:  sysScope,
-					SystemType:   sysType,
-				}
-			}
-		}
-
-		instanceInfos := []InstanceInfo{}
-		for _, info := range instanceInfoMap {
-			instanceInfos = append(instanceInfos, info)
-		}
-
-		info_handler_internal(instanceInfos).ServeHTTP(w, r)
-	})
-}
-
 func getValue(sample map[string]interface{}, key string) string {
 	if mapValue, ok := sample["metric"].(map[string]interface{}); ok {
 		if value, ok := mapValue[key].(string); ok && value != "" {
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -11,24 +12,16 @@ import (
 	"strconv"
 	"time"
 
+	"local/bff/pkg/metrics/metricstest"
+	"local/bff/pkg/query_storage"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
-type MockMetricsService struct {
-	mock.Mock
-}
-
-func (m *MockMetricsService) Execute(metrics map[string]string, options map[string]string) (map[int64]map[string]float64, error) {
-	args := m.Called(metrics, options)
-	return args.Get(0).(map[int64]map[string]float64), args.Error(1)
-}
-
-func (m *MockMetricsService) ExecuteRaw(query string, options map[string]string) ([]map[string]interface{}, error) {
-	args := m.Called(query, options)
-	return args.Get(0).([]map[string]interface{}), args.Error(1)
-}
+type MockMetricsService = metricstest.MockService
 
 type MockQueryStorage struct{}
 
@@ -40,6 +33,99 @@ func (m *MockQueryStorage) GetFullQuery(queryFP string) (string, error) {
 	return "SELECT * FROM table WHERE id = 1", nil
 }
 
+func (m *MockQueryStorage) GetQueries(system query_storage.SystemRef, queryFPs []string) (map[string]string, error) {
+	texts := make(map[string]string)
+	for _, queryFP := range queryFPs {
+		if queryFP == "failing" {
+			return nil, errors.New("database is locked")
+		}
+		if queryFP != "unknown" {
+			texts[queryFP] = "SELECT * FROM table WHERE id = $1"
+		}
+	}
+	return texts, nil
+}
+
+func (m *MockQueryStorage) ListQueries(system query_storage.SystemRef, start, end int64) ([]query_storage.SystemQuery, error) {
+	return []query_storage.SystemQuery{
+		{Fingerprint: "fp1", Query: "SELECT * FROM table WHERE id = $1", FirstSeen: start, LastSeen: end, InOrderSightings: 3},
+	}, nil
+}
+
+func (m *MockQueryStorage) ListNewQueries(system query_storage.SystemRef, since int64) ([]query_storage.SystemQuery, error) {
+	return []query_storage.SystemQuery{
+		{Fingerprint: "fp2", Query: "UPDATE table SET x = $1", FirstSeen: since + 10, LastSeen: since + 20, InOrderSightings: 1},
+		{Fingerprint: "fp3", Query: "DELETE FROM table", FirstSeen: since + 1000000, LastSeen: since + 1000000, InOrderSightings: 1},
+	}, nil
+}
+
+func (m *MockQueryStorage) SearchQueries(system query_storage.SystemRef, text string, start, end int64, limit int) ([]query_storage.SystemQuery, error) {
+	return []query_storage.SystemQuery{
+		{Fingerprint: "fp1", Query: "SELECT * FROM users WHERE id = $1", FirstSeen: 1000, LastSeen: 2000, InOrderSightings: 5},
+		{Fingerprint: "fp+2/", Query: "UPDATE users SET name = $1", FirstSeen: 1500, LastSeen: 1900, InOrderSightings: 2},
+	}, nil
+}
+
+func (m *MockQueryStorage) ListIndexes(system query_storage.SystemRef) ([]query_storage.SystemIndex, error) {
+	return []query_storage.SystemIndex{
+		{
+			Database: "app", Schema: "public", Relation: "orders", Name: "orders_status_idx", Type: "btree",
+			Definition: "CREATE INDEX orders_status_idx ON public.orders USING btree (status)", IsValid: true,
+			SizeBytes: 8192, ScansChangedAt: 1000, FirstSeen: 1000,
+		},
+	}, nil
+}
+
+func (m *MockQueryStorage) ListSettings(system query_storage.SystemRef) ([]query_storage.SystemSetting, error) {
+	settings := []query_storage.SystemSetting{
+		{Name: "jit", Value: "on", Source: "default", CollectedAt: 1000},
+		{Name: "work_mem", Value: "4096", Unit: "kB", Source: "default", CollectedAt: 1000},
+	}
+	if system.SystemID == "replica_db" {
+		settings[1].Value, settings[1].Source, settings[1].CollectedAt = "65536", "configuration file", 1200
+		settings = append(settings, query_storage.SystemSetting{Name: "hot_standby", Value: "on", Source: "configuration file", CollectedAt: 1200})
+	}
+	return settings, nil
+}
+
+func (m *MockQueryStorage) ListSettingChanges(system query_storage.SystemRef, start, end int64) ([]query_storage.SettingChange, error) {
+	return []query_storage.SettingChange{
+		{Name: "jit", ChangeType: "added", NewValue: "on", NewSource: "default", PreviousCollectedAt: 900, ChangedAt: 1000},
+		{Name: "work_mem", ChangeType: "changed", Unit: "kB", OldValue: "4096", NewValue: "65536", OldSource: "default", NewSource: "configuration file", PreviousCollectedAt: 900, ChangedAt: 1000},
+	}, nil
+}
+
+func (m *MockQueryStorage) ListSchemaEvents(system query_storage.SystemRef, start, end int64) ([]query_storage.SchemaEvent, error) {
+	return []query_storage.SchemaEvent{
+		{Database: "app", Type: "table_created", Schema: "public", Relation: "customers", Name: "customers", PreviousCollectedAt: 900, DetectedAt: 1000},
+		{Database: "app", Type: "column_added", Schema: "public", Relation: "orders", Name: "status", NewDefinition: "text", PreviousCollectedAt: 900, DetectedAt: 1000},
+		{Database: "reporting", Type: "index_created", Schema: "public", Relation: "reports", Name: "reports_day_idx",
+			NewDefinition: "CREATE INDEX reports_day_idx ON public.reports USING btree (day)", PreviousCollectedAt: 900, DetectedAt: 1000},
+		{Database: "app", Type: "table_dropped", Schema: "public", Relation: "carts", Name: "carts", PreviousCollectedAt: 1000, DetectedAt: 1100},
+		{Database: "app", Type: "column_dropped", Schema: "public", Relation: "orders", Name: "legacy", OldDefinition: "text", PreviousCollectedAt: 1000, DetectedAt: 1100},
+		{Database: "app", Type: "column_altered", Schema: "public", Relation: "orders", Name: "status", OldDefinition: "text", NewDefinition: "text NOT NULL", PreviousCollectedAt: 1000, DetectedAt: 1100},
+		{Database: "app", Type: "partition_attached", Schema: "public", Relation: "events_2024", Name: "events_2024", PreviousCollectedAt: 1000, DetectedAt: 1100},
+	}, nil
+}
+
+// ListInstances returns a system that reported five minutes ago and one that
+// stopped reporting two hours ago
+func (m *MockQueryStorage) ListInstances() ([]query_storage.SystemInstance, error) {
+	now := time.Now().Unix()
+	return []query_storage.SystemInstance{
+		{
+			System:    query_storage.SystemRef{SystemType: "amazon_rds", SystemID: "orders", SystemScope: "us-west-2"},
+			FirstSeen: now - 86400, LastSeen: now - 300, PostgresVersion: "16.3", Platform: "amazon_rds",
+			InstanceClass: "db.t4g.medium", MemoryBytes: 4121206784, LogicalCores: 2,
+			CollectorVersion: "pganalyze-collector 0.58.0", FactsCollectedAt: now - 300,
+		},
+		{
+			System:    query_storage.SystemRef{SystemType: "amazon_rds", SystemID: "legacy", SystemScope: "us-west-2"},
+			FirstSeen: now - 86400, LastSeen: now - 7200,
+		},
+	}, nil
+}
+
 func TestEndpointsGeneration(t *testing.T) {
 	mockMetricsService := new(MockMetricsService)
 	mockMetricsService.On("Execute", mock.Anything, mock.Anything).Return(
@@ -65,7 +151,7 @@ func TestEndpointsGeneration(t *testing.T) {
 		},
 	}
 
-	handler := metrics_handler(routesConfig, mockMetricsService)
+	handler := metrics_handler(routesConfig, mockMetricsService, nil)
 
 	// TEST configured route exists
 	record := httptest.NewRecorder()
@@ -114,7 +200,7 @@ func TestParamsPopulation(t *testing.T) {
 		return true
 	})).Return(map[int64]map[string]float64{}, nil)
 
-	handler := metrics_handler(routesConfig, mockMetricsService)
+	handler := metrics_handler(routesConfig, mockMetricsService, nil)
 
 	// TEST params population
 	record := httptest.NewRecorder()
@@ -153,7 +239,7 @@ func TestMissingParams(t *testing.T) {
 		},
 		nil,
 	)
-	handler := metrics_handler(routesConfig, mockMetricsService)
+	handler := metrics_handler(routesConfig, mockMetricsService, nil)
 
 	record := httptest.NewRecorder()
 
@@ -188,7 +274,7 @@ func TestMetricsHandlerJSONFormat(t *testing.T) {
 		},
 	}
 
-	handler := metrics_handler(routesConfig, mockMetricsService)
+	handler := metrics_handler(routesConfig, mockMetricsService, nil)
 
 	record := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics?start=now&dbidentifier=amazon_rds/default_db/us-west-2/abcdefghijkl", nil)
@@ -225,7 +311,7 @@ func TestMissingParameters(t *testing.T) {
 
 	validate := CreateValidator()
 	mockQueryStorage := &MockQueryStorage{}
-	handler := activity_handler(mockService, mockQueryStorage, validate, 24, 24)
+	handler := activity_handler(mockService, mockQueryStorage, nil, validate, 24, 24)
 
 	defaultParams := map[string]string{
 		"dbidentifier":  "test",
@@ -268,7 +354,7 @@ func TestActivityValidationLogic(t *testing.T) {
 
 	mockQueryStorage := &MockQueryStorage{}
 	validate := CreateValidator()
-	handler := activity_handler(mockService, mockQueryStorage, validate, 24, 24)
+	handler := activity_handler(mockService, mockQueryStorage, nil, validate, 24, 24)
 
 	tests := []struct {
 		name           string
@@ -405,7 +491,7 @@ func TestOptions(t *testing.T) {
 
 	validate := CreateValidator()
 
-	handler := activity_handler(mockService, mockQueryStorage, validate, 24, 24)
+	handler := activity_handler(mockService, mockQueryStorage, nil, validate, 24, 24)
 
 	expectedOptions := map[string]string{
 		"start": "10",
@@ -455,7 +541,7 @@ func TestDefaultDBIdentifier(t *testing.T) {
 	// Create a request without the dbidentifier parameter
 	record := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/api/v1/test?start=now&dbidentifier=amazon_rds/default_db/us-west-2/abcdefghijkl", nil)
-	handler := metrics_handler(routeConfigs, mockService)
+	handler := metrics_handler(routeConfigs, mockService, nil)
 	handler.ServeHTTP(record, req)
 
 	mockService.AssertExpectations(t)
@@ -961,3 +1047,80 @@ func TestExtractPromQLInput(t *testing.T) {
 		})
 	}
 }
+
+func TestActivityReportsMissingQueryText(t *testing.T) {
+	mockService := new(MockMetricsService)
+	handler := activity_handler(mockService, &MockQueryStorage{}, nil, CreateValidator(), 24, 24)
+
+	mockService.On("ExecuteRaw", mock.Anything, mock.Anything).Return([]map[string]interface{}{
+		{"metric": map[string]interface{}{"query_fp": "fp1"}},
+		{"metric": map[string]interface{}{"query_fp": "unknown"}},
+		{"metric": map[string]interface{}{"query_fp": "unknown"}},
+		{"metric": map[string]interface{}{"query_fp": "__other__"}},
+	}, nil)
+
+	params := map[string]string{
+		"dbidentifier":  "amazon_rds/testdb/us-west-2/abcdefghijkl",
+		"database_list": "postgres",
+		"start":         "10",
+		"end":           "20",
+		"step":          "5000ms",
+		"legend":        "query_fp",
+		"dim":           "query_fp",
+	}
+
+	record := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/activity?"+formatQueryParams(params), nil)
+	handler.ServeHTTP(record, req)
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data            []map[string]map[string]string `json:"data"`
+		MissingQueryFPs []string                       `json:"missing_query_fps"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+
+	assert.Equal(t, []string{"unknown"}, response.MissingQueryFPs)
+	assert.Equal(t, "SELECT * FROM table WHERE id = $1", response.Data[0]["metric"]["query_text"])
+	_, hasText := response.Data[1]["metric"]["query_text"]
+	assert.False(t, hasText)
+	_, hasText = response.Data[3]["metric"]["query_text"]
+	assert.False(t, hasText, "the bucket of folded fingerprints is not looked up")
+}
+
+func TestActivityReportsQueryTextErrors(t *testing.T) {
+	mockService := new(MockMetricsService)
+	handler := activity_handler(mockService, &MockQueryStorage{}, nil, CreateValidator(), 24, 24)
+
+	mockService.On("ExecuteRaw", mock.Anything, mock.Anything).Return([]map[string]interface{}{
+		{"metric": map[string]interface{}{"query_fp": "failing"}},
+	}, nil)
+
+	params := map[string]string{
+		"dbidentifier":  "amazon_rds/testdb/us-west-2/abcdefghijkl",
+		"database_list": "postgres",
+		"start":         "10",
+		"end":           "20",
+		"step":          "5000ms",
+		"legend":        "query_fp",
+		"dim":           "query_fp",
+	}
+
+	record := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/activity?"+formatQueryParams(params), nil)
+	handler.ServeHTTP(record, req)
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data            []map[string]map[string]string `json:"data"`
+		MissingQueryFPs []string                       `json:"missing_query_fps"`
+		QueryTextError  string                         `json:"query_text_error"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	assert.Equal(t, "database is locked", response.QueryTextError)
+	assert.Empty(t, response.MissingQueryFPs)
+	require.Len(t, response.Data, 1)
+	assert.Equal(t, "failing", response.Data[0]["metric"]["query_fp"])
+	_, hasText := response.Data[0]["metric"]["query_text"]
+	assert.False(t, hasText)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"local/bff/pkg/advisor"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// settings_advice_handler checks the settings of an instance against the
+// memory, cores and disks of its host, and suggests values for the settings
+// that look wrong
+func settings_advice_handler(metrics_service metrics.Service, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		end := now
+		if value := r.URL.Query().Get("end"); value != "" {
+			end, err = parseTimeParameter(value, now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		settings, host, err := querySettings(metrics_service, system, end)
+		if err != nil {
+			http.Error(w, "Error querying settings: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, advisor.AdviseSettings(settings, host), now)
+	})
+}
+
+// querySettings fetches the settings of system at the given time and the host
+// facts of the same snapshot, in a single query
+func querySettings(metrics_service metrics.Service, system query_storage.SystemRef, at time.Time) (map[string]advisor.Setting, advisor.Host, error) {
+	selector := fmt.Sprintf(`sys_id="%s",sys_scope="%s",sys_type="%s"`, system.SystemID, system.SystemScope, system.SystemType)
+	samples, err := metrics_service.ExecuteRaw(fmt.Sprintf(
+		`label_replace(cc_pgsetting_current_value{%[1]s}, "stat", "setting", "", "")`+
+			` or label_replace(cc_system_memory_total_bytes{%[1]s}, "stat", "memory_bytes", "", "")`+
+			` or label_replace(cc_system_cpu_logical_cores{%[1]s}, "stat", "logical_cores", "", "")`+
+			` or label_replace(cc_system_diskinfo_provisioned_iops{%[1]s}, "stat", "disk", "", "")`,
+		selector), instantOptions(at, "name"))
+	if err != nil {
+		return nil, advisor.Host{}, err
+	}
+
+	settings := make(map[string]advisor.Setting)
+	host := advisor.Host{DiskTypes: []string{}}
+	diskTypes := make(map[string]bool)
+	for _, sample := range samples {
+		value, ok := sampleValue(sample)
+		if !ok {
+			continue
+		}
+		switch getValue(sample, "stat") {
+		case "setting":
+			settings[getValue(sample, "name")] = advisor.Setting{Value: value, Unit: getValue(sample, "unit")}
+		case "memory_bytes":
+			host.MemoryBytes = value
+		case "logical_cores":
+			host.LogicalCores = value
+		case "disk":
+			if diskType := getValue(sample, "disk_type"); diskType != "" && !diskTypes[diskType] {
+				diskTypes[diskType] = true
+				host.DiskTypes = append(host.DiskTypes, diskType)
+			}
+		}
+	}
+	sort.Strings(host.DiskTypes)
+	return settings, host, nil
+}
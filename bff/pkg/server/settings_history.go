@@ -0,0 +1,172 @@
+package server
+
+import (
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// SettingChangeInfo is a setting change detected between two consecutive full
+// snapshots of an instance
+type SettingChangeInfo struct {
+	Name                  string `json:"name"`
+	ChangeType            string `json:"change_type"`
+	Unit                  string `json:"unit,omitempty"`
+	OldValue              string `json:"old_value,omitempty"`
+	NewValue              string `json:"new_value,omitempty"`
+	OldSource             string `json:"old_source,omitempty"`
+	NewSource             string `json:"new_source,omitempty"`
+	PreviousCollectedAtMs int64  `json:"previous_collected_at_ms"`
+	ChangedAtMs           int64  `json:"changed_at_ms"`
+}
+
+// SettingDiff is a setting whose value differs between two instances. A
+// setting missing from one of them has a nil value there.
+type SettingDiff struct {
+	Name          string  `json:"name"`
+	Unit          string  `json:"unit,omitempty"`
+	Value         *string `json:"value"`
+	CompareValue  *string `json:"compare_value"`
+	Source        string  `json:"source,omitempty"`
+	CompareSource string  `json:"compare_source,omitempty"`
+}
+
+// SettingsComparison lists the differing settings of two instances, with the
+// time of the snapshot each side was taken from
+type SettingsComparison struct {
+	CollectedAtMs        int64         `json:"collected_at_ms"`
+	CompareCollectedAtMs int64         `json:"compare_collected_at_ms"`
+	Differences          []SettingDiff `json:"differences"`
+}
+
+// setting_changes_handler lists the setting changes of an instance within a
+// time window, oldest first. The name parameter narrows it to one setting.
+func setting_changes_handler(queryStore query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		startTime, endTime, err := parseTimeWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stored, err := queryStore.ListSettingChanges(system, startTime.Unix(), endTime.Unix())
+		if err != nil {
+			http.Error(w, "Error listing setting changes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		changes := make([]SettingChangeInfo, 0, len(stored))
+		for _, c := range stored {
+			if name != "" && c.Name != name {
+				continue
+			}
+			changes = append(changes, SettingChangeInfo{
+				Name:                  c.Name,
+				ChangeType:            c.ChangeType,
+				Unit:                  c.Unit,
+				OldValue:              c.OldValue,
+				NewValue:              c.NewValue,
+				OldSource:             c.OldSource,
+				NewSource:             c.NewSource,
+				PreviousCollectedAtMs: c.PreviousCollectedAt * 1000,
+				ChangedAtMs:           c.ChangedAt * 1000,
+			})
+		}
+
+		writeJSON(w, http.StatusOK, changes, now)
+	})
+}
+
+// settings_diff_handler compares the latest settings of the instance in
+// dbidentifier with those of the instance in compare_to
+func settings_diff_handler(queryStore query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		other, err := parseSystemRef(r.URL.Query().Get("compare_to"), validate)
+		if err != nil {
+			http.Error(w, "The 'compare_to' parameter is required and must be a valid dbidentifier.", http.StatusBadRequest)
+			return
+		}
+
+		settings, err := queryStore.ListSettings(system)
+		if err != nil {
+			http.Error(w, "Error listing settings: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		otherSettings, err := queryStore.ListSettings(other)
+		if err != nil {
+			http.Error(w, "Error listing settings: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, compareSettings(settings, otherSettings), time.Now())
+	})
+}
+
+// compareSettings diffs two instances' settings by raw value. Values in
+// different units are always reported.
+func compareSettings(settings, other []query_storage.SystemSetting) SettingsComparison {
+	comparison := SettingsComparison{Differences: []SettingDiff{}}
+
+	byName := make(map[string]query_storage.SystemSetting, len(settings))
+	for _, s := range settings {
+		byName[s.Name] = s
+		comparison.CollectedAtMs = max(comparison.CollectedAtMs, s.CollectedAt*1000)
+	}
+	otherByName := make(map[string]query_storage.SystemSetting, len(other))
+	for _, s := range other {
+		otherByName[s.Name] = s
+		comparison.CompareCollectedAtMs = max(comparison.CompareCollectedAtMs, s.CollectedAt*1000)
+	}
+
+	for _, s := range settings {
+		o, ok := otherByName[s.Name]
+		switch {
+		case !ok:
+			comparison.Differences = append(comparison.Differences, SettingDiff{
+				Name: s.Name, Unit: s.Unit, Value: &s.Value, Source: s.Source,
+			})
+		case s.Value != o.Value || s.Unit != o.Unit:
+			diff := SettingDiff{Name: s.Name, Unit: s.Unit, Value: &s.Value, CompareValue: &o.Value, Source: s.Source, CompareSource: o.Source}
+			if s.Unit != o.Unit {
+				value, compareValue := withUnit(s.Value, s.Unit), withUnit(o.Value, o.Unit)
+				diff.Unit, diff.Value, diff.CompareValue = "", &value, &compareValue
+			}
+			comparison.Differences = append(comparison.Differences, diff)
+		}
+	}
+	for _, o := range other {
+		if _, ok := byName[o.Name]; !ok {
+			comparison.Differences = append(comparison.Differences, SettingDiff{
+				Name: o.Name, Unit: o.Unit, CompareValue: &o.Value, CompareSource: o.Source,
+			})
+		}
+	}
+
+	sort.Slice(comparison.Differences, func(i, j int) bool {
+		return comparison.Differences[i].Name < comparison.Differences[j].Name
+	})
+	return comparison
+}
+
+func withUnit(value, unit string) string {
+	if unit == "" {
+		return value
+	}
+	return value + " " + unit
+}
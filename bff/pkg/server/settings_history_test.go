@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingChangesHandler(t *testing.T) {
+	handler := setting_changes_handler(&MockQueryStorage{}, CreateValidator())
+
+	record := httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/settings/changes?dbidentifier=amazon_rds/default_db/us-west-2&start=now-1h&name=work_mem", nil))
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data []SettingChangeInfo `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	assert.Equal(t, []SettingChangeInfo{{
+		Name: "work_mem", ChangeType: "changed", Unit: "kB", OldValue: "4096", NewValue: "65536",
+		OldSource: "default", NewSource: "configuration file", PreviousCollectedAtMs: 900000, ChangedAtMs: 1000000,
+	}}, response.Data)
+
+	record = httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/settings/changes?dbidentifier=amazon_rds/default_db/us-west-2", nil))
+	assert.Equal(t, http.StatusBadRequest, record.Code, "start is required")
+}
+
+func TestSettingsDiffHandler(t *testing.T) {
+	handler := settings_diff_handler(&MockQueryStorage{}, CreateValidator())
+
+	record := httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/settings/diff?dbidentifier=amazon_rds/default_db/us-west-2&compare_to=amazon_rds/replica_db/us-west-2", nil))
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data SettingsComparison `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	assert.Equal(t, int64(1000000), response.Data.CollectedAtMs)
+	assert.Equal(t, int64(1200000), response.Data.CompareCollectedAtMs)
+
+	on, defaultWorkMem, replicaWorkMem := "on", "4096", "65536"
+	assert.Equal(t, []SettingDiff{
+		{Name: "hot_standby", CompareValue: &on, CompareSource: "configuration file"},
+		{Name: "work_mem", Unit: "kB", Value: &defaultWorkMem, CompareValue: &replicaWorkMem, Source: "default", CompareSource: "configuration file"},
+	}, response.Data.Differences)
+
+	record = httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/settings/diff?dbidentifier=amazon_rds/default_db/us-west-2", nil))
+	assert.Equal(t, http.StatusBadRequest, record.Code)
+}
+
+func TestCompareSettingsUnits(t *testing.T) {
+	comparison := compareSettings(
+		[]query_storage.SystemSetting{{Name: "shared_buffers", Value: "16384", Unit: "8kB"}, {Name: "jit", Value: "on"}},
+		[]query_storage.SystemSetting{{Name: "shared_buffers", Value: "128", Unit: "MB"}, {Name: "jit", Value: "on"}},
+	)
+	require.Len(t, comparison.Differences, 1)
+	diff := comparison.Differences[0]
+	assert.Equal(t, "", diff.Unit)
+	assert.Equal(t, "16384 8kB", *diff.Value)
+	assert.Equal(t, "128 MB", *diff.CompareValue)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"local/bff/pkg/advisor"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsAdviceHandler(t *testing.T) {
+	dbIdentifier := "amazon_rds/default_db/us-west-2"
+
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, `cc_pgsetting_current_value{sys_id="default_db",sys_scope="us-west-2",sys_type="amazon_rds"}`) &&
+			strings.Contains(query, "cc_system_cpu_logical_cores")
+	}), map[string]string{"start": "2000000", "end": "2000000", "dim": "name"}).Return([]map[string]interface{}{
+		statSample("setting", map[string]interface{}{"name": "shared_buffers", "unit": "8kB"}, 16384),
+		statSample("setting", map[string]interface{}{"name": "random_page_cost"}, 4),
+		statSample("memory_bytes", nil, 16<<30),
+		statSample("logical_cores", nil, 4),
+		statSample("disk", map[string]interface{}{"disk_idx": "0", "disk_type": "gp3"}, 3000),
+		statSample("disk", map[string]interface{}{"disk_idx": "1", "disk_type": "gp3"}, 3000),
+	}, nil)
+
+	handler := settings_advice_handler(mockService, CreateValidator())
+
+	record := httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/settings/advice?dbidentifier="+dbIdentifier+"&end=2000000", nil))
+	require.Equal(t, http.StatusOK, record.Code)
+
+	var response struct {
+		Data advisor.SettingsAdvice `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	assert.Equal(t, advisor.Host{MemoryBytes: 16 << 30, LogicalCores: 4, DiskTypes: []string{"gp3"}}, response.Data.Host)
+
+	var settings []string
+	for _, f := range response.Data.Findings {
+		settings = append(settings, f.Setting)
+	}
+	assert.Equal(t, []string{"random_page_cost", "shared_buffers"}, settings)
+
+	record = httptest.NewRecorder()
+	handler.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/settings/advice?dbidentifier="+dbIdentifier+"&end=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, record.Code)
+
+	failing := new(MockMetricsService)
+	failing.On("ExecuteRaw", mock.Anything, mock.Anything).Return([]map[string]interface{}{}, errors.New("prometheus unavailable"))
+	record = httptest.NewRecorder()
+	settings_advice_handler(failing, CreateValidator()).ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/settings/advice?dbidentifier="+dbIdentifier, nil))
+	assert.Equal(t, http.StatusInternalServerError, record.Code)
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"fmt"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// tableDetailPoints is the number of points a table's series get by
+	// default, whatever the window
+	tableDetailPoints     = 120
+	minTableDetailStep    = 5 * time.Minute
+	maxTableDetailSamples = 11000
+	// minTableRateWindow spans at least two full snapshots, so that rates
+	// and last values can be computed at every step
+	minTableRateWindow = 30 * time.Minute
+)
+
+// TableSample holds the state and activity of a table at one step. Rates are
+// per second over the step, or over minTableRateWindow when the step is
+// shorter; last_* are the times of the last maintenance runs in unix
+// milliseconds. Metrics that were not reported are null.
+type TableSample struct {
+	TimeMs            int64    `json:"time_ms"`
+	SizeBytes         *float64 `json:"size_bytes"`
+	ToastSizeBytes    *float64 `json:"toast_size_bytes"`
+	LiveTuples        *float64 `json:"n_live_tup"`
+	DeadTuples        *float64 `json:"n_dead_tup"`
+	InsertsPerSecond  *float64 `json:"inserts_per_second"`
+	UpdatesPerSecond  *float64 `json:"updates_per_second"`
+	DeletesPerSecond  *float64 `json:"deletes_per_second"`
+	SeqScansPerSecond *float64 `json:"seq_scans_per_second"`
+	IdxScansPerSecond *float64 `json:"idx_scans_per_second"`
+	CacheHitRatio     *float64 `json:"cache_hit_ratio"`
+	LastVacuumMs      *float64 `json:"last_vacuum_ms"`
+	LastAutovacuumMs  *float64 `json:"last_autovacuum_ms"`
+	LastAnalyzeMs     *float64 `json:"last_analyze_ms"`
+	LastAutoanalyzeMs *float64 `json:"last_autoanalyze_ms"`
+}
+
+func (s *TableSample) field(stat string) **float64 {
+	switch stat {
+	case "size_bytes":
+		return &s.SizeBytes
+	case "toast_size_bytes":
+		return &s.ToastSizeBytes
+	case "n_live_tup":
+		return &s.LiveTuples
+	case "n_dead_tup":
+		return &s.DeadTuples
+	case "inserts_per_second":
+		return &s.InsertsPerSecond
+	case "updates_per_second":
+		return &s.UpdatesPerSecond
+	case "deletes_per_second":
+		return &s.DeletesPerSecond
+	case "seq_scans_per_second":
+		return &s.SeqScansPerSecond
+	case "idx_scans_per_second":
+		return &s.IdxScansPerSecond
+	case "cache_hit_ratio":
+		return &s.CacheHitRatio
+	case "last_vacuum_ms":
+		return &s.LastVacuumMs
+	case "last_autovacuum_ms":
+		return &s.LastAutovacuumMs
+	case "last_analyze_ms":
+		return &s.LastAnalyzeMs
+	case "last_autoanalyze_ms":
+		return &s.LastAutoanalyzeMs
+	}
+	return nil
+}
+
+// tableGauges and tableRates map the cc_relation_* metrics behind
+// TableSample to its fields. Rates are computed from the running totals of
+// the counters where there are any, so statistics resets do not show up as
+// negative activity.
+var (
+	tableGauges = map[string]string{
+		"cc_relation_size_bytes":       "size_bytes",
+		"cc_relation_toast_size_bytes": "toast_size_bytes",
+		"cc_relation_n_live_tup":       "n_live_tup",
+		"cc_relation_n_dead_tup":       "n_dead_tup",
+		"cc_relation_cache_hit_ratio":  "cache_hit_ratio",
+		"cc_relation_last_vacuum":      "last_vacuum_ms",
+		"cc_relation_last_autovacuum":  "last_autovacuum_ms",
+		"cc_relation_last_analyze":     "last_analyze_ms",
+		"cc_relation_last_autoanalyze": "last_autoanalyze_ms",
+	}
+	tableRates = map[string]string{
+		"cc_relation_n_tup_ins": "inserts_per_second",
+		"cc_relation_n_tup_upd": "updates_per_second",
+		"cc_relation_n_tup_del": "deletes_per_second",
+		"cc_relation_seq_scan":  "seq_scans_per_second",
+		"cc_relation_idx_scan":  "idx_scans_per_second",
+	}
+)
+
+// TableIndex is an index of a table with its usage over the requested window
+type TableIndex struct {
+	Name             string   `json:"name"`
+	Definition       string   `json:"definition"`
+	Type             string   `json:"type"`
+	IsPrimary        bool     `json:"is_primary"`
+	IsUnique         bool     `json:"is_unique"`
+	IsValid          bool     `json:"is_valid"`
+	SizeBytes        int64    `json:"size_bytes"`
+	Scans            *float64 `json:"scans"`
+	ScansPerSecond   *float64 `json:"scans_per_second"`
+	LastScanChangeMs int64    `json:"last_scan_change_ms,omitempty"`
+}
+
+// TableDetail is the history of a single table and its indexes
+type TableDetail struct {
+	Datname  string        `json:"datname"`
+	Schema   string        `json:"schema"`
+	Relation string        `json:"relation"`
+	StepMs   int64         `json:"step_ms"`
+	Samples  []TableSample `json:"samples"`
+	Indexes  []TableIndex  `json:"indexes"`
+}
+
+// table_detail_handler returns the growth, activity and maintenance history of
+// one table, given as schema.table, over a time window, with its indexes. The
+// datname parameter is needed when the table exists in several databases.
+func table_detail_handler(metrics_service metrics.Service, queryStore query_storage.QueryStorage, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		schema, relation, ok := strings.Cut(chi.URLParam(r, "table"), ".")
+		if !ok || schema == "" || relation == "" {
+			http.Error(w, "The table must be given as schema.table.", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		start, end, err := parseTimeWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		step := max(end.Sub(start)/tableDetailPoints, minTableDetailStep).Truncate(time.Second)
+		if value := r.URL.Query().Get("step"); value != "" {
+			step, err = time.ParseDuration(value)
+			if err != nil || step < time.Second {
+				http.Error(w, "The 'step' parameter must be a duration of at least 1s.", http.StatusBadRequest)
+				return
+			}
+		}
+		if end.Sub(start)/step > maxTableDetailSamples {
+			http.Error(w, fmt.Sprintf("Maximum time samples exceeded. %d samples max per query", maxTableDetailSamples), http.StatusBadRequest)
+			return
+		}
+
+		table := TableDetail{Schema: schema, Relation: relation, StepMs: step.Milliseconds(), Samples: []TableSample{}, Indexes: []TableIndex{}}
+		datnames, err := queryTableSamples(metrics_service, system, &table, r.URL.Query().Get("datname"), start, end, step)
+		if err != nil {
+			http.Error(w, "Error querying table: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		switch {
+		case len(datnames) == 0:
+			http.Error(w, fmt.Sprintf("No metrics found for table %s.%s.", schema, relation), http.StatusNotFound)
+			return
+		case len(datnames) > 1:
+			http.Error(w, fmt.Sprintf("Table %s.%s exists in databases %s; set the 'datname' parameter.", schema, relation, strings.Join(datnames, ", ")), http.StatusBadRequest)
+			return
+		}
+		table.Datname = datnames[0]
+
+		table.Indexes, err = queryTableIndexes(metrics_service, queryStore, system, table, start, end)
+		if err != nil {
+			http.Error(w, "Error querying indexes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, table, now)
+	})
+}
+
+func tableSelector(system query_storage.SystemRef, datname, schema, relation string) string {
+	selector := fmt.Sprintf(`sys_id="%s",sys_scope="%s",sys_type="%s",schema="%s",relation="%s"`,
+		system.SystemID, system.SystemScope, system.SystemType, escapePromQLLabelValue(schema), escapePromQLLabelValue(relation))
+	if datname != "" {
+		selector += fmt.Sprintf(`,datname="%s"`, escapePromQLLabelValue(datname))
+	}
+	return selector
+}
+
+// queryTableSamples fills the samples of table over [start, end] in a single
+// range query, and returns the databases the table was found in. When it was
+// found in several, the samples are left empty.
+func queryTableSamples(metrics_service metrics.Service, system query_storage.SystemRef, table *TableDetail, datname string, start, end time.Time, step time.Duration) ([]string, error) {
+	selector := tableSelector(system, datname, table.Schema, table.Relation)
+	window := int64(max(step, minTableRateWindow).Seconds())
+
+	var parts []string
+	for _, name := range sortedKeys(tableGauges) {
+		parts = append(parts, fmt.Sprintf(`label_replace(last_over_time(%s{%s}[%ds]), "stat", "%s", "", "")`, name, selector, window, tableGauges[name]))
+	}
+	for _, name := range sortedKeys(tableRates) {
+		parts = append(parts, fmt.Sprintf(`label_replace(%s, "stat", "%s", "", "")`, counterExpr("rate", name, selector, window), tableRates[name]))
+	}
+
+	samples, err := metrics_service.ExecuteRaw(strings.Join(parts, " or "), map[string]string{
+		"start": strconv.FormatInt(start.UnixMilli(), 10),
+		"end":   strconv.FormatInt(end.UnixMilli(), 10),
+		"step":  step.String(),
+		"dim":   "time",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	var datnames []string
+	for _, sample := range samples {
+		if name := getValue(sample, "datname"); !found[name] {
+			found[name] = true
+			datnames = append(datnames, name)
+		}
+	}
+	sort.Strings(datnames)
+	if len(datnames) != 1 {
+		return datnames, nil
+	}
+
+	byTime := make(map[int64]*TableSample)
+	for _, sample := range samples {
+		stat := getValue(sample, "stat")
+		values, _ := sample["values"].([]map[string]interface{})
+		for _, v := range values {
+			timestamp, ok := v["timestamp"].(int64)
+			if !ok {
+				continue
+			}
+			value, ok := v["value"].(float64)
+			// Maintenance times are zero when there never was a run
+			if !ok || (strings.HasPrefix(stat, "last_") && value == 0) {
+				continue
+			}
+
+			point, ok := byTime[timestamp]
+			if !ok {
+				point = &TableSample{TimeMs: timestamp}
+				byTime[timestamp] = point
+			}
+			if field := point.field(stat); field != nil {
+				*field = &value
+			}
+		}
+	}
+
+	for _, point := range byTime {
+		table.Samples = append(table.Samples, *point)
+	}
+	sort.Slice(table.Samples, func(i, j int) bool {
+		return table.Samples[i].TimeMs < table.Samples[j].TimeMs
+	})
+	return datnames, nil
+}
+
+// queryTableIndexes lists the stored indexes of table with the scans of each
+// over the window ending at end, of at least minTableRateWindow. Indexes that
+// have metrics but are not stored yet are listed by name only.
+func queryTableIndexes(metrics_service metrics.Service, queryStore query_storage.QueryStorage, system query_storage.SystemRef, table TableDetail, start, end time.Time) ([]TableIndex, error) {
+	stored, err := queryStore.ListIndexes(system)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index metrics carry the database, schema and index name; cc_index_info
+	// tells which table each index belongs to
+	window := max(end.Sub(start), minTableRateWindow).Truncate(time.Second)
+	seconds := int64(window.Seconds())
+	indexSelector := fmt.Sprintf(`sys_id="%s",sys_scope="%s",sys_type="%s",datname="%s",schema="%s"`,
+		system.SystemID, system.SystemScope, system.SystemType, escapePromQLLabelValue(table.Datname), escapePromQLLabelValue(table.Schema))
+	samples, err := metrics_service.ExecuteRaw(fmt.Sprintf(
+		`sum by (index) (%s and on (sys_id, sys_scope, sys_type, datname, schema, index) last_over_time(cc_index_info{%s}[%ds]))`,
+		counterExpr("increase", "cc_index_scan_count", indexSelector, seconds),
+		tableSelector(system, table.Datname, table.Schema, table.Relation), seconds),
+		instantOptions(end, "index"))
+	if err != nil {
+		return nil, err
+	}
+	scans := make(map[string]float64)
+	for _, sample := range samples {
+		if value, ok := sampleValue(sample); ok {
+			scans[getValue(sample, "index")] = value
+		}
+	}
+
+	indexes := []TableIndex{}
+	for _, idx := range stored {
+		if idx.Database != table.Datname || idx.Schema != table.Schema || idx.Relation != table.Relation {
+			continue
+		}
+		index := TableIndex{
+			Name:             idx.Name,
+			Definition:       idx.Definition,
+			Type:             idx.Type,
+			IsPrimary:        idx.IsPrimary,
+			IsUnique:         idx.IsUnique,
+			IsValid:          idx.IsValid,
+			SizeBytes:        idx.SizeBytes,
+			LastScanChangeMs: idx.ScansChangedAt * 1000,
+		}
+		if count, ok := scans[idx.Name]; ok {
+			index.Scans, index.ScansPerSecond = scanUsage(count, window)
+			delete(scans, idx.Name)
+		}
+		indexes = append(indexes, index)
+	}
+	for name, count := range scans {
+		index := TableIndex{Name: name}
+		index.Scans, index.ScansPerSecond = scanUsage(count, window)
+		indexes = append(indexes, index)
+	}
+
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].Name < indexes[j].Name
+	})
+	return indexes, nil
+}
+
+func scanUsage(count float64, window time.Duration) (*float64, *float64) {
+	perSecond := count / window.Seconds()
+	return &count, &perSecond
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func tableSeries(stat, datname string, values ...float64) map[string]interface{} {
+	points := make([]map[string]interface{}, 0, len(values))
+	for i, value := range values {
+		points = append(points, map[string]interface{}{"timestamp": int64(1000000 + 300000*i), "value": value})
+	}
+	return map[string]interface{}{
+		"metric": map[string]interface{}{"stat": stat, "datname": datname, "schema": "public", "relation": "orders"},
+		"values": points,
+	}
+}
+
+func TestTableDetailHandler(t *testing.T) {
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "last_over_time") && strings.Contains(query, `relation="orders"`) &&
+			strings.Contains(query, `(rate(cc_relation_n_tup_ins_total{`) && strings.Contains(query, ` or rate(cc_relation_n_tup_ins{`)
+	}), mock.MatchedBy(func(options map[string]string) bool {
+		return options["dim"] == "time" && options["step"] == "5m0s"
+	})).Return([]map[string]interface{}{
+		tableSeries("size_bytes", "app", 8192, 16384),
+		tableSeries("inserts_per_second", "app", 2.5, 3),
+		tableSeries("last_vacuum_ms", "app", 0, 1200000),
+	}, nil)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "last_over_time") && strings.Contains(query, `relation="events"`)
+	}), mock.Anything).Return([]map[string]interface{}{
+		tableSeries("size_bytes", "app", 1),
+		tableSeries("size_bytes", "reporting", 1),
+	}, nil)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "last_over_time") && strings.Contains(query, `relation="missing"`)
+	}), mock.Anything).Return([]map[string]interface{}{}, nil)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.HasPrefix(query, `sum by (index) ((increase(cc_index_scan_count_total{sys_id="default_db",sys_scope="us-west-2",sys_type="amazon_rds",datname="app",schema="public"}`) &&
+			strings.Contains(query, " or increase(cc_index_scan_count{") && strings.Contains(query, "on (sys_id, sys_scope, sys_type, datname, schema, index)") && strings.Contains(query, `cc_index_info{`) && strings.Contains(query, `datname="app"`)
+	}), mock.Anything).Return([]map[string]interface{}{
+		statSample("", map[string]interface{}{"index": "orders_status_idx"}, 3600),
+		statSample("", map[string]interface{}{"index": "orders_created_at_idx"}, 36),
+	}, nil)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/tables/{table}", table_detail_handler(mockService, &MockQueryStorage{}, CreateValidator()))
+
+	dbIdentifier := "amazon_rds/default_db/us-west-2"
+	record := httptest.NewRecorder()
+	r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/api/v1/tables/public.orders?dbidentifier="+dbIdentifier+"&start=1000000&end=4600000", nil))
+	require.Equal(t, http.StatusOK, record.Code, record.Body.String())
+
+	var response struct {
+		Data TableDetail `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+	table := response.Data
+	assert.Equal(t, "app", table.Datname)
+	assert.Equal(t, int64(300000), table.StepMs)
+
+	require.Len(t, table.Samples, 2)
+	assert.Equal(t, int64(1000000), table.Samples[0].TimeMs)
+	assert.Equal(t, 8192.0, *table.Samples[0].SizeBytes)
+	assert.Equal(t, 2.5, *table.Samples[0].InsertsPerSecond)
+	assert.Nil(t, table.Samples[0].LastVacuumMs, "a table that was never vacuumed has no last vacuum")
+	assert.Nil(t, table.Samples[0].CacheHitRatio)
+	assert.Equal(t, 1200000.0, *table.Samples[1].LastVacuumMs)
+
+	require.Len(t, table.Indexes, 2)
+	assert.Equal(t, TableIndex{Name: "orders_created_at_idx", Scans: floatPtr(36), ScansPerSecond: floatPtr(0.01)}, table.Indexes[0])
+	assert.Equal(t, "orders_status_idx", table.Indexes[1].Name)
+	assert.Equal(t, "btree", table.Indexes[1].Type)
+	assert.Equal(t, int64(8192), table.Indexes[1].SizeBytes)
+	assert.Equal(t, 1.0, *table.Indexes[1].ScansPerSecond)
+	assert.Equal(t, int64(1000000), table.Indexes[1].LastScanChangeMs)
+
+	testCases := []struct {
+		name         string
+		target       string
+		expectedCode int
+	}{
+		{name: "Table in several databases", target: "/api/v1/tables/public.events?dbidentifier=" + dbIdentifier + "&start=now-1h", expectedCode: http.StatusBadRequest},
+		{name: "Unknown table", target: "/api/v1/tables/public.missing?dbidentifier=" + dbIdentifier + "&start=now-1h", expectedCode: http.StatusNotFound},
+		{name: "Table without schema", target: "/api/v1/tables/orders?dbidentifier=" + dbIdentifier + "&start=now-1h", expectedCode: http.StatusBadRequest},
+		{name: "Invalid step", target: "/api/v1/tables/public.orders?dbidentifier=" + dbIdentifier + "&start=now-1h&step=1ms", expectedCode: http.StatusBadRequest},
+		{name: "Too many samples", target: "/api/v1/tables/public.orders?dbidentifier=" + dbIdentifier + "&start=now-30d&step=1s", expectedCode: http.StatusBadRequest},
+		{name: "Missing start", target: "/api/v1/tables/public.orders?dbidentifier=" + dbIdentifier, expectedCode: http.StatusBadRequest},
+		{name: "Missing dbidentifier", target: "/api/v1/tables/public.orders?start=now-1h", expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			r.ServeHTTP(record, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			assert.Equal(t, tc.expectedCode, record.Code, record.Body.String())
+		})
+	}
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// TableHealth holds the derived health metrics of a table, as computed by
+// collector-api at ingest. Metrics that were not reported are null.
+type TableHealth struct {
+	Datname             string   `json:"datname"`
+	Schema              string   `json:"schema"`
+	Relation            string   `json:"relation"`
+	SizeBytes           *float64 `json:"size_bytes"`
+	CacheHitRatio       *float64 `json:"cache_hit_ratio"`
+	DeadTupRatio        *float64 `json:"dead_tup_ratio"`
+	EstimatedBloatBytes *float64 `json:"estimated_bloat_bytes"`
+	SecondsSinceVacuum  *float64 `json:"seconds_since_vacuum"`
+	SecondsSinceAnalyze *float64 `json:"seconds_since_analyze"`
+}
+
+// tableHealthMetrics maps the cc_relation_* metrics behind TableHealth to the
+// sort key of each field
+var tableHealthMetrics = map[string]string{
+	"cc_relation_size_bytes":            "size_bytes",
+	"cc_relation_cache_hit_ratio":       "cache_hit_ratio",
+	"cc_relation_dead_tup_ratio":        "dead_tup_ratio",
+	"cc_relation_estimated_bloat_bytes": "estimated_bloat_bytes",
+	"cc_relation_seconds_since_vacuum":  "seconds_since_vacuum",
+	"cc_relation_seconds_since_analyze": "seconds_since_analyze",
+}
+
+const (
+	defaultTableHealthSort  = "dead_tup_ratio"
+	defaultTableHealthLimit = 20
+	maxTableHealthLimit     = 500
+)
+
+func (t *TableHealth) field(key string) **float64 {
+	switch key {
+	case "size_bytes":
+		return &t.SizeBytes
+	case "cache_hit_ratio":
+		return &t.CacheHitRatio
+	case "dead_tup_ratio":
+		return &t.DeadTupRatio
+	case "estimated_bloat_bytes":
+		return &t.EstimatedBloatBytes
+	case "seconds_since_vacuum":
+		return &t.SecondsSinceVacuum
+	case "seconds_since_analyze":
+		return &t.SecondsSinceAnalyze
+	}
+	return nil
+}
+
+// worseThan reports whether t needs attention before other by the given sort
+// key. A low cache hit ratio is worse; for every other metric a high value
+// is. Tables without the metric come last.
+func (t *TableHealth) worseThan(other *TableHealth, key string) bool {
+	a, b := *t.field(key), *other.field(key)
+	if a == nil || b == nil {
+		return a != nil
+	}
+	if key == "cache_hit_ratio" {
+		return *a < *b
+	}
+	return *a > *b
+}
+
+// tables_health_handler lists the tables of an instance that most need
+// vacuum or tuning attention, worst first by the chosen metric
+func tables_health_handler(metrics_service metrics.Service, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		at := now
+		if end := r.URL.Query().Get("end"); end != "" {
+			at, err = parseTimeParameter(end, now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		sortKey := defaultTableHealthSort
+		if value := r.URL.Query().Get("sort"); value != "" {
+			if (&TableHealth{}).field(value) == nil {
+				http.Error(w, fmt.Sprintf("The 'sort' parameter must be one of: %s.", strings.Join(tableHealthSortKeys(), ", ")), http.StatusBadRequest)
+				return
+			}
+			sortKey = value
+		}
+
+		limit := defaultTableHealthLimit
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 || limit > maxTableHealthLimit {
+				http.Error(w, fmt.Sprintf("The 'limit' parameter must be between 1 and %d.", maxTableHealthLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		tables, err := queryTableHealth(metrics_service, system, at)
+		if err != nil {
+			http.Error(w, "Error querying table health: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sort.SliceStable(tables, func(i, j int) bool {
+			return tables[i].worseThan(&tables[j], sortKey)
+		})
+		if len(tables) > limit {
+			tables = tables[:limit]
+		}
+
+		js, err := json.Marshal(tables)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		wrappedJSON, err := WrapJSON(js, map[string]interface{}{"server_now": now.UnixMilli(), "sort": sortKey})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(wrappedJSON)
+	})
+}
+
+func tableHealthSortKeys() []string {
+	keys := make([]string, 0, len(tableHealthMetrics))
+	for _, key := range tableHealthMetrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// queryTableHealth fetches the latest health metrics of every table of system
+// in a single instant query, ordered by table
+func queryTableHealth(metrics_service metrics.Service, system query_storage.SystemRef, at time.Time) ([]TableHealth, error) {
+	names := make([]string, 0, len(tableHealthMetrics))
+	for name := range tableHealthMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	query := fmt.Sprintf(`{__name__=~"%s",sys_id="%s",sys_scope="%s",sys_type="%s"}`,
+		strings.Join(names, "|"), system.SystemID, system.SystemScope, system.SystemType)
+
+	options := map[string]string{
+		"start": strconv.FormatInt(at.UnixMilli(), 10),
+		"end":   strconv.FormatInt(at.UnixMilli(), 10),
+		"dim":   "relation",
+	}
+
+	samples, err := metrics_service.ExecuteRaw(query, options)
+	if err != nil {
+		return nil, err
+	}
+
+	byTable := make(map[string]*TableHealth)
+	var keys []string
+	for _, sample := range samples {
+		key, ok := tableHealthMetrics[getValue(sample, "__name__")]
+		if !ok {
+			continue
+		}
+		value, ok := sampleValue(sample)
+		if !ok {
+			continue
+		}
+
+		datname, schema, relation := getValue(sample, "datname"), getValue(sample, "schema"), getValue(sample, "relation")
+		tableKey := datname + "\x00" + schema + "\x00" + relation
+		table, ok := byTable[tableKey]
+		if !ok {
+			table = &TableHealth{Datname: datname, Schema: schema, Relation: relation}
+			byTable[tableKey] = table
+			keys = append(keys, tableKey)
+		}
+		*table.field(key) = &value
+	}
+
+	sort.Strings(keys)
+	tables := make([]TableHealth, 0, len(keys))
+	for _, key := range keys {
+		tables = append(tables, *byTable[key])
+	}
+	return tables, nil
+}
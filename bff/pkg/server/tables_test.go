@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func tableHealthSample(name, relation string, value float64) map[string]interface{} {
+	return map[string]interface{}{
+		"metric": map[string]interface{}{"__name__": name, "datname": "app", "schema": "public", "relation": relation},
+		"values": []map[string]interface{}{{"timestamp": int64(2000000), "value": value}},
+	}
+}
+
+func TestTablesHealthHandler(t *testing.T) {
+	dbIdentifier := "amazon_rds/default_db/us-west-2/abcdefghijkl"
+
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "cc_relation_dead_tup_ratio|") && strings.Contains(query, `sys_id="default_db"`)
+	}), map[string]string{"start": "2000000", "end": "2000000", "dim": "relation"}).Return([]map[string]interface{}{
+		tableHealthSample("cc_relation_dead_tup_ratio", "orders", 0.1),
+		tableHealthSample("cc_relation_cache_hit_ratio", "orders", 0.99),
+		tableHealthSample("cc_relation_dead_tup_ratio", "events", 0.4),
+		tableHealthSample("cc_relation_cache_hit_ratio", "events", 0.95),
+		tableHealthSample("cc_relation_size_bytes", "users", 8192),
+	}, nil)
+
+	handler := tables_health_handler(mockService, CreateValidator())
+
+	testCases := []struct {
+		name              string
+		query             string
+		expectedCode      int
+		expectedRelations []string
+	}{
+		{
+			name:              "Worst dead tuple ratio first",
+			query:             "dbidentifier=" + dbIdentifier + "&end=2000000",
+			expectedCode:      http.StatusOK,
+			expectedRelations: []string{"events", "orders", "users"},
+		},
+		{
+			name:              "Lowest cache hit ratio first",
+			query:             "dbidentifier=" + dbIdentifier + "&end=2000000&sort=cache_hit_ratio&limit=2",
+			expectedCode:      http.StatusOK,
+			expectedRelations: []string{"events", "orders"},
+		},
+		{
+			name:         "Unknown sort",
+			query:        "dbidentifier=" + dbIdentifier + "&sort=n_live_tup",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Missing dbidentifier",
+			query:        "sort=dead_tup_ratio",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/tables/health?"+tc.query, nil)
+			handler.ServeHTTP(record, req)
+			assert.Equal(t, tc.expectedCode, record.Code)
+
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data []TableHealth `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+
+			var relations []string
+			for _, table := range response.Data {
+				relations = append(relations, table.Relation)
+			}
+			assert.Equal(t, tc.expectedRelations, relations)
+			require.NotNil(t, response.Data[0].DeadTupRatio)
+			assert.Equal(t, 0.4, *response.Data[0].DeadTupRatio)
+			assert.Nil(t, response.Data[0].SecondsSinceVacuum)
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"local/bff/pkg/metrics"
+	"local/bff/pkg/query_storage"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// wraparoundLimit is the transaction and multixact ID age at which
+	// Postgres wraps around; it stops assigning IDs shortly before
+	wraparoundLimit = float64(1 << 31)
+
+	// Postgres defaults, used when the instance did not report its settings
+	defaultAutovacuumFreezeMaxAge          = 200_000_000
+	defaultAutovacuumMultixactFreezeMaxAge = 400_000_000
+
+	// wraparoundBurnWindow is how far back the ID consumption rate is measured
+	wraparoundBurnWindow = "6h"
+
+	defaultWraparoundTableLimit = 10
+	maxWraparoundTableLimit     = 500
+)
+
+// Wraparound severities, from least to most urgent
+const (
+	WraparoundOK       = "ok"       // Below autovacuum_freeze_max_age
+	WraparoundWarning  = "warning"  // Past autovacuum_freeze_max_age, so anti-wraparound vacuums are due
+	WraparoundCritical = "critical" // Past half of the wraparound limit
+)
+
+var wraparoundSeverityRank = map[string]int{WraparoundOK: 0, WraparoundWarning: 1, WraparoundCritical: 2}
+
+// WraparoundRisk is how close one transaction or multixact ID age is to
+// wraparound. The projections assume the current burn rate continues and are
+// null when the threshold is already passed or the age is not growing.
+type WraparoundRisk struct {
+	Age                   float64  `json:"age"`
+	FreezeMaxAgePct       float64  `json:"freeze_max_age_pct"`
+	LimitPct              float64  `json:"limit_pct"`
+	SecondsToFreezeMaxAge *float64 `json:"seconds_to_freeze_max_age"`
+	SecondsToLimit        *float64 `json:"seconds_to_limit"`
+	Severity              string   `json:"severity"`
+}
+
+// DatabaseWraparound is the wraparound risk of a database, set by its oldest table
+type DatabaseWraparound struct {
+	Datname  string          `json:"datname"`
+	XID      *WraparoundRisk `json:"xid"`
+	MXID     *WraparoundRisk `json:"mxid"`
+	Severity string          `json:"severity"`
+}
+
+// TableWraparound is the wraparound risk of a single table
+type TableWraparound struct {
+	Datname  string          `json:"datname"`
+	Schema   string          `json:"schema"`
+	Relation string          `json:"relation"`
+	XID      *WraparoundRisk `json:"xid"`
+	MXID     *WraparoundRisk `json:"mxid"`
+	Severity string          `json:"severity"`
+}
+
+// WraparoundReport is the wraparound risk of an instance: every database and
+// the tables closest to the limit
+type WraparoundReport struct {
+	Severity                        string               `json:"severity"`
+	AutovacuumFreezeMaxAge          float64              `json:"autovacuum_freeze_max_age"`
+	AutovacuumMultixactFreezeMaxAge float64              `json:"autovacuum_multixact_freeze_max_age"`
+	XIDBurnRate                     float64              `json:"xid_burn_rate_per_second"`
+	MXIDBurnRate                    float64              `json:"mxid_burn_rate_per_second"`
+	Databases                       []DatabaseWraparound `json:"databases"`
+	Tables                          []TableWraparound    `json:"tables"`
+}
+
+// newWraparoundRisk models an ID age against its autovacuum freeze max age
+// and the wraparound limit, growing at burnRate IDs per second
+func newWraparoundRisk(age, freezeMaxAge, burnRate float64) *WraparoundRisk {
+	risk := &WraparoundRisk{
+		Age:             age,
+		FreezeMaxAgePct: age / freezeMaxAge * 100,
+		LimitPct:        age / wraparoundLimit * 100,
+		Severity:        WraparoundOK,
+	}
+
+	switch {
+	case age >= wraparoundLimit/2:
+		risk.Severity = WraparoundCritical
+	case age >= freezeMaxAge:
+		risk.Severity = WraparoundWarning
+	}
+
+	risk.SecondsToFreezeMaxAge = secondsToThreshold(age, freezeMaxAge, burnRate)
+	risk.SecondsToLimit = secondsToThreshold(age, wraparoundLimit, burnRate)
+	return risk
+}
+
+func secondsToThreshold(age, threshold, burnRate float64) *float64 {
+	if age >= threshold || burnRate <= 0 {
+		return nil
+	}
+	seconds := math.Round((threshold - age) / burnRate)
+	return &seconds
+}
+
+// severity returns the severity of r, which is ok when there is no risk to report
+func (r *WraparoundRisk) severity() string {
+	if r == nil {
+		return WraparoundOK
+	}
+	return r.Severity
+}
+
+// worstSeverity returns the most urgent of the given severities
+func worstSeverity(severities ...string) string {
+	worst := WraparoundOK
+	for _, severity := range severities {
+		if wraparoundSeverityRank[severity] > wraparoundSeverityRank[worst] {
+			worst = severity
+		}
+	}
+	return worst
+}
+
+// limitPct is the larger percent of the wraparound limit of the given risks
+func limitPct(risks ...*WraparoundRisk) float64 {
+	pct := 0.0
+	for _, risk := range risks {
+		if risk != nil && risk.LimitPct > pct {
+			pct = risk.LimitPct
+		}
+	}
+	return pct
+}
+
+// wraparound_handler reports the transaction ID and multixact ID wraparound
+// risk of an instance, per database and for the tables closest to the limit
+func wraparound_handler(metrics_service metrics.Service, validate *validator.Validate) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system, err := parseSystemRef(r.URL.Query().Get("dbidentifier"), validate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		at := now
+		if end := r.URL.Query().Get("end"); end != "" {
+			at, err = parseTimeParameter(end, now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		limit := defaultWraparoundTableLimit
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 || limit > maxWraparoundTableLimit {
+				http.Error(w, fmt.Sprintf("The 'limit' parameter must be between 1 and %d.", maxWraparoundTableLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		report, err := queryWraparound(metrics_service, system, at, limit)
+		if err != nil {
+			http.Error(w, "Error querying wraparound risk: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		js, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		wrappedJSON, err := WrapJSON(js, map[string]interface{}{"server_now": now.UnixMilli()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(wrappedJSON)
+	})
+}
+
+// queryWraparound builds the wraparound report of system at the given time
+// from two instant queries: the current ages and settings, and the burn rates
+func queryWraparound(metrics_service metrics.Service, system query_storage.SystemRef, at time.Time, tableLimit int) (*WraparoundReport, error) {
+	selector := fmt.Sprintf(`sys_id="%s",sys_scope="%s",sys_type="%s"`, system.SystemID, system.SystemScope, system.SystemType)
+	options := map[string]string{
+		"start": strconv.FormatInt(at.UnixMilli(), 10),
+		"end":   strconv.FormatInt(at.UnixMilli(), 10),
+		"dim":   "datname",
+	}
+
+	ages, err := metrics_service.ExecuteRaw(fmt.Sprintf(
+		`{__name__=~"cc_db_frozen_xid_age|cc_db_minmxid_age|cc_relation_frozenxid_age|cc_relation_minmxid_age",%[1]s}`+
+			` or cc_pgsetting_current_value{name=~"autovacuum_freeze_max_age|autovacuum_multixact_freeze_max_age",%[1]s}`,
+		selector), options)
+	if err != nil {
+		return nil, err
+	}
+
+	// IDs are consumed cluster-wide; the database with the oldest unfrozen
+	// table between vacuums shows the full rate
+	burnRates, err := metrics_service.ExecuteRaw(fmt.Sprintf(
+		`label_replace(max(deriv(cc_db_frozen_xid_age{%[1]s}[%[2]s])), "id", "xid", "", "")`+
+			` or label_replace(max(deriv(cc_db_minmxid_age{%[1]s}[%[2]s])), "id", "mxid", "", "")`,
+		selector, wraparoundBurnWindow), options)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &WraparoundReport{
+		AutovacuumFreezeMaxAge:          defaultAutovacuumFreezeMaxAge,
+		AutovacuumMultixactFreezeMaxAge: defaultAutovacuumMultixactFreezeMaxAge,
+		Databases:                       []DatabaseWraparound{},
+		Tables:                          []TableWraparound{},
+	}
+
+	for _, sample := range burnRates {
+		value, ok := sampleValue(sample)
+		if !ok || math.IsNaN(value) {
+			continue
+		}
+		switch getValue(sample, "id") {
+		case "xid":
+			report.XIDBurnRate = math.Max(value, 0)
+		case "mxid":
+			report.MXIDBurnRate = math.Max(value, 0)
+		}
+	}
+
+	type tableRef struct{ datname, schema, relation string }
+	dbXID, dbMXID := make(map[string]float64), make(map[string]float64)
+	tableXID, tableMXID := make(map[tableRef]float64), make(map[tableRef]float64)
+
+	for _, sample := range ages {
+		value, ok := sampleValue(sample)
+		if !ok {
+			continue
+		}
+		table := tableRef{getValue(sample, "datname"), getValue(sample, "schema"), getValue(sample, "relation")}
+
+		switch getValue(sample, "__name__") {
+		case "cc_db_frozen_xid_age":
+			dbXID[table.datname] = value
+		case "cc_db_minmxid_age":
+			dbMXID[table.datname] = value
+		case "cc_relation_frozenxid_age":
+			tableXID[table] = value
+		case "cc_relation_minmxid_age":
+			tableMXID[table] = value
+		case "cc_pgsetting_current_value":
+			if value <= 0 {
+				continue
+			}
+			if getValue(sample, "name") == "autovacuum_freeze_max_age" {
+				report.AutovacuumFreezeMaxAge = value
+			} else {
+				report.AutovacuumMultixactFreezeMaxAge = value
+			}
+		}
+	}
+
+	risks := func(xidAge float64, hasXID bool, mxidAge float64, hasMXID bool) (*WraparoundRisk, *WraparoundRisk) {
+		var xid, mxid *WraparoundRisk
+		if hasXID {
+			xid = newWraparoundRisk(xidAge, report.AutovacuumFreezeMaxAge, report.XIDBurnRate)
+		}
+		if hasMXID {
+			mxid = newWraparoundRisk(mxidAge, report.AutovacuumMultixactFreezeMaxAge, report.MXIDBurnRate)
+		}
+		return xid, mxid
+	}
+
+	datnames := make(map[string]bool)
+	for datname := range dbXID {
+		datnames[datname] = true
+	}
+	for datname := range dbMXID {
+		datnames[datname] = true
+	}
+	for datname := range datnames {
+		xidAge, hasXID := dbXID[datname]
+		mxidAge, hasMXID := dbMXID[datname]
+		xid, mxid := risks(xidAge, hasXID, mxidAge, hasMXID)
+		report.Databases = append(report.Databases, DatabaseWraparound{
+			Datname:  datname,
+			XID:      xid,
+			MXID:     mxid,
+			Severity: worstSeverity(xid.severity(), mxid.severity()),
+		})
+	}
+
+	tables := make(map[tableRef]bool)
+	for table := range tableXID {
+		tables[table] = true
+	}
+	for table := range tableMXID {
+		tables[table] = true
+	}
+	for table := range tables {
+		xidAge, hasXID := tableXID[table]
+		mxidAge, hasMXID := tableMXID[table]
+		xid, mxid := risks(xidAge, hasXID, mxidAge, hasMXID)
+		report.Tables = append(report.Tables, TableWraparound{
+			Datname:  table.datname,
+			Schema:   table.schema,
+			Relation: table.relation,
+			XID:      xid,
+			MXID:     mxid,
+			Severity: worstSeverity(xid.severity(), mxid.severity()),
+		})
+	}
+
+	sort.Slice(report.Databases, func(i, j int) bool {
+		a, b := report.Databases[i], report.Databases[j]
+		if pa, pb := limitPct(a.XID, a.MXID), limitPct(b.XID, b.MXID); pa != pb {
+			return pa > pb
+		}
+		return a.Datname < b.Datname
+	})
+	sort.Slice(report.Tables, func(i, j int) bool {
+		a, b := report.Tables[i], report.Tables[j]
+		if pa, pb := limitPct(a.XID, a.MXID), limitPct(b.XID, b.MXID); pa != pb {
+			return pa > pb
+		}
+		if a.Datname != b.Datname {
+			return a.Datname < b.Datname
+		}
+		if a.Schema != b.Schema {
+			return a.Schema < b.Schema
+		}
+		return a.Relation < b.Relation
+	})
+	if len(report.Tables) > tableLimit {
+		report.Tables = report.Tables[:tableLimit]
+	}
+
+	report.Severity = WraparoundOK
+	for _, db := range report.Databases {
+		report.Severity = worstSeverity(report.Severity, db.Severity)
+	}
+	for _, table := range report.Tables {
+		report.Severity = worstSeverity(report.Severity, table.Severity)
+	}
+
+	return report, nil
+}
+
+// sampleValue returns the value of an instant query sample
+func sampleValue(sample map[string]interface{}) (float64, bool) {
+	values, ok := sample["values"].([]map[string]interface{})
+	if !ok || len(values) == 0 {
+		return 0, false
+	}
+	value, ok := values[0]["value"].(float64)
+	return value, ok
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func wraparoundSample(labels map[string]interface{}, value float64) map[string]interface{} {
+	return map[string]interface{}{
+		"metric": labels,
+		"values": []map[string]interface{}{{"timestamp": int64(2000000), "value": value}},
+	}
+}
+
+func TestNewWraparoundRisk(t *testing.T) {
+	risk := newWraparoundRisk(100_000_000, 200_000_000, 1000)
+	assert.Equal(t, WraparoundOK, risk.Severity)
+	assert.InDelta(t, 50, risk.FreezeMaxAgePct, 1e-9)
+	assert.InDelta(t, 100_000_000/wraparoundLimit*100, risk.LimitPct, 1e-9)
+	require.NotNil(t, risk.SecondsToFreezeMaxAge)
+	assert.Equal(t, 100_000.0, *risk.SecondsToFreezeMaxAge)
+	require.NotNil(t, risk.SecondsToLimit)
+
+	risk = newWraparoundRisk(300_000_000, 200_000_000, 1000)
+	assert.Equal(t, WraparoundWarning, risk.Severity)
+	assert.InDelta(t, 150, risk.FreezeMaxAgePct, 1e-9)
+	assert.Nil(t, risk.SecondsToFreezeMaxAge, "threshold already passed")
+
+	risk = newWraparoundRisk(1_200_000_000, 200_000_000, 0)
+	assert.Equal(t, WraparoundCritical, risk.Severity)
+	assert.Nil(t, risk.SecondsToLimit, "age not growing")
+}
+
+func TestWraparoundHandler(t *testing.T) {
+	dbIdentifier := "amazon_rds/default_db/us-west-2/abcdefghijkl"
+	options := map[string]string{"start": "2000000", "end": "2000000", "dim": "datname"}
+
+	table := func(name, relation string, value float64) map[string]interface{} {
+		return wraparoundSample(map[string]interface{}{"__name__": name, "datname": "app", "schema": "public", "relation": relation}, value)
+	}
+
+	mockService := new(MockMetricsService)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "cc_db_frozen_xid_age|") && strings.Contains(query, `sys_id="default_db"`)
+	}), options).Return([]map[string]interface{}{
+		wraparoundSample(map[string]interface{}{"__name__": "cc_db_frozen_xid_age", "datname": "app"}, 300_000_000),
+		wraparoundSample(map[string]interface{}{"__name__": "cc_db_minmxid_age", "datname": "app"}, 1000),
+		wraparoundSample(map[string]interface{}{"__name__": "cc_db_frozen_xid_age", "datname": "postgres"}, 50_000_000),
+		table("cc_relation_frozenxid_age", "orders", 300_000_000),
+		table("cc_relation_frozenxid_age", "users", 20_000_000),
+		table("cc_relation_frozenxid_age", "events", 150_000_000),
+		wraparoundSample(map[string]interface{}{"__name__": "cc_pgsetting_current_value", "name": "autovacuum_freeze_max_age"}, 250_000_000),
+	}, nil)
+	mockService.On("ExecuteRaw", mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "deriv(cc_db_frozen_xid_age{") && strings.Contains(query, "[6h]")
+	}), options).Return([]map[string]interface{}{
+		wraparoundSample(map[string]interface{}{"id": "xid"}, 1000),
+		wraparoundSample(map[string]interface{}{"id": "mxid"}, -5),
+	}, nil)
+
+	handler := wraparound_handler(mockService, CreateValidator())
+
+	testCases := []struct {
+		name              string
+		query             string
+		expectedCode      int
+		expectedRelations []string
+	}{
+		{
+			name:              "Report",
+			query:             "dbidentifier=" + dbIdentifier + "&end=2000000",
+			expectedCode:      http.StatusOK,
+			expectedRelations: []string{"orders", "events", "users"},
+		},
+		{
+			name:              "Top tables",
+			query:             "dbidentifier=" + dbIdentifier + "&end=2000000&limit=1",
+			expectedCode:      http.StatusOK,
+			expectedRelations: []string{"orders"},
+		},
+		{
+			name:         "Invalid limit",
+			query:        "dbidentifier=" + dbIdentifier + "&limit=0",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Missing dbidentifier",
+			query:        "end=2000000",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/wraparound?"+tc.query, nil)
+			handler.ServeHTTP(record, req)
+			assert.Equal(t, tc.expectedCode, record.Code)
+
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data WraparoundReport `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(record.Body.Bytes(), &response))
+			report := response.Data
+
+			assert.Equal(t, WraparoundWarning, report.Severity)
+			assert.Equal(t, 250_000_000.0, report.AutovacuumFreezeMaxAge)
+			assert.Equal(t, float64(defaultAutovacuumMultixactFreezeMaxAge), report.AutovacuumMultixactFreezeMaxAge)
+			assert.Equal(t, 1000.0, report.XIDBurnRate)
+			assert.Equal(t, 0.0, report.MXIDBurnRate)
+
+			require.Len(t, report.Databases, 2)
+			app := report.Databases[0]
+			assert.Equal(t, "app", app.Datname)
+			assert.Equal(t, WraparoundWarning, app.Severity)
+			require.NotNil(t, app.XID)
+			assert.InDelta(t, 120, app.XID.FreezeMaxAgePct, 1e-9)
+			require.NotNil(t, app.XID.SecondsToLimit)
+			assert.Equal(t, math.Round((wraparoundLimit-300_000_000)/1000), *app.XID.SecondsToLimit)
+			require.NotNil(t, app.MXID)
+			assert.Nil(t, app.MXID.SecondsToLimit)
+			assert.Equal(t, "postgres", report.Databases[1].Datname)
+			assert.Equal(t, WraparoundOK, report.Databases[1].Severity)
+			assert.Nil(t, report.Databases[1].MXID)
+
+			var relations []string
+			for _, table := range report.Tables {
+				relations = append(relations, table.Relation)
+			}
+			assert.Equal(t, tc.expectedRelations, relations)
+		})
+	}
+}
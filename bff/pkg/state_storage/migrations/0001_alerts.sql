@@ -0,0 +1,31 @@
+-- One row per alert instance, identified by the fingerprint of its labels.
+-- Times are unix seconds.
+CREATE TABLE IF NOT EXISTS alerts (
+    fingerprint TEXT PRIMARY KEY,
+    rule TEXT NOT NULL,
+    labels TEXT NOT NULL,
+    annotations TEXT NOT NULL,
+    state TEXT NOT NULL,
+    value REAL NOT NULL,
+    active_at INTEGER NOT NULL,
+    fired_at INTEGER,
+    resolved_at INTEGER,
+    last_evaluated_at INTEGER NOT NULL,
+    notified_state TEXT,
+    last_notified_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS alerts_rule ON alerts (rule);
+CREATE INDEX IF NOT EXISTS alerts_state ON alerts (state);
+
+CREATE TABLE IF NOT EXISTS silences (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    matchers TEXT NOT NULL,
+    starts_at INTEGER NOT NULL,
+    ends_at INTEGER NOT NULL,
+    created_by TEXT NOT NULL,
+    comment TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS silences_ends_at ON silences (ends_at);
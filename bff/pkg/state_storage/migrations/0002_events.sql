@@ -0,0 +1,22 @@
+-- Events shown alongside the metrics of a system, such as detected anomalies.
+-- Times are unix milliseconds. dedup_key lets a source extend an ongoing event
+-- instead of recording a new one.
+CREATE TABLE IF NOT EXISTS events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    source TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    title TEXT NOT NULL,
+    starts_at INTEGER NOT NULL,
+    ends_at INTEGER NOT NULL,
+    tags TEXT NOT NULL DEFAULT '[]',
+    link TEXT NOT NULL DEFAULT '',
+    details TEXT,
+    dedup_key TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS events_system_time ON events (sys_id, sys_scope, sys_type, starts_at);
+CREATE INDEX IF NOT EXISTS events_dedup_key ON events (sys_id, sys_scope, sys_type, dedup_key, ends_at);
@@ -0,0 +1,13 @@
+-- Names, environments, teams and free-form tags given to systems by users.
+-- tags is a JSON array of strings; updated_at is unix milliseconds.
+CREATE TABLE IF NOT EXISTS instance_metadata (
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    display_name TEXT NOT NULL DEFAULT '',
+    environment TEXT NOT NULL DEFAULT '',
+    team TEXT NOT NULL DEFAULT '',
+    tags TEXT NOT NULL DEFAULT '[]',
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY (sys_id, sys_scope, sys_type)
+);
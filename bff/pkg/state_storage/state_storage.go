@@ -0,0 +1,169 @@
+// Package state_storage holds the state owned by the bff itself, such as alert
+// states and silences, in a SQLite database of its own. The collector-api
+// database is only ever read by the bff.
+package state_storage
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer build
+// than the one currently running.
+var ErrSchemaTooNew = errors.New("state database schema is newer than this build supports")
+
+// Migration is a single, ordered up-migration of the state database schema.
+type Migration struct {
+	Version int
+	Name    string
+	stmt    string
+}
+
+// Open opens the state database at dbPath, creating it if needed, and brings
+// its schema up to date.
+func Open(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("open state database: %w", err)
+	}
+
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate state database: %w", err)
+	}
+
+	return db, nil
+}
+
+// Migrations returns all known migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read embedded migrations: %w", err)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		version, name, err := parseMigrationFilename(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: name, stmt: string(contents)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous from 1: found %d (%s) at position %d", m.Version, m.Name, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// parseMigrationFilename splits "0001_alerts.sql" into 1 and "alerts"
+func parseMigrationFilename(filename string) (int, string, error) {
+	base := strings.TrimSuffix(filename, ".sql")
+	versionStr, name, found := strings.Cut(base, "_")
+	if !found || name == "" {
+		return 0, "", fmt.Errorf("invalid migration filename %q: expected <version>_<name>.sql", filename)
+	}
+
+	version, err := strconv.Atoi(versionStr)
+	if err != nil || version <= 0 {
+		return 0, "", fmt.Errorf("invalid migration filename %q: version must be a positive integer", filename)
+	}
+
+	return version, name, nil
+}
+
+// CurrentSchemaVersion returns the highest applied migration version, or 0 for
+// a database that has never been migrated.
+func CurrentSchemaVersion(db *sql.DB) (int, error) {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return 0, fmt.Errorf("create schema_version table: %w", err)
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Migrate applies every pending migration, each in its own transaction. It
+// refuses to touch a database whose schema is newer than this build knows about.
+func Migrate(db *sql.DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	current, err := CurrentSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if current > len(migrations) {
+		return fmt.Errorf("%w: database is at version %d, latest known version is %d", ErrSchemaTooNew, current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	defer tx.Rollback() // Will be ignored if tx.Commit() is called
+
+	if _, err := tx.Exec(m.stmt); err != nil {
+		return fmt.Errorf("apply migration %d (%s): %w", m.Version, m.Name, err)
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("record migration %d (%s): %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package state_storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenMigratesFreshDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "state.db")
+	db, err := Open(dbPath)
+	require.NoError(t, err)
+
+	migrations, err := Migrations()
+	require.NoError(t, err)
+
+	version, err := CurrentSchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	for _, table := range []string{"alerts", "silences", "events"} {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count, "table %s should exist", table)
+	}
+	require.NoError(t, db.Close())
+
+	// Reopening applies nothing new
+	db, err = Open(dbPath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	version, err = CurrentSchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+}
+
+func TestOpenRefusesNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "state.db")
+	db, err := Open(dbPath)
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (999, 'future', 0)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = Open(dbPath)
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
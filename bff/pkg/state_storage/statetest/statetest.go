@@ -0,0 +1,21 @@
+// Package statetest opens throwaway state databases for tests.
+package statetest
+
+import (
+	"database/sql"
+	"local/bff/pkg/state_storage"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Open opens a migrated state database in a temporary directory, which is
+// closed when the test ends.
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := state_storage.Open(filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -3,17 +3,25 @@ package main
 import (
 	"collector-api/internal/api"
 	"collector-api/internal/config"
+	"collector-api/internal/db"
+	"collector-api/internal/redaction"
 	"collector-api/internal/storage"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 func main() {
 	reprocessFull := flag.Bool("reprocess-full", false, "Reprocess all full snapshots")
 	reprocessCompact := flag.Bool("reprocess-compact", false, "Reprocess all compact snapshots")
+	migrationStatus := flag.Bool("migration-status", false, "Print the database schema migration status and exit")
+	redactionReport := flag.Bool("redaction-report", false, "Report how many stored query texts the configured redaction would change, and exit")
+	redactStored := flag.Bool("redact-stored-queries", false, "Apply the configured redaction to already stored query texts, and exit")
 	flag.Parse()
 
 	// Load the configuration from the global config path
@@ -30,12 +38,41 @@ func main() {
 		os.Exit(-1)
 	}
 
+	if *migrationStatus {
+		if err := printMigrationStatus(cfg.DBPath); err != nil {
+			log.Printf("Failed to read migration status: %v", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	redactor, err := redaction.New(cfg.QueryRedaction)
+	if err != nil {
+		log.Printf("Invalid query redaction configuration: %v", err)
+		os.Exit(-1)
+	}
+
+	// Activity labels, relation metrics, metric relabeling and query
+	// redaction are configured once for every snapshot processed
+	if err := api.ConfigureSnapshots(cfg); err != nil {
+		log.Printf("Invalid snapshot processing configuration: %v", err)
+		os.Exit(-1)
+	}
+
 	err = storage.InitQueryStorage(cfg.DBPath)
 	if err != nil {
 		log.Printf("Failed to initialize query storage: %v", err)
 		os.Exit(-1)
 	}
 
+	if *redactionReport || *redactStored {
+		if err := redactStoredQueries(redactor, *redactStored); err != nil {
+			log.Printf("Failed to redact stored queries: %v", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
 	// Create error channel for goroutines
 	errChan := make(chan error, 2)
 
@@ -54,6 +91,9 @@ func main() {
 		}()
 	}
 
+	// Process snapshots held for reordering as they become due
+	go api.RunReorderBuffer(cfg)
+
 	// Start HTTP server in a goroutine
 	go func() {
 		router := api.SetupRoutes(cfg)
@@ -73,3 +113,77 @@ func main() {
 		}
 	}
 }
+
+// printMigrationStatus lists every known schema migration and whether it has
+// been applied to the database at dbPath, without applying anything.
+func printMigrationStatus(dbPath string) error {
+	database, err := db.OpenDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	statuses, err := db.GetMigrationStatus(database)
+	if err != nil {
+		return err
+	}
+
+	latest, err := db.LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	current, err := db.CurrentSchemaVersion(database)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n", dbPath)
+	fmt.Printf("Schema version: %d (latest known: %d)\n\n", current, latest)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		status := "pending"
+		appliedAt := "-"
+		if s.Applied {
+			status = "applied"
+			appliedAt = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		name := s.Name
+		if name == "" {
+			name = "<unknown to this build>"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, name, status, appliedAt)
+	}
+	return w.Flush()
+}
+
+// redactStoredQueries runs the configured redaction over query texts already
+// in storage, printing how many values change. Nothing is written unless apply
+// is set.
+func redactStoredQueries(redactor *redaction.Redactor, apply bool) error {
+	reports, err := storage.QueryStore.RedactStoredQueries(redactor.Query, redactor.FullQuery, apply)
+	if err != nil {
+		return err
+	}
+
+	action := "would change"
+	if apply {
+		action = "changed"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TABLE\tCOLUMN\tROWS\t%s\n", strings.ToUpper(action))
+	total := 0
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", r.Table, r.Column, r.Scanned, r.Changed)
+		total += r.Changed
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nRedaction %s %d stored values\n", action, total)
+	return nil
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// OtherLabelValue replaces label values beyond a system's cardinality budget
+const OtherLabelValue = "__other__"
+
+const (
+	// labelScoreDecay is applied to every tracked value's score once per
+	// snapshot, so the kept values follow recent activity without flapping on
+	// every snapshot
+	labelScoreDecay = 0.98
+	// minLabelScore drops values that have not been seen for a long while
+	minLabelScore = 0.01
+	// trackedValuesFactor bounds the tracked values of a label to a multiple of its budget
+	trackedValuesFactor = 10
+)
+
+// activityLabels are the cc_pg_stat_activity labels that may be dropped or
+// limited. System labels and __name__ are never touched.
+var activityLabels = map[string]bool{
+	"application_name": true,
+	"backend_type":     true,
+	"client_addr":      true,
+	"datname":          true,
+	"query_fp":         true,
+	"state":            true,
+	"usename":          true,
+	"wait_event_name":  true,
+}
+
+// activityLabelPolicy rewrites the labels of cc_pg_stat_activity series
+// according to config.ActivityLabelsConfig. A nil policy leaves series as is.
+type activityLabelPolicy struct {
+	drop       map[string]bool
+	ipv4Prefix int
+	ipv6Prefix int
+	limits     map[string]int
+}
+
+func newActivityLabelPolicy(cfg config.ActivityLabelsConfig) (*activityLabelPolicy, error) {
+	policy := &activityLabelPolicy{
+		drop:       make(map[string]bool),
+		ipv4Prefix: cfg.ClientAddrIPv4Prefix,
+		ipv6Prefix: cfg.ClientAddrIPv6Prefix,
+		limits:     make(map[string]int),
+	}
+
+	for _, label := range cfg.DropLabels {
+		if !activityLabels[label] {
+			return nil, fmt.Errorf("cannot drop unknown activity label %q", label)
+		}
+		policy.drop[label] = true
+	}
+
+	if policy.ipv4Prefix < 0 || policy.ipv4Prefix > 32 {
+		return nil, fmt.Errorf("client_addr_ipv4_prefix must be between 0 and 32, got %d", policy.ipv4Prefix)
+	}
+	if policy.ipv6Prefix < 0 || policy.ipv6Prefix > 128 {
+		return nil, fmt.Errorf("client_addr_ipv6_prefix must be between 0 and 128, got %d", policy.ipv6Prefix)
+	}
+
+	for label, limit := range cfg.CardinalityLimits {
+		if !activityLabels[label] {
+			return nil, fmt.Errorf("cannot limit unknown activity label %q", label)
+		}
+		if limit <= 0 {
+			return nil, fmt.Errorf("cardinality limit of %s must be positive, got %d", label, limit)
+		}
+		if !policy.drop[label] {
+			policy.limits[label] = limit
+		}
+	}
+
+	return policy, nil
+}
+
+// labelCardinality tracks decayed backend counts per label value of a system
+type labelCardinality struct {
+	mu     sync.Mutex
+	scores map[string]map[string]float64
+}
+
+var cardinalityBySystem = struct {
+	sync.Mutex
+	trackers map[SystemInfo]*labelCardinality
+}{trackers: make(map[SystemInfo]*labelCardinality)}
+
+func cardinalityTracker(systemInfo SystemInfo) *labelCardinality {
+	cardinalityBySystem.Lock()
+	defer cardinalityBySystem.Unlock()
+
+	tracker, ok := cardinalityBySystem.trackers[systemInfo]
+	if !ok {
+		tracker = &labelCardinality{scores: make(map[string]map[string]float64)}
+		cardinalityBySystem.trackers[systemInfo] = tracker
+	}
+	return tracker
+}
+
+// keep updates the scores of label with this snapshot's backend counts and
+// returns the values within budget
+func (c *labelCardinality) keep(label string, counts map[string]float64, limit int) map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	scores, ok := c.scores[label]
+	if !ok {
+		scores = make(map[string]float64)
+		c.scores[label] = scores
+	}
+
+	for value := range scores {
+		scores[value] *= labelScoreDecay
+	}
+	for value, count := range counts {
+		scores[value] += count
+	}
+
+	ranked := make([]string, 0, len(scores))
+	for value, score := range scores {
+		if score < minLabelScore && counts[value] == 0 {
+			delete(scores, value)
+			continue
+		}
+		ranked = append(ranked, value)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+
+	for _, value := range ranked[min(len(ranked), limit*trackedValuesFactor):] {
+		delete(scores, value)
+	}
+
+	kept := make(map[string]bool, limit)
+	for _, value := range ranked[:min(len(ranked), limit)] {
+		kept[value] = true
+	}
+	return kept
+}
+
+// apply drops, buckets and folds the labels of the given cc_pg_stat_activity
+// series, merging series that end up with identical labels. For every limited
+// label it adds cc_pg_stat_activity_folded_values and
+// cc_pg_stat_activity_folded_backends, counting what was folded into
+// OtherLabelValue.
+func (p *activityLabelPolicy) apply(systemInfo SystemInfo, series []prompb.TimeSeries, timestamp int64) []prompb.TimeSeries {
+	if p == nil {
+		return series
+	}
+
+	for i := range series {
+		labels := series[i].Labels[:0]
+		for _, label := range series[i].Labels {
+			if p.drop[label.Name] {
+				continue
+			}
+			if label.Name == "client_addr" {
+				label.Value = p.bucketClientAddr(label.Value)
+			}
+			labels = append(labels, label)
+		}
+		series[i].Labels = labels
+	}
+
+	var foldMetrics []prompb.TimeSeries
+	if len(p.limits) > 0 {
+		tracker := cardinalityTracker(systemInfo)
+
+		limited := make([]string, 0, len(p.limits))
+		for label := range p.limits {
+			limited = append(limited, label)
+		}
+		sort.Strings(limited)
+
+		for _, label := range limited {
+			counts := make(map[string]float64)
+			for _, s := range series {
+				if value, ok := labelValue(s.Labels, label); ok {
+					counts[value] += s.Samples[0].Value
+				}
+			}
+
+			kept := tracker.keep(label, counts, p.limits[label])
+
+			foldedValues, foldedBackends := 0.0, 0.0
+			for value, count := range counts {
+				if !kept[value] {
+					foldedValues++
+					foldedBackends += count
+				}
+			}
+
+			for i := range series {
+				for j := range series[i].Labels {
+					if series[i].Labels[j].Name == label && !kept[series[i].Labels[j].Value] {
+						series[i].Labels[j].Value = OtherLabelValue
+					}
+				}
+			}
+
+			foldLabels := []prompb.Label{{Name: "label", Value: label}}
+			foldMetrics = append(foldMetrics,
+				createTimeSeries(systemInfo, "cc_pg_stat_activity_folded_values", foldLabels, foldedValues, timestamp),
+				createTimeSeries(systemInfo, "cc_pg_stat_activity_folded_backends", foldLabels, foldedBackends, timestamp),
+			)
+		}
+	}
+
+	return append(mergeSeries(series), foldMetrics...)
+}
+
+// bucketClientAddr replaces an IP address with the network of the configured
+// prefix length. Values that are not IP addresses are returned unchanged.
+func (p *activityLabelPolicy) bucketClientAddr(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		if p.ipv4Prefix == 0 {
+			return addr
+		}
+		network := net.IPNet{IP: ip4.Mask(net.CIDRMask(p.ipv4Prefix, 32)), Mask: net.CIDRMask(p.ipv4Prefix, 32)}
+		return network.String()
+	}
+
+	if p.ipv6Prefix == 0 {
+		return addr
+	}
+	network := net.IPNet{IP: ip.Mask(net.CIDRMask(p.ipv6Prefix, 128)), Mask: net.CIDRMask(p.ipv6Prefix, 128)}
+	return network.String()
+}
+
+// mergeSeries sums the single-sample series that share identical labels
+func mergeSeries(series []prompb.TimeSeries) []prompb.TimeSeries {
+	merged := make([]prompb.TimeSeries, 0, len(series))
+	indexByKey := make(map[string]int, len(series))
+
+	for _, s := range series {
+		key := getMetricKey(s)
+		if i, ok := indexByKey[key]; ok {
+			merged[i].Samples[0].Value += s.Samples[0].Value
+			continue
+		}
+		indexByKey[key] = len(merged)
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+func labelValue(labels []prompb.Label, name string) (string, bool) {
+	for _, label := range labels {
+		if label.Name == name {
+			return label.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func activitySnapshot(backends ...*pganalyze_collector.Backend) *pganalyze_collector.CompactSnapshot {
+	return &pganalyze_collector.CompactSnapshot{
+		Data: &pganalyze_collector.CompactSnapshot_ActivitySnapshot{
+			ActivitySnapshot: &pganalyze_collector.CompactActivitySnapshot{Backends: backends},
+		},
+		BaseRefs: getTestBaseRefs(),
+	}
+}
+
+// seriesByLabel sums cc_pg_stat_activity samples per value of label, and
+// returns the fold metrics keyed by metric name and label
+func seriesByLabel(metrics []prompb.TimeSeries, label string) (map[string]float64, map[string]float64) {
+	activity := make(map[string]float64)
+	folded := make(map[string]float64)
+	for _, m := range metrics {
+		name, _ := labelValue(m.Labels, "__name__")
+		if name == "cc_pg_stat_activity" {
+			value, _ := labelValue(m.Labels, label)
+			activity[value] += m.Samples[0].Value
+			continue
+		}
+		foldedLabel, _ := labelValue(m.Labels, "label")
+		folded[name+"/"+foldedLabel] = m.Samples[0].Value
+	}
+	return activity, folded
+}
+
+func TestActivityLabelsCardinalityLimit(t *testing.T) {
+	policy, err := newActivityLabelPolicy(config.ActivityLabelsConfig{
+		CardinalityLimits: map[string]int{"application_name": 2},
+	})
+	require.NoError(t, err)
+	sysInfo := createTestSystemInfo("cardinality-limit")
+
+	snapshot := activitySnapshot(
+		createTestBackend(1, "web", "active"),
+		createTestBackend(2, "web", "active"),
+		createTestBackend(3, "web", "active"),
+		createTestBackend(4, "worker", "active"),
+		createTestBackend(5, "worker", "active"),
+		createTestBackend(6, "psql-1", "active"),
+		createTestBackend(7, "psql-2", "active"),
+	)
+
+	metrics := compactSnapshotMetrics(snapshot, sysInfo, 100, policy)
+	activity, folded := seriesByLabel(metrics, "application_name")
+
+	assert.Equal(t, map[string]float64{"web": 3, "worker": 2, OtherLabelValue: 2}, activity)
+	assert.Equal(t, map[string]float64{
+		"cc_pg_stat_activity_folded_values/application_name":   2,
+		"cc_pg_stat_activity_folded_backends/application_name": 2,
+	}, folded)
+
+	// A value that was active before keeps its slot through a quiet snapshot
+	snapshot = activitySnapshot(
+		createTestBackend(1, "web", "active"),
+		createTestBackend(6, "psql-3", "active"),
+	)
+	metrics = compactSnapshotMetrics(snapshot, sysInfo, 110, policy)
+	activity, _ = seriesByLabel(metrics, "application_name")
+	assert.Equal(t, map[string]float64{"web": 1, OtherLabelValue: 1}, activity)
+}
+
+func TestActivityLabelsDropAndBucket(t *testing.T) {
+	policy, err := newActivityLabelPolicy(config.ActivityLabelsConfig{
+		DropLabels:           []string{"application_name"},
+		ClientAddrIPv4Prefix: 24,
+		ClientAddrIPv6Prefix: 64,
+	})
+	require.NoError(t, err)
+
+	backend1 := createTestBackend(1, "web-1", "active")
+	backend1.ClientAddr = "10.0.1.17"
+	backend2 := createTestBackend(2, "web-2", "active")
+	backend2.ClientAddr = "10.0.1.200"
+	backend3 := createTestBackend(3, "web-3", "active")
+	backend3.ClientAddr = "2001:db8::1"
+
+	metrics := compactSnapshotMetrics(activitySnapshot(backend1, backend2, backend3), createTestSystemInfo("drop-bucket"), 100, policy)
+	activity, folded := seriesByLabel(metrics, "client_addr")
+
+	assert.Equal(t, map[string]float64{"10.0.1.0/24": 2, "2001:db8::/64": 1}, activity)
+	assert.Empty(t, folded)
+	for _, m := range metrics {
+		_, ok := labelValue(m.Labels, "application_name")
+		assert.False(t, ok, "application_name should be dropped")
+	}
+}
+
+func TestActivityLabelsConfigValidation(t *testing.T) {
+	_, err := newActivityLabelPolicy(config.ActivityLabelsConfig{})
+	assert.NoError(t, err)
+	_, err = newActivityLabelPolicy(config.ActivityLabelsConfig{DropLabels: []string{"sys_id"}})
+	assert.Error(t, err)
+	_, err = newActivityLabelPolicy(config.ActivityLabelsConfig{CardinalityLimits: map[string]int{"query_fp": 0}})
+	assert.Error(t, err)
+	_, err = newActivityLabelPolicy(config.ActivityLabelsConfig{ClientAddrIPv4Prefix: 33})
+	assert.Error(t, err)
+}
+
+func TestFullSnapshotLeavesActivitySeriesToActivitySnapshots(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"__name__":"cc_pg_stat_activity","sys_id":"db1"},"value":[1700000000,"3"]},
+			{"metric":{"__name__":"cc_pg_stat_activity_folded_values","sys_id":"db1","label":"query_fp"},"value":[1700000000,"12"]},
+			{"metric":{"__name__":"cc_relation_size_bytes","sys_id":"db1"},"value":[1700000000,"8192"]}
+		]}}`))
+	}))
+	t.Cleanup(prometheus.Close)
+
+	endpoint, err := url.Parse(prometheus.URL)
+	require.NoError(t, err)
+	systemInfo := SystemInfo{SystemID: "db1", SystemType: "self_hosted"}
+	t.Cleanup(func() { delete(previousMetrics, systemInfo) })
+
+	promClient := &prometheusClient{Client: prometheus.Client(), endpoint: *endpoint}
+	require.NoError(t, initializePreviousMetrics(promClient, systemInfo, FullSnapshotType))
+
+	previous := previousMetrics[systemInfo][FullSnapshotType]
+	require.Len(t, previous, 1)
+	assert.Contains(t, previous[0].Labels, prompb.Label{Name: "__name__", Value: "cc_relation_size_bytes"})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bufio"
+	"collector-api/internal/config"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// backfillMetrics writes metrics that are too old for the live TSDB as blocks
+// into the Prometheus data directory. It is a variable so tests can replace it.
+var backfillMetrics = createBlocksFromMetrics
+
+func createBlocksFromMetrics(cfg *config.Config, metrics []prompb.TimeSeries) error {
+	dataDir, err := os.MkdirTemp("", "prometheus-backfill-*")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	input := path.Join(dataDir, "metrics.om")
+	f, err := os.Create(input)
+	if err != nil {
+		return fmt.Errorf("create backfill input: %w", err)
+	}
+	if err := writeOpenMetrics(f, metrics); err != nil {
+		f.Close()
+		return fmt.Errorf("write backfill input: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close backfill input: %w", err)
+	}
+
+	blocksDir := path.Join(dataDir, "blocks")
+	cmd := exec.Command("../../prometheus/promtool",
+		"tsdb", "create-blocks-from", "openmetrics",
+		input, blocksDir)
+
+	log.Printf("Running promtool command: %v", cmd.Args)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("create blocks from openmetrics: %v\nOutput: %s", err, output)
+	}
+
+	if cfg.Debug {
+		log.Printf("Successfully created backfill blocks. Output: %s", output)
+	}
+
+	return moveBlocks(cfg, blocksDir)
+}
+
+// writeOpenMetrics writes metrics in the OpenMetrics text format read by
+// promtool. Samples are grouped by metric name, as the format requires, and
+// ordered by series and time. Stale markers and other non-finite values are
+// left out.
+func writeOpenMetrics(w io.Writer, metrics []prompb.TimeSeries) error {
+	type sample struct {
+		name      string
+		series    string
+		value     float64
+		timestamp int64
+	}
+
+	var samples []sample
+	for _, ts := range metrics {
+		labels := make([]prompb.Label, len(ts.Labels))
+		copy(labels, ts.Labels)
+		sortLabels(labels)
+
+		name := ""
+		var series strings.Builder
+		for _, label := range labels {
+			if label.Name == "__name__" {
+				name = label.Value
+				continue
+			}
+			if series.Len() > 0 {
+				series.WriteByte(',')
+			}
+			series.WriteString(label.Name)
+			series.WriteString(`="`)
+			series.WriteString(escapeLabelValue(label.Value))
+			series.WriteByte('"')
+		}
+		if name == "" {
+			continue
+		}
+
+		for _, s := range ts.Samples {
+			if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+				continue
+			}
+			samples = append(samples, sample{name: name, series: series.String(), value: s.Value, timestamp: s.Timestamp})
+		}
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		if samples[i].series != samples[j].series {
+			return samples[i].series < samples[j].series
+		}
+		return samples[i].timestamp < samples[j].timestamp
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, s := range samples {
+		fmt.Fprintf(bw, "%s{%s} %s %d.%03d\n", s.name, s.series,
+			strconv.FormatFloat(s.value, 'g', -1, 64), s.timestamp/1000, s.timestamp%1000)
+	}
+	fmt.Fprintln(bw, "# EOF")
+	return bw.Flush()
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// moveBlocks copies the TSDB blocks in dataDir into the Prometheus data
+// directory, which loads them on its next block reload
+func moveBlocks(cfg *config.Config, dataDir string) error {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return fmt.Errorf("read data directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		source := path.Join(dataDir, entry.Name())
+		dest := path.Join("../../prometheus_data", entry.Name())
+
+		if err := copyDir(source, dest); err != nil {
+			return fmt.Errorf("copy block %s: %w", entry.Name(), err)
+		}
+
+		// Clean up source directory after successful copy
+		if err := os.RemoveAll(source); err != nil {
+			log.Printf("Warning: Failed to clean up source directory %s: %v", source, err)
+		}
+
+		if cfg.Debug {
+			log.Printf("Copied block %s to Prometheus data directory", entry.Name())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// counterMetrics are the full snapshot metrics of cumulative Postgres
+// statistics. The collector reports them as the change since its previous
+// snapshot, so a negative value means the statistic was reset in between
+// (pg_stat_reset, pg_stat_statements_reset or a failover).
+var counterMetrics = map[string]bool{
+	"cc_db_xact_commit":           true,
+	"cc_db_xact_rollback":         true,
+	"cc_query_calls":              true,
+	"cc_query_total_time_seconds": true,
+	"cc_relation_idx_scan":        true,
+	"cc_relation_seq_scan":        true,
+	"cc_relation_seq_tup_read":    true,
+	"cc_relation_idx_tup_fetch":   true,
+	"cc_relation_n_tup_ins":       true,
+	"cc_relation_n_tup_upd":       true,
+	"cc_relation_n_tup_del":       true,
+	"cc_relation_n_tup_hot_upd":   true,
+	"cc_relation_heap_blks_read":  true,
+	"cc_relation_heap_blks_hit":   true,
+	"cc_relation_idx_blks_read":   true,
+	"cc_relation_idx_blks_hit":    true,
+	"cc_relation_toast_blks_read": true,
+	"cc_relation_toast_blks_hit":  true,
+	"cc_relation_tidx_blks_read":  true,
+	"cc_relation_tidx_blks_hit":   true,
+	"cc_index_scan_count":         true,
+}
+
+// statsResetsMetric counts the series found reset, per counter metric
+const statsResetsMetric = "cc_stats_resets_total"
+
+// counterPolicy derives reset-safe series from counterMetrics: a running
+// <name>_total counter and a <name>_delta gauge of the increase per snapshot.
+// A nil policy derives nothing.
+type counterPolicy struct {
+	totals bool
+	deltas bool
+}
+
+func newCounterPolicy(cfg config.CounterMetricsConfig) *counterPolicy {
+	if !cfg.Totals && !cfg.Deltas {
+		return nil
+	}
+	return &counterPolicy{totals: cfg.Totals, deltas: cfg.Deltas}
+}
+
+// counterState holds the running totals of a system, by series key, and the
+// resets seen, by metric name
+type counterState struct {
+	totals map[string]float64
+	resets map[string]float64
+}
+
+var counterStates = struct {
+	sync.Mutex
+	systems map[SystemInfo]*counterState
+}{systems: make(map[SystemInfo]*counterState)}
+
+// apply appends the derived series for the counter metrics in series. Totals
+// are only advanced by snapshots processed in order; a total whose series is
+// missing from a snapshot starts over, which PromQL treats as a counter reset.
+func (p *counterPolicy) apply(systemInfo SystemInfo, series []prompb.TimeSeries, timestamp int64, inOrder bool) []prompb.TimeSeries {
+	if p == nil {
+		return series
+	}
+
+	var state *counterState
+	totals := make(map[string]float64)
+	if p.totals && inOrder {
+		counterStates.Lock()
+		defer counterStates.Unlock()
+
+		state = counterStates.systems[systemInfo]
+		if state == nil {
+			state = &counterState{totals: make(map[string]float64), resets: make(map[string]float64)}
+			counterStates.systems[systemInfo] = state
+		}
+	}
+
+	var derived []prompb.TimeSeries
+	resets := make(map[string]float64)
+
+	for _, s := range series {
+		name, _ := labelValue(s.Labels, "__name__")
+		if !counterMetrics[name] {
+			continue
+		}
+
+		increase := s.Samples[0].Value
+		if _, ok := resets[name]; !ok {
+			resets[name] = 0
+		}
+		if increase < 0 {
+			increase = 0
+			resets[name]++
+		}
+
+		if p.deltas {
+			derived = append(derived, renameSeries(s, name+"_delta", increase))
+		}
+
+		if state != nil {
+			total := renameSeries(s, name+"_total", 0)
+			key := getMetricKey(total)
+			total.Samples[0].Value = state.totals[key] + increase
+			totals[key] = total.Samples[0].Value
+			derived = append(derived, total)
+		}
+	}
+
+	if state != nil {
+		state.totals = totals
+
+		names := make([]string, 0, len(resets))
+		for name := range resets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			state.resets[name] += resets[name]
+			derived = append(derived, createTimeSeries(systemInfo, statsResetsMetric, []prompb.Label{
+				{Name: "metric", Value: name},
+			}, state.resets[name], timestamp))
+		}
+	}
+
+	return append(series, derived...)
+}
+
+// renameSeries returns a copy of the single-sample series s named name
+func renameSeries(s prompb.TimeSeries, name string, value float64) prompb.TimeSeries {
+	labels := make([]prompb.Label, len(s.Labels))
+	copy(labels, s.Labels)
+	for i := range labels {
+		if labels[i].Name == "__name__" {
+			labels[i].Value = name
+		}
+	}
+
+	return prompb.TimeSeries{
+		Labels:  labels,
+		Samples: []prompb.Sample{{Timestamp: s.Samples[0].Timestamp, Value: value}},
+	}
+}
+
+// counterMetadata types the derived _total series as counters in a remote
+// write request
+func counterMetadata(series []prompb.TimeSeries) []prompb.MetricMetadata {
+	seen := make(map[string]bool)
+	var metadata []prompb.MetricMetadata
+
+	for _, s := range series {
+		name, _ := labelValue(s.Labels, "__name__")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		isCounter := name == statsResetsMetric ||
+			(strings.HasSuffix(name, "_total") && counterMetrics[strings.TrimSuffix(name, "_total")])
+		if isCounter {
+			metadata = append(metadata, prompb.MetricMetadata{
+				Type:             prompb.MetricMetadata_COUNTER,
+				MetricFamilyName: name,
+			})
+		}
+	}
+
+	return metadata
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+)
+
+// seriesValues returns the value of every series named metric, by datname
+// label (or metric label for cc_stats_resets_total)
+func seriesValues(ts []prompb.TimeSeries, metric string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, s := range ts {
+		if name, _ := labelValue(s.Labels, "__name__"); name != metric {
+			continue
+		}
+		key, ok := labelValue(s.Labels, "datname")
+		if !ok {
+			key, _ = labelValue(s.Labels, "metric")
+		}
+		values[key] = s.Samples[0].Value
+	}
+	return values
+}
+
+func TestCounterPolicyTotals(t *testing.T) {
+	now := time.Now()
+	systemInfo := createTestSystemInfo("counters")
+	policy := newCounterPolicy(config.CounterMetricsConfig{Totals: true, Deltas: true})
+
+	snapshot := func(app, reporting float64) []prompb.TimeSeries {
+		return []prompb.TimeSeries{
+			createTestTimeSeries("cc_db_xact_commit", map[string]string{"datname": "app"}, app, now),
+			createTestTimeSeries("cc_db_xact_commit", map[string]string{"datname": "reporting"}, reporting, now),
+			createTestTimeSeries("cc_db_frozen_xid_age", map[string]string{"datname": "app"}, 1000, now),
+		}
+	}
+
+	ts := policy.apply(systemInfo, snapshot(10, 5), 0, true)
+	assert.Equal(t, map[string]float64{"app": 10, "reporting": 5}, seriesValues(ts, "cc_db_xact_commit_total"))
+	assert.Empty(t, seriesValues(ts, "cc_db_frozen_xid_age_total"), "gauges are not counters")
+
+	// The reporting database had its statistics reset since the last snapshot
+	ts = policy.apply(systemInfo, snapshot(7, -4), 0, true)
+	assert.Equal(t, map[string]float64{"app": 17, "reporting": 5}, seriesValues(ts, "cc_db_xact_commit_total"))
+	assert.Equal(t, map[string]float64{"app": 7, "reporting": 0}, seriesValues(ts, "cc_db_xact_commit_delta"))
+	assert.Equal(t, map[string]float64{"cc_db_xact_commit": 1}, seriesValues(ts, "cc_stats_resets_total"))
+
+	// Out-of-order snapshots get deltas, but do not move the totals
+	ts = policy.apply(systemInfo, snapshot(100, 100), 0, false)
+	assert.Empty(t, seriesValues(ts, "cc_db_xact_commit_total"))
+	assert.Equal(t, map[string]float64{"app": 100, "reporting": 100}, seriesValues(ts, "cc_db_xact_commit_delta"))
+
+	ts = policy.apply(systemInfo, snapshot(1, 1), 0, true)
+	assert.Equal(t, map[string]float64{"app": 18, "reporting": 6}, seriesValues(ts, "cc_db_xact_commit_total"))
+	assert.Equal(t, map[string]float64{"cc_db_xact_commit": 1}, seriesValues(ts, "cc_stats_resets_total"))
+
+	// The raw series are passed through unchanged
+	assert.Equal(t, map[string]float64{"app": 1, "reporting": 1}, seriesValues(ts, "cc_db_xact_commit"))
+}
+
+func TestCounterPolicyDisabled(t *testing.T) {
+	assert.Nil(t, newCounterPolicy(config.CounterMetricsConfig{}))
+
+	series := []prompb.TimeSeries{createTestTimeSeries("cc_query_calls", nil, 3, time.Now())}
+	var policy *counterPolicy
+	assert.Equal(t, series, policy.apply(createTestSystemInfo("disabled"), series, 0, true))
+}
+
+func TestCounterMetadata(t *testing.T) {
+	now := time.Now()
+	metadata := counterMetadata([]prompb.TimeSeries{
+		createTestTimeSeries("cc_query_calls", nil, 1, now),
+		createTestTimeSeries("cc_query_calls_total", map[string]string{"query": "a"}, 1, now),
+		createTestTimeSeries("cc_query_calls_total", map[string]string{"query": "b"}, 1, now),
+		createTestTimeSeries("cc_stats_resets_total", nil, 0, now),
+		createTestTimeSeries("cc_other_total", nil, 0, now),
+	})
+
+	assert.Equal(t, []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "cc_query_calls_total"},
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "cc_stats_resets_total"},
+	}, metadata)
+}
@@ -15,10 +15,8 @@ func GrantHandler(w http.ResponseWriter, r *http.Request) {
 	// Load configuration
 	cfg, err := config.LoadConfigWithDefaultPath()
 	if err != nil {
+		log.Printf("Error loading config: %v", err)
 		http.Error(w, "Failed to load config", http.StatusInternalServerError)
-		if cfg.Debug {
-			log.Printf("Error loading config: %v", err)
-		}
 		return
 	}
 
@@ -47,7 +45,7 @@ func GrantHandler(w http.ResponseWriter, r *http.Request) {
 		SentryDsn:        "",
 		EnableActivity:   true,
 		EnableLogs:       true,
-		SchemaTableLimit: 0,
+		SchemaTableLimit: cfg.SchemaTableLimit,
 		Features: models.GrantFeatures{
 			Logs:                        true,
 			StatementResetFrequency:     0,
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"collector-api/internal/storage"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+)
+
+// snapshotIndexes returns the definition and usage of every index in a full
+// snapshot. Indexes without statistics report zero size and scans.
+func snapshotIndexes(snapshot *collector_proto.FullSnapshot) []storage.IndexRep {
+	stats := make(map[int32]*collector_proto.IndexStatistic, len(snapshot.IndexStatistics))
+	for _, stat := range snapshot.IndexStatistics {
+		stats[stat.IndexIdx] = stat
+	}
+
+	indexes := make([]storage.IndexRep, 0, len(snapshot.IndexInformations))
+	for _, info := range snapshot.IndexInformations {
+		if int(info.IndexIdx) >= len(snapshot.IndexReferences) || int(info.RelationIdx) >= len(snapshot.RelationReferences) {
+			continue
+		}
+		ref := snapshot.IndexReferences[info.IndexIdx]
+		relation := snapshot.RelationReferences[info.RelationIdx]
+
+		idx := storage.IndexRep{
+			Database:      snapshot.DatabaseReferences[ref.DatabaseIdx].GetName(),
+			Schema:        ref.SchemaName,
+			Name:          ref.IndexName,
+			Relation:      relation.RelationName,
+			Definition:    info.IndexDef,
+			ConstraintDef: info.GetConstraintDef().GetValue(),
+			Type:          info.IndexType,
+			IsPrimary:     info.IsPrimary,
+			IsUnique:      info.IsUnique,
+			IsValid:       info.IsValid,
+		}
+		if stat, ok := stats[info.IndexIdx]; ok {
+			idx.SizeBytes = stat.SizeBytes
+			idx.ScanCount = stat.IdxScan
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes
+}
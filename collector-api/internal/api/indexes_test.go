@@ -0,0 +1,42 @@
+package api
+
+import (
+	"collector-api/internal/storage"
+	"testing"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSnapshotIndexes(t *testing.T) {
+	pbBytes, err := readAndDecompressSnapshot("test_data/full-snapshot-rds-1.binpb")
+	require.NoError(t, err)
+	var snapshot collector_proto.FullSnapshot
+	require.NoError(t, proto.Unmarshal(pbBytes, &snapshot))
+
+	indexes := snapshotIndexes(&snapshot)
+	require.Len(t, indexes, 3)
+
+	var accounts storage.IndexRep
+	for _, idx := range indexes {
+		if idx.Name == "pgbench_accounts_pkey" {
+			accounts = idx
+		}
+	}
+	assert.Equal(t, storage.IndexRep{
+		Database:      "postgres",
+		Schema:        "public",
+		Name:          "pgbench_accounts_pkey",
+		Relation:      "pgbench_accounts",
+		Definition:    "CREATE UNIQUE INDEX pgbench_accounts_pkey ON public.pgbench_accounts USING btree (aid)",
+		ConstraintDef: "PRIMARY KEY (aid)",
+		Type:          "btree",
+		IsPrimary:     true,
+		IsUnique:      true,
+		IsValid:       true,
+		SizeBytes:     22487040,
+		ScanCount:     2037884,
+	}, accounts)
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"collector-api/internal/storage"
+	"strings"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+)
+
+// snapshotInstance returns what a full snapshot reports about the instance
+// itself. The platform is the system type, e.g. amazon_rds or self_hosted. It
+// returns nil for failed runs and other snapshots without the system and
+// Postgres version, so that they do not blank the stored facts.
+func snapshotInstance(snapshot *collector_proto.FullSnapshot, collectedAt int64) *storage.InstanceRep {
+	if snapshot.FailedRun || snapshot.GetSystem() == nil || snapshot.GetPostgresVersion().GetShort() == "" {
+		return nil
+	}
+
+	info := snapshot.GetSystem().GetSystemInformation()
+	instance := &storage.InstanceRep{
+		PostgresVersion:  snapshot.GetPostgresVersion().GetShort(),
+		InstanceClass:    info.GetAmazonRds().GetInstanceClass(),
+		MemoryBytes:      int64(snapshot.GetSystem().GetMemoryStatistic().GetTotalBytes()),
+		LogicalCores:     int64(snapshot.GetSystem().GetCpuInformation().GetLogicalCoreCount()),
+		CollectorVersion: snapshot.CollectorVersion,
+		CollectedAt:      collectedAt,
+	}
+	if info != nil {
+		instance.Platform = strings.ToLower(strings.TrimSuffix(info.Type.String(), "_SYSTEM"))
+	}
+	return instance
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"collector-api/internal/storage"
+	"testing"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSnapshotInstance(t *testing.T) {
+	pbBytes, err := readAndDecompressSnapshot("test_data/full-snapshot-rds-1.binpb")
+	require.NoError(t, err)
+	var snapshot collector_proto.FullSnapshot
+	require.NoError(t, proto.Unmarshal(pbBytes, &snapshot))
+
+	assert.Equal(t, &storage.InstanceRep{
+		PostgresVersion:  "16.3",
+		Platform:         "amazon_rds",
+		InstanceClass:    "db.t4g.medium",
+		MemoryBytes:      4121206784,
+		LogicalCores:     2,
+		CollectorVersion: "pganalyze-collector 0.58.0",
+		CollectedAt:      1700000000,
+	}, snapshotInstance(&snapshot, 1700000000))
+
+	assert.Nil(t, snapshotInstance(&collector_proto.FullSnapshot{}, 1))
+	snapshot.FailedRun = true
+	assert.Nil(t, snapshotInstance(&snapshot, 1700000000))
+}
@@ -163,6 +163,7 @@ func (c *prometheusClient) RemoteWrite(data []prompb.TimeSeries) (*http.Response
 	// Create the write request
 	payload := &prompb.WriteRequest{
 		Timeseries: data,
+		Metadata:   counterMetadata(data),
 	}
 
 	// Marshal to protobuf
@@ -247,7 +248,7 @@ func createMultipleTimeSeries(systemInfo SystemInfo, metrics map[string]float64,
 }
 
 // fullSnapshotMetrics processes a FullSnapshot and generates Prometheus metrics, along with individual time-series tracking
-func fullSnapshotMetrics(snapshot *collector_proto.FullSnapshot, systemInfo SystemInfo, collectedAt int64) []prompb.TimeSeries {
+func fullSnapshotMetrics(snapshot *collector_proto.FullSnapshot, systemInfo SystemInfo, collectedAt int64, relations *relationPolicy) []prompb.TimeSeries {
 	var ts []prompb.TimeSeries
 	snapshotTimestamp := collectedAt * 1000 // in milli-seconds
 
@@ -264,11 +265,14 @@ func fullSnapshotMetrics(snapshot *collector_proto.FullSnapshot, systemInfo Syst
 	ts = append(ts, processQueryStats(snapshot, systemInfo, snapshotTimestamp)...)
 
 	// Process relation and index statistics
-	ts = append(ts, processRelationAndIndexStats(snapshot, systemInfo, snapshotTimestamp)...)
+	ts = append(ts, processRelationAndIndexStats(snapshot, systemInfo, snapshotTimestamp, relations)...)
 
 	// Process settings statistics
 	ts = append(ts, processSettingsStats(snapshot, systemInfo, snapshotTimestamp)...)
 
+	// Process replication statistics
+	ts = append(ts, processReplicationStats(snapshot, systemInfo, snapshotTimestamp)...)
+
 	return ts
 }
 
@@ -326,6 +330,9 @@ func processCPUStats(snapshot *collector_proto.FullSnapshot, systemInfo SystemIn
 	} else {
 		log.Println("Warning: snapshot.System.CpuStatistics is nil")
 	}
+	if info := snapshot.System.CpuInformation; info != nil && info.LogicalCoreCount > 0 {
+		ts = append(ts, createTimeSeries(systemInfo, "cc_system_cpu_logical_cores", nil, float64(info.LogicalCoreCount), timestamp))
+	}
 	return ts
 }
 
@@ -561,11 +568,12 @@ func processDatabaseStats(snapshot *collector_proto.FullSnapshot, systemInfo Sys
 
 	for _, dbStat := range snapshot.DatabaseStatictics {
 		dbName := snapshot.DatabaseReferences[dbStat.DatabaseIdx].Name
-		// Create multiple time-series for transaction commit, rollback, and frozen XID age
+		// Create multiple time-series for transaction commit, rollback, and frozen XID and multixact ID age
 		ts = append(ts, createMultipleTimeSeries(systemInfo, map[string]float64{
 			"cc_db_xact_commit":    float64(dbStat.XactCommit),
 			"cc_db_xact_rollback":  float64(dbStat.XactRollback),
 			"cc_db_frozen_xid_age": float64(dbStat.FrozenxidAge),
+			"cc_db_minmxid_age":    float64(dbStat.MinmxidAge),
 		}, []prompb.Label{
 			{Name: "datname", Value: dbName},
 		}, timestamp)...)
@@ -574,6 +582,42 @@ func processDatabaseStats(snapshot *collector_proto.FullSnapshot, systemInfo Sys
 	return ts
 }
 
+// processReplicationStats generates replication lag metrics. A standby reports
+// how far behind its replay is; a primary reports, per standby, the WAL bytes
+// not yet replayed there. Lags the collector could not determine are skipped.
+func processReplicationStats(snapshot *collector_proto.FullSnapshot, systemInfo SystemInfo, timestamp int64) []prompb.TimeSeries {
+	var ts []prompb.TimeSeries
+
+	replication := snapshot.GetReplication()
+	if replication == nil {
+		return ts
+	}
+
+	if replication.InRecovery {
+		ts = append(ts, createTimeSeries(systemInfo, "cc_replication_apply_byte_lag", nil, float64(replication.ApplyByteLag), timestamp))
+		if replication.ReplayTimestamp != nil {
+			ts = append(ts, createTimeSeries(systemInfo, "cc_replication_replay_lag_seconds", nil, float64(replication.ReplayTimestampAge), timestamp))
+		}
+	}
+
+	applicationNames := make(map[int32]string)
+	for _, info := range replication.StandbyInformations {
+		applicationNames[info.StandbyIdx] = info.ApplicationName
+	}
+
+	for _, stat := range replication.StandbyStatistics {
+		if stat.RemoteByteLag < 0 || stat.LocalByteLag < 0 || int(stat.StandbyIdx) >= len(replication.StandbyReferences) {
+			continue
+		}
+		ts = append(ts, createTimeSeries(systemInfo, "cc_replication_standby_byte_lag", []prompb.Label{
+			{Name: "application_name", Value: applicationNames[stat.StandbyIdx]},
+			{Name: "client_addr", Value: replication.StandbyReferences[stat.StandbyIdx].ClientAddr},
+		}, float64(stat.RemoteByteLag+stat.LocalByteLag), timestamp))
+	}
+
+	return ts
+}
+
 func processDatabaseReferences(snapshot *collector_proto.FullSnapshot, systemInfo SystemInfo, timestamp int64) []prompb.TimeSeries {
 	var ts []prompb.TimeSeries
 
@@ -617,74 +661,113 @@ func convertTimestampToMilliseconds(timestamp *collector_proto.NullTimestamp) fl
 	return float64(innerTs.Seconds*1000 + int64(innerTs.Nanos)/1000000)
 }
 
-// processRelationAndIndexStats generates relation and index statistics and tracks seen metrics
-func processRelationAndIndexStats(snapshot *collector_proto.FullSnapshot, systemInfo SystemInfo, timestamp int64) []prompb.TimeSeries {
+// processRelationAndIndexStats generates relation and index statistics for the tables selected by policy
+func processRelationAndIndexStats(snapshot *collector_proto.FullSnapshot, systemInfo SystemInfo, timestamp int64, policy *relationPolicy) []prompb.TimeSeries {
 	var ts []prompb.TimeSeries
 
-	for _, relStat := range snapshot.RelationStatistics {
-		relationName := snapshot.RelationReferences[relStat.RelationIdx].RelationName
-		schemaName := snapshot.RelationReferences[relStat.RelationIdx].SchemaName
-		databaseName := snapshot.DatabaseReferences[snapshot.RelationReferences[relStat.RelationIdx].DatabaseIdx].GetName()
-		// Create multiple time-series for relation size and sequential scan count
-		ts = append(ts, createMultipleTimeSeries(systemInfo, map[string]float64{
-			"cc_relation_size_bytes":          float64(relStat.SizeBytes),
-			"cc_relation_idx_scan":            float64(relStat.IdxScan),
-			"cc_relation_seq_scan":            float64(relStat.SeqScan),
-			"cc_relation_seq_tup_read":        float64(relStat.SeqTupRead),
-			"cc_relation_idx_tup_fetch":       float64(relStat.IdxTupFetch),
-			"cc_relation_n_tup_ins":           float64(relStat.NTupIns),
-			"cc_relation_n_tup_upd":           float64(relStat.NTupUpd),
-			"cc_relation_n_tup_del":           float64(relStat.NTupDel),
-			"cc_relation_n_tup_hot_upd":       float64(relStat.NTupHotUpd),
-			"cc_relation_n_live_tup":          float64(relStat.NLiveTup),
-			"cc_relation_n_dead_tup":          float64(relStat.NDeadTup),
-			"cc_relation_n_mod_since_analyze": float64(relStat.NModSinceAnalyze),
-			"cc_relation_n_ins_since_vacuum":  float64(relStat.NInsSinceVacuum),
-			"cc_relation_heap_blks_read":      float64(relStat.HeapBlksRead),
-			"cc_relation_heap_blks_hit":       float64(relStat.HeapBlksHit),
-			"cc_relation_idx_blks_read":       float64(relStat.IdxBlksRead),
-			"cc_relation_idx_blks_hit":        float64(relStat.IdxBlksHit),
-			"cc_relation_toast_blks_read":     float64(relStat.ToastBlksRead),
-			"cc_relation_toast_blks_hit":      float64(relStat.ToastBlksHit),
-			"cc_relation_tidx_blks_read":      float64(relStat.TidxBlksRead),
-			"cc_relation_tidx_blks_hit":       float64(relStat.TidxBlksHit),
-			"cc_relation_toast_size_bytes":    float64(relStat.ToastSizeBytes),
-			"cc_relation_analyzed_at":         convertTimestampToMilliseconds(relStat.AnalyzedAt),
-			"cc_relation_frozenxid_age":       float64(relStat.FrozenxidAge),
-			"cc_relation_minmxid_age":         float64(relStat.MinmxidAge),
-
-			// Statistics that are infrequently updated (e.g. by VACUUM, ANALYZE, and a few DDL commands)
-			"cc_relation_relpages":         float64(relStat.Relpages),
-			"cc_relation_reltuples":        float64(relStat.Reltuples),
-			"cc_relation_relfrozenxid":     float64(relStat.Relfrozenxid),
-			"cc_relation_relminmxid":       float64(relStat.Relminmxid),
-			"cc_relation_last_vacuum":      convertTimestampToMilliseconds(relStat.LastVacuum),
-			"cc_relation_last_autovacuum":  convertTimestampToMilliseconds(relStat.LastAutovacuum),
-			"cc_relation_last_analyze":     convertTimestampToMilliseconds(relStat.LastAnalyze),
-			"cc_relation_last_autoanalyze": convertTimestampToMilliseconds(relStat.LastAutoanalyze),
-			"cc_relation_toast_reltuples":  float64(relStat.ToastReltuples),
-			"cc_relation_toast_relpages":   float64(relStat.ToastRelpages),
-		}, []prompb.Label{
+	tables, reportedIndexes, skipped := policy.selectRelations(snapshot)
+
+	for _, table := range tables {
+		ref := snapshot.RelationReferences[table.relationIdx]
+		databaseName := snapshot.DatabaseReferences[ref.DatabaseIdx].GetName()
+		labels := []prompb.Label{
 			{Name: "datname", Value: databaseName},
-			{Name: "schema", Value: schemaName},
-			{Name: "relation", Value: relationName},
-		}, timestamp)...)
+			{Name: "schema", Value: ref.SchemaName},
+			{Name: "relation", Value: ref.RelationName},
+		}
+
+		ts = append(ts, createMultipleTimeSeries(systemInfo, table.metrics, labels, timestamp)...)
+		ts = append(ts, createMultipleTimeSeries(systemInfo, relationHealthMetrics(table, timestamp), labels, timestamp)...)
+		if table.partitions > 0 {
+			ts = append(ts, createTimeSeries(systemInfo, "cc_relation_partitions", labels, float64(table.partitions), timestamp))
+		}
+	}
+
+	indexRelations := make(map[int32]int32, len(snapshot.IndexInformations))
+	for _, info := range snapshot.IndexInformations {
+		indexRelations[info.IndexIdx] = info.RelationIdx
 	}
 
 	for _, idxStat := range snapshot.IndexStatistics {
-		indexName := snapshot.IndexReferences[idxStat.IndexIdx].IndexName
+		relationIdx, ok := indexRelations[idxStat.IndexIdx]
+		if policy != nil && (!ok || !reportedIndexes[relationIdx]) {
+			continue
+		}
+
+		// Index names are unique per schema, so the database and schema
+		// identify an index together with its name
+		indexRef := snapshot.IndexReferences[idxStat.IndexIdx]
+		labels := []prompb.Label{
+			{Name: "datname", Value: snapshot.DatabaseReferences[indexRef.DatabaseIdx].GetName()},
+			{Name: "schema", Value: indexRef.SchemaName},
+			{Name: "index", Value: indexRef.IndexName},
+		}
+
 		// Create multiple time-series for index size and scan count
 		ts = append(ts, createMultipleTimeSeries(systemInfo, map[string]float64{
 			"cc_index_size_bytes": float64(idxStat.SizeBytes),
 			"cc_index_scan_count": float64(idxStat.IdxScan),
-		}, []prompb.Label{
-			{Name: "index", Value: indexName},
-		}, timestamp)...)
+		}, labels, timestamp)...)
+
+		// The table of an index is a separate series, so that the index
+		// metrics do not grow another label
+		if ok && int(relationIdx) < len(snapshot.RelationReferences) {
+			ts = append(ts, createTimeSeries(systemInfo, "cc_index_info", append(labels,
+				prompb.Label{Name: "relation", Value: snapshot.RelationReferences[relationIdx].RelationName},
+			), 1, timestamp))
+		}
+	}
+
+	if policy != nil {
+		ts = append(ts, relationSkippedMetrics(systemInfo, skipped, timestamp)...)
 	}
 
 	return ts
 }
 
+// relationStatMetrics returns the cc_relation_* values of a single relation
+func relationStatMetrics(relStat *collector_proto.RelationStatistic) map[string]float64 {
+	return map[string]float64{
+		"cc_relation_size_bytes":          float64(relStat.SizeBytes),
+		"cc_relation_idx_scan":            float64(relStat.IdxScan),
+		"cc_relation_seq_scan":            float64(relStat.SeqScan),
+		"cc_relation_seq_tup_read":        float64(relStat.SeqTupRead),
+		"cc_relation_idx_tup_fetch":       float64(relStat.IdxTupFetch),
+		"cc_relation_n_tup_ins":           float64(relStat.NTupIns),
+		"cc_relation_n_tup_upd":           float64(relStat.NTupUpd),
+		"cc_relation_n_tup_del":           float64(relStat.NTupDel),
+		"cc_relation_n_tup_hot_upd":       float64(relStat.NTupHotUpd),
+		"cc_relation_n_live_tup":          float64(relStat.NLiveTup),
+		"cc_relation_n_dead_tup":          float64(relStat.NDeadTup),
+		"cc_relation_n_mod_since_analyze": float64(relStat.NModSinceAnalyze),
+		"cc_relation_n_ins_since_vacuum":  float64(relStat.NInsSinceVacuum),
+		"cc_relation_heap_blks_read":      float64(relStat.HeapBlksRead),
+		"cc_relation_heap_blks_hit":       float64(relStat.HeapBlksHit),
+		"cc_relation_idx_blks_read":       float64(relStat.IdxBlksRead),
+		"cc_relation_idx_blks_hit":        float64(relStat.IdxBlksHit),
+		"cc_relation_toast_blks_read":     float64(relStat.ToastBlksRead),
+		"cc_relation_toast_blks_hit":      float64(relStat.ToastBlksHit),
+		"cc_relation_tidx_blks_read":      float64(relStat.TidxBlksRead),
+		"cc_relation_tidx_blks_hit":       float64(relStat.TidxBlksHit),
+		"cc_relation_toast_size_bytes":    float64(relStat.ToastSizeBytes),
+		"cc_relation_analyzed_at":         convertTimestampToMilliseconds(relStat.AnalyzedAt),
+		"cc_relation_frozenxid_age":       float64(relStat.FrozenxidAge),
+		"cc_relation_minmxid_age":         float64(relStat.MinmxidAge),
+
+		// Statistics that are infrequently updated (e.g. by VACUUM, ANALYZE, and a few DDL commands)
+		"cc_relation_relpages":         float64(relStat.Relpages),
+		"cc_relation_reltuples":        float64(relStat.Reltuples),
+		"cc_relation_relfrozenxid":     float64(relStat.Relfrozenxid),
+		"cc_relation_relminmxid":       float64(relStat.Relminmxid),
+		"cc_relation_last_vacuum":      convertTimestampToMilliseconds(relStat.LastVacuum),
+		"cc_relation_last_autovacuum":  convertTimestampToMilliseconds(relStat.LastAutovacuum),
+		"cc_relation_last_analyze":     convertTimestampToMilliseconds(relStat.LastAnalyze),
+		"cc_relation_last_autoanalyze": convertTimestampToMilliseconds(relStat.LastAutoanalyze),
+		"cc_relation_toast_reltuples":  float64(relStat.ToastReltuples),
+		"cc_relation_toast_relpages":   float64(relStat.ToastRelpages),
+	}
+}
+
 // processSettingsStats generates metrics for each setting in the FullSnapshot and tracks seen metrics
 func processSettingsStats(snapshot *collector_proto.FullSnapshot, systemInfo SystemInfo, timestamp int64) []prompb.TimeSeries {
 	var ts []prompb.TimeSeries
@@ -814,9 +897,42 @@ func defaultString(s, defaultVal string) string {
 	return s
 }
 
+// oldestTransactionMetrics generates, per database, the age in seconds of the
+// oldest transaction open at collectedAt. Databases without an open
+// transaction have no series.
+func oldestTransactionMetrics(snapshot *collector_proto.CompactSnapshot, systemInfo SystemInfo, collectedAt int64) []prompb.TimeSeries {
+	baseRef := snapshot.GetBaseRefs()
+	oldest := make(map[string]float64)
+
+	for _, backend := range snapshot.GetActivitySnapshot().GetBackends() {
+		if backend.GetXactStart() == nil {
+			continue
+		}
+
+		datname := PgInternal
+		if backend.GetHasDatabaseIdx() && int(backend.GetDatabaseIdx()) < len(baseRef.GetDatabaseReferences()) {
+			datname = baseRef.GetDatabaseReferences()[backend.GetDatabaseIdx()].GetName()
+		}
+
+		age := math.Max(float64(collectedAt-backend.GetXactStart().GetSeconds()), 0)
+		if current, ok := oldest[datname]; !ok || age > current {
+			oldest[datname] = age
+		}
+	}
+
+	var ts []prompb.TimeSeries
+	for datname, age := range oldest {
+		ts = append(ts, createTimeSeries(systemInfo, "cc_backend_max_xact_age_seconds", []prompb.Label{
+			{Name: "datname", Value: datname},
+		}, age, collectedAt*1000))
+	}
+	return ts
+}
+
 // compactSnapshotMetrics processes a compact snapshot and returns time series for each backend
 // It also returns a map of seen backends for stale marker generation
-func compactSnapshotMetrics(snapshot *collector_proto.CompactSnapshot, systemInfo SystemInfo, collectedAt int64) []prompb.TimeSeries {
+// Labels are then dropped, bucketed and folded according to labelPolicy
+func compactSnapshotMetrics(snapshot *collector_proto.CompactSnapshot, systemInfo SystemInfo, collectedAt int64, labelPolicy *activityLabelPolicy) []prompb.TimeSeries {
 	snapshotTimestamp := collectedAt * 1000 // in milliseconds
 	baseRef := snapshot.GetBaseRefs()
 	backendCounts := make(map[string]float64)
@@ -878,7 +994,7 @@ func compactSnapshotMetrics(snapshot *collector_proto.CompactSnapshot, systemInf
 		})
 	}
 
-	return ts
+	return labelPolicy.apply(systemInfo, ts, snapshotTimestamp)
 }
 
 func createStaleMarkers(prevMetrics, currentMetrics []prompb.TimeSeries, timestamp int64) []prompb.TimeSeries {
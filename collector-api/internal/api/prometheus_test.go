@@ -93,7 +93,7 @@ func TestCompactSnapshotMetrics(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			metrics := compactSnapshotMetrics(tc.snapshot, sysInfo, 0)
+			metrics := compactSnapshotMetrics(tc.snapshot, sysInfo, 0, nil)
 			assert.Equal(t, tc.expected, len(metrics), "Unexpected number of time series")
 
 			// Check if all metrics have the correct system info labels
@@ -242,8 +242,8 @@ func TestMultipleSystemsHandling(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			metrics1 := compactSnapshotMetrics(tc.snapshot1, system1, 0)
-			metrics2 := compactSnapshotMetrics(tc.snapshot2, system2, 0)
+			metrics1 := compactSnapshotMetrics(tc.snapshot1, system1, 0, nil)
+			metrics2 := compactSnapshotMetrics(tc.snapshot2, system2, 0, nil)
 
 			assert.Equal(t, tc.expectedMetrics[system1], len(metrics1), "Unexpected number of metrics for system 1")
 			assert.Equal(t, tc.expectedMetrics[system2], len(metrics2), "Unexpected number of metrics for system 2")
@@ -290,3 +290,106 @@ func getLabelValue(labels []prompb.Label, name string) string {
 	}
 	return ""
 }
+
+func TestOldestTransactionMetrics(t *testing.T) {
+	sysInfo := createTestSystemInfo("test-system-1")
+	collectedAt := int64(1_700_000_000)
+
+	inTransaction := func(datIdx int32, xactStart int64) *pganalyze_collector.Backend {
+		b := createTestBackend(1, "app", "idle in transaction")
+		b.HasDatabaseIdx, b.DatabaseIdx = true, datIdx
+		b.XactStart = &timestamppb.Timestamp{Seconds: xactStart}
+		return b
+	}
+
+	snapshot := &pganalyze_collector.CompactSnapshot{
+		Data: &pganalyze_collector.CompactSnapshot_ActivitySnapshot{
+			ActivitySnapshot: &pganalyze_collector.CompactActivitySnapshot{
+				Backends: []*pganalyze_collector.Backend{
+					inTransaction(0, collectedAt-30),
+					inTransaction(0, collectedAt-600),
+					inTransaction(1, collectedAt-5),
+					createTestBackend(2, "app", "idle"),
+				},
+			},
+		},
+		BaseRefs: &pganalyze_collector.CompactSnapshot_BaseRefs{
+			DatabaseReferences: []*pganalyze_collector.DatabaseReference{{Name: "app"}, {Name: "reporting"}},
+		},
+	}
+
+	ages := make(map[string]float64)
+	for _, ts := range oldestTransactionMetrics(snapshot, sysInfo, collectedAt) {
+		name, _ := labelValue(ts.Labels, "__name__")
+		assert.Equal(t, "cc_backend_max_xact_age_seconds", name)
+		assertSystemInfoLabels(t, ts.Labels, sysInfo)
+		datname, _ := labelValue(ts.Labels, "datname")
+		ages[datname] = ts.Samples[0].Value
+		assert.Equal(t, collectedAt*1000, ts.Samples[0].Timestamp)
+	}
+	assert.Equal(t, map[string]float64{"app": 600, "reporting": 5}, ages)
+}
+
+func TestProcessReplicationStats(t *testing.T) {
+	sysInfo := createTestSystemInfo("test-system-1")
+
+	values := func(ts []prompb.TimeSeries) map[string]float64 {
+		result := make(map[string]float64)
+		for _, s := range ts {
+			name, _ := labelValue(s.Labels, "__name__")
+			if app, ok := labelValue(s.Labels, "application_name"); ok {
+				name += "/" + app
+			}
+			result[name] = s.Samples[0].Value
+		}
+		return result
+	}
+
+	standby := &pganalyze_collector.FullSnapshot{Replication: &pganalyze_collector.Replication{
+		InRecovery:         true,
+		ApplyByteLag:       4096,
+		ReplayTimestamp:    timestamppb.Now(),
+		ReplayTimestampAge: 12,
+	}}
+	assert.Equal(t, map[string]float64{
+		"cc_replication_apply_byte_lag":     4096,
+		"cc_replication_replay_lag_seconds": 12,
+	}, values(processReplicationStats(standby, sysInfo, 1000)))
+
+	primary := &pganalyze_collector.FullSnapshot{Replication: &pganalyze_collector.Replication{
+		StandbyReferences: []*pganalyze_collector.StandbyReference{{ClientAddr: "10.0.0.2"}, {ClientAddr: "10.0.0.3"}},
+		StandbyInformations: []*pganalyze_collector.StandbyInformation{
+			{StandbyIdx: 0, ApplicationName: "replica1"},
+			{StandbyIdx: 1, ApplicationName: "replica2"},
+		},
+		StandbyStatistics: []*pganalyze_collector.StandbyStatistic{
+			{StandbyIdx: 0, RemoteByteLag: 100, LocalByteLag: 20},
+			{StandbyIdx: 1, RemoteByteLag: -1, LocalByteLag: 20},
+		},
+	}}
+	assert.Equal(t, map[string]float64{
+		"cc_replication_standby_byte_lag/replica1": 120,
+	}, values(processReplicationStats(primary, sysInfo, 1000)))
+
+	assert.Empty(t, processReplicationStats(&pganalyze_collector.FullSnapshot{}, sysInfo, 1000))
+}
+
+func TestProcessCPUStatsLogicalCores(t *testing.T) {
+	sysInfo := createTestSystemInfo("test-system-1")
+
+	snapshot := &pganalyze_collector.FullSnapshot{System: &pganalyze_collector.System{
+		CpuInformation: &pganalyze_collector.CPUInformation{LogicalCoreCount: 8, PhysicalCoreCount: 4},
+	}}
+	ts := processCPUStats(snapshot, sysInfo, 1000)
+	if !assert.Len(t, ts, 1) {
+		return
+	}
+	name, _ := labelValue(ts[0].Labels, "__name__")
+	assert.Equal(t, "cc_system_cpu_logical_cores", name)
+	assertSystemInfoLabels(t, ts[0].Labels, sysInfo)
+	assert.Equal(t, float64(8), ts[0].Samples[0].Value)
+
+	// Unknown core counts are not reported
+	snapshot.System.CpuInformation = &pganalyze_collector.CPUInformation{}
+	assert.Empty(t, processCPUStats(snapshot, sysInfo, 1000))
+}
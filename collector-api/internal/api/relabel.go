@@ -0,0 +1,176 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// metricRelabeler applies config.MetricRelabelingConfig to generated metrics.
+// A nil relabeler leaves series as is.
+type metricRelabeler struct {
+	staticLabels []prompb.Label
+	configs      []*relabel.Config
+}
+
+func newMetricRelabeler(cfg config.MetricRelabelingConfig) (*metricRelabeler, error) {
+	if len(cfg.StaticLabels) == 0 && len(cfg.RelabelConfigs) == 0 {
+		return nil, nil
+	}
+
+	r := &metricRelabeler{}
+
+	for name, value := range cfg.StaticLabels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid static label name %q", name)
+		}
+		r.staticLabels = append(r.staticLabels, prompb.Label{Name: name, Value: value})
+	}
+	sortLabels(r.staticLabels)
+
+	for i, c := range cfg.RelabelConfigs {
+		relabelConfig, err := toRelabelConfig(c)
+		if err != nil {
+			return nil, fmt.Errorf("relabel config %d: %w", i+1, err)
+		}
+		r.configs = append(r.configs, relabelConfig)
+	}
+
+	return r, nil
+}
+
+func toRelabelConfig(c config.RelabelConfig) (*relabel.Config, error) {
+	relabelConfig := relabel.DefaultRelabelConfig
+
+	if c.Action != "" {
+		action := relabel.Action(strings.ToLower(c.Action))
+		switch action {
+		case relabel.Replace, relabel.Keep, relabel.Drop, relabel.KeepEqual, relabel.DropEqual, relabel.HashMod,
+			relabel.LabelMap, relabel.LabelDrop, relabel.LabelKeep, relabel.Lowercase, relabel.Uppercase:
+			relabelConfig.Action = action
+		default:
+			return nil, fmt.Errorf("unknown relabel action %q", c.Action)
+		}
+	}
+
+	for _, name := range c.SourceLabels {
+		if !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("invalid source label %q", name)
+		}
+		relabelConfig.SourceLabels = append(relabelConfig.SourceLabels, model.LabelName(name))
+	}
+
+	if c.Separator != nil {
+		relabelConfig.Separator = *c.Separator
+	}
+	if c.Regex != nil {
+		regex, err := relabel.NewRegexp(*c.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", *c.Regex, err)
+		}
+		relabelConfig.Regex = regex
+	}
+	if c.Replacement != nil {
+		relabelConfig.Replacement = *c.Replacement
+	}
+	relabelConfig.Modulus = c.Modulus
+	relabelConfig.TargetLabel = c.TargetLabel
+
+	if err := relabelConfig.Validate(); err != nil {
+		return nil, err
+	}
+	return &relabelConfig, nil
+}
+
+// apply adds the static labels and runs the relabel configs over every
+// series. Dropped series and series left without a metric name are removed.
+// Series that end up with identical labels are summed into one, and how many
+// were merged into another is returned, so that the loss of detail shows.
+func (r *metricRelabeler) apply(series []prompb.TimeSeries) ([]prompb.TimeSeries, int) {
+	if r == nil {
+		return series, 0
+	}
+
+	result := make([]prompb.TimeSeries, 0, len(series))
+	seen := make(map[string]int, len(series))
+	merged := 0
+	builder := labels.NewBuilder(labels.EmptyLabels())
+
+	for _, s := range series {
+		builder.Reset(labels.EmptyLabels())
+		for _, label := range s.Labels {
+			builder.Set(label.Name, label.Value)
+		}
+		for _, label := range r.staticLabels {
+			if builder.Get(label.Name) == "" {
+				builder.Set(label.Name, label.Value)
+			}
+		}
+
+		if !relabel.ProcessBuilder(builder, r.configs...) {
+			continue
+		}
+
+		relabeled := builder.Labels()
+		if relabeled.Get(model.MetricNameLabel) == "" {
+			continue
+		}
+
+		key := relabeled.String()
+		if i, ok := seen[key]; ok {
+			result[i].Samples = sumSamples(result[i].Samples, s.Samples)
+			merged++
+			continue
+		}
+		seen[key] = len(result)
+
+		// Labels are already sorted by name
+		promLabels := make([]prompb.Label, 0, relabeled.Len())
+		relabeled.Range(func(l labels.Label) {
+			promLabels = append(promLabels, prompb.Label{Name: l.Name, Value: l.Value})
+		})
+
+		result = append(result, prompb.TimeSeries{Labels: promLabels, Samples: s.Samples})
+	}
+
+	return result, merged
+}
+
+// sumSamples adds the values of other to the samples of the same timestamp in
+// samples, returning a new slice
+func sumSamples(samples, other []prompb.Sample) []prompb.Sample {
+	sum := append([]prompb.Sample(nil), samples...)
+	for _, o := range other {
+		found := false
+		for i := range sum {
+			if sum[i].Timestamp == o.Timestamp {
+				sum[i].Value += o.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			sum = append(sum, o)
+		}
+	}
+	sort.Slice(sum, func(i, j int) bool { return sum[i].Timestamp < sum[j].Timestamp })
+	return sum
+}
+
+// relabelMergedMetrics reports how many series of a snapshot were summed into
+// another by relabeling. The series keeps the labels of the system, since it
+// is written after relabeling.
+func relabelMergedMetrics(systemInfo SystemInfo, snapshotType string, merged int, timestamp int64) []prompb.TimeSeries {
+	if merged == 0 {
+		return nil
+	}
+	return []prompb.TimeSeries{createTimeSeries(systemInfo, "cc_relabel_merged_series", []prompb.Label{
+		{Name: "snapshot_type", Value: snapshotType},
+	}, float64(merged), timestamp)}
+}
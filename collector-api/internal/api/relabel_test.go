@@ -0,0 +1,172 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+type goldenSeries struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+func loadGoldenInput(t *testing.T, path string) []prompb.TimeSeries {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var input []goldenSeries
+	require.NoError(t, json.Unmarshal(data, &input))
+
+	series := make([]prompb.TimeSeries, 0, len(input))
+	for _, s := range input {
+		var labels []prompb.Label
+		for name, value := range s.Labels {
+			labels = append(labels, prompb.Label{Name: name, Value: value})
+		}
+		sortLabels(labels)
+		series = append(series, prompb.TimeSeries{
+			Labels:  labels,
+			Samples: []prompb.Sample{{Value: s.Value, Timestamp: 1000}},
+		})
+	}
+	return series
+}
+
+// formatSeries renders series one per line in exposition format, sorted
+func formatSeries(series []prompb.TimeSeries) string {
+	lines := make([]string, 0, len(series))
+	for _, s := range series {
+		var name string
+		var pairs []string
+		for _, label := range s.Labels {
+			if label.Name == "__name__" {
+				name = label.Value
+				continue
+			}
+			pairs = append(pairs, fmt.Sprintf("%s=%q", label.Name, label.Value))
+		}
+		lines = append(lines, fmt.Sprintf("%s{%s} %g", name, strings.Join(pairs, ","), s.Samples[0].Value))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// TestMetricRelabelingGolden runs every test_data/relabel/*.config.json over
+// input.json and compares with the matching .golden file. Run with -update to
+// rewrite the golden files.
+func TestMetricRelabelingGolden(t *testing.T) {
+	configPaths, err := filepath.Glob("test_data/relabel/*.config.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, configPaths)
+
+	for _, configPath := range configPaths {
+		name := strings.TrimSuffix(filepath.Base(configPath), ".config.json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(configPath)
+			require.NoError(t, err)
+
+			var cfg config.MetricRelabelingConfig
+			require.NoError(t, json.Unmarshal(data, &cfg))
+
+			relabeler, err := newMetricRelabeler(cfg)
+			require.NoError(t, err)
+
+			relabeled, _ := relabeler.apply(loadGoldenInput(t, "test_data/relabel/input.json"))
+			got := formatSeries(relabeled)
+
+			goldenPath := filepath.Join("test_data/relabel", name+".golden")
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(goldenPath, []byte(got), 0644))
+			}
+
+			want, err := os.ReadFile(goldenPath)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), got)
+		})
+	}
+}
+
+func TestMetricRelabelingConfigValidation(t *testing.T) {
+	regex := "("
+	empty := ""
+
+	testCases := []struct {
+		name string
+		cfg  config.MetricRelabelingConfig
+	}{
+		{name: "Unknown action", cfg: config.MetricRelabelingConfig{RelabelConfigs: []config.RelabelConfig{{Action: "rename"}}}},
+		{name: "Invalid regex", cfg: config.MetricRelabelingConfig{RelabelConfigs: []config.RelabelConfig{{Action: "drop", Regex: &regex}}}},
+		{name: "Replace without target", cfg: config.MetricRelabelingConfig{RelabelConfigs: []config.RelabelConfig{{Replacement: &empty}}}},
+		{name: "Reserved static label", cfg: config.MetricRelabelingConfig{StaticLabels: map[string]string{"__name__": "x"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newMetricRelabeler(tc.cfg)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := newMetricRelabeler(config.MetricRelabelingConfig{})
+	assert.NoError(t, err)
+}
+
+func TestMetricRelabelingSumsCollidingSeries(t *testing.T) {
+	relabeler, err := newMetricRelabeler(config.MetricRelabelingConfig{
+		RelabelConfigs: []config.RelabelConfig{{Regex: strPtr("client_addr"), Action: "labeldrop"}},
+	})
+	require.NoError(t, err)
+
+	systemInfo := SystemInfo{SystemID: "db1", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+	series := []prompb.TimeSeries{
+		createTimeSeries(systemInfo, "cc_pg_stat_activity", []prompb.Label{{Name: "client_addr", Value: "10.0.0.1"}}, 2, 1000),
+		createTimeSeries(systemInfo, "cc_pg_stat_activity", []prompb.Label{{Name: "client_addr", Value: "10.0.0.2"}}, 3, 1000),
+		createTimeSeries(systemInfo, "cc_pg_stat_activity", []prompb.Label{{Name: "client_addr", Value: "10.0.0.3"}}, 4, 2000),
+	}
+
+	relabeled, merged := relabeler.apply(series)
+	require.Len(t, relabeled, 1)
+	assert.Equal(t, []prompb.Sample{{Timestamp: 1000, Value: 5}, {Timestamp: 2000, Value: 4}}, relabeled[0].Samples)
+	assert.Equal(t, 2, merged)
+	assert.Equal(t, float64(2), series[0].Samples[0].Value, "input series are left as they were")
+
+	mergedMetrics := relabelMergedMetrics(systemInfo, CompactActivitySnapshotType, merged, 1000)
+	require.Len(t, mergedMetrics, 1)
+	assert.Equal(t, "cc_relabel_merged_series", mergedMetrics[0].Labels[0].Value)
+	assert.Equal(t, float64(2), mergedMetrics[0].Samples[0].Value)
+	assert.Empty(t, relabelMergedMetrics(systemInfo, CompactActivitySnapshotType, 0, 1000))
+}
+
+func TestMetricRelabelingFullSnapshot(t *testing.T) {
+	relabeler, err := newMetricRelabeler(config.MetricRelabelingConfig{
+		RelabelConfigs: []config.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: strPtr("cc_relation_.*"), Action: "drop"}},
+	})
+	require.NoError(t, err)
+
+	systemInfo := SystemInfo{SystemID: "rds-instance-100", SystemScope: "test-scope", SystemType: "amazon_rds"}
+	allMetrics, _, err := processFullSnapshotData(nil, "test_data/full-snapshot-rds-1.binpb", systemInfo, 0, snapshotPolicies{relabeler: relabeler}, true)
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, allMetrics)
+	for _, metric := range allMetrics {
+		name, _ := labelValue(metric.Labels, "__name__")
+		assert.False(t, strings.HasPrefix(name, "cc_relation_"), "unexpected %s", name)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
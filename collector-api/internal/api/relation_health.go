@@ -0,0 +1,138 @@
+package api
+
+import (
+	"math"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+)
+
+// Heap page layout of PostgreSQL with the default block size, as used to
+// estimate how many pages a table needs
+const (
+	heapBlockSize       = 8192
+	heapPageHeaderBytes = 24
+	heapItemIDBytes     = 4
+	heapTupleHeader     = 23
+	heapMaxAlign        = 8
+)
+
+// relationHealthMetrics derives per-table health metrics from the cc_relation_*
+// values of a table, so vacuum triage does not need hand-written PromQL.
+// Metrics whose inputs are missing or meaningless for this snapshot are left
+// out rather than reported as zero:
+//
+//   - cc_relation_cache_hit_ratio: share of heap blocks read during the
+//     snapshot interval that were found in shared buffers
+//   - cc_relation_dead_tup_ratio: dead tuples out of all tuples
+//   - cc_relation_estimated_bloat_bytes: heap pages beyond those reltuples
+//     rows need, see estimatedBloatBytes
+//   - cc_relation_seconds_since_vacuum and cc_relation_seconds_since_analyze:
+//     time since the last manual or automatic run, for tables that had one
+func relationHealthMetrics(table *reportedTable, timestamp int64) map[string]float64 {
+	metrics := table.metrics
+	health := make(map[string]float64)
+
+	// Block counts are changes over the interval, negative after a stats reset
+	hit, read := metrics["cc_relation_heap_blks_hit"], metrics["cc_relation_heap_blks_read"]
+	if hit >= 0 && read >= 0 && hit+read > 0 {
+		health["cc_relation_cache_hit_ratio"] = hit / (hit + read)
+	}
+
+	live, dead := metrics["cc_relation_n_live_tup"], metrics["cc_relation_n_dead_tup"]
+	if live >= 0 && dead >= 0 && live+dead > 0 {
+		health["cc_relation_dead_tup_ratio"] = dead / (live + dead)
+	}
+
+	if table.bloatEstimated {
+		health["cc_relation_estimated_bloat_bytes"] = table.bloatBytes
+	}
+
+	// Last run timestamps are in milliseconds, zero if there never was one
+	if lastVacuum := maxValue(metrics["cc_relation_last_vacuum"], metrics["cc_relation_last_autovacuum"]); lastVacuum > 0 {
+		health["cc_relation_seconds_since_vacuum"] = secondsSince(lastVacuum, timestamp)
+	}
+	if lastAnalyze := maxValue(metrics["cc_relation_last_analyze"], metrics["cc_relation_last_autoanalyze"]); lastAnalyze > 0 {
+		health["cc_relation_seconds_since_analyze"] = secondsSince(lastAnalyze, timestamp)
+	}
+
+	return health
+}
+
+func secondsSince(fromMs float64, timestamp int64) float64 {
+	return maxValue(float64(timestamp)-fromMs, 0) / 1000
+}
+
+// estimatedBloatBytes estimates the heap space of a relation beyond what its
+// rows need. The expected number of pages follows from reltuples and the
+// estimated tuple width, packed up to the fillfactor; every page in relpages
+// above that counts as bloat. Like the estimates commonly run against
+// pg_stats, it ignores per-page free space and alignment between columns, so
+// small values are noise. It returns false when the table was never analyzed.
+func estimatedBloatBytes(relStat *collector_proto.RelationStatistic, info *collector_proto.RelationInformation) (float64, bool) {
+	if relStat.Relpages <= 0 || relStat.Reltuples < 0 {
+		return 0, false
+	}
+
+	width, ok := estimatedTupleWidth(info)
+	if !ok {
+		return 0, false
+	}
+
+	fillfactor := 100.0
+	if info.Fillfactor > 0 {
+		fillfactor = float64(info.Fillfactor)
+	}
+	tuplesPerPage := math.Floor((heapBlockSize - heapPageHeaderBytes) * fillfactor / 100 / (width + heapItemIDBytes))
+	if tuplesPerPage < 1 {
+		return 0, false
+	}
+
+	expectedPages := math.Ceil(float64(relStat.Reltuples) / tuplesPerPage)
+	return math.Max(float64(relStat.Relpages)-expectedPages, 0) * heapBlockSize, true
+}
+
+// estimatedTupleWidth estimates the bytes a heap tuple of the relation takes:
+// the tuple header, a null bitmap if any column has nulls, and the average
+// width of every column weighted by how often it is not null. It returns
+// false when a column has no statistics.
+func estimatedTupleWidth(info *collector_proto.RelationInformation) (float64, bool) {
+	if info == nil || len(info.Columns) == 0 {
+		return 0, false
+	}
+
+	header := heapTupleHeader
+	data := 0.0
+	hasNulls := false
+	for _, column := range info.Columns {
+		stat := ownColumnStatistic(column)
+		if stat == nil {
+			return 0, false
+		}
+		if stat.NullFrac > 0 {
+			hasNulls = true
+		}
+		data += (1 - stat.NullFrac) * float64(stat.AvgWidth)
+	}
+	if hasNulls {
+		header += (len(info.Columns) + 7) / 8
+	}
+
+	return maxAligned(float64(header)) + maxAligned(data), true
+}
+
+// ownColumnStatistic returns the statistics of the column in its own table,
+// rather than those including inheritance children, if there are any
+func ownColumnStatistic(column *collector_proto.RelationInformation_Column) *collector_proto.RelationInformation_ColumnStatistic {
+	var inherited *collector_proto.RelationInformation_ColumnStatistic
+	for _, stat := range column.Statistics {
+		if !stat.Inherited {
+			return stat
+		}
+		inherited = stat
+	}
+	return inherited
+}
+
+func maxAligned(bytes float64) float64 {
+	return math.Ceil(bytes/heapMaxAlign) * heapMaxAlign
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"testing"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelationHealthMetrics(t *testing.T) {
+	timestamp := int64(10_000_000)
+
+	health := relationHealthMetrics(&reportedTable{metrics: map[string]float64{
+		"cc_relation_size_bytes":       1000,
+		"cc_relation_heap_blks_hit":    90,
+		"cc_relation_heap_blks_read":   10,
+		"cc_relation_n_live_tup":       75,
+		"cc_relation_n_dead_tup":       25,
+		"cc_relation_last_vacuum":      4_000_000,
+		"cc_relation_last_autovacuum":  7_000_000,
+		"cc_relation_last_analyze":     0,
+		"cc_relation_last_autoanalyze": 0,
+	}, bloatBytes: 24576, bloatEstimated: true}, timestamp)
+
+	assert.Equal(t, map[string]float64{
+		"cc_relation_cache_hit_ratio":       0.9,
+		"cc_relation_dead_tup_ratio":        0.25,
+		"cc_relation_estimated_bloat_bytes": 24576,
+		"cc_relation_seconds_since_vacuum":  3000,
+	}, health)
+}
+
+func TestRelationHealthMetricsWithoutActivity(t *testing.T) {
+	health := relationHealthMetrics(&reportedTable{metrics: map[string]float64{
+		"cc_relation_size_bytes":     8192,
+		"cc_relation_heap_blks_hit":  -50, // Statistics were reset
+		"cc_relation_heap_blks_read": 5,
+	}}, 0)
+
+	assert.Empty(t, health)
+}
+
+func TestEstimatedBloatBytes(t *testing.T) {
+	info := &collector_proto.RelationInformation{
+		Columns: []*collector_proto.RelationInformation_Column{
+			{Name: "id", Statistics: []*collector_proto.RelationInformation_ColumnStatistic{
+				{AvgWidth: 8},
+			}},
+			{Name: "name", Statistics: []*collector_proto.RelationInformation_ColumnStatistic{
+				{Inherited: true, AvgWidth: 40},
+				{NullFrac: 0.5, AvgWidth: 20},
+			}},
+		},
+	}
+
+	// 24 byte header with null bitmap plus 18 bytes of data, aligned to 24,
+	// fits 157 tuples per page, so 1000 tuples need 7 of the 10 pages
+	bloat, ok := estimatedBloatBytes(&collector_proto.RelationStatistic{Relpages: 10, Reltuples: 1000}, info)
+	assert.True(t, ok)
+	assert.Equal(t, 3.0*8192, bloat)
+
+	// Half full pages at fillfactor 50 leave no room for bloat
+	info.Fillfactor = 50
+	bloat, ok = estimatedBloatBytes(&collector_proto.RelationStatistic{Relpages: 10, Reltuples: 1000}, info)
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, bloat)
+
+	_, ok = estimatedBloatBytes(&collector_proto.RelationStatistic{Relpages: 10, Reltuples: -1}, info)
+	assert.False(t, ok, "never analyzed")
+
+	info.Columns = append(info.Columns, &collector_proto.RelationInformation_Column{Name: "note"})
+	_, ok = estimatedBloatBytes(&collector_proto.RelationStatistic{Relpages: 10, Reltuples: 1000}, info)
+	assert.False(t, ok, "column without statistics")
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"fmt"
+	"path"
+	"sort"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Reasons a table is left out of the relation metrics, as reported by
+// cc_relation_tables_skipped
+const (
+	skipReasonFiltered  = "filtered"
+	skipReasonOverLimit = "over_limit"
+	skipReasonRolledUp  = "rolled_up"
+)
+
+// relationMetricsCombine lists relation metrics that are not summed when
+// partitions are rolled up into their parent
+var relationMetricsCombine = map[string]func(a, b float64) float64{
+	"cc_relation_analyzed_at":      maxValue,
+	"cc_relation_last_vacuum":      maxValue,
+	"cc_relation_last_autovacuum":  maxValue,
+	"cc_relation_last_analyze":     maxValue,
+	"cc_relation_last_autoanalyze": maxValue,
+	"cc_relation_frozenxid_age":    maxValue,
+	"cc_relation_minmxid_age":      maxValue,
+	"cc_relation_relfrozenxid":     minNonZeroValue,
+	"cc_relation_relminmxid":       minNonZeroValue,
+}
+
+// relationPolicy decides which tables and indexes of a full snapshot get
+// metrics. A nil policy reports everything.
+type relationPolicy struct {
+	include          []string
+	exclude          []string
+	limit            int
+	rollupPartitions bool
+}
+
+func newRelationPolicy(cfg config.RelationMetricsConfig) (*relationPolicy, error) {
+	for _, pattern := range append(append([]string{}, cfg.Include...), cfg.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid relation pattern %q: %w", pattern, err)
+		}
+	}
+
+	if cfg.TableLimit < 0 {
+		return nil, fmt.Errorf("table_limit must not be negative, got %d", cfg.TableLimit)
+	}
+
+	return &relationPolicy{
+		include:          cfg.Include,
+		exclude:          cfg.Exclude,
+		limit:            cfg.TableLimit,
+		rollupPartitions: cfg.RollupPartitions,
+	}, nil
+}
+
+func (p *relationPolicy) matches(schema, relation string) bool {
+	if p == nil {
+		return true
+	}
+
+	name := schema + "." + relation
+	for _, pattern := range p.exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+
+	if len(p.include) == 0 {
+		return true
+	}
+	for _, pattern := range p.include {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reportedTable accumulates the metrics of one reported table, including the
+// partitions rolled up into it. The bloat estimate is the sum over the
+// partitions that have one.
+type reportedTable struct {
+	relationIdx    int32
+	metrics        map[string]float64
+	partitions     int
+	bloatBytes     float64
+	bloatEstimated bool
+}
+
+func (t *reportedTable) size() float64 {
+	return t.metrics["cc_relation_size_bytes"] + t.metrics["cc_relation_toast_size_bytes"]
+}
+
+func (t *reportedTable) add(metrics map[string]float64) {
+	if t.metrics == nil {
+		t.metrics = metrics
+		return
+	}
+	for name, value := range metrics {
+		if combine, ok := relationMetricsCombine[name]; ok {
+			t.metrics[name] = combine(t.metrics[name], value)
+		} else {
+			t.metrics[name] += value
+		}
+	}
+}
+
+// selectRelations applies the policy to the relation statistics of snapshot.
+// It returns the tables to report, ordered by relation, the set of relations
+// whose indexes are reported, and how many tables were skipped per reason.
+// Indexes of rolled up partitions are not reported.
+func (p *relationPolicy) selectRelations(snapshot *collector_proto.FullSnapshot) ([]*reportedTable, map[int32]bool, map[string]int) {
+	parents := make(map[int32]int32)
+	infos := make(map[int32]*collector_proto.RelationInformation, len(snapshot.RelationInformations))
+	for _, info := range snapshot.RelationInformations {
+		infos[info.RelationIdx] = info
+		if info.HasParentRelation {
+			parents[info.RelationIdx] = info.ParentRelationIdx
+		}
+	}
+
+	skipped := map[string]int{skipReasonFiltered: 0, skipReasonOverLimit: 0, skipReasonRolledUp: 0}
+	tables := make(map[int32]*reportedTable)
+	reportedIndexes := make(map[int32]bool)
+
+	for _, relStat := range snapshot.RelationStatistics {
+		relationIdx := relStat.RelationIdx
+		isPartition := false
+		if p != nil && p.rollupPartitions {
+			relationIdx = topLevelRelation(relationIdx, parents)
+			isPartition = relationIdx != relStat.RelationIdx
+		}
+
+		ref := snapshot.RelationReferences[relationIdx]
+		if !p.matches(ref.SchemaName, ref.RelationName) {
+			skipped[skipReasonFiltered]++
+			continue
+		}
+
+		table, ok := tables[relationIdx]
+		if !ok {
+			table = &reportedTable{relationIdx: relationIdx}
+			tables[relationIdx] = table
+		}
+		table.add(relationStatMetrics(relStat))
+		if bloat, ok := estimatedBloatBytes(relStat, infos[relStat.RelationIdx]); ok {
+			table.bloatBytes += bloat
+			table.bloatEstimated = true
+		}
+
+		if isPartition {
+			table.partitions++
+			skipped[skipReasonRolledUp]++
+		}
+		reportedIndexes[relationIdx] = true
+	}
+
+	selected := make([]*reportedTable, 0, len(tables))
+	for _, table := range tables {
+		selected = append(selected, table)
+	}
+
+	if p != nil && p.limit > 0 && len(selected) > p.limit {
+		sort.Slice(selected, func(i, j int) bool {
+			if selected[i].size() != selected[j].size() {
+				return selected[i].size() > selected[j].size()
+			}
+			return selected[i].relationIdx < selected[j].relationIdx
+		})
+		for _, table := range selected[p.limit:] {
+			delete(reportedIndexes, table.relationIdx)
+		}
+		skipped[skipReasonOverLimit] = len(selected) - p.limit
+		selected = selected[:p.limit]
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].relationIdx < selected[j].relationIdx
+	})
+
+	return selected, reportedIndexes, skipped
+}
+
+// topLevelRelation follows parent relations up to the root of a partition tree
+func topLevelRelation(relationIdx int32, parents map[int32]int32) int32 {
+	for depth := 0; depth < len(parents); depth++ {
+		parent, ok := parents[relationIdx]
+		if !ok {
+			break
+		}
+		relationIdx = parent
+	}
+	return relationIdx
+}
+
+func maxValue(a, b float64) float64 {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func minNonZeroValue(a, b float64) float64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func relationSkippedMetrics(systemInfo SystemInfo, skipped map[string]int, timestamp int64) []prompb.TimeSeries {
+	reasons := make([]string, 0, len(skipped))
+	for reason := range skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	ts := make([]prompb.TimeSeries, 0, len(reasons))
+	for _, reason := range reasons {
+		ts = append(ts, createTimeSeries(systemInfo, "cc_relation_tables_skipped", []prompb.Label{
+			{Name: "reason", Value: reason},
+		}, float64(skipped[reason]), timestamp))
+	}
+	return ts
+}
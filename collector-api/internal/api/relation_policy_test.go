@@ -0,0 +1,184 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"testing"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// partitionedSnapshot has public.events partitioned into two partitions, the
+// tables public.users and audit.log, and an index on every table
+func partitionedSnapshot() *collector_proto.FullSnapshot {
+	return &collector_proto.FullSnapshot{
+		DatabaseReferences: []*collector_proto.DatabaseReference{{Name: "app"}},
+		RelationReferences: []*collector_proto.RelationReference{
+			{SchemaName: "public", RelationName: "events"},
+			{SchemaName: "public", RelationName: "events_2024"},
+			{SchemaName: "public", RelationName: "events_2025"},
+			{SchemaName: "public", RelationName: "users"},
+			{SchemaName: "audit", RelationName: "log"},
+		},
+		RelationInformations: []*collector_proto.RelationInformation{
+			{RelationIdx: 1, HasParentRelation: true, ParentRelationIdx: 0},
+			{RelationIdx: 2, HasParentRelation: true, ParentRelationIdx: 0},
+		},
+		RelationStatistics: []*collector_proto.RelationStatistic{
+			{RelationIdx: 1, SizeBytes: 1000, NLiveTup: 10, FrozenxidAge: 50},
+			{RelationIdx: 2, SizeBytes: 3000, NLiveTup: 30, FrozenxidAge: 20},
+			{RelationIdx: 3, SizeBytes: 2000, NLiveTup: 5},
+			{RelationIdx: 4, SizeBytes: 500, NLiveTup: 1},
+		},
+		IndexReferences: []*collector_proto.IndexReference{
+			{SchemaName: "public", IndexName: "events_2024_pkey"},
+			{SchemaName: "public", IndexName: "events_2025_pkey"},
+			{SchemaName: "public", IndexName: "users_pkey"},
+			{SchemaName: "audit", IndexName: "log_pkey"},
+		},
+		IndexInformations: []*collector_proto.IndexInformation{
+			{IndexIdx: 0, RelationIdx: 1},
+			{IndexIdx: 1, RelationIdx: 2},
+			{IndexIdx: 2, RelationIdx: 3},
+			{IndexIdx: 3, RelationIdx: 4},
+		},
+		IndexStatistics: []*collector_proto.IndexStatistic{
+			{IndexIdx: 0, SizeBytes: 10},
+			{IndexIdx: 1, SizeBytes: 10},
+			{IndexIdx: 2, SizeBytes: 10},
+			{IndexIdx: 3, SizeBytes: 10},
+		},
+	}
+}
+
+// relationValues returns the value of metric per relation (or index) label
+func relationValues(ts []prompb.TimeSeries, metric string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, s := range ts {
+		if name, _ := labelValue(s.Labels, "__name__"); name != metric {
+			continue
+		}
+		key, ok := labelValue(s.Labels, "relation")
+		if !ok {
+			key, ok = labelValue(s.Labels, "index")
+		}
+		if !ok {
+			key, _ = labelValue(s.Labels, "reason")
+		}
+		values[key] = s.Samples[0].Value
+	}
+	return values
+}
+
+func TestRelationPolicy(t *testing.T) {
+	sysInfo := createTestSystemInfo("relations")
+
+	testCases := []struct {
+		name            string
+		cfg             config.RelationMetricsConfig
+		expectedSizes   map[string]float64
+		expectedIndexes []string
+		expectedSkipped map[string]float64
+	}{
+		{
+			name:            "No filters",
+			cfg:             config.RelationMetricsConfig{},
+			expectedSizes:   map[string]float64{"events_2024": 1000, "events_2025": 3000, "users": 2000, "log": 500},
+			expectedIndexes: []string{"events_2024_pkey", "events_2025_pkey", "users_pkey", "log_pkey"},
+			expectedSkipped: map[string]float64{"filtered": 0, "over_limit": 0, "rolled_up": 0},
+		},
+		{
+			name:            "Include and exclude patterns",
+			cfg:             config.RelationMetricsConfig{Include: []string{"public.*"}, Exclude: []string{"public.events_2024"}},
+			expectedSizes:   map[string]float64{"events_2025": 3000, "users": 2000},
+			expectedIndexes: []string{"events_2025_pkey", "users_pkey"},
+			expectedSkipped: map[string]float64{"filtered": 2, "over_limit": 0, "rolled_up": 0},
+		},
+		{
+			name:            "Top tables by size",
+			cfg:             config.RelationMetricsConfig{TableLimit: 2},
+			expectedSizes:   map[string]float64{"events_2025": 3000, "users": 2000},
+			expectedIndexes: []string{"events_2025_pkey", "users_pkey"},
+			expectedSkipped: map[string]float64{"filtered": 0, "over_limit": 2, "rolled_up": 0},
+		},
+		{
+			name:            "Partitions rolled up before the limit",
+			cfg:             config.RelationMetricsConfig{RollupPartitions: true, TableLimit: 2},
+			expectedSizes:   map[string]float64{"events": 4000, "users": 2000},
+			expectedIndexes: []string{"users_pkey"},
+			expectedSkipped: map[string]float64{"filtered": 0, "over_limit": 1, "rolled_up": 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := newRelationPolicy(tc.cfg)
+			require.NoError(t, err)
+
+			ts := processRelationAndIndexStats(partitionedSnapshot(), sysInfo, 0, policy)
+
+			assert.Equal(t, tc.expectedSizes, relationValues(ts, "cc_relation_size_bytes"))
+			var indexes []string
+			for index := range relationValues(ts, "cc_index_size_bytes") {
+				indexes = append(indexes, index)
+			}
+			assert.ElementsMatch(t, tc.expectedIndexes, indexes)
+			assert.Equal(t, tc.expectedSkipped, relationValues(ts, "cc_relation_tables_skipped"))
+		})
+	}
+}
+
+func TestIndexInfoLabelsTheTable(t *testing.T) {
+	ts := processRelationAndIndexStats(partitionedSnapshot(), createTestSystemInfo("index_labels"), 0, nil)
+
+	indexes := make(map[string]string)
+	tables := make(map[string]string)
+	for _, s := range ts {
+		name, _ := labelValue(s.Labels, "__name__")
+		index, _ := labelValue(s.Labels, "index")
+		datname, _ := labelValue(s.Labels, "datname")
+		schema, _ := labelValue(s.Labels, "schema")
+		relation, hasRelation := labelValue(s.Labels, "relation")
+		switch name {
+		case "cc_index_scan_count":
+			assert.False(t, hasRelation, "the table is only on cc_index_info")
+			indexes[index] = datname + "/" + schema
+		case "cc_index_info":
+			tables[index] = datname + "/" + schema + "." + relation
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"events_2024_pkey": "app/public",
+		"events_2025_pkey": "app/public",
+		"users_pkey":       "app/public",
+		"log_pkey":         "app/audit",
+	}, indexes)
+	assert.Equal(t, map[string]string{
+		"events_2024_pkey": "app/public.events_2024",
+		"events_2025_pkey": "app/public.events_2025",
+		"users_pkey":       "app/public.users",
+		"log_pkey":         "app/audit.log",
+	}, tables)
+}
+
+func TestRelationPolicyRollupCombinesMetrics(t *testing.T) {
+	policy, err := newRelationPolicy(config.RelationMetricsConfig{RollupPartitions: true})
+	require.NoError(t, err)
+
+	ts := processRelationAndIndexStats(partitionedSnapshot(), createTestSystemInfo("rollup"), 0, policy)
+
+	assert.Equal(t, 40.0, relationValues(ts, "cc_relation_n_live_tup")["events"], "counts are summed")
+	assert.Equal(t, 50.0, relationValues(ts, "cc_relation_frozenxid_age")["events"], "ages take the oldest partition")
+	assert.Equal(t, map[string]float64{"events": 2}, relationValues(ts, "cc_relation_partitions"))
+}
+
+func TestRelationPolicyValidation(t *testing.T) {
+	_, err := newRelationPolicy(config.RelationMetricsConfig{Include: []string{"public.*"}})
+	assert.NoError(t, err)
+	_, err = newRelationPolicy(config.RelationMetricsConfig{Exclude: []string{"public.[a-"}})
+	assert.Error(t, err)
+	_, err = newRelationPolicy(config.RelationMetricsConfig{TableLimit: -1})
+	assert.Error(t, err)
+}
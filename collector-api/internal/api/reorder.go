@@ -0,0 +1,170 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// snapshotRoute says how the metrics of a snapshot reach Prometheus
+type snapshotRoute int
+
+const (
+	// routeLive writes in collected_at order and tracks stale markers
+	routeLive snapshotRoute = iota
+	// routeLate writes to the live TSDB, within its out-of-order window, but
+	// behind snapshots already written, so no stale markers are derived
+	routeLate
+	// routeBackfill is too old for the live TSDB and is written as blocks
+	routeBackfill
+)
+
+// streamKey identifies a stream of snapshots whose stale markers depend on
+// each other
+type streamKey struct {
+	system  SystemInfo
+	compact bool
+}
+
+type heldTask struct {
+	task      SnapshotTask
+	releaseAt time.Time
+}
+
+// reorderBuffer holds incoming snapshots for a short while so that snapshots
+// of a system are processed in collected_at order even when they arrive out
+// of order, and routes snapshots that arrive too late anyway.
+type reorderBuffer struct {
+	mu           sync.Mutex
+	held         []heldTask
+	written      map[streamKey]int64 // Latest collected_at written live per stream
+	reprocessing bool
+}
+
+var snapshotReorder = newReorderBuffer()
+
+func newReorderBuffer() *reorderBuffer {
+	return &reorderBuffer{written: make(map[streamKey]int64)}
+}
+
+// Hold adds task to the buffer until releaseAt
+func (b *reorderBuffer) Hold(task SnapshotTask, releaseAt time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.held = append(b.held, heldTask{task: task, releaseAt: releaseAt})
+}
+
+// Release removes the tasks due at now, together with every held task of the
+// same stream collected before them, and returns them in collected_at order.
+func (b *reorderBuffer) Release(now time.Time) []SnapshotTask {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	releaseUpTo := make(map[streamKey]int64)
+	for _, h := range b.held {
+		if h.releaseAt.After(now) {
+			continue
+		}
+		key := streamKey{system: h.task.SystemInfo, compact: h.task.IsCompact}
+		if h.task.CollectedAt > releaseUpTo[key] {
+			releaseUpTo[key] = h.task.CollectedAt
+		}
+	}
+
+	var released []SnapshotTask
+	remaining := b.held[:0]
+	for _, h := range b.held {
+		key := streamKey{system: h.task.SystemInfo, compact: h.task.IsCompact}
+		if upTo, ok := releaseUpTo[key]; ok && h.task.CollectedAt <= upTo {
+			released = append(released, h.task)
+		} else {
+			remaining = append(remaining, h)
+		}
+	}
+	b.held = remaining
+
+	sort.SliceStable(released, func(i, j int) bool {
+		return released[i].CollectedAt < released[j].CollectedAt
+	})
+	return released
+}
+
+// setReprocessing disables backfill routing while all stored snapshots are
+// written, in order, into the empty TSDB of a reprocessing Prometheus
+func (b *reorderBuffer) setReprocessing(reprocessing bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reprocessing = reprocessing
+}
+
+// route decides how each of tasks is written, given the out-of-order window
+// of the live TSDB, and records the live ones as written. A zero window
+// writes everything live.
+func (b *reorderBuffer) route(tasks []SnapshotTask, window time.Duration, now time.Time) []snapshotRoute {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	order := make([]int, len(tasks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return tasks[order[i]].CollectedAt < tasks[order[j]].CollectedAt
+	})
+
+	routes := make([]snapshotRoute, len(tasks))
+	for _, i := range order {
+		task := tasks[i]
+		key := streamKey{system: task.SystemInfo, compact: task.IsCompact}
+
+		switch {
+		case window > 0 && !b.reprocessing && time.Unix(task.CollectedAt, 0).Before(now.Add(-window)):
+			routes[i] = routeBackfill
+		case task.CollectedAt <= b.written[key]:
+			routes[i] = routeLate
+		default:
+			routes[i] = routeLive
+			b.written[key] = task.CollectedAt
+		}
+	}
+	return routes
+}
+
+// holdOrProcess buffers task when a hold is configured, and otherwise
+// processes it right away. It reports whether the task was buffered.
+func holdOrProcess(cfg *config.Config, task SnapshotTask) (bool, error) {
+	if cfg.ReorderBuffer.HoldSeconds <= 0 {
+		return false, HandleSnapshots(cfg, []SnapshotTask{task})
+	}
+
+	snapshotReorder.Hold(task, time.Now().Add(time.Duration(cfg.ReorderBuffer.HoldSeconds)*time.Second))
+	return true, nil
+}
+
+// RunReorderBuffer processes held snapshots as they become due. It never
+// returns.
+func RunReorderBuffer(cfg *config.Config) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		tasks := snapshotReorder.Release(now)
+		if len(tasks) == 0 {
+			continue
+		}
+
+		queue := GetQueueInstance()
+		if queue.IsLocked() {
+			for _, task := range tasks {
+				queue.Enqueue(task)
+			}
+			continue
+		}
+
+		if err := HandleSnapshotBatches(cfg, tasks, DefaultSnapshotBatchSize); err != nil {
+			log.Printf("Error processing held snapshots: %v", err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"collector-api/internal/config"
+	"collector-api/internal/db"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReorderBufferReleasesInCollectedOrder(t *testing.T) {
+	now := time.Unix(1000, 0)
+	system1 := createTestSystemInfo("system-1")
+	system2 := createTestSystemInfo("system-2")
+	buffer := newReorderBuffer()
+
+	buffer.Hold(SnapshotTask{SystemInfo: system1, CollectedAt: 120, IsCompact: true}, now)
+	buffer.Hold(SnapshotTask{SystemInfo: system2, CollectedAt: 130, IsCompact: true}, now.Add(time.Second))
+	// Arrived later but collected earlier; released with the due snapshot of its stream
+	buffer.Hold(SnapshotTask{SystemInfo: system1, CollectedAt: 110, IsCompact: true}, now.Add(time.Second))
+	// Collected after the due snapshot, so it keeps waiting
+	buffer.Hold(SnapshotTask{SystemInfo: system1, CollectedAt: 140, IsCompact: true}, now.Add(time.Second))
+
+	released := buffer.Release(now)
+	require.Len(t, released, 2)
+	assert.Equal(t, int64(110), released[0].CollectedAt)
+	assert.Equal(t, int64(120), released[1].CollectedAt)
+
+	released = buffer.Release(now.Add(time.Second))
+	require.Len(t, released, 2)
+	assert.Equal(t, int64(130), released[0].CollectedAt)
+	assert.Equal(t, int64(140), released[1].CollectedAt)
+
+	assert.Empty(t, buffer.Release(now.Add(time.Minute)))
+}
+
+func TestReorderBufferRoute(t *testing.T) {
+	now := time.Unix(10000, 0)
+	window := 240 * time.Second
+	system := createTestSystemInfo("system-1")
+	compact := func(collectedAt int64) SnapshotTask {
+		return SnapshotTask{SystemInfo: system, CollectedAt: collectedAt, IsCompact: true}
+	}
+
+	buffer := newReorderBuffer()
+
+	routes := buffer.route([]SnapshotTask{compact(9950), compact(9900)}, window, now)
+	assert.Equal(t, []snapshotRoute{routeLive, routeLive}, routes, "a batch is written in collected_at order")
+
+	routes = buffer.route([]SnapshotTask{compact(9920), compact(9000), compact(9990)}, window, now)
+	assert.Equal(t, []snapshotRoute{routeLate, routeBackfill, routeLive}, routes)
+
+	// Full snapshots are a separate stream
+	routes = buffer.route([]SnapshotTask{{SystemInfo: system, CollectedAt: 9920}}, window, now)
+	assert.Equal(t, []snapshotRoute{routeLive}, routes)
+
+	routes = buffer.route([]SnapshotTask{compact(9000)}, 0, now)
+	assert.Equal(t, []snapshotRoute{routeLate}, routes, "no window writes everything live")
+
+	buffer.setReprocessing(true)
+	routes = buffer.route([]SnapshotTask{compact(100), compact(200)}, window, now)
+	assert.Equal(t, []snapshotRoute{routeLate, routeLate}, routes, "reprocessing never backfills")
+}
+
+func TestProcessOutOfOrderSnapshotKeepsStaleMarkerState(t *testing.T) {
+	systemInfo := createTestSystemInfo("out-of-order")
+	delete(previousMetrics, systemInfo)
+
+	_, _, err := processFullSnapshotData(nil, "test_data/full-snapshot-rds-1.binpb", systemInfo, 100, snapshotPolicies{}, true)
+	require.NoError(t, err)
+	previous := previousMetrics[systemInfo][FullSnapshotType]
+	require.NotEmpty(t, previous)
+
+	metrics, _, err := processFullSnapshotData(nil, "test_data/full-snapshot-rds-2.binpb", systemInfo, 50, snapshotPolicies{}, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, previous, previousMetrics[systemInfo][FullSnapshotType])
+	for _, ts := range metrics {
+		assert.False(t, value.IsStaleNaN(ts.Samples[0].Value), "no stale markers for out-of-order snapshots")
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	series := func(name string, labels map[string]string, v float64, timestamp int64) prompb.TimeSeries {
+		return createTestTimeSeries(name, labels, v, time.UnixMilli(timestamp))
+	}
+
+	var out strings.Builder
+	err := writeOpenMetrics(&out, []prompb.TimeSeries{
+		series("cc_b", map[string]string{"sys_id": "db1"}, 2, 2000),
+		series("cc_a", map[string]string{"sys_id": "db1", "query": "say \"hi\"\n"}, 1.5, 1500),
+		series("cc_b", map[string]string{"sys_id": "db1"}, 1, 1000),
+		series("cc_b", map[string]string{"sys_id": "db1"}, math.Float64frombits(value.StaleNaN), 3000),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `cc_a{query="say \"hi\"\n",sys_id="db1"} 1.5 1.500
+cc_b{sys_id="db1"} 1 1.000
+cc_b{sys_id="db1"} 2 2.000
+# EOF
+`, out.String())
+}
+
+func TestHeldSnapshotSubmissionsReturnOK(t *testing.T) {
+	t.Setenv("AUTODBA_API_KEY", "test-api-key")
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"reorder_buffer": {"hold_seconds": 15}}`), 0o600))
+	config.SetGlobalConfigPath(configPath)
+	t.Cleanup(func() { config.SetGlobalConfigPath("collector-api-config.json") })
+	_, err := db.InitDB(filepath.Join(dir, "collector.db"))
+	require.NoError(t, err)
+
+	handlers := map[string]http.HandlerFunc{
+		"Full":    SnapshotHandler,
+		"Compact": CompactSnapshotHandler,
+	}
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			form := url.Values{"s3_location": {"snapshots/held"}, "collected_at": {"1700000000"}}
+			req := httptest.NewRequest(http.MethodPost, "/v2/snapshots", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Pganalyze-Api-Key", os.Getenv("AUTODBA_API_KEY"))
+			req.Header.Set("Pganalyze-System-Id", "held")
+			record := httptest.NewRecorder()
+
+			handler(record, req)
+
+			// The collector only accepts 200, and logs anything else as a failed submission
+			assert.Equal(t, http.StatusOK, record.Code, record.Body.String())
+			assert.Contains(t, record.Body.String(), "queued for processing")
+		})
+	}
+	snapshotReorder.Release(time.Now().Add(time.Hour))
+}
+
+func TestSnapshotSubmissionsWithoutConfig(t *testing.T) {
+	t.Setenv("AUTODBA_API_KEY", "")
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{}`), 0o600))
+	config.SetGlobalConfigPath(configPath)
+	t.Cleanup(func() { config.SetGlobalConfigPath("collector-api-config.json") })
+
+	for name, handler := range map[string]http.HandlerFunc{"Full": SnapshotHandler, "Compact": CompactSnapshotHandler} {
+		t.Run(name, func(t *testing.T) {
+			record := httptest.NewRecorder()
+			handler(record, httptest.NewRequest(http.MethodPost, "/v2/snapshots", nil))
+			assert.Equal(t, http.StatusInternalServerError, record.Code)
+		})
+	}
+}
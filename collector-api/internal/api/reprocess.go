@@ -20,7 +20,9 @@ func ReprocessSnapshots(cfg *config.Config, reprocessFull, reprocessCompact bool
 	startTime := time.Now()
 
 	queue := GetQueueInstance()
+	snapshotReorder.setReprocessing(true)
 	defer func() {
+		snapshotReorder.setReprocessing(false)
 		queue.Unlock()
 		if err := queue.ProcessQueue(cfg); err != nil {
 			log.Printf("Error processing queued snapshots: %v", err)
@@ -183,36 +185,7 @@ func EvaluateRecordingRules(cfg *config.Config, start, end time.Time) error {
 		log.Printf("Successfully created blocks. Output: %s", output)
 	}
 
-	// Move the generated blocks from the data subdirectory to prometheus data directory
-	entries, err := os.ReadDir(dataDir)
-	if err != nil {
-		return fmt.Errorf("read data directory: %w", err)
-	}
-
-	// Replace the existing move block code with:
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		source := path.Join(dataDir, entry.Name())
-		dest := path.Join("../../prometheus_data", entry.Name())
-
-		if err := copyDir(source, dest); err != nil {
-			return fmt.Errorf("copy block %s: %w", entry.Name(), err)
-		}
-
-		// Clean up source directory after successful copy
-		if err := os.RemoveAll(source); err != nil {
-			log.Printf("Warning: Failed to clean up source directory %s: %v", source, err)
-		}
-
-		if cfg.Debug {
-			log.Printf("Copied block %s to Prometheus data directory", entry.Name())
-		}
-	}
-
-	return nil
+	return moveBlocks(cfg, dataDir)
 }
 
 func copyDir(src, dst string) error {
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"collector-api/internal/storage"
+	"strings"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+)
+
+// snapshotSchema returns the tables, including partitioned tables, of a full
+// snapshot with their columns and indexes, in that order
+func snapshotSchema(snapshot *collector_proto.FullSnapshot) []storage.SchemaObject {
+	relationName := func(idx int32) (storage.SchemaObject, bool) {
+		if idx < 0 || int(idx) >= len(snapshot.RelationReferences) {
+			return storage.SchemaObject{}, false
+		}
+		ref := snapshot.RelationReferences[idx]
+		if ref.DatabaseIdx < 0 || int(ref.DatabaseIdx) >= len(snapshot.DatabaseReferences) {
+			return storage.SchemaObject{}, false
+		}
+		return storage.SchemaObject{
+			Database: snapshot.DatabaseReferences[ref.DatabaseIdx].GetName(),
+			Schema:   ref.SchemaName,
+			Relation: ref.RelationName,
+		}, true
+	}
+
+	var tables, columns, indexes []storage.SchemaObject
+	tracked := make(map[int32]bool)
+	for _, info := range snapshot.RelationInformations {
+		// Ordinary and partitioned tables
+		if info.RelationType != "r" && info.RelationType != "p" {
+			continue
+		}
+		table, ok := relationName(info.RelationIdx)
+		if !ok {
+			continue
+		}
+		tracked[info.RelationIdx] = true
+
+		table.Type, table.Name, table.Locked = storage.SchemaTable, table.Relation, info.ExclusivelyLocked
+		if info.HasParentRelation {
+			if parent, ok := relationName(info.ParentRelationIdx); ok {
+				table.Definition = strings.TrimSpace("PARTITION OF " + parent.Schema + "." + parent.Relation + " " + info.PartitionBoundary)
+			}
+		}
+		tables = append(tables, table)
+
+		for _, column := range info.Columns {
+			definition := column.DataType
+			if column.NotNull {
+				definition += " NOT NULL"
+			}
+			if column.GetDefaultValue().GetValid() {
+				definition += " DEFAULT " + column.GetDefaultValue().GetValue()
+			}
+			columns = append(columns, storage.SchemaObject{
+				Database:   table.Database,
+				Type:       storage.SchemaColumn,
+				Schema:     table.Schema,
+				Relation:   table.Relation,
+				Name:       column.Name,
+				Definition: definition,
+			})
+		}
+	}
+
+	for _, info := range snapshot.IndexInformations {
+		if !tracked[info.RelationIdx] || info.IndexIdx < 0 || int(info.IndexIdx) >= len(snapshot.IndexReferences) {
+			continue
+		}
+		table, _ := relationName(info.RelationIdx)
+		indexes = append(indexes, storage.SchemaObject{
+			Database:   table.Database,
+			Type:       storage.SchemaIndex,
+			Schema:     table.Schema,
+			Relation:   table.Relation,
+			Name:       snapshot.IndexReferences[info.IndexIdx].IndexName,
+			Definition: info.IndexDef,
+		})
+	}
+
+	return append(append(tables, columns...), indexes...)
+}
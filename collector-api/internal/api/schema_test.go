@@ -0,0 +1,62 @@
+package api
+
+import (
+	"collector-api/internal/storage"
+	"testing"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSnapshotSchema(t *testing.T) {
+	pbBytes, err := readAndDecompressSnapshot("test_data/full-snapshot-rds-1.binpb")
+	require.NoError(t, err)
+	var snapshot collector_proto.FullSnapshot
+	require.NoError(t, proto.Unmarshal(pbBytes, &snapshot))
+
+	byType := make(map[string][]storage.SchemaObject)
+	for _, o := range snapshotSchema(&snapshot) {
+		byType[o.Type] = append(byType[o.Type], o)
+	}
+	assert.Len(t, byType[storage.SchemaTable], 4)
+	assert.Len(t, byType[storage.SchemaColumn], 17)
+	assert.Len(t, byType[storage.SchemaIndex], 3)
+
+	assert.Contains(t, byType[storage.SchemaColumn], storage.SchemaObject{
+		Database: "postgres", Type: storage.SchemaColumn, Schema: "public", Relation: "pgbench_accounts", Name: "aid", Definition: "integer NOT NULL",
+	})
+	assert.Contains(t, byType[storage.SchemaIndex], storage.SchemaObject{
+		Database: "postgres", Type: storage.SchemaIndex, Schema: "public", Relation: "pgbench_accounts", Name: "pgbench_accounts_pkey",
+		Definition: "CREATE UNIQUE INDEX pgbench_accounts_pkey ON public.pgbench_accounts USING btree (aid)",
+	})
+}
+
+func TestSnapshotSchemaPartitions(t *testing.T) {
+	snapshot := &collector_proto.FullSnapshot{
+		DatabaseReferences: []*collector_proto.DatabaseReference{{Name: "app"}},
+		RelationReferences: []*collector_proto.RelationReference{
+			{SchemaName: "public", RelationName: "events"},
+			{SchemaName: "public", RelationName: "events_2024"},
+			{SchemaName: "public", RelationName: "events_view"},
+		},
+		RelationInformations: []*collector_proto.RelationInformation{
+			{RelationIdx: 0, RelationType: "p", Columns: []*collector_proto.RelationInformation_Column{
+				{Name: "created_at", DataType: "timestamp with time zone", NotNull: true,
+					DefaultValue: &collector_proto.NullString{Valid: true, Value: "now()"}},
+			}},
+			{RelationIdx: 1, RelationType: "r", HasParentRelation: true, ParentRelationIdx: 0,
+				PartitionBoundary: "FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')", ExclusivelyLocked: true},
+			{RelationIdx: 2, RelationType: "v"},
+		},
+	}
+
+	assert.Equal(t, []storage.SchemaObject{
+		{Database: "app", Type: storage.SchemaTable, Schema: "public", Relation: "events", Name: "events"},
+		{Database: "app", Type: storage.SchemaTable, Schema: "public", Relation: "events_2024", Name: "events_2024",
+			Definition: "PARTITION OF public.events FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')", Locked: true},
+		{Database: "app", Type: storage.SchemaColumn, Schema: "public", Relation: "events", Name: "created_at",
+			Definition: "timestamp with time zone NOT NULL DEFAULT now()"},
+	}, snapshotSchema(snapshot))
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"collector-api/internal/storage"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+)
+
+// snapshotSettings returns the raw values of every setting in a full snapshot
+func snapshotSettings(snapshot *collector_proto.FullSnapshot) []storage.SettingRep {
+	settings := make([]storage.SettingRep, 0, len(snapshot.Settings))
+	for _, setting := range snapshot.Settings {
+		settings = append(settings, storage.SettingRep{
+			Name:       setting.Name,
+			Value:      setting.CurrentValue,
+			Unit:       setting.GetUnit().GetValue(),
+			Source:     setting.GetSource().GetValue(),
+			SourceFile: setting.GetSourceFile().GetValue(),
+			BootValue:  setting.GetBootValue().GetValue(),
+			ResetValue: setting.GetResetValue().GetValue(),
+		})
+	}
+	return settings
+}
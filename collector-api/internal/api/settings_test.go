@@ -0,0 +1,42 @@
+package api
+
+import (
+	"collector-api/internal/storage"
+	"testing"
+
+	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSnapshotSettings(t *testing.T) {
+	pbBytes, err := readAndDecompressSnapshot("test_data/full-snapshot-rds-1.binpb")
+	require.NoError(t, err)
+	var snapshot collector_proto.FullSnapshot
+	require.NoError(t, proto.Unmarshal(pbBytes, &snapshot))
+
+	settings := snapshotSettings(&snapshot)
+	require.Len(t, settings, len(snapshot.Settings))
+
+	byName := make(map[string]storage.SettingRep)
+	for _, setting := range settings {
+		byName[setting.Name] = setting
+	}
+	assert.Equal(t, storage.SettingRep{
+		Name:       "shared_buffers",
+		Value:      "119369",
+		Unit:       "8kB",
+		Source:     "configuration file",
+		SourceFile: "/rdsdbdata/config/postgresql.conf",
+		BootValue:  "16384",
+		ResetValue: "119369",
+	}, byName["shared_buffers"])
+	assert.Equal(t, storage.SettingRep{
+		Name:       "autovacuum",
+		Value:      "on",
+		Source:     "default",
+		BootValue:  "on",
+		ResetValue: "on",
+	}, byName["autovacuum"], "non-numeric values are kept as is")
+}
@@ -4,6 +4,7 @@ import (
 	"collector-api/internal/auth"
 	"collector-api/internal/config"
 	"collector-api/internal/db"
+	"collector-api/internal/redaction"
 	"collector-api/internal/storage"
 	"collector-api/pkg/models"
 	"compress/zlib"
@@ -14,6 +15,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,10 +31,8 @@ func SnapshotHandler(w http.ResponseWriter, r *http.Request) {
 	// Load configuration
 	cfg, err := config.LoadConfigWithDefaultPath()
 	if err != nil {
+		log.Printf("Error loading config: %v", err)
 		http.Error(w, "Failed to load config", http.StatusInternalServerError)
-		if cfg.Debug {
-			log.Printf("Error loading config: %v", err)
-		}
 		return
 	}
 
@@ -111,14 +111,15 @@ func SnapshotHandler(w http.ResponseWriter, r *http.Request) {
 	if queue.IsLocked() {
 		// Queue the task for later processing
 		queue.Enqueue(task)
-		w.WriteHeader(http.StatusAccepted)
+		// The collector treats anything but 200 as a failed submission
+		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "Full snapshot queued for processing")
 		log.Printf("Full snapshot queued for processing: s3_location=%s, collected_at=%d", s3Location, collectedAt)
 		return
 	}
 
-	// Process immediately if not locked
-	err = HandleSnapshots(cfg, []SnapshotTask{task})
+	// Process (or hold for reordering) if not locked
+	held, err := holdOrProcess(cfg, task)
 	if err != nil {
 		if cfg.Debug {
 			log.Printf("Error handling full snapshot submission: %v", err)
@@ -127,6 +128,12 @@ func SnapshotHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if held {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Full snapshot queued for processing")
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "Full snapshot successfully processed")
 }
@@ -186,20 +193,33 @@ func HandleSnapshots(cfg *config.Config, tasks []SnapshotTask) error {
 		log.Printf("Processing %d snapshot tasks", len(tasks))
 	}
 
+	policies := configuredPolicies
+
+	// Snapshots are processed in collected_at order; the ones that arrived
+	// too late for that are written without stale markers, or backfilled
+	window := time.Duration(cfg.ReorderBuffer.OutOfOrderWindowSeconds) * time.Second
+	routes := snapshotReorder.route(tasks, window, time.Now())
+
 	// Group tasks by SystemInfo
-	tasksBySystem := make(map[SystemInfo][]SnapshotTask)
-	for _, task := range tasks {
-		tasksBySystem[task.SystemInfo] = append(tasksBySystem[task.SystemInfo], task)
+	tasksBySystem := make(map[SystemInfo][]routedTask)
+	for i, task := range tasks {
+		tasksBySystem[task.SystemInfo] = append(tasksBySystem[task.SystemInfo], routedTask{task, routes[i]})
+	}
+	for _, systemTasks := range tasksBySystem {
+		sort.SliceStable(systemTasks, func(i, j int) bool {
+			return systemTasks[i].CollectedAt < systemTasks[j].CollectedAt
+		})
 	}
 
 	// Process each system's tasks concurrently
 	var wg sync.WaitGroup
 	errorsChan := make(chan error, len(tasksBySystem))
 	metricsChan := make(chan []prompb.TimeSeries, len(tasks))
+	backfillChan := make(chan []prompb.TimeSeries, len(tasks))
 
 	for systemInfo, systemTasks := range tasksBySystem {
 		wg.Add(1)
-		go func(sysInfo SystemInfo, tasks []SnapshotTask) {
+		go func(sysInfo SystemInfo, tasks []routedTask) {
 			defer wg.Done()
 
 			promClient := prometheusClient{
@@ -208,6 +228,12 @@ func HandleSnapshots(cfg *config.Config, tasks []SnapshotTask) error {
 			}
 
 			var allQueries []storage.QueryRep
+			// Only the indexes of the latest full snapshot are kept, while
+			// the settings and schema of every full snapshot are diffed in
+			// order
+			var latestIndexes []storage.IndexRep
+			var latestIndexesAt int64
+			var states []fullSnapshotState
 
 			// Process tasks for this system serially
 			for _, task := range tasks {
@@ -218,12 +244,14 @@ func HandleSnapshots(cfg *config.Config, tasks []SnapshotTask) error {
 
 				var metrics []prompb.TimeSeries
 				var queries []storage.QueryRep
+				var state *fullSnapshotState
 				var err error
 
+				inOrder := task.route == routeLive
 				if task.IsCompact {
-					metrics, queries, err = processCompactSnapshotData(&promClient, task.S3Location, sysInfo, task.CollectedAt)
+					metrics, queries, err = processCompactSnapshotData(&promClient, task.S3Location, sysInfo, task.CollectedAt, policies, inOrder)
 				} else {
-					metrics, err = processFullSnapshotData(&promClient, task.S3Location, sysInfo, task.CollectedAt)
+					metrics, state, err = processFullSnapshotData(&promClient, task.S3Location, sysInfo, task.CollectedAt, policies, inOrder)
 				}
 
 				if err != nil {
@@ -233,16 +261,80 @@ func HandleSnapshots(cfg *config.Config, tasks []SnapshotTask) error {
 				}
 
 				allQueries = append(allQueries, queries...)
+				if state != nil {
+					// A failed run reports no indexes, which must not be
+					// taken as all of them being dropped
+					if !state.failedRun && len(state.indexes) > 0 {
+						latestIndexes, latestIndexesAt = state.indexes, task.CollectedAt
+					}
+					states = append(states, *state)
+				}
 
-				metricsChan <- metrics
+				if task.route == routeBackfill {
+					backfillChan <- metrics
+				} else {
+					metricsChan <- metrics
+				}
 			}
 
+			var storeErrors []error
+
 			// Batch store queries
 			if len(allQueries) > 0 {
-				if err := storage.QueryStore.StoreBatchQueries(allQueries); err != nil {
-					errorsChan <- fmt.Errorf("store batch queries: %w", err)
+				if err := storage.QueryStore.StoreBatchQueries(policies.redactor.Apply(allQueries)); err != nil {
+					storeErrors = append(storeErrors, fmt.Errorf("store batch queries: %w", err))
+				}
+			}
+
+			systemRef := storage.SystemRef{
+				SystemID:    sysInfo.SystemID,
+				SystemScope: sysInfo.SystemScope,
+				SystemType:  sysInfo.SystemType,
+			}
+
+			if storage.InstanceStore != nil && len(tasks) > 0 {
+				var facts *storage.InstanceRep
+				for _, snapshot := range states {
+					if snapshot.instance != nil {
+						facts = snapshot.instance
+					}
+				}
+				firstSeen, lastSeen := tasks[0].CollectedAt, tasks[len(tasks)-1].CollectedAt
+				if err := storage.InstanceStore.StoreInstance(systemRef, firstSeen, lastSeen, facts); err != nil {
+					storeErrors = append(storeErrors, fmt.Errorf("store instance: %w", err))
 				}
 			}
+
+			if latestIndexes != nil && storage.IndexStore != nil {
+				if err := storage.IndexStore.StoreIndexes(systemRef, latestIndexesAt, latestIndexes); err != nil {
+					storeErrors = append(storeErrors, fmt.Errorf("store indexes: %w", err))
+				}
+			}
+
+			if storage.SettingStore != nil {
+				for _, snapshot := range states {
+					if snapshot.failedRun {
+						continue
+					}
+					if err := storage.SettingStore.StoreSettings(systemRef, snapshot.collectedAt, snapshot.settings); err != nil {
+						storeErrors = append(storeErrors, fmt.Errorf("store settings collected at %d: %w", snapshot.collectedAt, err))
+						break
+					}
+				}
+			}
+
+			if storage.SchemaStore != nil {
+				for _, snapshot := range states {
+					if err := storage.SchemaStore.StoreSchema(systemRef, snapshot.collectedAt, snapshot.schema); err != nil {
+						storeErrors = append(storeErrors, fmt.Errorf("store schema collected at %d: %w", snapshot.collectedAt, err))
+						break
+					}
+				}
+			}
+
+			if len(storeErrors) > 0 {
+				errorsChan <- combineErrors(storeErrors)
+			}
 		}(systemInfo, systemTasks)
 	}
 
@@ -251,6 +343,7 @@ func HandleSnapshots(cfg *config.Config, tasks []SnapshotTask) error {
 		wg.Wait()
 		close(errorsChan)
 		close(metricsChan)
+		close(backfillChan)
 	}()
 
 	// Collect results
@@ -261,10 +354,16 @@ func HandleSnapshots(cfg *config.Config, tasks []SnapshotTask) error {
 	estimatedMetricsPerTask := 1000
 	allMetrics = make([]prompb.TimeSeries, 0, len(tasks)*estimatedMetricsPerTask)
 
+	var backfill []prompb.TimeSeries
+
 	for metrics := range metricsChan {
 		allMetrics = append(allMetrics, metrics...)
 	}
 
+	for metrics := range backfillChan {
+		backfill = append(backfill, metrics...)
+	}
+
 	for err := range errorsChan {
 		errors = append(errors, err)
 	}
@@ -283,6 +382,13 @@ func HandleSnapshots(cfg *config.Config, tasks []SnapshotTask) error {
 		log.Printf("No metrics to send, skipping remote write")
 	}
 
+	if len(backfill) > 0 {
+		log.Printf("Backfilling %d time series of snapshots older than the out-of-order window", len(backfill))
+		if err := backfillMetrics(cfg, backfill); err != nil {
+			errors = append(errors, fmt.Errorf("backfill metrics: %w", err))
+		}
+	}
+
 	if cfg.Debug && len(errors) == 0 {
 		log.Printf("All snapshot tasks processed successfully!")
 	}
@@ -295,6 +401,66 @@ func HandleSnapshots(cfg *config.Config, tasks []SnapshotTask) error {
 	return nil
 }
 
+type routedTask struct {
+	SnapshotTask
+	route snapshotRoute
+}
+
+// snapshotPolicies shapes the metrics and query text generated from
+// snapshots. The zero value reports everything unchanged.
+type snapshotPolicies struct {
+	activityLabels *activityLabelPolicy
+	relations      *relationPolicy
+	counters       *counterPolicy
+	relabeler      *metricRelabeler
+	redactor       *redaction.Redactor // Query text is redacted before it ever reaches storage
+}
+
+// configuredPolicies are the policies of the config, set by ConfigureSnapshots
+// at startup
+var configuredPolicies snapshotPolicies
+
+// ConfigureSnapshots builds the snapshot policies of cfg once, before any
+// snapshot is processed
+func ConfigureSnapshots(cfg *config.Config) error {
+	policies, err := newSnapshotPolicies(cfg)
+	if err != nil {
+		return err
+	}
+	configuredPolicies = policies
+	return nil
+}
+
+func newSnapshotPolicies(cfg *config.Config) (snapshotPolicies, error) {
+	activityLabels, err := newActivityLabelPolicy(cfg.ActivityLabels)
+	if err != nil {
+		return snapshotPolicies{}, fmt.Errorf("configure activity labels: %w", err)
+	}
+
+	relations, err := newRelationPolicy(cfg.RelationMetrics)
+	if err != nil {
+		return snapshotPolicies{}, fmt.Errorf("configure relation metrics: %w", err)
+	}
+
+	relabeler, err := newMetricRelabeler(cfg.MetricRelabeling)
+	if err != nil {
+		return snapshotPolicies{}, fmt.Errorf("configure metric relabeling: %w", err)
+	}
+
+	redactor, err := redaction.New(cfg.QueryRedaction)
+	if err != nil {
+		return snapshotPolicies{}, fmt.Errorf("configure query redaction: %w", err)
+	}
+
+	return snapshotPolicies{
+		activityLabels: activityLabels,
+		relations:      relations,
+		counters:       newCounterPolicy(cfg.CounterMetrics),
+		relabeler:      relabeler,
+		redactor:       redactor,
+	}, nil
+}
+
 // Helper function to combine multiple errors into a single error message
 func combineErrors(errors []error) error {
 	if len(errors) == 0 {
@@ -311,18 +477,45 @@ func combineErrors(errors []error) error {
 	return fmt.Errorf(combined.String())
 }
 
-func processFullSnapshotData(promClient *prometheusClient, s3Location string, systemInfo SystemInfo, collectedAt int64) ([]prompb.TimeSeries, error) {
+// fullSnapshotState is what a full snapshot reports about the objects of a
+// system, kept in SQLite rather than as metrics
+type fullSnapshotState struct {
+	collectedAt int64
+	failedRun   bool
+	instance    *storage.InstanceRep
+	indexes     []storage.IndexRep
+	settings    []storage.SettingRep
+	schema      []storage.SchemaObject
+}
+
+// processFullSnapshotData returns the metrics and the object state of a full
+// snapshot. Stale markers are only derived for snapshots processed in order.
+func processFullSnapshotData(promClient *prometheusClient, s3Location string, systemInfo SystemInfo, collectedAt int64, policies snapshotPolicies, inOrder bool) ([]prompb.TimeSeries, *fullSnapshotState, error) {
 	pbBytes, err := readAndDecompressSnapshot(s3Location)
 	if err != nil {
-		return nil, fmt.Errorf("read and decompress snapshot: %w", err)
+		return nil, nil, fmt.Errorf("read and decompress snapshot: %w", err)
 	}
 
 	var fullSnapshot collector_proto.FullSnapshot
 	if err := proto.Unmarshal(pbBytes, &fullSnapshot); err != nil {
-		return nil, fmt.Errorf("unmarshal full snapshot: %w", err)
+		return nil, nil, fmt.Errorf("unmarshal full snapshot: %w", err)
+	}
+	state := &fullSnapshotState{
+		collectedAt: collectedAt,
+		failedRun:   fullSnapshot.FailedRun,
+		instance:    snapshotInstance(&fullSnapshot, collectedAt),
+		indexes:     snapshotIndexes(&fullSnapshot),
+		settings:    snapshotSettings(&fullSnapshot),
+		schema:      snapshotSchema(&fullSnapshot),
 	}
 
-	currentMetrics := fullSnapshotMetrics(&fullSnapshot, systemInfo, collectedAt)
+	currentMetrics := fullSnapshotMetrics(&fullSnapshot, systemInfo, collectedAt, policies.relations)
+	currentMetrics = policies.counters.apply(systemInfo, currentMetrics, collectedAt*1000, inOrder)
+	currentMetrics, merged := policies.relabeler.apply(currentMetrics)
+	currentMetrics = append(currentMetrics, relabelMergedMetrics(systemInfo, FullSnapshotType, merged, collectedAt*1000)...)
+	if !inOrder {
+		return currentMetrics, state, nil
+	}
 
 	if previousMetrics[systemInfo] == nil {
 		err := initializePreviousMetrics(promClient, systemInfo, FullSnapshotType)
@@ -337,7 +530,7 @@ func processFullSnapshotData(promClient *prometheusClient, s3Location string, sy
 
 	previousMetrics[systemInfo][FullSnapshotType] = currentMetrics
 
-	return allMetrics, nil
+	return allMetrics, state, nil
 }
 
 func initializePreviousMetrics(promClient *prometheusClient, systemInfo SystemInfo, snapshotType string) error {
@@ -381,9 +574,12 @@ func initializePreviousMetrics(promClient *prometheusClient, systemInfo SystemIn
 
 		skip := false
 
-		// Convert metric labels to prompb.Label format
+		// Convert metric labels to prompb.Label format. Full snapshots leave
+		// out cc_pg_stat_activity and the cc_pg_stat_activity_folded_*
+		// series that go with it, which activity snapshots write and mark
+		// stale
 		for name, value := range sample.Metric {
-			if snapshotType == FullSnapshotType && name == "__name__" && value == "cc_pg_stat_activity" {
+			if snapshotType == FullSnapshotType && name == "__name__" && strings.HasPrefix(string(value), "cc_pg_stat_activity") {
 				skip = true
 				break
 			}
@@ -410,10 +606,8 @@ func CompactSnapshotHandler(w http.ResponseWriter, r *http.Request) {
 	// Load configuration
 	cfg, err := config.LoadConfigWithDefaultPath()
 	if err != nil {
+		log.Printf("Error loading config: %v", err)
 		http.Error(w, "Failed to load config", http.StatusInternalServerError)
-		if cfg.Debug {
-			log.Printf("Error loading config: %v", err)
-		}
 		return
 	}
 
@@ -498,8 +692,8 @@ func CompactSnapshotHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Simulate handling the compact snapshot (you'll replace this with your actual logic)
-	err = HandleSnapshots(cfg, []SnapshotTask{task})
+	// Process (or hold for reordering) if not locked
+	held, err := holdOrProcess(cfg, task)
 	if err != nil {
 		if cfg.Debug {
 			log.Printf("Error handling compact snapshot: %v", err)
@@ -508,6 +702,12 @@ func CompactSnapshotHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if held {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Compact snapshot queued for processing")
+		return
+	}
+
 	// Respond with success
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "Compact snapshot successfully processed")
@@ -570,7 +770,9 @@ func readAndDecompressSnapshot(s3Location string) ([]byte, error) {
 	return pbBytes, nil
 }
 
-func processCompactSnapshotData(promClient *prometheusClient, s3Location string, systemInfo SystemInfo, collectedAt int64) ([]prompb.TimeSeries, []storage.QueryRep, error) {
+// processCompactSnapshotData returns the metrics and queries of a compact
+// snapshot. Stale markers are only derived for snapshots processed in order.
+func processCompactSnapshotData(promClient *prometheusClient, s3Location string, systemInfo SystemInfo, collectedAt int64, policies snapshotPolicies, inOrder bool) ([]prompb.TimeSeries, []storage.QueryRep, error) {
 	pbBytes, err := readAndDecompressSnapshot(s3Location)
 	if err != nil {
 		return nil, nil, fmt.Errorf("read and decompress snapshot: %w", err)
@@ -589,6 +791,13 @@ func processCompactSnapshotData(promClient *prometheusClient, s3Location string,
 	if baseRef != nil {
 		queryRefs := baseRef.GetQueryReferences()
 		queryInfos := baseRef.GetQueryInformations()
+		databaseRefs := baseRef.GetDatabaseReferences()
+		roleRefs := baseRef.GetRoleReferences()
+		systemRef := storage.SystemRef{
+			SystemID:    systemInfo.SystemID,
+			SystemScope: systemInfo.SystemScope,
+			SystemType:  systemInfo.SystemType,
+		}
 
 		for _, backend := range backends {
 			if !backend.GetHasQueryIdx() {
@@ -608,12 +817,21 @@ func processCompactSnapshotData(promClient *prometheusClient, s3Location string,
 			fp := string(queryRefs[idx].GetFingerprint())
 			fingerprint := base64.StdEncoding.EncodeToString([]byte(fp))
 
-			queries = append(queries, storage.QueryRep{
+			queryRep := storage.QueryRep{
+				System:      systemRef,
 				Fingerprint: fingerprint,
 				Query:       query,
 				QueryFull:   backend.GetQueryText(),
 				CollectedAt: collectedAt,
-			})
+			}
+			if backend.GetHasDatabaseIdx() && int(backend.GetDatabaseIdx()) < len(databaseRefs) {
+				queryRep.Database = databaseRefs[backend.GetDatabaseIdx()].GetName()
+			}
+			if backend.GetHasRoleIdx() && int(backend.GetRoleIdx()) < len(roleRefs) {
+				queryRep.Role = roleRefs[backend.GetRoleIdx()].GetName()
+			}
+
+			queries = append(queries, queryRep)
 		}
 	}
 
@@ -623,7 +841,11 @@ func processCompactSnapshotData(promClient *prometheusClient, s3Location string,
 	// Handle different types of snapshot data
 	switch data := compactSnapshot.Data.(type) {
 	case *collector_proto.CompactSnapshot_ActivitySnapshot:
-		currentMetrics = compactSnapshotMetrics(&compactSnapshot, systemInfo, collectedAt)
+		currentMetrics = compactSnapshotMetrics(&compactSnapshot, systemInfo, collectedAt, policies.activityLabels)
+		currentMetrics = append(currentMetrics, oldestTransactionMetrics(&compactSnapshot, systemInfo, collectedAt)...)
+		var merged int
+		currentMetrics, merged = policies.relabeler.apply(currentMetrics)
+		currentMetrics = append(currentMetrics, relabelMergedMetrics(systemInfo, CompactActivitySnapshotType, merged, collectedAt*1000)...)
 		snapshotType = CompactActivitySnapshotType
 	case *collector_proto.CompactSnapshot_LogSnapshot:
 		snapshotType = CompactLogSnapshotType
@@ -637,7 +859,7 @@ func processCompactSnapshotData(promClient *prometheusClient, s3Location string,
 		log.Printf("Unknown compact snapshot type: %T", data)
 	}
 
-	if snapshotType != CompactActivitySnapshotType {
+	if snapshotType != CompactActivitySnapshotType || !inOrder {
 		return currentMetrics, queries, nil
 	}
 
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"collector-api/internal/config"
 	"testing"
 
 	collector_proto "github.com/pganalyze/collector/output/pganalyze_collector"
@@ -47,7 +48,7 @@ func TestProcessFullSnapshotData(t *testing.T) {
 			}
 
 			// Call processFullSnapshotData
-			allMetrics, err := processFullSnapshotData(nil, tc.filename, systemInfo, 0)
+			allMetrics, _, err := processFullSnapshotData(nil, tc.filename, systemInfo, 0, snapshotPolicies{}, true)
 			assert.NoError(t, err)
 
 			// for _, metric := range allMetrics {
@@ -116,3 +117,19 @@ func containsMetric(metrics []prompb.TimeSeries, metricName string) bool {
 	}
 	return false
 }
+
+func TestConfigureSnapshots(t *testing.T) {
+	t.Cleanup(func() { configuredPolicies = snapshotPolicies{} })
+
+	err := ConfigureSnapshots(&config.Config{QueryRedaction: config.QueryRedactionConfig{
+		Rules: []config.QueryRedactionRule{{Name: "emails", Pattern: "[a-z]+@[a-z.]+", Replacement: "<email>"}},
+	}})
+	assert.NoError(t, err)
+	assert.True(t, configuredPolicies.redactor.Enabled())
+
+	err = ConfigureSnapshots(&config.Config{QueryRedaction: config.QueryRedactionConfig{
+		Rules: []config.QueryRedactionRule{{Name: "broken", Pattern: "("}},
+	}})
+	assert.ErrorContains(t, err, "configure query redaction")
+	assert.True(t, configuredPolicies.redactor.Enabled(), "an invalid config keeps the policies in use")
+}
@@ -21,10 +21,8 @@ type s3UploadResponse struct {
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	cfg, err := config.LoadConfigWithDefaultPath()
 	if err != nil {
+		log.Printf("Error loading config: %v", err)
 		http.Error(w, "Failed to load config", http.StatusInternalServerError)
-		if cfg.Debug {
-			log.Printf("Error loading config: %v", err)
-		}
 		return
 	}
 
@@ -10,12 +10,95 @@ import (
 var globalConfigPath string = "collector-api-config.json" // Default config path
 
 type Config struct {
-	ServerHost string `json:"server_host"`
-	ServerPort int    `json:"server_port"`
-	DBPath     string `json:"db_path"`     // Path to SQLite database file
-	StorageDir string `json:"storage_dir"` // Base storage directory
-	APIKey     string `json:"api_key"`
-	Debug      bool   `json:"debug"` // Enable or disable debug logging
+	ServerHost       string                 `json:"server_host"`
+	ServerPort       int                    `json:"server_port"`
+	DBPath           string                 `json:"db_path"`     // Path to SQLite database file
+	StorageDir       string                 `json:"storage_dir"` // Base storage directory
+	APIKey           string                 `json:"api_key"`
+	Debug            bool                   `json:"debug"`              // Enable or disable debug logging
+	QueryRedaction   QueryRedactionConfig   `json:"query_redaction"`    // Redaction applied to query text before it is stored
+	ActivityLabels   ActivityLabelsConfig   `json:"activity_labels"`    // Label set and cardinality budgets of cc_pg_stat_activity
+	MetricRelabeling MetricRelabelingConfig `json:"metric_relabeling"`  // Rewriting and filtering of all metrics before remote write
+	RelationMetrics  RelationMetricsConfig  `json:"relation_metrics"`   // Which tables and indexes get cc_relation_* and cc_index_* metrics
+	ReorderBuffer    ReorderBufferConfig    `json:"reorder_buffer"`     // Ordering of snapshots that arrive late or out of order
+	CounterMetrics   CounterMetricsConfig   `json:"counter_metrics"`    // Reset-safe series derived from cumulative statistics
+	SchemaTableLimit int                    `json:"schema_table_limit"` // Sent to the collector in the grant: above this many tables per server it stops sending schema information (0 for the collector default of 5000)
+}
+
+// CounterMetricsConfig controls the series derived from cumulative statistics
+// such as cc_db_xact_commit, which snapshots carry as per-interval changes
+type CounterMetricsConfig struct {
+	Totals bool `json:"totals"` // Emit <name>_total counters, for rate() and increase()
+	Deltas bool `json:"deltas"` // Emit <name>_delta gauges of the increase per snapshot, with resets counted as zero
+}
+
+// ReorderBufferConfig controls how snapshots are ordered before their metrics
+// are written. Snapshots collected longer than OutOfOrderWindowSeconds ago are
+// written as TSDB blocks instead, since the live TSDB would reject them.
+type ReorderBufferConfig struct {
+	HoldSeconds             int `json:"hold_seconds"`                // Hold each snapshot this long so later-arriving, earlier snapshots go first (0 processes right away)
+	OutOfOrderWindowSeconds int `json:"out_of_order_window_seconds"` // Keep below Prometheus' out_of_order_time_window (0 writes everything live)
+}
+
+// RelationMetricsConfig bounds the tables and indexes reported per system.
+// Include and Exclude are "schema.table" glob patterns (as in path.Match); an
+// empty Include matches every table. Indexes follow the table they belong to.
+type RelationMetricsConfig struct {
+	Include          []string `json:"include"`
+	Exclude          []string `json:"exclude"`
+	TableLimit       int      `json:"table_limit"`       // Report only the N largest tables (0 for no limit)
+	RollupPartitions bool     `json:"rollup_partitions"` // Report partitions as part of their top-level parent table
+}
+
+// MetricRelabelingConfig adds static labels to every metric and then applies
+// Prometheus-style relabel configs, in order. Series left with identical
+// labels are summed, and how many were merged is written as
+// cc_relabel_merged_series.
+//
+// Rewriting or dropping sys_id, sys_scope or sys_type is allowed but has a
+// cost. After a restart, the series of a system are looked up by its original
+// labels, so stale markers are not written for the rewritten ones. The bff
+// also selects metrics by the labels of the registry and stored queries,
+// which keep the original values, so such systems show without metrics there.
+type MetricRelabelingConfig struct {
+	StaticLabels   map[string]string `json:"static_labels"` // Added unless the metric already has the label
+	RelabelConfigs []RelabelConfig   `json:"relabel_configs"`
+}
+
+// RelabelConfig mirrors a Prometheus relabel_config. Unset fields take the
+// Prometheus defaults (separator ";", regex "(.*)", replacement "$1", action "replace").
+type RelabelConfig struct {
+	SourceLabels []string `json:"source_labels"`
+	Separator    *string  `json:"separator"`
+	Regex        *string  `json:"regex"`
+	Modulus      uint64   `json:"modulus"`
+	TargetLabel  string   `json:"target_label"`
+	Replacement  *string  `json:"replacement"`
+	Action       string   `json:"action"`
+}
+
+// ActivityLabelsConfig controls the labels written for cc_pg_stat_activity.
+// CardinalityLimits maps a label name to the number of distinct values kept
+// per system; less active values are folded into a single "__other__" value.
+type ActivityLabelsConfig struct {
+	DropLabels           []string       `json:"drop_labels"`             // Labels left off entirely, e.g. client_addr
+	ClientAddrIPv4Prefix int            `json:"client_addr_ipv4_prefix"` // Bucket IPv4 client addresses into CIDRs of this prefix length (0 keeps full addresses)
+	ClientAddrIPv6Prefix int            `json:"client_addr_ipv6_prefix"` // Bucket IPv6 client addresses into CIDRs of this prefix length (0 keeps full addresses)
+	CardinalityLimits    map[string]int `json:"cardinality_limits"`
+}
+
+// QueryRedactionConfig controls how query text is scrubbed before it is persisted
+type QueryRedactionConfig struct {
+	DropFullText    bool                 `json:"drop_full_text"`   // Never store the full (non-normalized) query text
+	ReplaceLiterals bool                 `json:"replace_literals"` // Replace string and numeric literals in the full query text
+	Rules           []QueryRedactionRule `json:"rules"`            // Regex rules applied to both normalized and full query text
+}
+
+// QueryRedactionRule replaces every match of Pattern with Replacement
+type QueryRedactionRule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
 }
 
 func LoadConfig(configPath string) (*Config, error) {
@@ -4,97 +4,51 @@ import (
 	"collector-api/pkg/models"
 	"database/sql"
 	"fmt"
-	"log"
 	"os"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
 var db *sql.DB
 
+// InitDB opens the SQLite database at dbPath and brings its schema up to date.
 func InitDB(dbPath string) (*sql.DB, error) {
-	var err error
+	database, err := OpenDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Migrate(database); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("migrate database schema: %w", err)
+	}
+
+	db = database
+	return db, nil
+}
 
+// OpenDB opens the SQLite database at dbPath, creating the file if needed,
+// without applying any migrations. In-memory databases and file: URIs are
+// passed to SQLite as they are.
+func OpenDB(dbPath string) (*sql.DB, error) {
 	// Create the SQLite database file if it doesn't exist
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+	isFile := dbPath != ":memory:" && !strings.HasPrefix(dbPath, "file:")
+	if _, err := os.Stat(dbPath); isFile && os.IsNotExist(err) {
 		file, err := os.Create(dbPath)
-		defer file.Close()
 		if err != nil {
 			return nil, fmt.Errorf("failed to create database file: %w", err)
 		}
+		file.Close()
 	}
 
 	// Open the SQLite database
-	db, err = sql.Open("sqlite3", dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to SQLite database: %w", err)
 	}
 
-	// Initialize database schema
-	initSchema()
-
-	return db, nil
-}
-
-func initSchema() {
-	// Create the necessary tables for snapshots
-	createSnapshotTable := `
-	CREATE TABLE IF NOT EXISTS snapshots (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		collected_at INTEGER,
-		s3_location TEXT,
-        system_id TEXT,
-        system_scope TEXT,
-        system_type TEXT
-	);`
-
-	createCompactSnapshotTable := `
-	CREATE TABLE IF NOT EXISTS compact_snapshots (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		collected_at INTEGER,
-		s3_location TEXT,
-        system_id TEXT,
-        system_scope TEXT,
-        system_type TEXT
-	);`
-
-	_, err := db.Exec(createSnapshotTable)
-	if err != nil {
-		log.Fatalf("Error creating snapshots table: %v", err)
-	}
-
-	_, err = db.Exec(createCompactSnapshotTable)
-	if err != nil {
-		log.Fatalf("Error creating compact_snapshots table: %v", err)
-	}
-
-	// Check if we need to add new columns
-	if err := addColumnsIfNotExist("snapshots", []string{"system_id", "system_scope", "system_type"}); err != nil {
-		log.Fatalf("Error adding columns to snapshots table: %v", err)
-	}
-
-	if err := addColumnsIfNotExist("compact_snapshots", []string{"system_id", "system_scope", "system_type"}); err != nil {
-		log.Fatalf("Error adding columns to compact_snapshots table: %v", err)
-	}
-}
-
-func addColumnsIfNotExist(table string, columns []string) error {
-	for _, col := range columns {
-		var count int
-		query := fmt.Sprintf("SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name='%s';", table, col)
-		err := db.QueryRow(query).Scan(&count)
-		if err != nil {
-			return fmt.Errorf("check column existence: %w", err)
-		}
-
-		if count == 0 {
-			_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s TEXT;", table, col))
-			if err != nil {
-				return fmt.Errorf("add column %s: %w", col, err)
-			}
-		}
-	}
-	return nil
+	return database, nil
 }
 
 func StoreSnapshotMetadata(snapshot models.Snapshot) error {
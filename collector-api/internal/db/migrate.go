@@ -0,0 +1,316 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer build
+// than the one currently running.
+var ErrSchemaTooNew = errors.New("database schema is newer than this build supports")
+
+// Migration is a single, ordered up-migration of the SQLite schema.
+type Migration struct {
+	Version int
+	Name    string
+	up      func(tx *sql.Tx) error
+}
+
+// MigrationStatus describes whether a known migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// goMigrations holds migrations that cannot be expressed as plain SQL.
+var goMigrations = []Migration{
+	{
+		// Databases created before system info was tracked lack these columns.
+		Version: 2,
+		Name:    "snapshot_system_columns",
+		up: func(tx *sql.Tx) error {
+			columns := []string{"system_id", "system_scope", "system_type"}
+			if err := addColumnsIfNotExist(tx, "snapshots", columns); err != nil {
+				return err
+			}
+			return addColumnsIfNotExist(tx, "compact_snapshots", columns)
+		},
+	},
+	{
+		// Full-text index over system_queries, kept in sync by triggers
+		Version: 5,
+		Name:    "query_search",
+		up:      createQuerySearchIndex,
+	},
+}
+
+// createQuerySearchIndex creates the query_search full-text table. FTS5 is used
+// when the SQLite driver was built with the sqlite_fts5 tag, falling back to
+// FTS4 otherwise; both answer the same MATCH queries.
+func createQuerySearchIndex(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS query_search USING fts5(query, full_query)`)
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		_, err = tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS query_search USING fts4(query, full_query)`)
+	}
+	if err != nil {
+		return fmt.Errorf("create query_search table: %w", err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO query_search (rowid, query, full_query)
+		SELECT rowid, query, full_query FROM system_queries;
+
+	CREATE TRIGGER IF NOT EXISTS system_queries_search_insert AFTER INSERT ON system_queries
+	BEGIN
+		INSERT INTO query_search (rowid, query, full_query) VALUES (new.rowid, new.query, new.full_query);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS system_queries_search_update AFTER UPDATE OF query, full_query ON system_queries
+	WHEN new.query IS NOT old.query OR new.full_query IS NOT old.full_query
+	BEGIN
+		UPDATE query_search SET query = new.query, full_query = new.full_query WHERE rowid = new.rowid;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS system_queries_search_delete AFTER DELETE ON system_queries
+	BEGIN
+		DELETE FROM query_search WHERE rowid = old.rowid;
+	END;`)
+	if err != nil {
+		return fmt.Errorf("populate query_search table: %w", err)
+	}
+	return nil
+}
+
+// Migrations returns all known migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read embedded migrations: %w", err)
+	}
+
+	migrations := append([]Migration(nil), goMigrations...)
+	for _, entry := range entries {
+		version, name, err := parseMigrationFilename(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+
+		stmt := string(contents)
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			up: func(tx *sql.Tx) error {
+				_, err := tx.Exec(stmt)
+				return err
+			},
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous from 1: found %d (%s) at position %d", m.Version, m.Name, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// parseMigrationFilename splits "0003_query_tables.sql" into 3 and "query_tables"
+func parseMigrationFilename(filename string) (int, string, error) {
+	base := strings.TrimSuffix(filename, ".sql")
+	versionStr, name, found := strings.Cut(base, "_")
+	if !found || name == "" {
+		return 0, "", fmt.Errorf("invalid migration filename %q: expected <version>_<name>.sql", filename)
+	}
+
+	version, err := strconv.Atoi(versionStr)
+	if err != nil || version <= 0 {
+		return 0, "", fmt.Errorf("invalid migration filename %q: version must be a positive integer", filename)
+	}
+
+	return version, name, nil
+}
+
+// LatestSchemaVersion returns the highest migration version known to this build.
+func LatestSchemaVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations), nil
+}
+
+func ensureSchemaVersionTable(database *sql.DB) error {
+	_, err := database.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return fmt.Errorf("create schema_version table: %w", err)
+	}
+	return nil
+}
+
+// CurrentSchemaVersion returns the highest applied migration version, or 0 for
+// a database that has never been migrated.
+func CurrentSchemaVersion(database *sql.DB) (int, error) {
+	if err := ensureSchemaVersionTable(database); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := database.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Migrate applies every pending migration, each in its own transaction. It
+// refuses to touch a database whose schema is newer than this build knows about.
+func Migrate(database *sql.DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	current, err := CurrentSchemaVersion(database)
+	if err != nil {
+		return err
+	}
+
+	if current > len(migrations) {
+		return fmt.Errorf("%w: database is at version %d, latest known version is %d", ErrSchemaTooNew, current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		if err := applyMigration(database, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(database *sql.DB, m Migration) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	defer tx.Rollback() // Will be ignored if tx.Commit() is called
+
+	if err := m.up(tx); err != nil {
+		return fmt.Errorf("apply migration %d (%s): %w", m.Version, m.Name, err)
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("record migration %d (%s): %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// GetMigrationStatus reports every known migration and whether it has been
+// applied. Versions recorded in the database but unknown to this build are
+// included with an empty name.
+func GetMigrationStatus(database *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureSchemaVersionTable(database); err != nil {
+		return nil, err
+	}
+
+	rows, err := database.Query("SELECT version, applied_at FROM schema_version ORDER BY version ASC")
+	if err != nil {
+		return nil, fmt.Errorf("query schema_version: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(appliedAt, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+		delete(applied, m.Version)
+	}
+
+	for version, appliedAt := range applied {
+		statuses = append(statuses, MigrationStatus{
+			Version:   version,
+			Applied:   true,
+			AppliedAt: appliedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+func addColumnsIfNotExist(tx *sql.Tx, table string, columns []string) error {
+	for _, col := range columns {
+		var count int
+		query := fmt.Sprintf("SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name='%s';", table, col)
+		err := tx.QueryRow(query).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("check column existence: %w", err)
+		}
+
+		if count == 0 {
+			_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s TEXT;", table, col))
+			if err != nil {
+				return fmt.Errorf("add column %s: %w", col, err)
+			}
+		}
+	}
+	return nil
+}
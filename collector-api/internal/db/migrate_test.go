@@ -0,0 +1,140 @@
+package db_test
+
+import (
+	"collector-api/internal/db"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+
+	latest, err := db.LatestSchemaVersion()
+	require.NoError(t, err)
+
+	current, err := db.CurrentSchemaVersion(database)
+	assert.NoError(t, err)
+	assert.Equal(t, latest, current)
+
+	for _, table := range []string{"snapshots", "compact_snapshots", "queries", "full_queries"} {
+		var count int
+		err := database.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&count)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count, "table %s should exist", table)
+	}
+
+	// Re-running is a no-op
+	assert.NoError(t, db.Migrate(database))
+	current, err = db.CurrentSchemaVersion(database)
+	assert.NoError(t, err)
+	assert.Equal(t, latest, current)
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// A database created before schema_version existed and before system info was tracked
+	legacy, err := db.OpenDB(dbPath)
+	require.NoError(t, err)
+	_, err = legacy.Exec(`
+		CREATE TABLE snapshots (id INTEGER PRIMARY KEY AUTOINCREMENT, collected_at INTEGER, s3_location TEXT);
+		CREATE TABLE compact_snapshots (id INTEGER PRIMARY KEY AUTOINCREMENT, collected_at INTEGER, s3_location TEXT);
+		INSERT INTO snapshots (collected_at, s3_location) VALUES (1234567890, '/test/dir');`)
+	require.NoError(t, err)
+	legacy.Close()
+
+	database, err := db.InitDB(dbPath)
+	require.NoError(t, err)
+	defer database.Close()
+
+	snapshots, err := db.GetAllFullSnapshots()
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	assert.Equal(t, int64(1234567890), snapshots[0].CollectedAt)
+	assert.Equal(t, "", snapshots[0].SystemID)
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "newer.db")
+
+	database, err := db.InitDB(dbPath)
+	require.NoError(t, err)
+
+	latest, err := db.LatestSchemaVersion()
+	require.NoError(t, err)
+	_, err = database.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'from_the_future', 0)", latest+1)
+	require.NoError(t, err)
+	database.Close()
+
+	_, err = db.InitDB(dbPath)
+	assert.ErrorIs(t, err, db.ErrSchemaTooNew)
+}
+
+func TestGetMigrationStatus(t *testing.T) {
+	database, err := db.OpenDB(filepath.Join(t.TempDir(), "status.db"))
+	require.NoError(t, err)
+	defer database.Close()
+
+	statuses, err := db.GetMigrationStatus(database)
+	require.NoError(t, err)
+	assert.NotEmpty(t, statuses)
+	for i, s := range statuses {
+		assert.Equal(t, i+1, s.Version)
+		assert.NotEmpty(t, s.Name)
+		assert.False(t, s.Applied)
+	}
+
+	require.NoError(t, db.Migrate(database))
+
+	statuses, err = db.GetMigrationStatus(database)
+	require.NoError(t, err)
+	for _, s := range statuses {
+		assert.True(t, s.Applied, "migration %d (%s) should be applied", s.Version, s.Name)
+	}
+}
+
+func TestQuerySearchIndexFollowsSystemQueries(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+
+	search := func(text string) []string {
+		rows, err := database.Query(`
+			SELECT sq.fingerprint FROM query_search
+			JOIN system_queries sq ON sq.rowid = query_search.rowid
+			WHERE query_search MATCH ? ORDER BY sq.fingerprint`, text)
+		require.NoError(t, err)
+		defer rows.Close()
+
+		var fps []string
+		for rows.Next() {
+			var fp string
+			require.NoError(t, rows.Scan(&fp))
+			fps = append(fps, fp)
+		}
+		return fps
+	}
+
+	_, err = database.Exec(`
+		INSERT INTO system_queries (sys_id, sys_scope, sys_type, fingerprint, query, full_query, first_seen, last_seen, in_order_sightings)
+		VALUES ('a', 'us-east-1', 'amazon_rds', 'fp1', 'SELECT * FROM users WHERE id = $1', 'SELECT * FROM users WHERE id = 42', 1, 1, 1),
+			('a', 'us-east-1', 'amazon_rds', 'fp2', 'UPDATE accounts SET balance = $1', 'UPDATE accounts SET balance = 10', 1, 1, 1)`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"fp1"}, search(`"users"`))
+	assert.Equal(t, []string{"fp2"}, search(`"accounts" "balance"`))
+
+	// Redacting stored text also removes it from the index
+	_, err = database.Exec(`UPDATE system_queries SET full_query = 'SELECT * FROM users WHERE id = ?' WHERE fingerprint = 'fp1'`)
+	require.NoError(t, err)
+	assert.Empty(t, search(`"42"`))
+
+	_, err = database.Exec(`DELETE FROM system_queries WHERE fingerprint = 'fp2'`)
+	require.NoError(t, err)
+	assert.Empty(t, search(`"accounts"`))
+}
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS snapshots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    collected_at INTEGER,
+    s3_location TEXT,
+    system_id TEXT,
+    system_scope TEXT,
+    system_type TEXT
+);
+
+CREATE TABLE IF NOT EXISTS compact_snapshots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    collected_at INTEGER,
+    s3_location TEXT,
+    system_id TEXT,
+    system_scope TEXT,
+    system_type TEXT
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS queries (
+    fingerprint TEXT PRIMARY KEY,
+    query TEXT,
+    last_update INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS full_queries (
+    fingerprint TEXT PRIMARY KEY,
+    full_query TEXT,
+    last_update INTEGER
+);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS system_queries (
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    query TEXT,
+    full_query TEXT,
+    datname TEXT,
+    usename TEXT,
+    first_seen INTEGER NOT NULL,
+    last_seen INTEGER NOT NULL,
+    occurrences INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (sys_id, sys_scope, sys_type, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS system_queries_first_seen_idx
+    ON system_queries (sys_id, sys_scope, sys_type, first_seen);
+
+CREATE INDEX IF NOT EXISTS system_queries_last_seen_idx
+    ON system_queries (sys_id, sys_scope, sys_type, last_seen);
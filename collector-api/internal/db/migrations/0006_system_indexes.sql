@@ -0,0 +1,26 @@
+-- Latest definition and usage of every index of a system, from full snapshots.
+-- scan_count is the idx_scan of the latest snapshot, the scans since the one
+-- before. scans_changed_at is the last collected_at that counted any scans, so
+-- an index not scanned since a point in time can be found without keeping the
+-- scan history.
+CREATE TABLE IF NOT EXISTS system_indexes (
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    datname TEXT NOT NULL,
+    schema_name TEXT NOT NULL,
+    index_name TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    index_def TEXT NOT NULL,
+    constraint_def TEXT,
+    index_type TEXT NOT NULL,
+    is_primary INTEGER NOT NULL,
+    is_unique INTEGER NOT NULL,
+    is_valid INTEGER NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    scan_count INTEGER NOT NULL,
+    scans_changed_at INTEGER NOT NULL,
+    first_seen INTEGER NOT NULL,
+    collected_at INTEGER NOT NULL,
+    PRIMARY KEY (sys_id, sys_scope, sys_type, datname, schema_name, index_name)
+);
@@ -0,0 +1,38 @@
+-- Latest raw value of every setting of a system, from full snapshots. Values
+-- are kept as reported by pg_settings, in the setting's unit.
+CREATE TABLE IF NOT EXISTS system_settings (
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    name TEXT NOT NULL,
+    setting_value TEXT NOT NULL,
+    unit TEXT,
+    source TEXT,
+    source_file TEXT,
+    boot_value TEXT,
+    reset_value TEXT,
+    first_seen INTEGER NOT NULL,
+    collected_at INTEGER NOT NULL,
+    PRIMARY KEY (sys_id, sys_scope, sys_type, name)
+);
+
+-- Settings whose value or source differs from the previous full snapshot of
+-- the same system. change_type is added, changed or removed; the old columns
+-- are NULL for added settings and the new ones for removed settings.
+CREATE TABLE IF NOT EXISTS system_setting_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    name TEXT NOT NULL,
+    change_type TEXT NOT NULL,
+    unit TEXT,
+    old_value TEXT,
+    new_value TEXT,
+    old_source TEXT,
+    new_source TEXT,
+    previous_collected_at INTEGER NOT NULL,
+    changed_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_setting_changes_system ON system_setting_changes (sys_id, sys_scope, sys_type, changed_at);
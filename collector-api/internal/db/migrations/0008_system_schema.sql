@@ -0,0 +1,38 @@
+-- Latest tables, columns and indexes of a system, from full snapshots. The
+-- definition is what is compared between snapshots: the partition clause of a
+-- table, the type, nullability and default of a column, and the definition of
+-- an index. For tables, relation and object_name are both the table name.
+CREATE TABLE IF NOT EXISTS system_schema_objects (
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    datname TEXT NOT NULL,
+    object_type TEXT NOT NULL,
+    schema_name TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    object_name TEXT NOT NULL,
+    definition TEXT NOT NULL,
+    first_seen INTEGER NOT NULL,
+    collected_at INTEGER NOT NULL,
+    PRIMARY KEY (sys_id, sys_scope, sys_type, datname, object_type, schema_name, relation, object_name)
+);
+
+-- Schema changes detected between consecutive full snapshots of a system,
+-- which happened between previous_collected_at and detected_at
+CREATE TABLE IF NOT EXISTS system_schema_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    datname TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    schema_name TEXT NOT NULL,
+    relation TEXT NOT NULL,
+    object_name TEXT NOT NULL,
+    old_definition TEXT,
+    new_definition TEXT,
+    previous_collected_at INTEGER NOT NULL,
+    detected_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_schema_events_system ON system_schema_events (sys_id, sys_scope, sys_type, detected_at);
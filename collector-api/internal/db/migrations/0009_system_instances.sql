@@ -0,0 +1,18 @@
+-- Registry of the systems that submitted snapshots. first_seen and last_seen
+-- cover every snapshot, while the facts about the instance are those of its
+-- latest full snapshot, collected at facts_collected_at. Times are unix seconds.
+CREATE TABLE IF NOT EXISTS system_instances (
+    sys_id TEXT NOT NULL,
+    sys_scope TEXT NOT NULL,
+    sys_type TEXT NOT NULL,
+    first_seen INTEGER NOT NULL,
+    last_seen INTEGER NOT NULL,
+    postgres_version TEXT NOT NULL DEFAULT '',
+    platform TEXT NOT NULL DEFAULT '',
+    instance_class TEXT NOT NULL DEFAULT '',
+    memory_bytes INTEGER NOT NULL DEFAULT 0,
+    logical_cores INTEGER NOT NULL DEFAULT 0,
+    collector_version TEXT NOT NULL DEFAULT '',
+    facts_collected_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (sys_id, sys_scope, sys_type)
+);
@@ -0,0 +1,9 @@
+-- occurrences only ever counted the snapshots that saw a query later than any
+-- before them: late, out-of-order and reprocessed snapshots cannot be told
+-- apart, so none of them are counted. The column is named for what it holds.
+--
+-- system_queries starts empty when this table is added to an existing
+-- database: the global queries and full_queries tables do not record which
+-- system a query came from, so they cannot be backfilled. Queries show up per
+-- system once they are seen in a new snapshot.
+ALTER TABLE system_queries RENAME COLUMN occurrences TO in_order_sightings;
@@ -0,0 +1,246 @@
+// Package redaction scrubs sensitive values out of captured query text before
+// it is persisted.
+package redaction
+
+import (
+	"collector-api/internal/config"
+	"collector-api/internal/storage"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LiteralPlaceholder replaces string and numeric literals in full query text
+const LiteralPlaceholder = "?"
+
+type rule struct {
+	name        string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Redactor applies a configured redaction pipeline to query text
+type Redactor struct {
+	dropFullText    bool
+	replaceLiterals bool
+	rules           []rule
+}
+
+// New compiles the redaction rules of cfg
+func New(cfg config.QueryRedactionConfig) (*Redactor, error) {
+	r := &Redactor{
+		dropFullText:    cfg.DropFullText,
+		replaceLiterals: cfg.ReplaceLiterals,
+	}
+
+	for i, ruleCfg := range cfg.Rules {
+		name := ruleCfg.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		pattern, err := regexp.Compile(ruleCfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile redaction %s: %w", name, err)
+		}
+
+		r.rules = append(r.rules, rule{name: name, pattern: pattern, replacement: ruleCfg.Replacement})
+	}
+
+	return r, nil
+}
+
+// Enabled reports whether the redactor changes anything at all. A nil
+// Redactor changes nothing.
+func (r *Redactor) Enabled() bool {
+	return r != nil && (r.dropFullText || r.replaceLiterals || len(r.rules) > 0)
+}
+
+// Query redacts normalized query text. Normalization already replaced the
+// literals, so only the regex rules apply (e.g. to values left in comments).
+func (r *Redactor) Query(text string) string {
+	return r.applyRules(text)
+}
+
+// FullQuery redacts the full query text as sent by the client
+func (r *Redactor) FullQuery(text string) string {
+	if r.dropFullText {
+		return ""
+	}
+
+	if r.replaceLiterals {
+		text = ReplaceLiterals(text)
+	}
+
+	return r.applyRules(text)
+}
+
+// Apply redacts the query text of every query in place and returns the slice
+func (r *Redactor) Apply(queries []storage.QueryRep) []storage.QueryRep {
+	if !r.Enabled() {
+		return queries
+	}
+
+	for i := range queries {
+		queries[i].Query = r.Query(queries[i].Query)
+		queries[i].QueryFull = r.FullQuery(queries[i].QueryFull)
+	}
+	return queries
+}
+
+func (r *Redactor) applyRules(text string) string {
+	for _, rule := range r.rules {
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+	}
+	return text
+}
+
+// ReplaceLiterals replaces quoted string literals (including escape strings
+// and dollar-quoted strings) and numeric literals with LiteralPlaceholder. Quoted
+// identifiers and comments are left untouched.
+func ReplaceLiterals(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			// Line comment
+			end := strings.IndexByte(query[i:], '\n')
+			if end == -1 {
+				end = len(query) - i
+			}
+			b.WriteString(query[i : i+end])
+			i += end
+
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			// Block comment
+			end := strings.Index(query[i+2:], "*/")
+			if end == -1 {
+				b.WriteString(query[i:])
+				i = len(query)
+			} else {
+				b.WriteString(query[i : i+2+end+2])
+				i += 2 + end + 2
+			}
+
+		case c == '"':
+			// Quoted identifier
+			end := skipQuoted(query, i, '"', false)
+			b.WriteString(query[i:end])
+			i = end
+
+		case c == '\'':
+			i = skipQuoted(query, i, '\'', false)
+			b.WriteString(LiteralPlaceholder)
+
+		case (c == 'E' || c == 'e') && i+1 < len(query) && query[i+1] == '\'' && !isIdentChar(prevByte(query, i)):
+			// Escape string constant, where backslash escapes the quote
+			i = skipQuoted(query, i+1, '\'', true)
+			b.WriteString(LiteralPlaceholder)
+
+		case c == '$' && !isIdentChar(prevByte(query, i)):
+			tag, ok := dollarQuoteTag(query, i)
+			if !ok {
+				b.WriteByte(c)
+				i++
+				continue
+			}
+			end := strings.Index(query[i+len(tag):], tag)
+			if end == -1 {
+				i = len(query)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+			b.WriteString(LiteralPlaceholder)
+
+		case isDigit(c) && !isIdentChar(prevByte(query, i)):
+			i = skipNumber(query, i)
+			b.WriteString(LiteralPlaceholder)
+
+		case c == '.' && i+1 < len(query) && isDigit(query[i+1]) && !isIdentChar(prevByte(query, i)):
+			i = skipNumber(query, i)
+			b.WriteString(LiteralPlaceholder)
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// skipQuoted returns the index just past the quoted section starting at start.
+// Doubled quotes are always treated as escapes; backslashes only when
+// backslashEscapes is set.
+func skipQuoted(s string, start int, quote byte, backslashEscapes bool) int {
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case backslashEscapes && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// dollarQuoteTag returns the opening tag ("$$" or "$tag$") of a dollar-quoted
+// string starting at start. A positional parameter such as $1 is not a tag.
+func dollarQuoteTag(s string, start int) (string, bool) {
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[start : i+1], true
+		}
+		if isDigit(c) && i == start+1 {
+			return "", false
+		}
+		if !isIdentChar(c) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func skipNumber(s string, start int) int {
+	i := start
+	for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+		i++
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			i = j
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+func prevByte(s string, i int) byte {
+	if i == 0 {
+		return 0
+	}
+	return s[i-1]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
@@ -0,0 +1,137 @@
+package redaction_test
+
+import (
+	"collector-api/internal/config"
+	"collector-api/internal/redaction"
+	"collector-api/internal/storage"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceLiterals(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "String and number literals",
+			input:    "SELECT * FROM users WHERE email = 'alice@example.com' AND id = 42",
+			expected: "SELECT * FROM users WHERE email = ? AND id = ?",
+		},
+		{
+			name:     "Escaped quotes inside string",
+			input:    "UPDATE t SET note = 'it''s secret' WHERE x = 1.5e3",
+			expected: "UPDATE t SET note = ? WHERE x = ?",
+		},
+		{
+			name:     "Escape string constant",
+			input:    `INSERT INTO t VALUES (E'tok\'en', -7)`,
+			expected: "INSERT INTO t VALUES (?, -?)",
+		},
+		{
+			name:     "Dollar-quoted string",
+			input:    "SELECT $tag$4111 1111 1111 1111$tag$, $$x$$",
+			expected: "SELECT ?, ?",
+		},
+		{
+			name:     "Positional parameters and identifiers are kept",
+			input:    `SELECT "col1", t2.c3 FROM tbl_9 WHERE a = $1`,
+			expected: `SELECT "col1", t2.c3 FROM tbl_9 WHERE a = $1`,
+		},
+		{
+			name:     "Comments are kept",
+			input:    "SELECT 1 /* app:web 'x' */ -- trailing 2\n",
+			expected: "SELECT ? /* app:web 'x' */ -- trailing 2\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, redaction.ReplaceLiterals(tc.input))
+		})
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	r, err := redaction.New(config.QueryRedactionConfig{
+		ReplaceLiterals: true,
+		Rules: []config.QueryRedactionRule{
+			{Name: "email", Pattern: `[\w.+-]+@[\w-]+\.[\w.]+`, Replacement: "<email>"},
+		},
+	})
+	require.NoError(t, err)
+	assert.True(t, r.Enabled())
+
+	queries := r.Apply([]storage.QueryRep{{
+		Query:     "SELECT * FROM users WHERE email = $1 /* requested by bob@example.com */",
+		QueryFull: "SELECT * FROM users WHERE email = 'alice@example.com' /* requested by bob@example.com */",
+	}})
+
+	assert.Equal(t, "SELECT * FROM users WHERE email = $1 /* requested by <email> */", queries[0].Query)
+	assert.Equal(t, "SELECT * FROM users WHERE email = ? /* requested by <email> */", queries[0].QueryFull)
+
+	drop, err := redaction.New(config.QueryRedactionConfig{DropFullText: true})
+	require.NoError(t, err)
+	assert.Equal(t, "", drop.FullQuery("SELECT 'secret'"))
+	assert.Equal(t, "SELECT $1", drop.Query("SELECT $1"))
+
+	disabled, err := redaction.New(config.QueryRedactionConfig{})
+	require.NoError(t, err)
+	assert.False(t, disabled.Enabled())
+	assert.Equal(t, "SELECT 'secret'", disabled.FullQuery("SELECT 'secret'"))
+
+	_, err = redaction.New(config.QueryRedactionConfig{
+		Rules: []config.QueryRedactionRule{{Name: "broken", Pattern: "("}},
+	})
+	assert.ErrorContains(t, err, "broken")
+}
+
+func TestRedactStoredQueries(t *testing.T) {
+	store, err := storage.NewSQLiteQueryStorage(filepath.Join(t.TempDir(), "queries.db"))
+	require.NoError(t, err)
+
+	system := storage.SystemRef{SystemID: "a", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+	require.NoError(t, store.StoreBatchQueries([]storage.QueryRep{
+		{System: system, Fingerprint: "fp1", Query: "SELECT $1", QueryFull: "SELECT 'card 4111'", CollectedAt: 100},
+		{System: system, Fingerprint: "fp2", Query: "SELECT now()", QueryFull: "SELECT now()", CollectedAt: 100},
+	}))
+
+	r, err := redaction.New(config.QueryRedactionConfig{ReplaceLiterals: true})
+	require.NoError(t, err)
+
+	// The report leaves stored rows untouched
+	reports, err := store.RedactStoredQueries(r.Query, r.FullQuery, false)
+	require.NoError(t, err)
+	changed := map[string]int{}
+	for _, report := range reports {
+		changed[report.Table+"."+report.Column] = report.Changed
+	}
+	assert.Equal(t, map[string]int{
+		"queries.query":             0,
+		"full_queries.full_query":   1,
+		"system_queries.query":      0,
+		"system_queries.full_query": 1,
+	}, changed)
+
+	fullQuery, err := store.GetFullQuery("fp1")
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 'card 4111'", fullQuery)
+
+	// Applying rewrites them
+	_, err = store.RedactStoredQueries(r.Query, r.FullQuery, true)
+	require.NoError(t, err)
+
+	fullQuery, err = store.GetFullQuery("fp1")
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT ?", fullQuery)
+
+	queries, err := store.ListQueries(system, 0, 1000)
+	require.NoError(t, err)
+	for _, q := range queries {
+		assert.NotContains(t, q.QueryFull, "4111")
+	}
+}
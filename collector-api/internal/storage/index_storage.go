@@ -0,0 +1,36 @@
+package storage
+
+// IndexRep is an index of a system as reported by a full snapshot
+type IndexRep struct {
+	Database      string
+	Schema        string
+	Name          string
+	Relation      string
+	Definition    string
+	ConstraintDef string
+	Type          string
+	IsPrimary     bool
+	IsUnique      bool
+	IsValid       bool
+	SizeBytes     int64
+	ScanCount     int64 // Scans since the previous full snapshot
+}
+
+// SystemIndex is the stored state of an index. ScansChangedAt is the last
+// snapshot, in unix seconds, that counted scans of it, or the first one it
+// was seen in.
+type SystemIndex struct {
+	IndexRep
+	System         SystemRef
+	ScansChangedAt int64
+	FirstSeen      int64
+	CollectedAt    int64
+}
+
+type IndexStorage interface {
+	// StoreIndexes replaces the indexes of a system with those of the full
+	// snapshot collected at collectedAt, for the databases it has indexes of.
+	// Snapshots older than the stored ones are ignored.
+	StoreIndexes(system SystemRef, collectedAt int64, indexes []IndexRep) error
+	ListIndexes(system SystemRef) ([]SystemIndex, error)
+}
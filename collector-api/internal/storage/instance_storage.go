@@ -0,0 +1,31 @@
+package storage
+
+// InstanceRep holds the facts about an instance reported by a full snapshot
+// collected at CollectedAt (unix seconds)
+type InstanceRep struct {
+	PostgresVersion  string
+	Platform         string
+	InstanceClass    string
+	MemoryBytes      int64
+	LogicalCores     int64
+	CollectorVersion string
+	CollectedAt      int64
+}
+
+// SystemInstance is the registry entry of a system. FirstSeen and LastSeen
+// are the oldest and newest snapshots of any kind received for it, in unix
+// seconds; the facts are those of its latest full snapshot, if any.
+type SystemInstance struct {
+	InstanceRep
+	System    SystemRef
+	FirstSeen int64
+	LastSeen  int64
+}
+
+type InstanceStorage interface {
+	// StoreInstance records that snapshots of a system collected between
+	// firstSeen and lastSeen were received. facts, when not nil, replace the
+	// stored facts unless those come from a newer full snapshot.
+	StoreInstance(system SystemRef, firstSeen, lastSeen int64, facts *InstanceRep) error
+	ListInstances() ([]SystemInstance, error)
+}
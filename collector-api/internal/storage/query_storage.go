@@ -1,15 +1,60 @@
 package storage
 
+// SystemRef identifies the monitored system a query was observed on
+type SystemRef struct {
+	SystemID    string
+	SystemScope string
+	SystemType  string
+}
+
 type QueryRep struct {
+	System      SystemRef
 	Fingerprint string
 	Query       string
 	QueryFull   string
+	Database    string
+	Role        string
 	CollectedAt int64
 }
 
+// SystemQuery is the stored text and metadata of a query on a single system.
+// InOrderSightings counts the snapshots that saw the query later than any
+// before them. Late, out-of-order and reprocessed snapshots only extend
+// FirstSeen and LastSeen, so this is a lower bound on the snapshots the query
+// was seen in.
+type SystemQuery struct {
+	System           SystemRef
+	Fingerprint      string
+	Query            string
+	QueryFull        string
+	Database         string
+	Role             string
+	FirstSeen        int64
+	LastSeen         int64
+	InOrderSightings int64
+}
+
+// RedactionReport counts the stored values of one column changed by redaction
+type RedactionReport struct {
+	Table   string
+	Column  string
+	Scanned int
+	Changed int
+}
+
 type QueryStorage interface {
 	StoreQuery(fingerprint, query, fullQuery string, collectedAt int64) error
 	GetQuery(fingerprint string) (string, error)
 	GetFullQuery(fingerprint string) (string, error)
 	StoreBatchQueries(queries []QueryRep) error
+
+	// ListQueries returns queries of a system that were seen within [start, end]
+	ListQueries(system SystemRef, start, end int64) ([]SystemQuery, error)
+	// ListNewQueries returns queries of a system that were first seen at or after since
+	ListNewQueries(system SystemRef, since int64) ([]SystemQuery, error)
+
+	// RedactStoredQueries runs already stored query text through the given
+	// functions and reports how many values change. Values are only rewritten
+	// when apply is set.
+	RedactStoredQueries(redactQuery, redactFullQuery func(string) string, apply bool) ([]RedactionReport, error)
 }
@@ -0,0 +1,67 @@
+package storage
+
+// Schema object types
+const (
+	SchemaTable  = "table"
+	SchemaColumn = "column"
+	SchemaIndex  = "index"
+)
+
+// Schema event types
+const (
+	TableCreated      = "table_created"
+	TableDropped      = "table_dropped"
+	ColumnAdded       = "column_added"
+	ColumnAltered     = "column_altered"
+	ColumnDropped     = "column_dropped"
+	IndexCreated      = "index_created"
+	IndexAltered      = "index_altered"
+	IndexDropped      = "index_dropped"
+	PartitionAttached = "partition_attached"
+	PartitionDetached = "partition_detached"
+)
+
+// SchemaObject is a table, column or index of a system as reported by a full
+// snapshot. Definition is what is compared between snapshots: the partition
+// clause of a table, empty unless it is a partition, the type, nullability
+// and default of a column, and the definition of an index. For tables,
+// Relation and Name are both the table name. Locked tables were exclusively
+// locked during collection, and their columns and indexes are missing.
+type SchemaObject struct {
+	Database   string
+	Type       string
+	Schema     string
+	Relation   string
+	Name       string
+	Definition string
+	Locked     bool
+}
+
+// SchemaEvent is a schema change detected between two consecutive full
+// snapshots of a system, collected at PreviousCollectedAt and DetectedAt
+// (unix seconds). The old definition is empty for created objects and the
+// new one for dropped objects.
+type SchemaEvent struct {
+	ID                  int64
+	System              SystemRef
+	Database            string
+	Type                string
+	Schema              string
+	Relation            string
+	Name                string
+	OldDefinition       string
+	NewDefinition       string
+	PreviousCollectedAt int64
+	DetectedAt          int64
+}
+
+type SchemaStorage interface {
+	// StoreSchema replaces the schema of a system with that of the full
+	// snapshot collected at collectedAt, logging the differences with the
+	// stored one as events. The first snapshot of a system logs no events,
+	// and snapshots older than the stored ones are ignored.
+	StoreSchema(system SystemRef, collectedAt int64, objects []SchemaObject) error
+	// ListSchemaEvents returns the schema events of a system detected between
+	// start and end (unix seconds, inclusive), oldest first
+	ListSchemaEvents(system SystemRef, start, end int64) ([]SchemaEvent, error)
+}
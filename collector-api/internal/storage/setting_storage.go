@@ -0,0 +1,58 @@
+package storage
+
+// Setting change types
+const (
+	SettingAdded   = "added"
+	SettingChanged = "changed"
+	SettingRemoved = "removed"
+)
+
+// SettingRep is a setting of a system as reported by a full snapshot, with
+// its raw pg_settings values. Empty strings stand for NULL.
+type SettingRep struct {
+	Name       string
+	Value      string
+	Unit       string
+	Source     string
+	SourceFile string
+	BootValue  string
+	ResetValue string
+}
+
+// SystemSetting is the stored latest state of a setting
+type SystemSetting struct {
+	SettingRep
+	System      SystemRef
+	FirstSeen   int64
+	CollectedAt int64
+}
+
+// SettingChange is a setting whose value or source differs between two
+// consecutive full snapshots of a system, collected at PreviousCollectedAt and
+// ChangedAt (unix seconds)
+type SettingChange struct {
+	ID                  int64
+	System              SystemRef
+	Name                string
+	ChangeType          string
+	Unit                string
+	OldValue            string
+	NewValue            string
+	OldSource           string
+	NewSource           string
+	PreviousCollectedAt int64
+	ChangedAt           int64
+}
+
+type SettingStorage interface {
+	// StoreSettings replaces the settings of a system with those of the full
+	// snapshot collected at collectedAt, logging the differences with the
+	// stored ones as changes. The first snapshot of a system logs no changes,
+	// and snapshots without settings or older than the stored ones are
+	// ignored.
+	StoreSettings(system SystemRef, collectedAt int64, settings []SettingRep) error
+	ListSettings(system SystemRef) ([]SystemSetting, error)
+	// ListSettingChanges returns the changes of a system detected between
+	// start and end (unix seconds, inclusive), oldest first
+	ListSettingChanges(system SystemRef, start, end int64) ([]SettingChange, error)
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"database/sql"
+)
+
+var IndexStore IndexStorage
+
+// upsertSystemIndex records an index of a newer snapshot. The scan count of a
+// full snapshot is the number of scans since the previous one, so any scan at
+// all moves scans_changed_at to the snapshot.
+const upsertSystemIndex = `
+	INSERT INTO system_indexes (
+		sys_id, sys_scope, sys_type, datname, schema_name, index_name, relation, index_def, constraint_def,
+		index_type, is_primary, is_unique, is_valid, size_bytes, scan_count, scans_changed_at, first_seen, collected_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?16, ?16, ?16)
+	ON CONFLICT (sys_id, sys_scope, sys_type, datname, schema_name, index_name) DO UPDATE SET
+		relation = excluded.relation,
+		index_def = excluded.index_def,
+		constraint_def = excluded.constraint_def,
+		index_type = excluded.index_type,
+		is_primary = excluded.is_primary,
+		is_unique = excluded.is_unique,
+		is_valid = excluded.is_valid,
+		size_bytes = excluded.size_bytes,
+		scans_changed_at = CASE WHEN excluded.scan_count > 0 THEN excluded.collected_at ELSE scans_changed_at END,
+		scan_count = excluded.scan_count,
+		collected_at = excluded.collected_at`
+
+func (s *SQLiteQueryStorage) StoreIndexes(system SystemRef, collectedAt int64, indexes []IndexRep) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if tx.Commit() is called
+
+	var latest sql.NullInt64
+	err = tx.QueryRow(`SELECT MAX(collected_at) FROM system_indexes WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?`,
+		system.SystemID, system.SystemScope, system.SystemType).Scan(&latest)
+	if err != nil {
+		return err
+	}
+	if latest.Valid && latest.Int64 > collectedAt {
+		return nil
+	}
+
+	stmt, err := tx.Prepare(upsertSystemIndex)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, idx := range indexes {
+		var constraintDef sql.NullString
+		if idx.ConstraintDef != "" {
+			constraintDef = sql.NullString{String: idx.ConstraintDef, Valid: true}
+		}
+		_, err = stmt.Exec(
+			system.SystemID, system.SystemScope, system.SystemType, idx.Database, idx.Schema, idx.Name, idx.Relation,
+			idx.Definition, constraintDef, idx.Type, idx.IsPrimary, idx.IsUnique, idx.IsValid, idx.SizeBytes, idx.ScanCount,
+			collectedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Indexes missing from the snapshot were dropped. A database without any
+	// index in the snapshot was most likely not collected, so its indexes are
+	// kept.
+	databases := make(map[string]bool)
+	for _, idx := range indexes {
+		if databases[idx.Database] {
+			continue
+		}
+		databases[idx.Database] = true
+		_, err = tx.Exec(`DELETE FROM system_indexes WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND datname = ? AND collected_at < ?`,
+			system.SystemID, system.SystemScope, system.SystemType, idx.Database, collectedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteQueryStorage) ListIndexes(system SystemRef) ([]SystemIndex, error) {
+	rows, err := s.db.Query(`
+		SELECT datname, schema_name, index_name, relation, index_def, constraint_def, index_type,
+			is_primary, is_unique, is_valid, size_bytes, scan_count, scans_changed_at, first_seen, collected_at
+		FROM system_indexes
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?
+		ORDER BY datname, schema_name, relation, index_name`,
+		system.SystemID, system.SystemScope, system.SystemType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []SystemIndex
+	for rows.Next() {
+		idx := SystemIndex{System: system}
+		var constraintDef sql.NullString
+		err := rows.Scan(&idx.Database, &idx.Schema, &idx.Name, &idx.Relation, &idx.Definition, &constraintDef, &idx.Type,
+			&idx.IsPrimary, &idx.IsUnique, &idx.IsValid, &idx.SizeBytes, &idx.ScanCount, &idx.ScansChangedAt, &idx.FirstSeen, &idx.CollectedAt)
+		if err != nil {
+			return nil, err
+		}
+		idx.ConstraintDef = constraintDef.String
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
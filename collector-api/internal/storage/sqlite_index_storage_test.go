@@ -0,0 +1,75 @@
+package storage_test
+
+import (
+	"collector-api/internal/storage"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreIndexes(t *testing.T) {
+	store, err := storage.NewSQLiteQueryStorage(filepath.Join(t.TempDir(), "indexes.db"))
+	require.NoError(t, err)
+
+	system := storage.SystemRef{SystemID: "a", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+	other := storage.SystemRef{SystemID: "b", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+
+	ordersPkey := storage.IndexRep{Database: "app", Schema: "public", Name: "orders_pkey", Relation: "orders",
+		Definition: "CREATE UNIQUE INDEX orders_pkey ON public.orders USING btree (id)", ConstraintDef: "PRIMARY KEY (id)",
+		Type: "btree", IsPrimary: true, IsUnique: true, IsValid: true, SizeBytes: 8192, ScanCount: 10}
+	ordersCustomer := storage.IndexRep{Database: "app", Schema: "public", Name: "orders_customer_idx", Relation: "orders",
+		Definition: "CREATE INDEX orders_customer_idx ON public.orders USING btree (customer_id)", Type: "btree",
+		IsValid: true, SizeBytes: 16384, ScanCount: 5}
+
+	require.NoError(t, store.StoreIndexes(system, 100, []storage.IndexRep{ordersPkey, ordersCustomer}))
+	require.NoError(t, store.StoreIndexes(other, 100, []storage.IndexRep{ordersPkey}))
+
+	// Scan counts are per snapshot: the primary key keeps being scanned at
+	// the same rate, while the other index stays idle
+	ordersCustomer.ScanCount = 0
+	require.NoError(t, store.StoreIndexes(system, 200, []storage.IndexRep{ordersPkey, ordersCustomer}))
+
+	indexes, err := store.ListIndexes(system)
+	require.NoError(t, err)
+	require.Len(t, indexes, 2)
+	assert.Equal(t, "orders_customer_idx", indexes[0].Name)
+	assert.Equal(t, int64(0), indexes[0].ScanCount)
+	assert.Equal(t, int64(100), indexes[0].ScansChangedAt)
+	assert.Equal(t, int64(100), indexes[0].FirstSeen)
+	assert.Equal(t, int64(200), indexes[0].CollectedAt)
+	assert.Equal(t, ordersPkey, indexes[1].IndexRep)
+	assert.Equal(t, int64(200), indexes[1].ScansChangedAt)
+	assert.Equal(t, system, indexes[1].System)
+
+	// An older snapshot arriving late changes nothing
+	require.NoError(t, store.StoreIndexes(system, 150, []storage.IndexRep{ordersPkey}))
+	indexes, err = store.ListIndexes(system)
+	require.NoError(t, err)
+	assert.Len(t, indexes, 2)
+
+	// Dropped indexes disappear with the next snapshot
+	require.NoError(t, store.StoreIndexes(system, 300, []storage.IndexRep{ordersPkey}))
+	indexes, err = store.ListIndexes(system)
+	require.NoError(t, err)
+	require.Len(t, indexes, 1)
+	assert.Equal(t, "orders_pkey", indexes[0].Name)
+
+	// A snapshot missing a database keeps its indexes
+	analyticsEvents := storage.IndexRep{Database: "analytics", Schema: "public", Name: "events_pkey", Relation: "events",
+		Definition: "CREATE UNIQUE INDEX events_pkey ON public.events USING btree (id)", Type: "btree", IsValid: true}
+	require.NoError(t, store.StoreIndexes(system, 400, []storage.IndexRep{ordersPkey, analyticsEvents}))
+	require.NoError(t, store.StoreIndexes(system, 500, []storage.IndexRep{analyticsEvents}))
+	indexes, err = store.ListIndexes(system)
+	require.NoError(t, err)
+	require.Len(t, indexes, 2)
+	assert.Equal(t, "events_pkey", indexes[0].Name)
+	assert.Equal(t, "orders_pkey", indexes[1].Name)
+	assert.Equal(t, int64(100), indexes[1].FirstSeen)
+	assert.Equal(t, int64(400), indexes[1].CollectedAt)
+
+	indexes, err = store.ListIndexes(other)
+	require.NoError(t, err)
+	assert.Len(t, indexes, 1)
+}
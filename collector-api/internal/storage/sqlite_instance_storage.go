@@ -0,0 +1,62 @@
+package storage
+
+var InstanceStore InstanceStorage
+
+func (s *SQLiteQueryStorage) StoreInstance(system SystemRef, firstSeen, lastSeen int64, facts *InstanceRep) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if tx.Commit() is called
+
+	_, err = tx.Exec(`
+		INSERT INTO system_instances (sys_id, sys_scope, sys_type, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (sys_id, sys_scope, sys_type) DO UPDATE SET
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen)`,
+		system.SystemID, system.SystemScope, system.SystemType, firstSeen, lastSeen)
+	if err != nil {
+		return err
+	}
+
+	if facts != nil {
+		_, err = tx.Exec(`
+			UPDATE system_instances SET
+				postgres_version = ?, platform = ?, instance_class = ?, memory_bytes = ?, logical_cores = ?,
+				collector_version = ?, facts_collected_at = ?
+			WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND facts_collected_at <= ?`,
+			facts.PostgresVersion, facts.Platform, facts.InstanceClass, facts.MemoryBytes, facts.LogicalCores,
+			facts.CollectorVersion, facts.CollectedAt,
+			system.SystemID, system.SystemScope, system.SystemType, facts.CollectedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteQueryStorage) ListInstances() ([]SystemInstance, error) {
+	rows, err := s.db.Query(`
+		SELECT sys_id, sys_scope, sys_type, first_seen, last_seen, postgres_version, platform, instance_class,
+			memory_bytes, logical_cores, collector_version, facts_collected_at
+		FROM system_instances
+		ORDER BY sys_type, sys_id, sys_scope`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instances []SystemInstance
+	for rows.Next() {
+		var i SystemInstance
+		err := rows.Scan(&i.System.SystemID, &i.System.SystemScope, &i.System.SystemType, &i.FirstSeen, &i.LastSeen,
+			&i.PostgresVersion, &i.Platform, &i.InstanceClass, &i.MemoryBytes, &i.LogicalCores, &i.CollectorVersion, &i.CollectedAt)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, i)
+	}
+	return instances, rows.Err()
+}
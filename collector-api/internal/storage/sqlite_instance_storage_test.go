@@ -0,0 +1,40 @@
+package storage_test
+
+import (
+	"collector-api/internal/storage"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreInstance(t *testing.T) {
+	store, err := storage.NewSQLiteQueryStorage(filepath.Join(t.TempDir(), "instances.db"))
+	require.NoError(t, err)
+
+	system := storage.SystemRef{SystemID: "a", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+	other := storage.SystemRef{SystemID: "b", SystemScope: "", SystemType: "self_hosted"}
+
+	// Compact snapshots only move the times
+	require.NoError(t, store.StoreInstance(system, 100, 160, nil))
+	require.NoError(t, store.StoreInstance(other, 50, 50, nil))
+
+	facts := storage.InstanceRep{
+		PostgresVersion: "16.2", Platform: "amazon_rds", InstanceClass: "db.r6g.large",
+		MemoryBytes: 16 << 30, LogicalCores: 2, CollectorVersion: "0.58.0", CollectedAt: 200,
+	}
+	require.NoError(t, store.StoreInstance(system, 170, 200, &facts))
+
+	// A full snapshot that arrives late moves first_seen, but not the facts
+	older := facts
+	older.PostgresVersion, older.CollectedAt = "16.1", 90
+	require.NoError(t, store.StoreInstance(system, 90, 90, &older))
+
+	instances, err := store.ListInstances()
+	require.NoError(t, err)
+	assert.Equal(t, []storage.SystemInstance{
+		{InstanceRep: facts, System: system, FirstSeen: 90, LastSeen: 200},
+		{System: other, FirstSeen: 50, LastSeen: 50},
+	}, instances)
+}
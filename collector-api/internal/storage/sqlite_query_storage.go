@@ -3,6 +3,7 @@ package storage
 import (
 	"collector-api/internal/db"
 	"database/sql"
+	"fmt"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -20,38 +21,21 @@ func InitQueryStorage(dbPath string) error {
 	}
 
 	QueryStore = storage
+	IndexStore = storage
+	SettingStore = storage
+	SchemaStore = storage
+	InstanceStore = storage
 	return nil
 }
 
 func NewSQLiteQueryStorage(dbPath string) (*SQLiteQueryStorage, error) {
-	// Initialize the SQLite database
+	// Initialize the SQLite database; migrations create the query tables
 	database, err := db.InitDB(dbPath)
 	if err != nil {
 		return nil, err
 	}
 
-	storage := &SQLiteQueryStorage{db: database}
-	if err := storage.initTables(); err != nil {
-		return nil, err
-	}
-
-	return storage, nil
-}
-
-func (s *SQLiteQueryStorage) initTables() error {
-	_, err := s.db.Exec(`
-        CREATE TABLE IF NOT EXISTS queries (
-            fingerprint TEXT PRIMARY KEY,
-            query TEXT,
-            last_update INTEGER
-        );
-        CREATE TABLE IF NOT EXISTS full_queries (
-            fingerprint TEXT PRIMARY KEY,
-            full_query TEXT,
-            last_update INTEGER
-        );
-    `)
-	return err
+	return &SQLiteQueryStorage{db: database}, nil
 }
 
 func (s *SQLiteQueryStorage) StoreQuery(fingerprint, query, fullQuery string, collectedAt int64) error {
@@ -95,6 +79,12 @@ func (s *SQLiteQueryStorage) StoreBatchQueries(queries []QueryRep) error {
 	}
 	defer stmtFullQueries.Close()
 
+	stmtSystemQueries, err := tx.Prepare(upsertSystemQuery)
+	if err != nil {
+		return err
+	}
+	defer stmtSystemQueries.Close()
+
 	// Execute batch inserts
 	for _, q := range queries {
 		_, err = stmtQueries.Exec(q.Fingerprint, q.Query, q.CollectedAt)
@@ -106,11 +96,93 @@ func (s *SQLiteQueryStorage) StoreBatchQueries(queries []QueryRep) error {
 		if err != nil {
 			return err
 		}
+
+		_, err = stmtSystemQueries.Exec(
+			q.System.SystemID, q.System.SystemScope, q.System.SystemType, q.Fingerprint,
+			q.Query, q.QueryFull, q.Database, q.Role, q.CollectedAt)
+		if err != nil {
+			return err
+		}
 	}
 
 	return tx.Commit()
 }
 
+// upsertSystemQuery records a query sighting for a system. Text, database and
+// role follow the most recent sighting, and in_order_sightings only grows when
+// a newer snapshot is seen, so several backends running the same query in one
+// snapshot (or reprocessing old snapshots) do not inflate the count. Late
+// snapshots are not counted either, since they look the same as reprocessed
+// ones.
+const upsertSystemQuery = `
+	INSERT INTO system_queries (
+		sys_id, sys_scope, sys_type, fingerprint, query, full_query, datname, usename,
+		first_seen, last_seen, in_order_sightings
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?9, ?9, 1)
+	ON CONFLICT (sys_id, sys_scope, sys_type, fingerprint) DO UPDATE SET
+		query = CASE WHEN excluded.last_seen >= last_seen THEN excluded.query ELSE query END,
+		full_query = CASE WHEN excluded.last_seen >= last_seen THEN excluded.full_query ELSE full_query END,
+		datname = CASE WHEN excluded.last_seen >= last_seen THEN excluded.datname ELSE datname END,
+		usename = CASE WHEN excluded.last_seen >= last_seen THEN excluded.usename ELSE usename END,
+		in_order_sightings = in_order_sightings + (excluded.last_seen > last_seen),
+		first_seen = MIN(first_seen, excluded.first_seen),
+		last_seen = MAX(last_seen, excluded.last_seen)`
+
+const selectSystemQueries = `
+	SELECT sys_id, sys_scope, sys_type, fingerprint, query, full_query, datname, usename,
+		first_seen, last_seen, in_order_sightings
+	FROM system_queries`
+
+func (s *SQLiteQueryStorage) ListQueries(system SystemRef, start, end int64) ([]SystemQuery, error) {
+	return s.listSystemQueries(selectSystemQueries+`
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?
+			AND last_seen >= ? AND first_seen <= ?
+		ORDER BY last_seen DESC`,
+		system.SystemID, system.SystemScope, system.SystemType, start, end)
+}
+
+func (s *SQLiteQueryStorage) ListNewQueries(system SystemRef, since int64) ([]SystemQuery, error) {
+	return s.listSystemQueries(selectSystemQueries+`
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND first_seen >= ?
+		ORDER BY first_seen DESC`,
+		system.SystemID, system.SystemScope, system.SystemType, since)
+}
+
+func (s *SQLiteQueryStorage) listSystemQueries(query string, args ...interface{}) ([]SystemQuery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queries []SystemQuery
+	for rows.Next() {
+		var q SystemQuery
+		var text, fullText, datname, usename sql.NullString
+		if err := rows.Scan(
+			&q.System.SystemID,
+			&q.System.SystemScope,
+			&q.System.SystemType,
+			&q.Fingerprint,
+			&text,
+			&fullText,
+			&datname,
+			&usename,
+			&q.FirstSeen,
+			&q.LastSeen,
+			&q.InOrderSightings,
+		); err != nil {
+			return nil, err
+		}
+		q.Query = text.String
+		q.QueryFull = fullText.String
+		q.Database = datname.String
+		q.Role = usename.String
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
 func (s *SQLiteQueryStorage) GetQuery(fingerprint string) (string, error) {
 	var query string
 	err := s.db.QueryRow("SELECT query FROM queries WHERE fingerprint = ?", fingerprint).Scan(&query)
@@ -122,3 +194,83 @@ func (s *SQLiteQueryStorage) GetFullQuery(fingerprint string) (string, error) {
 	err := s.db.QueryRow("SELECT full_query FROM full_queries WHERE fingerprint = ?", fingerprint).Scan(&fullQuery)
 	return fullQuery, err
 }
+
+func (s *SQLiteQueryStorage) RedactStoredQueries(redactQuery, redactFullQuery func(string) string, apply bool) ([]RedactionReport, error) {
+	columns := []struct {
+		table  string
+		column string
+		redact func(string) string
+	}{
+		{"queries", "query", redactQuery},
+		{"full_queries", "full_query", redactFullQuery},
+		{"system_queries", "query", redactQuery},
+		{"system_queries", "full_query", redactFullQuery},
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // Will be ignored if tx.Commit() is called
+
+	reports := make([]RedactionReport, 0, len(columns))
+	for _, c := range columns {
+		report, err := redactColumn(tx, c.table, c.column, c.redact, apply)
+		if err != nil {
+			return nil, fmt.Errorf("redact %s.%s: %w", c.table, c.column, err)
+		}
+		reports = append(reports, report)
+	}
+
+	if !apply {
+		return reports, nil
+	}
+	return reports, tx.Commit()
+}
+
+func redactColumn(tx *sql.Tx, table, column string, redact func(string) string, apply bool) (RedactionReport, error) {
+	report := RedactionReport{Table: table, Column: column}
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT rowid, %s FROM %s WHERE %s IS NOT NULL AND %s != ''", column, table, column, column))
+	if err != nil {
+		return report, err
+	}
+
+	changed := make(map[int64]string)
+	for rows.Next() {
+		var rowid int64
+		var text string
+		if err := rows.Scan(&rowid, &text); err != nil {
+			rows.Close()
+			return report, err
+		}
+		report.Scanned++
+
+		if redacted := redact(text); redacted != text {
+			report.Changed++
+			changed[rowid] = redacted
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	if !apply || len(changed) == 0 {
+		return report, nil
+	}
+
+	stmt, err := tx.Prepare(fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", table, column))
+	if err != nil {
+		return report, err
+	}
+	defer stmt.Close()
+
+	for rowid, text := range changed {
+		if _, err := stmt.Exec(text, rowid); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
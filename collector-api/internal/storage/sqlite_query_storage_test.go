@@ -0,0 +1,69 @@
+package storage_test
+
+import (
+	"collector-api/internal/storage"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemScopedQueries(t *testing.T) {
+	store, err := storage.NewSQLiteQueryStorage(filepath.Join(t.TempDir(), "queries.db"))
+	require.NoError(t, err)
+
+	systemA := storage.SystemRef{SystemID: "a", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+	systemB := storage.SystemRef{SystemID: "b", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+
+	err = store.StoreBatchQueries([]storage.QueryRep{
+		// Two backends running the same query in one snapshot count once
+		{System: systemA, Fingerprint: "fp1", Query: "SELECT $1", QueryFull: "SELECT 1", Database: "app", Role: "web", CollectedAt: 100},
+		{System: systemA, Fingerprint: "fp1", Query: "SELECT $1", QueryFull: "SELECT 2", Database: "app", Role: "web", CollectedAt: 100},
+		{System: systemA, Fingerprint: "fp1", Query: "SELECT $1", QueryFull: "SELECT 3", Database: "app", Role: "worker", CollectedAt: 200},
+		{System: systemB, Fingerprint: "fp1", Query: "SELECT $1", QueryFull: "SELECT 4", Database: "other", Role: "web", CollectedAt: 150},
+		{System: systemA, Fingerprint: "fp2", Query: "UPDATE t SET x = $1", QueryFull: "UPDATE t SET x = 1", Database: "app", Role: "web", CollectedAt: 300},
+	})
+	require.NoError(t, err)
+
+	// Reprocessing an older snapshot must not move last_seen back or inflate counts
+	err = store.StoreBatchQueries([]storage.QueryRep{
+		{System: systemA, Fingerprint: "fp1", Query: "SELECT $1", QueryFull: "SELECT 0", Database: "app", Role: "web", CollectedAt: 50},
+	})
+	require.NoError(t, err)
+
+	queries, err := store.ListQueries(systemA, 0, 1000)
+	require.NoError(t, err)
+	require.Len(t, queries, 2)
+
+	assert.Equal(t, "fp2", queries[0].Fingerprint)
+	fp1 := queries[1]
+	assert.Equal(t, "fp1", fp1.Fingerprint)
+	assert.Equal(t, int64(50), fp1.FirstSeen)
+	assert.Equal(t, int64(200), fp1.LastSeen)
+	assert.Equal(t, int64(2), fp1.InOrderSightings, "the late snapshot at 50 is not counted")
+	assert.Equal(t, "SELECT 3", fp1.QueryFull)
+	assert.Equal(t, "worker", fp1.Role)
+	assert.Equal(t, systemA, fp1.System)
+
+	queries, err = store.ListQueries(systemB, 0, 1000)
+	require.NoError(t, err)
+	require.Len(t, queries, 1)
+	assert.Equal(t, "other", queries[0].Database)
+
+	// Window excludes queries last seen before it starts
+	queries, err = store.ListQueries(systemA, 250, 1000)
+	require.NoError(t, err)
+	require.Len(t, queries, 1)
+	assert.Equal(t, "fp2", queries[0].Fingerprint)
+
+	newQueries, err := store.ListNewQueries(systemA, 250)
+	require.NoError(t, err)
+	require.Len(t, newQueries, 1)
+	assert.Equal(t, "fp2", newQueries[0].Fingerprint)
+
+	// Fingerprint-only lookups keep working for the bff
+	text, err := store.GetQuery("fp2")
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE t SET x = $1", text)
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"database/sql"
+	"sort"
+)
+
+var SchemaStore SchemaStorage
+
+const upsertSchemaObject = `
+	INSERT INTO system_schema_objects (
+		sys_id, sys_scope, sys_type, datname, object_type, schema_name, relation, object_name, definition,
+		first_seen, collected_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?10, ?10)
+	ON CONFLICT (sys_id, sys_scope, sys_type, datname, object_type, schema_name, relation, object_name) DO UPDATE SET
+		definition = excluded.definition,
+		collected_at = excluded.collected_at`
+
+const insertSchemaEvent = `
+	INSERT INTO system_schema_events (
+		sys_id, sys_scope, sys_type, datname, event_type, schema_name, relation, object_name,
+		old_definition, new_definition, previous_collected_at, detected_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+type schemaObjectKey struct {
+	database, objectType, schema, relation, name string
+}
+
+type schemaTableKey struct {
+	database, schema, relation string
+}
+
+// schemaObjectOrder lists the objects of a table with the table first
+var schemaObjectOrder = map[string]int{SchemaTable: 0, SchemaColumn: 1, SchemaIndex: 2}
+
+func (k schemaTableKey) less(other schemaTableKey) bool {
+	if k.database != other.database {
+		return k.database < other.database
+	}
+	if k.schema != other.schema {
+		return k.schema < other.schema
+	}
+	return k.relation < other.relation
+}
+
+func (k schemaObjectKey) table() schemaTableKey {
+	return schemaTableKey{k.database, k.schema, k.relation}
+}
+
+func (o *SchemaObject) key() schemaObjectKey {
+	return schemaObjectKey{o.Database, o.Type, o.Schema, o.Relation, o.Name}
+}
+
+func (s *SQLiteQueryStorage) StoreSchema(system SystemRef, collectedAt int64, objects []SchemaObject) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if tx.Commit() is called
+
+	rows, err := tx.Query(`
+		SELECT datname, object_type, schema_name, relation, object_name, definition, collected_at
+		FROM system_schema_objects
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?`,
+		system.SystemID, system.SystemScope, system.SystemType)
+	if err != nil {
+		return err
+	}
+	stored := make(map[schemaObjectKey]string)
+	var previousCollectedAt int64
+	for rows.Next() {
+		var key schemaObjectKey
+		var definition string
+		var objectCollectedAt int64
+		if err := rows.Scan(&key.database, &key.objectType, &key.schema, &key.relation, &key.name, &definition, &objectCollectedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		stored[key] = definition
+		previousCollectedAt = max(previousCollectedAt, objectCollectedAt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if previousCollectedAt > collectedAt {
+		return nil
+	}
+
+	upsert, err := tx.Prepare(upsertSchemaObject)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+
+	logEvent, err := tx.Prepare(insertSchemaEvent)
+	if err != nil {
+		return err
+	}
+	defer logEvent.Close()
+
+	// Without a stored schema there is nothing to compare with, so the first
+	// snapshot of a system is a baseline rather than a change
+	changes := len(stored) > 0 && previousCollectedAt < collectedAt
+	event := func(eventType string, key schemaObjectKey, oldDefinition, newDefinition sql.NullString) error {
+		if !changes {
+			return nil
+		}
+		_, err := logEvent.Exec(system.SystemID, system.SystemScope, system.SystemType, key.database, eventType,
+			key.schema, key.relation, key.name, oldDefinition, newDefinition, previousCollectedAt, collectedAt)
+		return err
+	}
+
+	// A database without any table in the snapshot was most likely not
+	// collected, and a locked table was collected without its columns and
+	// indexes, so what they had is kept rather than reported as dropped
+	databases := make(map[string]bool)
+	current := make(map[schemaObjectKey]bool, len(objects))
+	locked := make(map[schemaTableKey]bool)
+	created := make(map[schemaTableKey]bool)
+	for i := range objects {
+		o := &objects[i]
+		key := o.key()
+		databases[o.Database] = true
+		current[key] = true
+		if o.Type != SchemaTable {
+			continue
+		}
+		if o.Locked {
+			locked[key.table()] = true
+		}
+		if _, ok := stored[key]; !ok {
+			created[key.table()] = true
+		}
+	}
+
+	for i := range objects {
+		o := &objects[i]
+		key := o.key()
+		newDefinition := sql.NullString{String: o.Definition, Valid: true}
+		oldDefinition, existed := stored[key]
+
+		switch {
+		case !existed:
+			switch o.Type {
+			case SchemaTable:
+				err = event(TableCreated, key, sql.NullString{}, sql.NullString{})
+				if err == nil && o.Definition != "" {
+					err = event(PartitionAttached, key, sql.NullString{}, newDefinition)
+				}
+			case SchemaColumn:
+				// The columns of a new table are part of its creation
+				if !created[key.table()] {
+					err = event(ColumnAdded, key, sql.NullString{}, newDefinition)
+				}
+			case SchemaIndex:
+				err = event(IndexCreated, key, sql.NullString{}, newDefinition)
+			}
+		case oldDefinition != o.Definition:
+			old := sql.NullString{String: oldDefinition, Valid: true}
+			switch o.Type {
+			case SchemaTable:
+				if o.Definition == "" {
+					err = event(PartitionDetached, key, old, sql.NullString{})
+				} else {
+					err = event(PartitionAttached, key, nullString(oldDefinition), newDefinition)
+				}
+			case SchemaColumn:
+				err = event(ColumnAltered, key, old, newDefinition)
+			case SchemaIndex:
+				err = event(IndexAltered, key, old, newDefinition)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = upsert.Exec(system.SystemID, system.SystemScope, system.SystemType, key.database, key.objectType,
+			key.schema, key.relation, key.name, o.Definition, collectedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	var dropped []schemaObjectKey
+	for key := range stored {
+		if current[key] || !databases[key.database] || (key.objectType != SchemaTable && locked[key.table()]) {
+			continue
+		}
+		dropped = append(dropped, key)
+	}
+	sort.Slice(dropped, func(i, j int) bool {
+		a, b := dropped[i], dropped[j]
+		if a.table() != b.table() {
+			return a.table().less(b.table())
+		}
+		if a.objectType != b.objectType {
+			return schemaObjectOrder[a.objectType] < schemaObjectOrder[b.objectType]
+		}
+		return a.name < b.name
+	})
+
+	droppedTables := make(map[schemaTableKey]bool)
+	for _, key := range dropped {
+		if key.objectType == SchemaTable {
+			droppedTables[key.table()] = true
+		}
+	}
+	for _, key := range dropped {
+		old := sql.NullString{String: stored[key], Valid: true}
+		switch {
+		case key.objectType == SchemaTable:
+			err = event(TableDropped, key, sql.NullString{}, sql.NullString{})
+		case droppedTables[key.table()]:
+			// The columns and indexes of a dropped table go with it
+		case key.objectType == SchemaColumn:
+			err = event(ColumnDropped, key, old, sql.NullString{})
+		case key.objectType == SchemaIndex:
+			err = event(IndexDropped, key, old, sql.NullString{})
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			DELETE FROM system_schema_objects
+			WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?
+				AND datname = ? AND object_type = ? AND schema_name = ? AND relation = ? AND object_name = ?`,
+			system.SystemID, system.SystemScope, system.SystemType,
+			key.database, key.objectType, key.schema, key.relation, key.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteQueryStorage) ListSchemaEvents(system SystemRef, start, end int64) ([]SchemaEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT id, datname, event_type, schema_name, relation, object_name, old_definition, new_definition,
+			previous_collected_at, detected_at
+		FROM system_schema_events
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND detected_at BETWEEN ? AND ?
+		ORDER BY detected_at, id`,
+		system.SystemID, system.SystemScope, system.SystemType, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SchemaEvent
+	for rows.Next() {
+		e := SchemaEvent{System: system}
+		var oldDefinition, newDefinition sql.NullString
+		err := rows.Scan(&e.ID, &e.Database, &e.Type, &e.Schema, &e.Relation, &e.Name, &oldDefinition, &newDefinition,
+			&e.PreviousCollectedAt, &e.DetectedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.OldDefinition, e.NewDefinition = oldDefinition.String, newDefinition.String
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
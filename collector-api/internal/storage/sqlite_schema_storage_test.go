@@ -0,0 +1,107 @@
+package storage_test
+
+import (
+	"collector-api/internal/storage"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreSchema(t *testing.T) {
+	store, err := storage.NewSQLiteQueryStorage(filepath.Join(t.TempDir(), "schema.db"))
+	require.NoError(t, err)
+
+	system := storage.SystemRef{SystemID: "a", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+
+	table := func(database, name, partitionOf string) storage.SchemaObject {
+		return storage.SchemaObject{Database: database, Type: storage.SchemaTable, Schema: "public", Relation: name, Name: name, Definition: partitionOf}
+	}
+	column := func(relation, name, definition string) storage.SchemaObject {
+		return storage.SchemaObject{Database: "app", Type: storage.SchemaColumn, Schema: "public", Relation: relation, Name: name, Definition: definition}
+	}
+	index := func(relation, name, definition string) storage.SchemaObject {
+		return storage.SchemaObject{Database: "app", Type: storage.SchemaIndex, Schema: "public", Relation: relation, Name: name, Definition: definition}
+	}
+
+	orders := table("app", "orders", "")
+	ordersID := column("orders", "id", "bigint NOT NULL")
+	ordersStatus := column("orders", "status", "text")
+	ordersPkey := index("orders", "orders_pkey", "CREATE UNIQUE INDEX orders_pkey ON public.orders USING btree (id)")
+	carts := table("app", "carts", "")
+	cartsID := column("carts", "id", "bigint")
+	reports := table("reporting", "reports", "")
+	events2024 := table("app", "events_2024", "")
+
+	// The first snapshot is a baseline
+	require.NoError(t, store.StoreSchema(system, 100, []storage.SchemaObject{
+		orders, carts, reports, events2024, ordersID, ordersStatus, cartsID, ordersPkey,
+	}))
+	events, err := store.ListSchemaEvents(system, 0, 1000)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// A deploy creates a table, adds and alters columns, indexes a column,
+	// drops a table and attaches a partition. The reporting database was not
+	// collected, and orders was locked.
+	ordersStatus.Definition = "text NOT NULL DEFAULT 'new'::text"
+	lockedOrders := orders
+	lockedOrders.Locked = true
+	customers := table("app", "customers", "")
+	customersID := column("customers", "id", "bigint NOT NULL")
+	cartsOwner := column("carts", "owner_id", "bigint")
+	cartsOwnerIdx := index("carts", "carts_owner_idx", "CREATE INDEX carts_owner_idx ON public.carts USING btree (owner_id)")
+	events2024.Definition = "PARTITION OF public.events FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')"
+	require.NoError(t, store.StoreSchema(system, 200, []storage.SchemaObject{
+		lockedOrders, customers, carts, events2024, customersID, cartsID, cartsOwner, cartsOwnerIdx,
+	}))
+
+	// orders is unlocked again, with the altered column, and carts is dropped
+	require.NoError(t, store.StoreSchema(system, 300, []storage.SchemaObject{
+		orders, customers, events2024, ordersID, ordersStatus, customersID, ordersPkey,
+	}))
+
+	events, err = store.ListSchemaEvents(system, 0, 1000)
+	require.NoError(t, err)
+	type event struct {
+		Type, Relation, Name, Old, New string
+		Previous, Detected             int64
+	}
+	var got []event
+	for _, e := range events {
+		assert.Equal(t, system, e.System)
+		assert.Equal(t, "app", e.Database)
+		got = append(got, event{e.Type, e.Relation, e.Name, e.OldDefinition, e.NewDefinition, e.PreviousCollectedAt, e.DetectedAt})
+	}
+	assert.Equal(t, []event{
+		{storage.TableCreated, "customers", "customers", "", "", 100, 200},
+		{storage.PartitionAttached, "events_2024", "events_2024", "", events2024.Definition, 100, 200},
+		{storage.ColumnAdded, "carts", "owner_id", "", "bigint", 100, 200},
+		{storage.IndexCreated, "carts", "carts_owner_idx", "", cartsOwnerIdx.Definition, 100, 200},
+		{storage.ColumnAltered, "orders", "status", "text", "text NOT NULL DEFAULT 'new'::text", 200, 300},
+		{storage.TableDropped, "carts", "carts", "", "", 200, 300},
+	}, got)
+
+	events, err = store.ListSchemaEvents(system, 250, 1000)
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	// An older snapshot arriving late changes nothing
+	require.NoError(t, store.StoreSchema(system, 250, []storage.SchemaObject{orders}))
+	events, err = store.ListSchemaEvents(system, 0, 1000)
+	require.NoError(t, err)
+	assert.Len(t, events, 6)
+
+	// Dropped columns and indexes of a kept table are reported, as are
+	// detached partitions
+	events2024.Definition = ""
+	require.NoError(t, store.StoreSchema(system, 400, []storage.SchemaObject{orders, customers, events2024, ordersID, customersID}))
+	events, err = store.ListSchemaEvents(system, 400, 400)
+	require.NoError(t, err)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{storage.PartitionDetached, storage.ColumnDropped, storage.IndexDropped}, types)
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"database/sql"
+)
+
+var SettingStore SettingStorage
+
+const upsertSystemSetting = `
+	INSERT INTO system_settings (
+		sys_id, sys_scope, sys_type, name, setting_value, unit, source, source_file, boot_value, reset_value,
+		first_seen, collected_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?11, ?11)
+	ON CONFLICT (sys_id, sys_scope, sys_type, name) DO UPDATE SET
+		setting_value = excluded.setting_value,
+		unit = excluded.unit,
+		source = excluded.source,
+		source_file = excluded.source_file,
+		boot_value = excluded.boot_value,
+		reset_value = excluded.reset_value,
+		collected_at = excluded.collected_at`
+
+const insertSettingChange = `
+	INSERT INTO system_setting_changes (
+		sys_id, sys_scope, sys_type, name, change_type, unit, old_value, new_value, old_source, new_source,
+		previous_collected_at, changed_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func (s *SQLiteQueryStorage) StoreSettings(system SystemRef, collectedAt int64, settings []SettingRep) error {
+	// Postgres always has settings, so a snapshot without any did not collect
+	// them rather than reporting every setting as removed
+	if len(settings) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if tx.Commit() is called
+
+	rows, err := tx.Query(`
+		SELECT name, setting_value, unit, source, collected_at
+		FROM system_settings
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?`,
+		system.SystemID, system.SystemScope, system.SystemType)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]SettingRep)
+	var previousCollectedAt int64
+	for rows.Next() {
+		var setting SettingRep
+		var unit, source sql.NullString
+		var settingCollectedAt int64
+		if err := rows.Scan(&setting.Name, &setting.Value, &unit, &source, &settingCollectedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		setting.Unit, setting.Source = unit.String, source.String
+		stored[setting.Name] = setting
+		previousCollectedAt = max(previousCollectedAt, settingCollectedAt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if previousCollectedAt > collectedAt {
+		return nil
+	}
+
+	upsert, err := tx.Prepare(upsertSystemSetting)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+
+	logChange, err := tx.Prepare(insertSettingChange)
+	if err != nil {
+		return err
+	}
+	defer logChange.Close()
+
+	// Without stored settings there is nothing to compare with, so the
+	// first snapshot of a system is a baseline rather than a change
+	changes := len(stored) > 0 && previousCollectedAt < collectedAt
+	change := func(changeType, name, unit string, before, after *SettingRep) error {
+		var oldValue, newValue, oldSource, newSource sql.NullString
+		if before != nil {
+			oldValue, oldSource = sql.NullString{String: before.Value, Valid: true}, nullString(before.Source)
+		}
+		if after != nil {
+			newValue, newSource = sql.NullString{String: after.Value, Valid: true}, nullString(after.Source)
+		}
+		_, err := logChange.Exec(system.SystemID, system.SystemScope, system.SystemType, name, changeType, nullString(unit),
+			oldValue, newValue, oldSource, newSource, previousCollectedAt, collectedAt)
+		return err
+	}
+
+	seen := make(map[string]bool, len(settings))
+	for i := range settings {
+		setting := &settings[i]
+		seen[setting.Name] = true
+
+		if changes {
+			old, ok := stored[setting.Name]
+			switch {
+			case !ok:
+				err = change(SettingAdded, setting.Name, setting.Unit, nil, setting)
+			case old.Value != setting.Value || old.Source != setting.Source:
+				err = change(SettingChanged, setting.Name, setting.Unit, &old, setting)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = upsert.Exec(system.SystemID, system.SystemScope, system.SystemType, setting.Name, setting.Value,
+			nullString(setting.Unit), nullString(setting.Source), nullString(setting.SourceFile),
+			nullString(setting.BootValue), nullString(setting.ResetValue), collectedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Settings missing from the snapshot were removed, e.g. with the
+	// extension that defined them
+	for name, old := range stored {
+		if seen[name] {
+			continue
+		}
+		if changes {
+			if err := change(SettingRemoved, name, old.Unit, &old, nil); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`DELETE FROM system_settings WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND name = ?`,
+			system.SystemID, system.SystemScope, system.SystemType, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteQueryStorage) ListSettings(system SystemRef) ([]SystemSetting, error) {
+	rows, err := s.db.Query(`
+		SELECT name, setting_value, unit, source, source_file, boot_value, reset_value, first_seen, collected_at
+		FROM system_settings
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ?
+		ORDER BY name`,
+		system.SystemID, system.SystemScope, system.SystemType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []SystemSetting
+	for rows.Next() {
+		setting := SystemSetting{System: system}
+		var unit, source, sourceFile, bootValue, resetValue sql.NullString
+		err := rows.Scan(&setting.Name, &setting.Value, &unit, &source, &sourceFile, &bootValue, &resetValue,
+			&setting.FirstSeen, &setting.CollectedAt)
+		if err != nil {
+			return nil, err
+		}
+		setting.Unit, setting.Source, setting.SourceFile = unit.String, source.String, sourceFile.String
+		setting.BootValue, setting.ResetValue = bootValue.String, resetValue.String
+		settings = append(settings, setting)
+	}
+	return settings, rows.Err()
+}
+
+func (s *SQLiteQueryStorage) ListSettingChanges(system SystemRef, start, end int64) ([]SettingChange, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, change_type, unit, old_value, new_value, old_source, new_source, previous_collected_at, changed_at
+		FROM system_setting_changes
+		WHERE sys_id = ? AND sys_scope = ? AND sys_type = ? AND changed_at BETWEEN ? AND ?
+		ORDER BY changed_at, name`,
+		system.SystemID, system.SystemScope, system.SystemType, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []SettingChange
+	for rows.Next() {
+		c := SettingChange{System: system}
+		var unit, oldValue, newValue, oldSource, newSource sql.NullString
+		err := rows.Scan(&c.ID, &c.Name, &c.ChangeType, &unit, &oldValue, &newValue, &oldSource, &newSource,
+			&c.PreviousCollectedAt, &c.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		c.Unit, c.OldValue, c.NewValue = unit.String, oldValue.String, newValue.String
+		c.OldSource, c.NewSource = oldSource.String, newSource.String
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
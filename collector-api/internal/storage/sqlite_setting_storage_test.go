@@ -0,0 +1,80 @@
+package storage_test
+
+import (
+	"collector-api/internal/storage"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreSettings(t *testing.T) {
+	store, err := storage.NewSQLiteQueryStorage(filepath.Join(t.TempDir(), "settings.db"))
+	require.NoError(t, err)
+
+	system := storage.SystemRef{SystemID: "a", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+	other := storage.SystemRef{SystemID: "b", SystemScope: "us-east-1", SystemType: "amazon_rds"}
+
+	workMem := storage.SettingRep{Name: "work_mem", Value: "4096", Unit: "kB", Source: "default", BootValue: "4096", ResetValue: "4096"}
+	jit := storage.SettingRep{Name: "jit", Value: "on", Source: "default"}
+	pgssMax := storage.SettingRep{Name: "pg_stat_statements.max", Value: "5000", Source: "configuration file"}
+
+	// The first snapshot is a baseline
+	require.NoError(t, store.StoreSettings(system, 100, []storage.SettingRep{workMem, jit}))
+	require.NoError(t, store.StoreSettings(other, 100, []storage.SettingRep{workMem}))
+	changes, err := store.ListSettingChanges(system, 0, 1000)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// An unchanged snapshot logs nothing
+	require.NoError(t, store.StoreSettings(system, 200, []storage.SettingRep{workMem, jit}))
+
+	changedWorkMem := workMem
+	changedWorkMem.Value, changedWorkMem.Source, changedWorkMem.SourceFile = "65536", "configuration file", "/etc/postgresql.conf"
+	require.NoError(t, store.StoreSettings(system, 300, []storage.SettingRep{changedWorkMem, pgssMax}))
+
+	changes, err = store.ListSettingChanges(system, 0, 1000)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	for i := range changes {
+		assert.NotZero(t, changes[i].ID)
+		changes[i].ID = 0
+	}
+	assert.Equal(t, []storage.SettingChange{
+		{System: system, Name: "jit", ChangeType: storage.SettingRemoved, OldValue: "on", OldSource: "default", PreviousCollectedAt: 200, ChangedAt: 300},
+		{System: system, Name: "pg_stat_statements.max", ChangeType: storage.SettingAdded, NewValue: "5000", NewSource: "configuration file", PreviousCollectedAt: 200, ChangedAt: 300},
+		{System: system, Name: "work_mem", ChangeType: storage.SettingChanged, Unit: "kB", OldValue: "4096", NewValue: "65536", OldSource: "default", NewSource: "configuration file", PreviousCollectedAt: 200, ChangedAt: 300},
+	}, changes)
+
+	changes, err = store.ListSettingChanges(system, 0, 299)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// An older snapshot arriving late changes nothing
+	require.NoError(t, store.StoreSettings(system, 250, []storage.SettingRep{workMem, jit}))
+	changes, err = store.ListSettingChanges(system, 0, 1000)
+	require.NoError(t, err)
+	assert.Len(t, changes, 3)
+
+	// A snapshot that did not collect settings changes nothing
+	require.NoError(t, store.StoreSettings(system, 400, nil))
+	require.NoError(t, store.StoreSettings(system, 500, []storage.SettingRep{changedWorkMem, pgssMax}))
+	changes, err = store.ListSettingChanges(system, 0, 1000)
+	require.NoError(t, err)
+	assert.Len(t, changes, 3)
+
+	settings, err := store.ListSettings(system)
+	require.NoError(t, err)
+	require.Len(t, settings, 2)
+	assert.Equal(t, pgssMax, settings[0].SettingRep)
+	assert.Equal(t, int64(300), settings[0].FirstSeen)
+	assert.Equal(t, changedWorkMem, settings[1].SettingRep)
+	assert.Equal(t, int64(100), settings[1].FirstSeen)
+	assert.Equal(t, int64(500), settings[1].CollectedAt)
+	assert.Equal(t, system, settings[1].System)
+
+	changes, err = store.ListSettingChanges(other, 0, 1000)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
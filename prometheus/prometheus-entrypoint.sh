@@ -18,6 +18,11 @@ fi
 # Copy the selected config to the final location
 cp "$CONFIG_SOURCE" "$PARENT_DIR/config/prometheus/prometheus.yml"
 
+# Point the bff scrape job at the bff when it runs elsewhere, e.g. in another container
+if [ -n "${AUTODBA_BFF_METRICS_TARGET}" ]; then
+    sed -i "s|\"localhost:4001\"|\"${AUTODBA_BFF_METRICS_TARGET}\"|" "$PARENT_DIR/config/prometheus/prometheus.yml"
+fi
+
 # Start up Prometheus for initialization
 "$PARENT_DIR/prometheus/prometheus" \
     --config.file="$PARENT_DIR/config/prometheus/prometheus.yml" \
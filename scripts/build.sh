@@ -35,10 +35,10 @@ echo "Building the project for multiple architectures..."
     cd bff
 
     # Build for x86_64
-    GOARCH=amd64 GOOS=linux go build -o ${OUTPUT_DIR}/autodba-bff-amd64 ./cmd/main.go
+    GOARCH=amd64 GOOS=linux go build -tags sqlite_fts5 -o ${OUTPUT_DIR}/autodba-bff-amd64 ./cmd/main.go
 
     # Build for ARM64
-    GOARCH=arm64 GOOS=linux go build -o ${OUTPUT_DIR}/autodba-bff-arm64 ./cmd/main.go
+    GOARCH=arm64 GOOS=linux go build -tags sqlite_fts5 -o ${OUTPUT_DIR}/autodba-bff-arm64 ./cmd/main.go
 
     # Copy the config.json
     cp config.json ${OUTPUT_DIR}/config.json
@@ -85,6 +85,7 @@ for arch in amd64 arm64; do
     cp prometheus/prometheus.normal.yml "${PROMETHEUS_CONFIG_DIR}/prometheus.normal.yml"
     cp prometheus/prometheus.reprocess.yml "${PROMETHEUS_CONFIG_DIR}/prometheus.reprocess.yml"
     cp prometheus/recording_rules.yml "${PROMETHEUS_CONFIG_DIR}/recording_rules.yml"
+    cp prometheus/alerting_rules.yml "${PROMETHEUS_CONFIG_DIR}/alerting_rules.yml"
 
     # Copy and build the reloader service
     cd prometheus/cmd/reloader
@@ -95,7 +96,7 @@ for arch in amd64 arm64; do
     mkdir -p "${COLLECTOR_API_SERVER_DIR}"
     cp -r collector-api/* "${COLLECTOR_API_SERVER_DIR}/"
     cd "${COLLECTOR_API_SERVER_DIR}"
-    go build -o collector-api-server ./cmd/server/main.go
+    go build -tags sqlite_fts5 -o collector-api-server ./cmd/server/main.go
     cd -
 
     # Prepare directories for install